@@ -0,0 +1,43 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package boltstore provides a data store implementation persisted by Bolt.
+
+Store
+
+A Store provides key:value cache that expires after defined duration of time,
+persisted on a Bolt database file, so values survive restarts without running
+a database server. That duration is defined when a new instance is initialized
+calling 'boltstore.New()' function and it is used to all stored values.
+
+Every value is stored on a single bucket along with the time it was stored or
+last renewed. Since Bolt has no native expiration, an expired value is handled
+as missing when it is read and it is removed calling 'GC()', which should be
+scheduled by the application.
+
+The lifetime for new values and existing values can be modified calling
+'SetLifetime()'.
+
+The expiration behaviour can be changed calling 'SetTransient()' to define
+whether the lifetime of stored value is fixed (transient) or is extended when
+it is read or written (non-transient).
+
+Values
+
+Values are serialized by msgpack, as in-memory store does.
+*/
+package boltstore
@@ -0,0 +1,535 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package boltstore
+
+import (
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/dot.v1"
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// A record represents a value stored on Bolt bucket.
+type record struct {
+	// At holds when the value was stored or last renewed as Unix
+	// nanoseconds, which added to the lifetime of Store defines when the
+	// value expires.
+	At    int64  `msgpack:"a"`
+	Value []byte `msgpack:"v"`
+}
+
+// A Store provides a Bolt-backed key:value cache that expires after defined
+// duration of time.
+//
+// It is a implementation of Store interface.
+type Store struct {
+	db          *bbolt.DB
+	bucket      []byte
+	lifetime    time.Duration
+	isTransient bool
+	clock       data.Clock
+}
+
+// New creates a new instance of Bolt Store, which stores its values on
+// specified bucket of db, and defines the lifetime of stored items. The bucket
+// is created whether it does not exist. The stored items lifetime are renewed
+// when it is read or written.
+//
+// The store does not own db, which must be closed by caller.
+//
+// Errors:
+// bbolt.ErrBucketNameRequired when bucket is empty.
+func New(db *bbolt.DB, bucket string, d time.Duration) (*Store, error) {
+	s := &Store{
+		db:       db,
+		bucket:   []byte(bucket),
+		lifetime: d,
+		clock:    data.SystemClock{},
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// decode stores the value of specified record in the value pointed to by ref.
+//
+// Errors:
+// data.InvalidTypeError when the value cannot be decoded into the type of ref.
+func decode(r *record, ref interface{}) error {
+	if err := msgpack.Unmarshal(r.Value, ref); err != nil {
+		return data.NewInvalidTypeError(ref)
+	}
+
+	if p, ok := ref.(*interface{}); ok {
+		*p = data.GenericValue(*p)
+	}
+	return nil
+}
+
+// isExpired returns whether specified record is expired at specified time.
+func (s *Store) isExpired(r *record, now time.Time) bool {
+	return now.UnixNano() > r.At+int64(s.lifetime)
+}
+
+// load gets the record stored by specified key from b, handling an expired
+// record as missing.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) load(b *bbolt.Bucket, key string) (*record, error) {
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return nil, dot.InvalidKeyError(key)
+	}
+
+	r := &record{}
+	if err := msgpack.Unmarshal(raw, r); err != nil {
+		return nil, err
+	}
+	if s.isExpired(r, s.clock.Now()) {
+		return nil, dot.InvalidKeyError(key)
+	}
+
+	return r, nil
+}
+
+// store stores specified record by key on b.
+func (s *Store) store(b *bbolt.Bucket, key string, r *record) error {
+	raw, err := msgpack.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(key), raw)
+}
+
+// Add adds a new key:value to current store. An expired value not removed by
+// GC yet is replaced.
+//
+// Errors:
+// dot.DuplicatedKeyError when requested key already exists.
+func (s *Store) Add(key string, value interface{}) error {
+	raw, err := msgpack.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b.Get([]byte(key)) != nil {
+			if _, err := s.load(b, key); err == nil {
+				return dot.DuplicatedKeyError(key)
+			}
+		}
+
+		return s.store(b, key, &record{s.clock.Now().UnixNano(), raw})
+	})
+}
+
+func (s *Store) atomicInteger(key string, inc int) (int, error) {
+	var value int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		now := s.clock.Now().UnixNano()
+		r, err := s.load(b, key)
+		if err != nil {
+			r = &record{At: now}
+		} else if err := decode(r, &value); err != nil {
+			return err
+		}
+
+		value += inc
+		if !s.isTransient {
+			r.At = now
+		}
+		if r.Value, err = msgpack.Marshal(value); err != nil {
+			return err
+		}
+		return s.store(b, key, r)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Close does nothing, since current store does not own its db, which must be
+// closed by caller, nor runs background work.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Count gets the number of stored values by current instance, excluding
+// expired values not removed by GC yet.
+func (s *Store) Count() (int, error) {
+	count := 0
+	err := s.scan(func(key string, r *record) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Decrement atomically gets the value stored by specified key and
+// decrements it by one. If the key does not exist, it is created.
+func (s *Store) Decrement(key string) (int, error) {
+	return s.atomicInteger(key, -1)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value. If the key does not exist, it is created.
+func (s *Store) DecrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, -1*value)
+}
+
+// Delete deletes the specified key:value.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if _, err := s.load(b, key); err != nil {
+			return err
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// Exists reports whether a value is stored by specified key, without renewing
+// it.
+func (s *Store) Exists(key string) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		_, err := s.load(tx.Bucket(s.bucket), key)
+		found = err == nil
+		if _, ok := err.(dot.InvalidKeyError); ok {
+			return nil
+		}
+		return err
+	})
+
+	return found, err
+}
+
+// Flush deletes any stored value by current instance.
+func (s *Store) Flush() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(s.bucket); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucket(s.bucket)
+		return err
+	})
+}
+
+// GC removes every expired value and returns how many values were removed.
+// Since Bolt has no native expiration, it should be called on a schedule to
+// reclaim space of expired values.
+func (s *Store) GC() (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		now := s.clock.Now()
+		b := tx.Bucket(s.bucket)
+		// Keys are deleted after iteration, since deleting from a cursor
+		// makes it skip the next key
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			r := record{}
+			if err := msgpack.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if s.isExpired(&r, now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// Get gets the value stored by specified key.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Get(key string, ref interface{}) error {
+	fn := s.db.View
+	if !s.isTransient {
+		fn = s.db.Update
+	}
+
+	return fn(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		r, err := s.load(b, key)
+		if err != nil {
+			return err
+		}
+
+		// A reference to pointer is filled with a newly allocated value
+		if err := decode(r, data.IndirectRef(ref)); err != nil {
+			return err
+		}
+		if s.isTransient {
+			return nil
+		}
+
+		r.At = s.clock.Now().UnixNano()
+		return s.store(b, key, r)
+	})
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero. Unlike Increment, a missing key is not created.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+// data.InvalidTypeError when the value stored at key is not integer.
+func (s *Store) GetAndReset(key string) (int, error) {
+	var value int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		r, err := s.load(b, key)
+		if err != nil {
+			return err
+		}
+		if err := decode(r, &value); err != nil {
+			return err
+		}
+
+		if !s.isTransient {
+			r.At = s.clock.Now().UnixNano()
+		}
+		if r.Value, err = msgpack.Marshal(0); err != nil {
+			return err
+		}
+		return s.store(b, key, r)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+func (s *Store) Increment(key string) (int, error) {
+	return s.atomicInteger(key, 1)
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created.
+//
+// Errors:
+// data.InvalidTypeError when the value stored at key is not integer, which is
+// kept unchanged.
+func (s *Store) IncrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, value)
+}
+
+// Keys gets the sorted keys of stored values by current instance, excluding
+// expired values not removed by GC yet.
+func (s *Store) Keys() ([]string, error) {
+	var keys []string
+	err := s.scan(func(key string, r *record) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Range calls fn for each stored value ordered by key, until fn returns false.
+// Values are not renewed and expired values are skipped.
+//
+// A read-only transaction is held while fn is called, so fn must not call back
+// into current store methods that write to the database, which may deadlock.
+// Whether it needs to, the keys can be snapshot by Keys beforehand.
+//
+// Errors:
+// data.InvalidTypeError when a value could not be decoded, which stops
+// iteration.
+func (s *Store) Range(fn func(key string, value interface{}) bool) error {
+	var err error
+	scanErr := s.scan(func(key string, r *record) bool {
+		var value interface{}
+		if err = decode(r, &value); err != nil {
+			return false
+		}
+		return fn(key, value)
+	})
+	if scanErr != nil {
+		return scanErr
+	}
+
+	return err
+}
+
+// Set sets the value of specified key.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Set(key string, value interface{}) error {
+	raw, err := msgpack.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		r, err := s.load(b, key)
+		if err != nil {
+			return err
+		}
+
+		r.Value = raw
+		if !s.isTransient {
+			r.At = s.clock.Now().UnixNano()
+		}
+		return s.store(b, key, r)
+	})
+}
+
+// SetClock defines the clock used to get the current time, which defaults to
+// system clock.
+func (s *Store) SetClock(c data.Clock) {
+	s.clock = c
+}
+
+// SetLifetime modifies the lifetime for new and existing stored items.
+//
+// Errors:
+// NotSupportedError when ScopeNewAndUpdate or ScopeNew is specified.
+func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	switch scope {
+	case data.ScopeAll:
+	case data.ScopeNewAndUpdated:
+		return dot.NotSupportedError("ScopeNewAndUpdated")
+	case data.ScopeNew:
+		return dot.NotSupportedError("ScopeNew")
+	default:
+		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	}
+
+	s.lifetime = d
+	return nil
+}
+
+// SetTransient defines whether should extends expiration of stored value
+// when it is read or written.
+func (s *Store) SetTransient(value bool) {
+	s.isTransient = value
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key.
+// Expired values are reported as missing, even when they are not removed by GC
+// yet.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		r, err := s.load(tx.Bucket(s.bucket), key)
+		if err != nil {
+			return err
+		}
+
+		expireAt := time.Unix(0, r.At).Add(s.lifetime)
+		ttl = expireAt.Sub(s.clock.Now())
+		return nil
+	})
+
+	return ttl, err
+}
+
+// Touch renews the lifetime of the value stored by specified key without
+// decoding it, even when current store is transient.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Touch(key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		r, err := s.load(b, key)
+		if err != nil {
+			return err
+		}
+
+		r.At = s.clock.Now().UnixNano()
+		return s.store(b, key, r)
+	})
+}
+
+// scan calls fn for each record not expired, ordered by key, until fn returns
+// false.
+func (s *Store) scan(fn func(key string, r *record) bool) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		now := s.clock.Now()
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			r := &record{}
+			if err := msgpack.Unmarshal(v, r); err != nil {
+				return err
+			}
+			if s.isExpired(r, now) {
+				continue
+			}
+			if !fn(string(k), r) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+var _ data.ResettableStore = (*Store)(nil)
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package boltstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+	"go.etcd.io/bbolt"
+)
+
+func TestBoltStore(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	clock := testdata.NewClock()
+	store, err := New(db, "cache", time.Millisecond)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.SetClock(clock)
+
+	testdata.TestExpirationWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestAtomic(store, t)
+
+	store.Flush()
+	testdata.TestValueHandling(store, t)
+
+	store.Flush()
+	testdata.TestKeyCollision(store, t)
+
+	store.Flush()
+	testdata.TestPostponeWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTransientWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTypeError(store, t)
+
+	store.Flush()
+	testdata.TestGenericDecode(store, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+
+	store.Flush()
+	testdata.TestKeysWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestExistsWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTTLWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouchWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestPointerValue(store, t)
+
+	store.Flush()
+	testdata.TestAddExpiredWithClock(store, clock, t)
+}
+
+func TestGC(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	clock := testdata.NewClock()
+	store, err := New(db, "cache", time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.SetClock(clock)
+
+	for _, k := range []string{"v1", "v2", "v3"} {
+		store.Add(k, k)
+	}
+	clock.Advance(time.Second * 40)
+	store.Get("v2", new(string))
+	clock.Advance(time.Second * 40)
+
+	removed, err := store.GC()
+	if err != nil {
+		t.Fatalf("Could not collect expired values: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 removed values but got %d", removed)
+	}
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		if n := tx.Bucket([]byte("cache")).Stats().KeyN; n != 1 {
+			t.Errorf("Expected only v2 kept on bucket but got %d keys", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkBoltStoreAddGet(b *testing.B) {
+	db := openDB(b)
+	defer db.Close()
+
+	store, err := New(db, "cache", 0)
+	if err != nil {
+		b.Fatalf("Could not create store: %v", err)
+	}
+	testdata.BenchmarkAddGet(store, b)
+}
+
+// openDB opens a Bolt database on a temporary directory, which is removed when
+// the test finishes.
+func openDB(tb testing.TB) *bbolt.DB {
+	dir, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		tb.Fatalf("Could not create temporary directory: %v", err)
+	}
+
+	db, err := bbolt.Open(filepath.Join(dir, "cache.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		tb.Fatalf("Could not open database: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+
+	return db
+}
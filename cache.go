@@ -0,0 +1,88 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import "gopkg.in/raiqub/dot.v1"
+
+// An ErrorHandler handles an error which cannot be returned to caller, like an
+// error returned by the Store wrapped by a Cache or raised by an asynchronous
+// callback. The op is the name of the operation which triggered the error,
+// like the Cache method.
+type ErrorHandler func(op, key string, err error)
+
+// A Cache adapts a Store to the simpler cache interface expected by code which
+// does not handle errors, where a missing value is reported by a boolean. It is
+// an interoperability layer, hence Store remains the primary API.
+//
+// Errors returned by wrapped store are translated as follows: InvalidKeyError
+// and ErrNegativelyCached report a missing value and are never handled as
+// errors, while any other error is passed to the ErrorHandler, then Get
+// reports a missing value.
+type Cache struct {
+	store   Store
+	onError ErrorHandler
+}
+
+// NewCache returns a Cache which reads and writes values from s. Errors other
+// than a missing key are passed to onError, or discarded whether onError is
+// nil.
+func NewCache(s Store, onError ErrorHandler) *Cache {
+	return &Cache{store: s, onError: onError}
+}
+
+// Delete deletes the value stored by specified key, whether it exists.
+func (c *Cache) Delete(key string) {
+	err := c.store.Delete(key)
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		c.handle("Delete", key, err)
+	}
+}
+
+// Get gets the value stored by specified key, decoded as GenericValue defines,
+// and reports whether it was found.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	var value interface{}
+	err := c.store.Get(key, &value)
+	if err != nil {
+		if !isMissing(err) {
+			c.handle("Get", key, err)
+		}
+		return nil, false
+	}
+
+	return value, true
+}
+
+// Set stores value by specified key, adding it whether the key does not exist.
+func (c *Cache) Set(key string, value interface{}) {
+	err := c.store.Set(key, value)
+	if _, ok := err.(dot.InvalidKeyError); ok {
+		err = c.store.Add(key, value)
+		if _, ok := err.(dot.DuplicatedKeyError); ok {
+			// The key was added meanwhile by someone else
+			err = c.store.Set(key, value)
+		}
+	}
+	c.handle("Set", key, err)
+}
+
+// handle passes err to error handler, whether it is not nil.
+func (c *Cache) handle(op, key string, err error) {
+	if err != nil && c.onError != nil {
+		c.onError(op, key, err)
+	}
+}
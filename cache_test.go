@@ -0,0 +1,92 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestCache(t *testing.T) {
+	var errs []string
+	cache := data.NewCache(memstore.New(time.Minute, false),
+		func(op, key string, err error) {
+			errs = append(errs, op+" "+key)
+		})
+
+	if _, ok := cache.Get("v1"); ok {
+		t.Error("A missing value should not be found")
+	}
+
+	cache.Set("v1", 5)
+	cache.Set("v1", 7)
+	if value, ok := cache.Get("v1"); !ok || value != int64(7) {
+		t.Errorf("Expected value 7 but got %v", value)
+	}
+
+	cache.Delete("v1")
+	cache.Delete("v1")
+	if _, ok := cache.Get("v1"); ok {
+		t.Error("A deleted value should not be found")
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("A missing key should not be handled as error: %v", errs)
+	}
+}
+
+func TestCacheNegativelyCached(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	store.AddNegative("v1", time.Minute)
+
+	var errs []error
+	cache := data.NewCache(store, func(op, key string, err error) {
+		errs = append(errs, err)
+	})
+
+	if _, ok := cache.Get("v1"); ok {
+		t.Error("A negatively cached key should not be found")
+	}
+	if len(errs) != 0 {
+		t.Errorf("A negatively cached key should not be handled as error: %v",
+			errs)
+	}
+}
+
+func TestCacheErrorHandler(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	store.SetValidator(func(key string, value interface{}) error {
+		return errors.New("invalid value")
+	})
+
+	var handled error
+	cache := data.NewCache(store, func(op, key string, err error) {
+		if op != "Set" || key != "v1" {
+			t.Errorf("Unexpected error of %s %s", op, key)
+		}
+		handled = err
+	})
+
+	cache.Set("v1", 5)
+	if handled == nil {
+		t.Error("The error of wrapped store was not handled")
+	}
+}
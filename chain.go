@@ -0,0 +1,315 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"reflect"
+	"time"
+
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A ChainStore represents an ordered list of stores acting as tiers of a
+// single cache, like a memory store in front of a MongoDB store. The first
+// store is the fastest tier and the last one is the authoritative tier.
+//
+// Get reads through the tiers in order and writes through every tier, so a
+// value read from a lower tier is promoted to the higher ones. Count, Exists,
+// Keys, Range and TTL are answered by the authoritative tier.
+type ChainStore struct {
+	stores []Store
+}
+
+// NewChainStore returns a ChainStore whose tiers are specified stores, from
+// the fastest to the authoritative one.
+//
+// A value promoted to a higher tier takes the lifetime of that tier, hence
+// higher tiers should have lifetimes no longer than the authoritative tier
+// to avoid serving values which it has already expired.
+func NewChainStore(first Store, others ...Store) *ChainStore {
+	return &ChainStore{append([]Store{first}, others...)}
+}
+
+// Add adds a new key:value to the authoritative tier and then to every
+// higher tier, replacing the value whether a higher tier still holds a
+// previous one.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists on the authoritative
+// tier.
+func (s *ChainStore) Add(key string, value interface{}) error {
+	if err := s.last().Add(key, value); err != nil {
+		return err
+	}
+	for _, st := range s.higher() {
+		if err := put(st, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every tier, from the authoritative to the fastest one. Every
+// tier is closed even when one of them fails, whose error is returned.
+func (s *ChainStore) Close() error {
+	var first error
+	for i := len(s.stores) - 1; i >= 0; i-- {
+		if err := s.stores[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Count gets the number of values stored by the authoritative tier.
+func (s *ChainStore) Count() (int, error) {
+	return s.last().Count()
+}
+
+// Decrement decrements the value stored by specified key on the
+// authoritative tier, removing it from higher tiers.
+func (s *ChainStore) Decrement(key string) (int, error) {
+	return s.atomic(key, "Decrement", func(as AtomicStore) (int, error) {
+		return as.Decrement(key)
+	})
+}
+
+// DecrementBy decrements by value the value stored by specified key on the
+// authoritative tier, removing it from higher tiers.
+func (s *ChainStore) DecrementBy(key string, value int) (int, error) {
+	return s.atomic(key, "DecrementBy", func(as AtomicStore) (int, error) {
+		return as.DecrementBy(key, value)
+	})
+}
+
+// Delete deletes the specified value from every tier.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found on the authoritative
+// tier.
+func (s *ChainStore) Delete(key string) error {
+	err := s.last().Delete(key)
+	if _, ok := err.(dot.InvalidKeyError); err != nil && !ok {
+		return err
+	}
+	if ierr := s.invalidate(key); ierr != nil {
+		return ierr
+	}
+	return err
+}
+
+// Exists reports whether a value is stored by specified key on the
+// authoritative tier.
+func (s *ChainStore) Exists(key string) (bool, error) {
+	return s.last().Exists(key)
+}
+
+// Flush deletes any value from every tier.
+func (s *ChainStore) Flush() error {
+	for i := len(s.stores) - 1; i >= 0; i-- {
+		if err := s.stores[i].Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get gets the value stored by specified key from the first tier holding it,
+// promoting it to every higher tier.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found on any tier.
+func (s *ChainStore) Get(key string, ref interface{}) error {
+	for i, st := range s.stores {
+		err := st.Get(key, ref)
+		if _, ok := err.(dot.InvalidKeyError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// A failed promotion only costs another read from a lower tier
+		value := reflect.ValueOf(IndirectRef(ref)).Elem().Interface()
+		for _, higher := range s.stores[:i] {
+			put(higher, key, value)
+		}
+		return nil
+	}
+	return dot.InvalidKeyError(key)
+}
+
+// GetAndReset gets and resets the value stored by specified key on the
+// authoritative tier, removing it from higher tiers.
+func (s *ChainStore) GetAndReset(key string) (int, error) {
+	return s.atomic(key, "GetAndReset", func(as AtomicStore) (int, error) {
+		rs, err := resettableOf(as)
+		if err != nil {
+			return 0, err
+		}
+		return rs.GetAndReset(key)
+	})
+}
+
+// Increment increments the value stored by specified key on the
+// authoritative tier, removing it from higher tiers.
+func (s *ChainStore) Increment(key string) (int, error) {
+	return s.atomic(key, "Increment", func(as AtomicStore) (int, error) {
+		return as.Increment(key)
+	})
+}
+
+// IncrementBy increments by value the value stored by specified key on the
+// authoritative tier, removing it from higher tiers.
+func (s *ChainStore) IncrementBy(key string, value int) (int, error) {
+	return s.atomic(key, "IncrementBy", func(as AtomicStore) (int, error) {
+		return as.IncrementBy(key, value)
+	})
+}
+
+// Keys gets the keys of values stored by the authoritative tier.
+func (s *ChainStore) Keys() ([]string, error) {
+	return s.last().Keys()
+}
+
+// Range calls fn for each value stored by the authoritative tier.
+func (s *ChainStore) Range(fn func(key string, value interface{}) bool) error {
+	return s.last().Range(fn)
+}
+
+// Set sets the value of specified key on the authoritative tier and then on
+// every higher tier, adding it whether a higher tier does not hold it.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found on the authoritative
+// tier.
+func (s *ChainStore) Set(key string, value interface{}) error {
+	if err := s.last().Set(key, value); err != nil {
+		return err
+	}
+	for _, st := range s.higher() {
+		if err := put(st, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLifetime modifies the lifetime of every tier for specified scope. Every
+// tier is modified even when one of them fails, whose error is returned.
+func (s *ChainStore) SetLifetime(d time.Duration, scope LifetimeScope) error {
+	var first error
+	for _, st := range s.stores {
+		if err := st.SetLifetime(d, scope); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// SetTransient defines whether every tier extends expiration of stored
+// values when they are read or written.
+func (s *ChainStore) SetTransient(value bool) {
+	for _, st := range s.stores {
+		st.SetTransient(value)
+	}
+}
+
+// Stores returns the tiers of current store, from the fastest to the
+// authoritative one.
+func (s *ChainStore) Stores() []Store {
+	return append([]Store(nil), s.stores...)
+}
+
+// Touch renews the lifetime of the value stored by specified key on every
+// tier holding it.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found on the authoritative
+// tier.
+func (s *ChainStore) Touch(key string) error {
+	if err := s.last().Touch(key); err != nil {
+		return err
+	}
+	for _, st := range s.higher() {
+		err := st.Touch(key)
+		if _, ok := err.(dot.InvalidKeyError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key on the
+// authoritative tier.
+func (s *ChainStore) TTL(key string) (time.Duration, error) {
+	return s.last().TTL(key)
+}
+
+// atomic calls fn by the authoritative tier, whether it supports atomic
+// operations, and removes specified key from higher tiers, since their value
+// is outdated.
+func (s *ChainStore) atomic(
+	key, method string, fn func(AtomicStore) (int, error),
+) (int, error) {
+	as, err := atomicOf(s.last(), method)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := fn(as)
+	if err != nil {
+		return 0, err
+	}
+	return result, s.invalidate(key)
+}
+
+// higher returns every tier but the authoritative one.
+func (s *ChainStore) higher() []Store {
+	return s.stores[:len(s.stores)-1]
+}
+
+// invalidate deletes specified key from higher tiers, whether they hold it.
+func (s *ChainStore) invalidate(key string) error {
+	for _, st := range s.higher() {
+		err := st.Delete(key)
+		if _, ok := err.(dot.InvalidKeyError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// last returns the authoritative tier.
+func (s *ChainStore) last() Store {
+	return s.stores[len(s.stores)-1]
+}
+
+// put sets the value of specified key on st, adding it whether it is missing.
+func put(st Store, key string, value interface{}) error {
+	err := st.Set(key, value)
+	if _, ok := err.(dot.InvalidKeyError); ok {
+		err = st.Add(key, value)
+		if _, ok := err.(dot.DuplicatedKeyError); ok {
+			// The value added meanwhile is replaced
+			return st.Set(key, value)
+		}
+	}
+	return err
+}
+
+var _ ResettableStore = (*ChainStore)(nil)
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+func TestChainStore(t *testing.T) {
+	l1 := memstore.New(time.Minute, false)
+	l2 := memstore.New(time.Hour, false)
+	store := data.NewChainStore(l1, l2)
+
+	// A value read from the lower tier is promoted to the higher one
+	l2.Add("k1", "lorem")
+	var value string
+	if err := store.Get("k1", &value); err != nil || value != "lorem" {
+		t.Fatalf("Expected lorem got %q: %v", value, err)
+	}
+	if ok, _ := l1.Exists("k1"); !ok {
+		t.Errorf("The value should be promoted to higher tier")
+	}
+
+	// Writes go through every tier
+	if err := store.Add("k2", 1); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	if err := store.Set("k1", "ipsum"); err != nil {
+		t.Fatalf("Could not set value: %v", err)
+	}
+	for _, tier := range []data.Store{l1, l2} {
+		if err := tier.Get("k1", &value); err != nil || value != "ipsum" {
+			t.Errorf("Expected ipsum got %q: %v", value, err)
+		}
+		if ok, _ := tier.Exists("k2"); !ok {
+			t.Errorf("The added value should be stored by every tier")
+		}
+	}
+
+	// An atomic operation removes the outdated value from higher tiers
+	if n, err := store.Increment("k2"); err != nil || n != 2 {
+		t.Errorf("Expected 2 got %d: %v", n, err)
+	}
+	if ok, _ := l1.Exists("k2"); ok {
+		t.Errorf("The incremented value should be removed from higher tier")
+	}
+
+	l1.Add("k3", 0)
+	if count, err := store.Count(); err != nil || count != 2 {
+		t.Errorf("Expected 2 values on authoritative tier got %d: %v",
+			count, err)
+	}
+	if err := store.Delete("k1"); err != nil {
+		t.Errorf("Could not delete value: %v", err)
+	}
+	if err := store.Delete("k3"); err == nil {
+		t.Errorf("Expected error deleting missing value")
+	} else if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if ok, _ := l1.Exists("k3"); ok {
+		t.Errorf("The value should be deleted from higher tier")
+	}
+	if err := store.Get("k1", &value); err == nil {
+		t.Errorf("The deleted value should not be found")
+	}
+}
+
+func TestChainStoreLifetime(t *testing.T) {
+	l1 := memstore.New(time.Minute, false)
+	l2 := memstore.New(time.Hour, false)
+	store := data.NewChainStore(l1, l2)
+	store.Add("k1", 1)
+
+	if err := store.SetLifetime(time.Second, data.ScopeAll); err != nil {
+		t.Fatalf("Could not set lifetime: %v", err)
+	}
+	if err := store.Touch("k1"); err != nil {
+		t.Fatalf("Could not touch value: %v", err)
+	}
+	for _, tier := range []data.Store{l1, l2} {
+		if ttl, err := tier.TTL("k1"); err != nil || ttl > time.Second {
+			t.Errorf("Expected lifetime to be propagated, got %v: %v", ttl, err)
+		}
+	}
+}
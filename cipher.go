@@ -0,0 +1,117 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// A Cipher represents an authenticated encryption used by stores to protect
+// stored values.
+type Cipher interface {
+	// Encrypt returns the encryption of plaintext.
+	Encrypt(plaintext []byte) ([]byte, error)
+
+	// Decrypt returns the plaintext of ciphertext, which must be encrypted
+	// by the same key.
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// errCiphertextSize is returned when a ciphertext is shorter than its nonce.
+var errCiphertextSize = errors.New("The ciphertext is too short")
+
+// An aesCipher represents a Cipher using AES-GCM.
+type aesCipher struct {
+	aead cipher.AEAD
+}
+
+// NewAESCipher returns a Cipher using AES-GCM by specified key, which must be
+// 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256. A random
+// nonce is prepended to every ciphertext.
+func NewAESCipher(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return aesCipher{aead}, nil
+}
+
+// Encrypt returns the encryption of plaintext prefixed by its nonce.
+func (c aesCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(),
+		c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt returns the plaintext of ciphertext prefixed by its nonce.
+func (c aesCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, errCiphertextSize
+	}
+
+	return c.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
+
+// A rotatedCipher represents a Cipher which decrypts by previous keys.
+type rotatedCipher struct {
+	current  Cipher
+	previous []Cipher
+}
+
+// RotatedCipher returns a Cipher which encrypts by current cipher and decrypts
+// by current cipher or, when it fails, by each previous cipher in order. It
+// allows rotating keys while values encrypted by previous keys are still
+// stored; those values are encrypted by current key once they are written
+// again.
+func RotatedCipher(current Cipher, previous ...Cipher) Cipher {
+	return rotatedCipher{current, previous}
+}
+
+// Encrypt returns the encryption of plaintext by current cipher.
+func (c rotatedCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	return c.current.Encrypt(plaintext)
+}
+
+// Decrypt returns the plaintext of ciphertext by the first cipher that
+// succeeds.
+func (c rotatedCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.current.Decrypt(ciphertext)
+	if err == nil {
+		return plaintext, nil
+	}
+
+	for _, p := range c.previous {
+		if plaintext, perr := p.Decrypt(ciphertext); perr == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
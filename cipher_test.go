@@ -0,0 +1,58 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestRotatedCipher(t *testing.T) {
+	oldKey, _ := data.NewAESCipher(bytes.Repeat([]byte{1}, 16))
+	newKey, _ := data.NewAESCipher(bytes.Repeat([]byte{2}, 32))
+	plaintext := []byte("lorem ipsum")
+
+	old, err := oldKey.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Could not encrypt: %v", err)
+	}
+	if bytes.Contains(old, plaintext) {
+		t.Error("The ciphertext should not contain the plaintext")
+	}
+	if _, err := newKey.Decrypt(old); err == nil {
+		t.Error("A ciphertext should not be decrypted by another key")
+	}
+
+	rotated := data.RotatedCipher(newKey, oldKey)
+	if result, err := rotated.Decrypt(old); err != nil ||
+		!bytes.Equal(result, plaintext) {
+		t.Errorf("Expected %q decrypted by previous key but got %q: %v",
+			plaintext, result, err)
+	}
+
+	current, _ := rotated.Encrypt(plaintext)
+	if result, err := newKey.Decrypt(current); err != nil ||
+		!bytes.Equal(result, plaintext) {
+		t.Errorf("Expected %q encrypted by current key but got %q: %v",
+			plaintext, result, err)
+	}
+	if _, err := rotated.Decrypt(current[:4]); err == nil {
+		t.Error("A truncated ciphertext should not be decrypted")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"sort"
+
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// CanonicalEncode returns a deterministic MessagePack encoding of v, where
+// the same logical value always produces the same bytes. It is suitable to be
+// hashed for deriving cache keys.
+//
+// Map entries, including struct fields, are sorted by the encoding of their
+// keys, removing the nondeterminism of map iteration order. Integers are
+// encoded using their smallest representation regardless of Go type.
+func CanonicalEncode(v interface{}) ([]byte, error) {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round-tripping through an empty interface turns structs and typed maps
+	// into generic maps, which are then encoded in a sorted order.
+	var generic interface{}
+	if err := msgpack.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeCanonical(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A canonicalEntry represents an encoded map key and its value.
+type canonicalEntry struct {
+	key   []byte
+	value interface{}
+}
+
+// encodeCanonical writes the canonical encoding of a generic decoded value.
+func encodeCanonical(buf *bytes.Buffer, v interface{}) error {
+	enc := msgpack.NewEncoder(buf)
+
+	var entries []canonicalEntry
+	switch t := v.(type) {
+	case []interface{}:
+		if err := enc.EncodeArrayLen(len(t)); err != nil {
+			return err
+		}
+		for _, item := range t {
+			if err := encodeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[interface{}]interface{}:
+		entries = make([]canonicalEntry, 0, len(t))
+		for k, item := range t {
+			e, err := newCanonicalEntry(k, item)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+	case map[string]interface{}:
+		entries = make([]canonicalEntry, 0, len(t))
+		for k, item := range t {
+			e, err := newCanonicalEntry(k, item)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+	default:
+		return enc.Encode(v)
+	}
+
+	sort.Sort(byCanonicalKey(entries))
+	if err := enc.EncodeMapLen(len(entries)); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		buf.Write(e.key)
+		if err := encodeCanonical(buf, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newCanonicalEntry creates a new map entry encoding specified key.
+func newCanonicalEntry(key, value interface{}) (canonicalEntry, error) {
+	var buf bytes.Buffer
+	if err := encodeCanonical(&buf, key); err != nil {
+		return canonicalEntry{}, err
+	}
+	return canonicalEntry{buf.Bytes(), value}, nil
+}
+
+// byCanonicalKey implements sort.Interface to sort map entries by their
+// encoded keys.
+type byCanonicalKey []canonicalEntry
+
+func (a byCanonicalKey) Len() int           { return len(a) }
+func (a byCanonicalKey) Less(i, j int) bool { return bytes.Compare(a[i].key, a[j].key) < 0 }
+func (a byCanonicalKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
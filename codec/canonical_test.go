@@ -0,0 +1,63 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+func TestCanonicalEncode(t *testing.T) {
+	type request struct {
+		Path   string
+		Params map[string]int
+		Tags   map[int]string
+	}
+
+	params := make(map[string]int)
+	tags := make(map[int]string)
+	for i := 0; i < 32; i++ {
+		params[string(rune('a'+i))] = i
+		tags[i] = string(rune('a' + i))
+	}
+	value := request{"/users", params, tags}
+
+	expected, err := CanonicalEncode(value)
+	if err != nil {
+		t.Fatalf("Could not encode value: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		b, err := CanonicalEncode(value)
+		if err != nil {
+			t.Fatalf("Could not encode value: %v", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Fatalf("Expected '%x' got '%x'", expected, b)
+		}
+	}
+
+	var result request
+	if err := msgpack.Unmarshal(expected, &result); err != nil {
+		t.Fatalf("Could not decode value: %v", err)
+	}
+	if result.Path != value.Path || len(result.Params) != len(params) ||
+		result.Params["z"] != params["z"] || result.Tags[5] != tags[5] {
+		t.Errorf("Expected '%v' got '%v'", value, result)
+	}
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package codec provides serialization formats to be used by data stores.
+
+Codec
+
+A Codec implements the 'data.Codec' interface and can be plugged into stores
+which persist serialized values. Two services sharing the same storage must
+agree on the codec, since a value encoded by a codec cannot be decoded by
+another one.
+
+Msgpack is the default codec of stores. JSON and Proto allow sharing values
+with services written in other languages.
+
+Compression
+
+Gzip wraps another codec, compressing its encodings larger than a threshold.
+Each encoding is prefixed by a header defining whether it is compressed, which
+is never a valid MessagePack or JSON encoding.
+
+Canonical Encoding
+
+CanonicalEncode provides a deterministic MessagePack encoding, where map
+entries are sorted, so equal values can be hashed into the same cache key.
+*/
+package codec
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+// headerMagic starts every compression header, followed by the header byte.
+// It is never used by MessagePack and it is not valid JSON, so an encoding
+// prefixed by a header is never decoded as a value by those codecs, which
+// would ignore the trailing bytes.
+const headerMagic byte = 0xc1
+
+const (
+	// uncompressedHeader prefixes an encoding stored as is.
+	uncompressedHeader byte = iota
+	// gzipHeader prefixes an encoding compressed by gzip.
+	gzipHeader
+)
+
+// ErrInvalidHeader is returned when decompressing data which does not start
+// with a known compression header.
+var ErrInvalidHeader = errors.New("codec: invalid compression header")
+
+// A Gzip represents a codec whose encodings larger than Threshold bytes are
+// compressed by gzip, which saves storage for large values at the cost of CPU
+// time. Every encoding is prefixed by a header defining whether it is
+// compressed, hence values encoded by distinct thresholds are decoded alike.
+// The header is never a valid MessagePack or JSON encoding, so wrapped codec
+// fails to decode an encoding of Gzip instead of misreading it.
+//
+// Encodings of Gzip are not decoded by its wrapped codec, and vice versa; see
+// Compress and Decompress.
+type Gzip struct {
+	// Codec serializes values before they are compressed, which is Msgpack
+	// when nil.
+	Codec data.Codec
+	// Threshold defines the size in bytes of the largest encoding which is
+	// not compressed.
+	Threshold int
+}
+
+// Marshal returns the encoding of v by wrapped codec, compressed whether it
+// is larger than threshold.
+func (g Gzip) Marshal(v interface{}) ([]byte, error) {
+	b, err := g.codec().Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return Compress(b, g.Threshold)
+}
+
+// Unmarshal decompresses data, whether it is compressed, and decodes it by
+// wrapped codec into the value pointed to by v.
+func (g Gzip) Unmarshal(data []byte, v interface{}) error {
+	b, err := Decompress(data)
+	if err != nil {
+		return err
+	}
+	return g.codec().Unmarshal(b, v)
+}
+
+// Name returns the name of serialization format, which is the name of wrapped
+// codec suffixed by "+gzip".
+func (g Gzip) Name() string {
+	return g.codec().Name() + "+gzip"
+}
+
+// codec returns the wrapped codec.
+func (g Gzip) codec() data.Codec {
+	if g.Codec == nil {
+		return Msgpack{}
+	}
+	return g.Codec
+}
+
+// Compress prefixes b by a header, compressing it by gzip whether it is larger
+// than threshold bytes.
+func Compress(b []byte, threshold int) ([]byte, error) {
+	if len(b) <= threshold {
+		return append([]byte{headerMagic, uncompressedHeader}, b...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{headerMagic, gzipHeader})
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the data prefixed by a header by Compress, decompressing
+// it whether it was compressed.
+//
+// Errors:
+// ErrInvalidHeader when b does not start with a known header.
+func Decompress(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != headerMagic {
+		return nil, ErrInvalidHeader
+	}
+
+	switch b[1] {
+	case uncompressedHeader:
+		return b[2:], nil
+	case gzipHeader:
+		r, err := gzip.NewReader(bytes.NewReader(b[2:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, ErrInvalidHeader
+}
+
+var _ data.Codec = Gzip{}
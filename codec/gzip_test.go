@@ -0,0 +1,157 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+// A payload represents a multi-KB JSON blob, as cached by web services.
+type payload struct {
+	ID    int      `json:"id"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+	Body  string   `json:"body"`
+}
+
+func newPayload() payload {
+	return payload{
+		ID:    42,
+		Title: "Lorem ipsum",
+		Tags:  []string{"lorem", "ipsum", "dolor"},
+		Body:  strings.Repeat("Lorem ipsum dolor sit amet, consectetur. ", 100),
+	}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	value := newPayload()
+	small := Gzip{Codec: JSON{}, Threshold: 1 << 20}
+	large := Gzip{Codec: JSON{}, Threshold: 256}
+
+	plain, err := small.Marshal(value)
+	if err != nil {
+		t.Fatalf("Could not marshal value: %v", err)
+	}
+	compressed, err := large.Marshal(value)
+	if err != nil {
+		t.Fatalf("Could not marshal value: %v", err)
+	}
+	if plain[0] != headerMagic || plain[1] != uncompressedHeader ||
+		compressed[0] != headerMagic || compressed[1] != gzipHeader {
+		t.Errorf("Unexpected headers: %v, %v", plain[:2], compressed[:2])
+	}
+	if len(compressed) >= len(plain) {
+		t.Errorf("Compressed size %d should be smaller than %d",
+			len(compressed), len(plain))
+	}
+
+	// Values encoded by distinct thresholds are decoded alike
+	for _, b := range [][]byte{plain, compressed} {
+		var result payload
+		if err := large.Unmarshal(b, &result); err != nil {
+			t.Fatalf("Could not unmarshal value: %v", err)
+		}
+		if result.Body != value.Body || result.ID != value.ID {
+			t.Errorf("Unexpected value: %+v", result)
+		}
+	}
+
+	if name := large.Name(); name != "json+gzip" {
+		t.Errorf("Unexpected codec name: %s", name)
+	}
+}
+
+func TestDecompressInvalidHeader(t *testing.T) {
+	for _, b := range [][]byte{nil, {headerMagic}, {0xff, 0x01}, {0x00, 0x61}} {
+		if _, err := Decompress(b); err != ErrInvalidHeader {
+			t.Errorf("Expected ErrInvalidHeader for %v but got %v", b, err)
+		}
+	}
+
+	b, err := Decompress([]byte{headerMagic, uncompressedHeader, 1, 2})
+	if err != nil || !bytes.Equal(b, []byte{1, 2}) {
+		t.Errorf("Unexpected decompressed data %v: %v", b, err)
+	}
+}
+
+func TestGzipNotDecodedByWrappedCodec(t *testing.T) {
+	for _, c := range []data.Codec{Msgpack{}, JSON{}} {
+		for _, threshold := range []int{1 << 20, 0} {
+			b, err := Gzip{Codec: c, Threshold: threshold}.Marshal("abc")
+			if err != nil {
+				t.Fatalf("Could not marshal value: %v", err)
+			}
+
+			var value interface{}
+			if err := c.Unmarshal(b, &value); err == nil {
+				t.Errorf("%s should not decode %v, got %v",
+					c.Name(), b, value)
+			}
+		}
+	}
+}
+
+func BenchmarkGzipMarshal(b *testing.B) {
+	benchmarkMarshal(Gzip{Codec: JSON{}, Threshold: 256}, b)
+}
+
+func BenchmarkJSONMarshal(b *testing.B) {
+	benchmarkMarshal(JSON{}, b)
+}
+
+func BenchmarkGzipUnmarshal(b *testing.B) {
+	benchmarkUnmarshal(Gzip{Codec: JSON{}, Threshold: 256}, b)
+}
+
+func BenchmarkJSONUnmarshal(b *testing.B) {
+	benchmarkUnmarshal(JSON{}, b)
+}
+
+// benchmarkMarshal encodes a representative payload, reporting the size of
+// its encoding.
+func benchmarkMarshal(c data.Codec, b *testing.B) {
+	value := newPayload()
+	var size int
+	for i := 0; i < b.N; i++ {
+		enc, err := c.Marshal(value)
+		if err != nil {
+			b.Fatalf("Could not marshal value: %v", err)
+		}
+		size = len(enc)
+	}
+	b.ReportMetric(float64(size), "bytes/value")
+}
+
+// benchmarkUnmarshal decodes the encoding of a representative payload.
+func benchmarkUnmarshal(c data.Codec, b *testing.B) {
+	enc, err := c.Marshal(newPayload())
+	if err != nil {
+		b.Fatalf("Could not marshal value: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result payload
+		if err := c.Unmarshal(enc, &result); err != nil {
+			b.Fatalf("Could not unmarshal value: %v", err)
+		}
+	}
+}
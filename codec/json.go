@@ -0,0 +1,46 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"encoding/json"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+// A JSON represents the JSON serialization format, which is readable by
+// services written in other languages. Numbers decoded into an empty interface
+// are float64, since JSON does not distinguish integers.
+type JSON struct{}
+
+// Marshal returns the JSON encoding of v.
+func (JSON) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON-encoded data and stores the result in the value
+// pointed to by v.
+func (JSON) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// Name returns the name of serialization format.
+func (JSON) Name() string {
+	return "json"
+}
+
+var _ data.Codec = JSON{}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import "testing"
+
+func TestJSONRoundTrip(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	c := JSON{}
+	value := user{"John", 30}
+
+	b, err := c.Marshal(value)
+	if err != nil {
+		t.Fatalf("Could not marshal value: %v", err)
+	}
+	if string(b) != `{"name":"John","age":30}` {
+		t.Errorf("Unexpected JSON encoding: %s", b)
+	}
+
+	var result user
+	if err := c.Unmarshal(b, &result); err != nil {
+		t.Fatalf("Could not unmarshal value: %v", err)
+	}
+	if result != value {
+		t.Errorf("Expected '%v' got '%v'", value, result)
+	}
+}
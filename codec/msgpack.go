@@ -0,0 +1,43 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// A Msgpack represents the MessagePack serialization format.
+type Msgpack struct{}
+
+// Marshal returns the MessagePack encoding of v.
+func (Msgpack) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal decodes the MessagePack-encoded data and stores the result in the
+// value pointed to by v.
+func (Msgpack) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// Name returns the name of serialization format.
+func (Msgpack) Name() string {
+	return "msgpack"
+}
+
+var _ data.Codec = Msgpack{}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"github.com/golang/protobuf/proto"
+	"gopkg.in/raiqub/data.v0"
+)
+
+// A Proto represents the Protocol Buffers serialization format. It supports
+// only values that implement proto.Message, hence the reference to retrieve a
+// value must be a pointer to generated message type.
+type Proto struct{}
+
+// Marshal returns the Protocol Buffers encoding of v.
+//
+// Errors:
+// InvalidTypeError when v does not implement proto.Message.
+func (Proto) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, data.NewInvalidTypeError(v)
+	}
+
+	return proto.Marshal(m)
+}
+
+// Unmarshal decodes the Protocol Buffers-encoded data and stores the result in
+// the message pointed to by v.
+//
+// Errors:
+// InvalidTypeError when v does not implement proto.Message.
+func (Proto) Unmarshal(b []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return data.NewInvalidTypeError(v)
+	}
+
+	return proto.Unmarshal(b, m)
+}
+
+// Name returns the name of serialization format.
+func (Proto) Name() string {
+	return "protobuf"
+}
+
+var _ data.Codec = Proto{}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codec
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	c := Proto{}
+	msg := &wrappers.StringValue{Value: "raiqub"}
+
+	b, err := c.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Could not marshal message: %v", err)
+	}
+
+	result := &wrappers.StringValue{}
+	if err := c.Unmarshal(b, result); err != nil {
+		t.Fatalf("Could not unmarshal message: %v", err)
+	}
+	if !proto.Equal(msg, result) {
+		t.Errorf("Expected '%v' got '%v'", msg, result)
+	}
+}
+
+func TestProtoInvalidType(t *testing.T) {
+	c := Proto{}
+
+	if _, err := c.Marshal(15); err == nil {
+		t.Error("A value that is not a message should not be marshaled")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	var result string
+	if err := c.Unmarshal([]byte{}, &result); err == nil {
+		t.Error("A value that is not a message should not be unmarshaled")
+	}
+}
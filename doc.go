@@ -35,6 +35,9 @@ The expiration behaviour can be changed calling 'SetTransient()' to define
 whether the lifetime of stored value is fixed (transient) or is extended when
 it is read or written (non-transient).
 
+A Store is closed calling 'Close()', which releases its resources and stops its
+background work. A closed Store may refuse later calls by 'ErrStoreClosed'.
+
 LifetimeScope
 
 A LifetimeScope which stored values will be affected by lifetime change.
@@ -47,5 +50,41 @@ are read or written, and the ones that will be created on the future.
 
 Use 'ScopeNew' to apply the new lifetime only for the ones that will be created
 on the future.
+
+Callbacks
+
+Stores that accept user-supplied callbacks, like the value middleware defined by
+'SetValueMiddleware()', recover from any panic raised by them and return it as a
+CallbackPanicError. A buggy callback then fails the operation which called it
+instead of crashing the application or leaving the store locked.
+
+Wrappers
+
+A Store can be wrapped to change how operations reach it. 'Recording()' records
+every operation to be replayed later, and 'HashKeys()' replaces every key by its
+hash, which keeps keys compact when natural keys are long, like URLs.
+'RateLimited()' limits the rate of writes reaching a Store, protecting its
+backend from write storms while reads are never limited.
+'Namespace()' prepends a namespace to every key, so many logical stores can
+share a single backend, and its Flush deletes only the values of the namespace.
+'ReadOnly()' returns a view which reads a Store while every operation that
+would modify it returns a ReadOnlyError. Its SetTransient silently does nothing
+instead, since it cannot return an error, and its Get still behaves as Get of
+the Store, renewing lifetimes and consuming uses.
+
+Many stores can act as tiers of a single cache by 'NewChainStore()', like a
+memory store in front of a MongoDB store. Reads promote values found on a lower
+tier to the higher ones, and writes go through every tier.
+
+A Store wrapped by 'NewLoader()' loads missing values on demand by
+'GetOrLoad()', which runs a single loader per key while concurrent callers
+missing the same key wait for its value. A loader returning 'ErrNotFound' marks
+the key as known to be absent, once 'SetNegativeLifetime()' is called.
+
+A Store can also be adapted by 'NewCache()' to the simpler cache interface,
+whose methods report a missing value by a boolean instead of returning errors.
+When every value has the same type, 'NewTypedStore()' returns a TypedStore whose
+values are read and written as that type, so a mismatching value is rejected at
+compile time.
 */
 package data
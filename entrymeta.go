@@ -0,0 +1,32 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import "time"
+
+// An EntryMeta represents the metadata of a stored value.
+type EntryMeta struct {
+	// CreatedAt defines when the value was added.
+	CreatedAt time.Time
+	// UpdatedAt defines when the value was last written.
+	UpdatedAt time.Time
+	// ExpiresAt defines when the value expires, unless it is renewed.
+	ExpiresAt time.Time
+	// Hits defines how many times the value was read, whether it is tracked by
+	// the store.
+	Hits uint64
+}
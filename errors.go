@@ -17,9 +17,43 @@
 package data
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/raiqub/dot.v1"
 )
 
+// ErrNegativelyCached is returned when a value is missing and its key is known
+// to be absent, as it was negatively cached. It allows callers to skip a
+// lookup on the backing source of values.
+var ErrNegativelyCached = errors.New("The key is known to be absent")
+
+// ErrNotFound is returned by a loader of Loader when the source of values
+// misses the requested key, so the key can be negatively cached.
+var ErrNotFound = errors.New("The value was not found on its source")
+
+// isMissing returns whether err reports a missing value, which is either
+// InvalidKeyError or ErrNegativelyCached.
+func isMissing(err error) bool {
+	if err == ErrNegativelyCached {
+		return true
+	}
+	_, ok := err.(dot.InvalidKeyError)
+	return ok
+}
+
+// ErrEmpty is returned when an item is requested from an empty queue.
+var ErrEmpty = errors.New("The queue is empty")
+
+// ErrRateLimited is returned when a write is refused by a RateLimitedStore for
+// exceeding its rate limit.
+var ErrRateLimited = errors.New("The rate limit of writes was exceeded")
+
+// ErrStoreClosed is returned when a store is used after it was closed.
+var ErrStoreClosed = errors.New("The store is closed")
+
 // A InvalidTypeError represents an error when value type is different than
 // expected.
 type InvalidTypeError struct {
@@ -35,3 +69,85 @@ func NewInvalidTypeError(value interface{}) InvalidTypeError {
 func (e InvalidTypeError) Error() string {
 	return fmt.Sprintf("Unexpected type: %T", e.Value)
 }
+
+// A InvalidFieldError represents an error when a field could not be found on a
+// stored value.
+type InvalidFieldError struct {
+	Key   string
+	Field string
+}
+
+// NewInvalidFieldError returns a new instance of InvalidFieldError.
+func NewInvalidFieldError(key, field string) InvalidFieldError {
+	return InvalidFieldError{key, field}
+}
+
+// Error returns string representation of current instance error.
+func (e InvalidFieldError) Error() string {
+	return fmt.Sprintf("The field '%s' of key '%s' could not be found",
+		e.Field, e.Key)
+}
+
+// A CallbackPanicError represents a panic raised by a user-supplied callback,
+// which was recovered by the store.
+type CallbackPanicError struct {
+	Value interface{}
+}
+
+// NewCallbackPanicError returns a new instance of CallbackPanicError.
+func NewCallbackPanicError(value interface{}) CallbackPanicError {
+	return CallbackPanicError{value}
+}
+
+// Error returns string representation of current instance error.
+func (e CallbackPanicError) Error() string {
+	return fmt.Sprintf("Callback panic: %v", e.Value)
+}
+
+// A VersionConflictError represents an error when a value could not be
+// written since its stored version differs from the expected one, as it was
+// written meanwhile by someone else.
+type VersionConflictError struct {
+	Key      string
+	Expected uint64
+	Actual   uint64
+}
+
+// NewVersionConflictError returns a new instance of VersionConflictError.
+func NewVersionConflictError(
+	key string, expected, actual uint64,
+) VersionConflictError {
+	return VersionConflictError{key, expected, actual}
+}
+
+// Error returns string representation of current instance error.
+func (e VersionConflictError) Error() string {
+	return fmt.Sprintf("The version of key '%s' is %d instead of %d",
+		e.Key, e.Actual, e.Expected)
+}
+
+// A ReadOnlyError represents an error when a operation which would modify a
+// store is called on a read-only view of it, holding the name of operation.
+type ReadOnlyError string
+
+// Error returns string representation of current instance error.
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("The operation '%s' is not allowed on a read-only store",
+		string(e))
+}
+
+// A BatchError represents the errors of a batch operation by key, which failed
+// only for those keys while it succeeded for every other key.
+type BatchError map[string]error
+
+// Error returns string representation of current instance error.
+func (e BatchError) Error() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return fmt.Sprintf("The operation failed for keys: %s",
+		strings.Join(keys, ", "))
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import "math"
+
+// IncrementMapField increments by delta the numeric value of specified field
+// of m, which is a generic representation of a decoded struct. The field value
+// is replaced by an int64 holding the result. A floating-point value is
+// accepted only whether it is integral, like numbers decoded from JSON.
+//
+// Errors:
+// InvalidFieldError when field could not be found on m.
+// InvalidTypeError when field value is not numeric or not integral.
+func IncrementMapField(
+	m map[string]interface{}, key, field string, delta int64,
+) (int64, error) {
+	v, ok := m[field]
+	if !ok {
+		return 0, NewInvalidFieldError(key, field)
+	}
+
+	var value int64
+	switch t := v.(type) {
+	case int:
+		value = int64(t)
+	case int8:
+		value = int64(t)
+	case int16:
+		value = int64(t)
+	case int32:
+		value = int64(t)
+	case int64:
+		value = t
+	case uint:
+		value = int64(t)
+	case uint8:
+		value = int64(t)
+	case uint16:
+		value = int64(t)
+	case uint32:
+		value = int64(t)
+	case uint64:
+		value = int64(t)
+	case float32:
+		if float64(t) != math.Trunc(float64(t)) {
+			return 0, NewInvalidTypeError(v)
+		}
+		value = int64(t)
+	case float64:
+		if t != math.Trunc(t) {
+			return 0, NewInvalidTypeError(v)
+		}
+		value = int64(t)
+	default:
+		return 0, NewInvalidTypeError(v)
+	}
+
+	value += delta
+	m[field] = value
+	return value, nil
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"testing"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestIncrementMapField(t *testing.T) {
+	m := map[string]interface{}{"i": int8(2), "f": float64(3), "h": 1.5}
+
+	if value, err := data.IncrementMapField(m, "k1", "i", 1); err != nil ||
+		value != 3 {
+		t.Errorf("Expected value 3 but got %d: %v", value, err)
+	}
+	if value, err := data.IncrementMapField(m, "k1", "f", 2); err != nil ||
+		value != 5 {
+		t.Errorf("Expected value 5 but got %d: %v", value, err)
+	}
+
+	_, err := data.IncrementMapField(m, "k1", "h", 1)
+	if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected InvalidTypeError for non-integral field but got %v",
+			err)
+	}
+	if m["h"] != 1.5 {
+		t.Errorf("A non-integral field should be kept but got %v", m["h"])
+	}
+
+	_, err = data.IncrementMapField(m, "k1", "missing", 1)
+	if _, ok := err.(data.InvalidFieldError); !ok {
+		t.Errorf("Expected InvalidFieldError but got %v", err)
+	}
+}
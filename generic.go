@@ -0,0 +1,114 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// GenericValue converts a value decoded into an empty interface to the
+// generic types shared by every store, so that the same stored value is
+// decoded equally regardless of store and serialization format:
+//
+//	integers           int64, or uint64 when it overflows int64
+//	floating-point     float64
+//	json.Number        int64 whether it is an integer; otherwise, float64
+//	maps               map[string]interface{} when every key is a string;
+//	                   otherwise map[interface{}]interface{}
+//	arrays and slices  []interface{}, except []byte which is kept
+//
+// The conversion is applied recursively to map and slice items, and any other
+// type is returned unchanged.
+func GenericValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return int64(t)
+	case int8:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case uint:
+		return genericUint(uint64(t))
+	case uint8:
+		return int64(t)
+	case uint16:
+		return int64(t)
+	case uint32:
+		return int64(t)
+	case uint64:
+		return genericUint(t)
+	case float32:
+		return float64(t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		for i := range t {
+			t[i] = GenericValue(t[i])
+		}
+		return t
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = GenericValue(t[k])
+		}
+		return t
+	case map[interface{}]interface{}:
+		return genericMap(t)
+	}
+
+	return v
+}
+
+// genericUint converts an unsigned integer to int64 whether it fits.
+func genericUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return v
+	}
+	return int64(v)
+}
+
+// genericMap converts the keys and values of specified map to generic types,
+// returning a map[string]interface{} when every key is a string.
+func genericMap(m map[interface{}]interface{}) interface{} {
+	isString := true
+	for k := range m {
+		if _, ok := k.(string); !ok {
+			isString = false
+			break
+		}
+	}
+
+	if isString {
+		result := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			result[k.(string)] = GenericValue(v)
+		}
+		return result
+	}
+
+	result := make(map[interface{}]interface{}, len(m))
+	for k, v := range m {
+		result[GenericValue(k)] = GenericValue(v)
+	}
+	return result
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package groupcachestore provides a read-through data store backed by
+groupcache, which shares loads among a set of peers.
+
+Store
+
+A Store fronts a groupcache group whose values are loaded from a backing
+'data.Store', which is the source of truth. It is initialized calling
+'groupcachestore.New()' function, and peers are set up through groupcache
+itself (e.g. 'groupcache.NewHTTPPool()').
+
+Groupcache values are immutable and never expire, hence only the read path of
+'data.Store' is supported. Get loads the value from the peer that owns the key,
+which loads it from the backing store once. Every method which writes values,
+Count, Flush and SetLifetime return NotSupportedError, while SetTransient has
+no effect. Values should be written to the backing store instead and must not
+change afterwards, since a loaded value is kept until evicted by groupcache.
+*/
+package groupcachestore
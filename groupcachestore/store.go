@@ -0,0 +1,161 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package groupcachestore
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/groupcache"
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/codec"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A Store provides a read-through key:value cache shared among groupcache
+// peers, which loads values from a backing store.
+//
+// It is a implementation of Store interface, supporting only its read path.
+type Store struct {
+	group   *groupcache.Group
+	backend data.Store
+	codec   data.Codec
+}
+
+// New creates a new instance of Store on a new groupcache group, named by
+// specified name and limited to cacheBytes, which loads values from backend.
+// As required by groupcache, the name must be unique for current process.
+func New(name string, cacheBytes int64, backend data.Store) *Store {
+	s := &Store{
+		backend: backend,
+		codec:   codec.Msgpack{},
+	}
+	s.group = groupcache.NewGroup(name, cacheBytes,
+		groupcache.GetterFunc(s.load))
+	return s
+}
+
+// Add is not supported, since groupcache values are immutable.
+func (s *Store) Add(key string, value interface{}) error {
+	return dot.NotSupportedError("Add")
+}
+
+// Close closes the backend store. The groupcache group cannot be removed, so
+// it keeps its cached values until the process exits.
+func (s *Store) Close() error {
+	return s.backend.Close()
+}
+
+// Count is not supported, since groupcache values are spread among peers.
+func (s *Store) Count() (int, error) {
+	return 0, dot.NotSupportedError("Count")
+}
+
+// Delete is not supported, since groupcache values cannot be removed.
+func (s *Store) Delete(key string) error {
+	return dot.NotSupportedError("Delete")
+}
+
+// Exists reports whether a value is stored by specified key on backing store,
+// since groupcache cannot report whether a value is cached without loading it.
+func (s *Store) Exists(key string) (bool, error) {
+	return s.backend.Exists(key)
+}
+
+// Flush is not supported, since groupcache values cannot be removed.
+func (s *Store) Flush() error {
+	return dot.NotSupportedError("Flush")
+}
+
+// Get gets the value stored by specified key, loading it from backing store
+// when it is not cached by any peer.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// data.InvalidTypeError when the value cannot be decoded into the type of ref.
+func (s *Store) Get(key string, ref interface{}) error {
+	var b []byte
+	err := s.group.Get(context.Background(), key,
+		groupcache.AllocatingByteSliceSink(&b))
+	if err != nil {
+		return err
+	}
+
+	if err := s.codec.Unmarshal(b, ref); err != nil {
+		return data.NewInvalidTypeError(ref)
+	}
+	if p, ok := ref.(*interface{}); ok {
+		*p = data.GenericValue(*p)
+	}
+	return nil
+}
+
+// Group returns the groupcache group used by current instance.
+func (s *Store) Group() *groupcache.Group {
+	return s.group
+}
+
+// Keys is not supported, since groupcache values are spread among peers.
+func (s *Store) Keys() ([]string, error) {
+	return nil, dot.NotSupportedError("Keys")
+}
+
+// Range is not supported, since groupcache values are spread among peers.
+func (s *Store) Range(fn func(key string, value interface{}) bool) error {
+	return dot.NotSupportedError("Range")
+}
+
+// Set is not supported, since groupcache values are immutable.
+func (s *Store) Set(key string, value interface{}) error {
+	return dot.NotSupportedError("Set")
+}
+
+// SetLifetime is not supported, since groupcache values never expire.
+func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	return dot.NotSupportedError("SetLifetime")
+}
+
+// SetTransient has no effect, since groupcache values are never renewed.
+func (s *Store) SetTransient(value bool) {
+}
+
+// TTL is not supported, since groupcache values never expire.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	return 0, dot.NotSupportedError("TTL")
+}
+
+// Touch is not supported, since groupcache values never expire.
+func (s *Store) Touch(key string) error {
+	return dot.NotSupportedError("Touch")
+}
+
+// load gets the value stored by specified key from backing store and encodes
+// it into groupcache sink.
+func (s *Store) load(ctx context.Context, key string, dest groupcache.Sink) error {
+	var value interface{}
+	if err := s.backend.Get(key, &value); err != nil {
+		return err
+	}
+
+	b, err := s.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(b)
+}
+
+var _ data.Store = (*Store)(nil)
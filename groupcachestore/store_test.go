@@ -0,0 +1,100 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package groupcachestore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+func TestReadThrough(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	backend := memstore.New(time.Minute, false)
+	store := New("TestReadThrough", 1<<20, backend)
+
+	if err := backend.Add("u1", user{"John", 30}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	var result user
+	if err := store.Get("u1", &result); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	if expected := (user{"John", 30}); result != expected {
+		t.Errorf("Expected '%v' got '%v'", expected, result)
+	}
+
+	// A loaded value is kept even when backing store is changed
+	backend.Set("u1", user{"Mary", 25})
+	if err := store.Get("u1", &result); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	if result.Name != "John" {
+		t.Errorf("The cached value should be kept but got '%v'", result)
+	}
+
+	if err := store.Get("u2", &result); err == nil {
+		t.Error("A missing key should return an error")
+	}
+}
+
+func TestGetTypes(t *testing.T) {
+	backend := memstore.New(time.Minute, false)
+	store := New("TestGetTypes", 1<<20, backend)
+	backend.Add("v1", map[string]int{"age": 30})
+
+	var generic interface{}
+	if err := store.Get("v1", &generic); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	expected := map[string]interface{}{"age": int64(30)}
+	if !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, generic)
+	}
+
+	var num int
+	if err := store.Get("v1", &num); err == nil {
+		t.Error("A value of another type should not be decoded")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected type error but got %v", err)
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	store := New("TestNotSupported", 1<<20, memstore.New(time.Minute, false))
+
+	if err := store.Add("v1", 1); err == nil {
+		t.Error("Add should not be supported")
+	} else if _, ok := err.(dot.NotSupportedError); !ok {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, ok := interface{}(store).(data.AtomicStore); ok {
+		t.Error("Atomic operations should not be supported")
+	}
+	if err := store.Delete("v1"); err == nil {
+		t.Error("Delete should not be supported")
+	}
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"time"
+
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A hashedValue represents a value stored by a hashed key along with its
+// original key.
+type hashedValue struct {
+	Key   string      `msgpack:"k"`
+	Value interface{} `msgpack:"v"`
+}
+
+// A hashedKeyStore represents a Store which replaces every key by its hash.
+type hashedKeyStore struct {
+	Store
+	hash func(string) string
+}
+
+// HashKeys returns a Store which replaces every key by its hash before
+// delegating to s, keeping keys compact and bounded regardless of the length
+// of original keys. When hash is nil the hexadecimal SHA-256 of the key is
+// used.
+//
+// The original key is stored along with each value written by Add or Set, so
+// that Keys returns original keys whether s provides Keys and Range. Integers
+// written by atomic operations are stored as is, hence their hashed keys are
+// returned instead. The value is stored on a structure, so s must use a codec
+// that supports any Go value, like MessagePack.
+//
+// Two keys having the same hash are handled as the same key. It is
+// astronomically unlikely using SHA-256, but a weaker hash function must be
+// chosen carefully.
+func HashKeys(s Store, hash func(string) string) Store {
+	if hash == nil {
+		hash = SHA256Key
+	}
+	return &hashedKeyStore{s, hash}
+}
+
+// SHA256Key returns the hexadecimal SHA-256 hash of specified key.
+func SHA256Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Add adds a new value to wrapped store by the hash of specified key.
+func (s *hashedKeyStore) Add(key string, value interface{}) error {
+	return s.Store.Add(s.hash(key), hashedValue{key, value})
+}
+
+// Decrement decrements the value stored by the hash of specified key.
+func (s *hashedKeyStore) Decrement(key string) (int, error) {
+	as, err := atomicOf(s.Store, "Decrement")
+	if err != nil {
+		return 0, err
+	}
+	return as.Decrement(s.hash(key))
+}
+
+// DecrementBy decrements the value stored by the hash of specified key.
+func (s *hashedKeyStore) DecrementBy(key string, value int) (int, error) {
+	as, err := atomicOf(s.Store, "DecrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.DecrementBy(s.hash(key), value)
+}
+
+// Delete deletes the value stored by the hash of specified key.
+func (s *hashedKeyStore) Delete(key string) error {
+	return s.Store.Delete(s.hash(key))
+}
+
+// Exists reports whether a value is stored by the hash of specified key.
+func (s *hashedKeyStore) Exists(key string) (bool, error) {
+	return s.Store.Exists(s.hash(key))
+}
+
+// Get gets the value stored by the hash of specified key.
+func (s *hashedKeyStore) Get(key string, ref interface{}) error {
+	refVal := reflect.ValueOf(ref)
+	if refVal.Kind() != reflect.Ptr || refVal.IsNil() {
+		return NewInvalidTypeError(ref)
+	}
+
+	// The value is decoded into a structure like hashedValue whose Value
+	// field has the type referenced by ref.
+	typ := reflect.StructOf([]reflect.StructField{
+		{
+			Name: "Key",
+			Type: reflect.TypeOf(""),
+			Tag:  `msgpack:"k"`,
+		},
+		{
+			Name: "Value",
+			Type: refVal.Type().Elem(),
+			Tag:  `msgpack:"v"`,
+		},
+	})
+	hv := reflect.New(typ)
+	hashed := s.hash(key)
+	if err := s.Store.Get(hashed, hv.Interface()); err != nil {
+		// Integers written by atomic operations are not wrapped
+		if _, ok := err.(dot.InvalidKeyError); !ok &&
+			s.Store.Get(hashed, ref) == nil {
+			return nil
+		}
+		return err
+	}
+
+	refVal.Elem().Set(hv.Elem().Field(1))
+	return nil
+}
+
+// GetAndReset gets and resets the value stored by the hash of specified key.
+func (s *hashedKeyStore) GetAndReset(key string) (int, error) {
+	rs, err := resettableOf(s.Store)
+	if err != nil {
+		return 0, err
+	}
+	return rs.GetAndReset(s.hash(key))
+}
+
+// Increment increments the value stored by the hash of specified key.
+func (s *hashedKeyStore) Increment(key string) (int, error) {
+	as, err := atomicOf(s.Store, "Increment")
+	if err != nil {
+		return 0, err
+	}
+	return as.Increment(s.hash(key))
+}
+
+// IncrementBy increments the value stored by the hash of specified key.
+func (s *hashedKeyStore) IncrementBy(key string, value int) (int, error) {
+	as, err := atomicOf(s.Store, "IncrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.IncrementBy(s.hash(key), value)
+}
+
+// Keys gets the original keys of values stored by wrapped store, which are
+// read by Range, so their lifetimes are not renewed.
+//
+// Errors:
+// NotSupportedError when wrapped store does not support Keys or Range.
+func (s *hashedKeyStore) Keys() ([]string, error) {
+	hashed, err := s.Store.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike Get, Range neither renews lifetimes nor counts hits
+	originals := make(map[string]string, len(hashed))
+	err = s.Store.Range(func(h string, value interface{}) bool {
+		if key, _ := unhashValue(h, value); key != h {
+			originals[h] = key
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(hashed))
+	for _, h := range hashed {
+		if key, ok := originals[h]; ok {
+			h = key
+		}
+		keys = append(keys, h)
+	}
+
+	return keys, nil
+}
+
+// Range calls fn for each value stored by wrapped store along with its original
+// key. Integers written by atomic operations are reported by their hashed keys.
+func (s *hashedKeyStore) Range(fn func(key string, value interface{}) bool) error {
+	return s.Store.Range(func(key string, value interface{}) bool {
+		return fn(unhashValue(key, value))
+	})
+}
+
+// unhashValue returns the original key and value of a hashedValue stored by
+// specified hashed key, as decoded by Range. Other values, like integers
+// written by atomic operations, are returned as is.
+func unhashValue(key string, value interface{}) (string, interface{}) {
+	// Values are decoded as generic maps of hashedValue fields
+	if m, ok := value.(map[string]interface{}); ok && len(m) == 2 {
+		if k, ok := m["k"].(string); ok {
+			return k, m["v"]
+		}
+	}
+	return key, value
+}
+
+// Set sets the value stored by the hash of specified key.
+func (s *hashedKeyStore) Set(key string, value interface{}) error {
+	return s.Store.Set(s.hash(key), hashedValue{key, value})
+}
+
+// Touch renews the lifetime of the value stored by the hash of specified key.
+func (s *hashedKeyStore) Touch(key string) error {
+	return s.Store.Touch(s.hash(key))
+}
+
+// TTL gets the remaining lifetime of the value stored by the hash of specified
+// key.
+func (s *hashedKeyStore) TTL(key string) (time.Duration, error) {
+	return s.Store.TTL(s.hash(key))
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+func TestHashKeys(t *testing.T) {
+	type page struct {
+		Title string
+		Size  int
+	}
+
+	ring := memstore.NewRingStore(10)
+	store := data.HashKeys(ring, nil)
+	url := "https://example.com/" + strings.Repeat("path/", 100)
+
+	if err := store.Add(url, page{"Example", 1024}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	if err := store.Add(url, page{}); err == nil {
+		t.Error("The same key should not be added twice")
+	}
+
+	var result page
+	if err := store.Get(url, &result); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	if expected := (page{"Example", 1024}); result != expected {
+		t.Errorf("Expected '%v' got '%v'", expected, result)
+	}
+
+	if _, err := store.(data.AtomicStore).Increment("counter"); err != nil {
+		t.Errorf("Could not increment value: %v", err)
+	}
+	var count int
+	if err := store.Get("counter", &count); err != nil || count != 1 {
+		t.Errorf("Expected counter 1 but got %d: %v", count, err)
+	}
+
+	hashed, _ := ring.Keys()
+	expected := []string{data.SHA256Key(url), data.SHA256Key("counter")}
+	if !reflect.DeepEqual(hashed, expected) {
+		t.Errorf("Expected hashed keys %v got %v", expected, hashed)
+	}
+
+	keys, err := store.Keys()
+	if err != nil {
+		t.Fatalf("Could not get keys: %v", err)
+	}
+	if keys[0] != url {
+		t.Errorf("Expected original key '%s' got '%s'", url, keys[0])
+	}
+
+	ranged := make(map[string]interface{})
+	err = store.Range(func(key string, value interface{}) bool {
+		ranged[key] = value
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Could not range values: %v", err)
+	}
+	if _, ok := ranged[url].(map[string]interface{}); !ok {
+		t.Errorf("Expected value of original key but got %v", ranged)
+	}
+
+	if err := store.Get("missing", &result); err == nil {
+		t.Error("A missing key should return an error")
+	}
+}
+
+// A noKeysStore represents a store which does not support listing its keys.
+type noKeysStore struct {
+	data.Store
+}
+
+func (noKeysStore) Keys() ([]string, error) {
+	return nil, dot.NotSupportedError("Keys")
+}
+
+func TestHashKeysNotSupported(t *testing.T) {
+	store := data.HashKeys(noKeysStore{memstore.New(time.Minute, false)}, nil)
+	_, err := store.Keys()
+	if err == nil {
+		t.Error("Keys should not be supported by wrapped store")
+	}
+}
+
+func TestHashKeysWithoutHits(t *testing.T) {
+	backend := memstore.New(time.Minute, false)
+	store := data.HashKeys(backend, nil)
+	if err := store.Add("k1", "lorem"); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	keys, err := store.Keys()
+	if err != nil || !reflect.DeepEqual(keys, []string{"k1"}) {
+		t.Errorf("Expected original key k1 but got %v: %v", keys, err)
+	}
+	if stats, _ := backend.Stats(); stats.Hits != 0 {
+		t.Errorf("Listing keys should not count hits but got %d", stats.Hits)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpcache
+
+import (
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A response represents a cached HTTP response.
+type response struct {
+	Status   int
+	Body     []byte
+	ExpireAt time.Time
+}
+
+// A ResponseCache provides caching of HTTP responses on a data store.
+type ResponseCache struct {
+	store data.Store
+}
+
+// New creates a new instance of ResponseCache which stores responses on
+// specified store.
+func New(store data.Store) *ResponseCache {
+	return &ResponseCache{store}
+}
+
+// Load loads the status code and body of response cached by specified key.
+// It returns false when the response is not cached or when its time-to-live
+// has elapsed.
+func (c *ResponseCache) Load(key string) (status int, body []byte, ok bool) {
+	var r response
+	if err := c.store.Get(key, &r); err != nil {
+		return 0, nil, false
+	}
+
+	if time.Now().After(r.ExpireAt) {
+		c.store.Delete(key)
+		return 0, nil, false
+	}
+
+	return r.Status, r.Body, true
+}
+
+// Store caches the status code and body of a response by specified key for
+// ttl duration, replacing any response already cached by same key.
+func (c *ResponseCache) Store(
+	key string, status int, body []byte, ttl time.Duration,
+) error {
+	r := response{status, body, time.Now().Add(ttl)}
+
+	err := c.store.Add(key, r)
+	if _, ok := err.(dot.DuplicatedKeyError); ok {
+		err = c.store.Set(key, r)
+	}
+
+	return err
+}
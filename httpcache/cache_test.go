@@ -0,0 +1,61 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache := New(memstore.New(time.Minute, false))
+	body := []byte(`{"name":"raiqub"}`)
+
+	if _, _, ok := cache.Load("/users/1"); ok {
+		t.Error("A missing response should not be loaded")
+	}
+
+	if err := cache.Store("/users/1", 200, body, time.Minute); err != nil {
+		t.Fatalf("Could not store response: %v", err)
+	}
+	if err := cache.Store("/users/2", 404, nil, time.Millisecond); err != nil {
+		t.Fatalf("Could not store response: %v", err)
+	}
+
+	status, result, ok := cache.Load("/users/1")
+	if !ok {
+		t.Fatal("The response was not cached")
+	}
+	if status != 200 || !bytes.Equal(result, body) {
+		t.Errorf("Unexpected response: %d %s", status, result)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	if _, _, ok := cache.Load("/users/2"); ok {
+		t.Error("The expired response should not be loaded")
+	}
+
+	if err := cache.Store("/users/1", 304, nil, time.Minute); err != nil {
+		t.Fatalf("Could not replace response: %v", err)
+	}
+	if status, _, _ := cache.Load("/users/1"); status != 304 {
+		t.Errorf("The response was not replaced: %d", status)
+	}
+}
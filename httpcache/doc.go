@@ -0,0 +1,29 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package httpcache provides helpers to cache HTTP responses on data stores.
+
+ResponseCache
+
+A ResponseCache stores the status code and body of HTTP responses on any
+'data.Store', each one with its own time-to-live. It is initialized calling
+'httpcache.New()' function.
+
+The time-to-live of a response is checked when it is loaded, hence it is
+effective only when it is shorter than the lifetime of underlying store.
+*/
+package httpcache
@@ -0,0 +1,39 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package httpstore provides a HTTP handler exposing a data store as a REST
+cache.
+
+Handler
+
+A Handler maps HTTP requests onto any 'data.Store', so non-Go clients can share
+the values of a store through a uniform network API. It is initialized calling
+'httpstore.New()' function and serves the following requests, whose bodies are
+JSON:
+
+	GET    /cache/{key}  gets the value stored by key
+	PUT    /cache/{key}  stores the request body as the value of key
+	DELETE /cache/{key}  deletes the value stored by key
+
+A PUT request replaces the value of an existing key or adds it. When the
+request has the 'If-None-Match: *' header the value is only added, and the
+request fails by 409 Conflict whether the key already exists.
+
+A missing key is reported by 404 Not Found. Failed requests have a JSON body
+whose 'error' field describes the error.
+*/
+package httpstore
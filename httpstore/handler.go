@@ -0,0 +1,170 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpstore
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// PathPrefix is the prefix of the URL path of every request served by a
+// Handler, which is followed by the key.
+const PathPrefix = "/cache/"
+
+// maxBodySize defines the size in bytes of the largest body accepted by PUT.
+const maxBodySize = 1 << 20
+
+// An errorResponse represents the body of a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// A Handler represents a HTTP handler which exposes a store as a REST cache.
+type Handler struct {
+	store data.Store
+}
+
+// New creates a new instance of Handler which serves the values of specified
+// store.
+func New(store data.Store) *Handler {
+	return &Handler{store}
+}
+
+// ServeHTTP serves a GET, PUT or DELETE request on the value stored by the
+// key which follows PathPrefix on URL path.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		writeError(w, http.StatusNotFound, "The path is not valid")
+		return
+	}
+	key := r.URL.Path[len(PathPrefix):]
+	if key == "" {
+		writeError(w, http.StatusNotFound, "The key is missing")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, key)
+	case http.MethodPut:
+		h.put(w, r, key)
+	case http.MethodDelete:
+		h.delete(w, key)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "The method is not allowed")
+	}
+}
+
+// delete deletes the value stored by specified key.
+func (h *Handler) delete(w http.ResponseWriter, key string) {
+	if err := h.store.Delete(key); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// get writes the value stored by specified key as JSON.
+func (h *Handler) get(w http.ResponseWriter, key string) {
+	var value interface{}
+	if err := h.store.Get(key, &value); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// put stores the JSON body of r as the value of specified key, adding it
+// whether the key is missing or the request has 'If-None-Match: *' header.
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key string) {
+	value, err := readValue(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.Header.Get("If-None-Match") != "*" {
+		err := h.store.Set(key, value)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if _, ok := err.(dot.InvalidKeyError); !ok {
+			writeStoreError(w, err)
+			return
+		}
+		// A missing key is added
+	}
+
+	if err := h.store.Add(key, value); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// readValue decodes the JSON body of r, up to maxBodySize bytes, converting
+// numbers as GenericValue defines.
+func readValue(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return data.GenericValue(value), nil
+}
+
+// writeError writes a failed response with specified status, whose JSON body
+// describes the error by msg.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	b, _ := json.Marshal(errorResponse{msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// writeStoreError writes a failed response for an error returned by store,
+// whose status is defined by the type of err.
+func writeStoreError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case dot.InvalidKeyError:
+		status = http.StatusNotFound
+	case dot.DuplicatedKeyError:
+		status = http.StatusConflict
+	}
+	if err == data.ErrNegativelyCached {
+		status = http.StatusNotFound
+	}
+
+	writeError(w, status, err.Error())
+}
+
+var _ http.Handler = (*Handler)(nil)
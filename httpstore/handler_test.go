@@ -0,0 +1,113 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+// do serves a request by h, returning its response.
+func do(
+	h http.Handler, method, path, body string, header http.Header,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	h := New(store)
+
+	if w := do(h, "GET", "/cache/k1", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing key but got %d", w.Code)
+	}
+
+	// A missing key is added by PUT
+	w := do(h, "PUT", "/cache/k1", `{"name":"lorem","n":2}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 but got %d: %s", w.Code, w.Body)
+	}
+	var n struct {
+		N int `msgpack:"n"`
+	}
+	if err := store.Get("k1", &n); err != nil || n.N != 2 {
+		t.Errorf("Numbers should be stored as integers, got %d: %v", n.N, err)
+	}
+
+	w = do(h, "GET", "/cache/k1", "", nil)
+	if w.Code != http.StatusOK ||
+		w.Header().Get("Content-Type") != "application/json" ||
+		strings.TrimSpace(w.Body.String()) != `{"n":2,"name":"lorem"}` {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body)
+	}
+
+	// An existing key is replaced by PUT, unless it is asked to only add
+	if w := do(h, "PUT", "/cache/k1", `"ipsum"`, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 but got %d: %s", w.Code, w.Body)
+	}
+	addOnly := http.Header{"If-None-Match": {"*"}}
+	w = do(h, "PUT", "/cache/k1", `"dolor"`, addOnly)
+	if w.Code != http.StatusConflict ||
+		!strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("Expected 409 but got %d: %s", w.Code, w.Body)
+	}
+	if w := do(h, "GET", "/cache/k1", "", nil); w.Body.String() != `"ipsum"` {
+		t.Errorf("Unexpected value: %s", w.Body)
+	}
+	if w := do(h, "PUT", "/cache/k2", `1`, addOnly); w.Code != http.StatusCreated {
+		t.Errorf("Expected 201 but got %d: %s", w.Code, w.Body)
+	}
+
+	if w := do(h, "DELETE", "/cache/k1", "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 but got %d: %s", w.Code, w.Body)
+	}
+	if w := do(h, "DELETE", "/cache/k1", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 but got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestHandlerBadRequest(t *testing.T) {
+	h := New(memstore.New(time.Minute, false))
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"PUT", "/cache/k1", `{"name":`, http.StatusBadRequest},
+		{"POST", "/cache/k1", `1`, http.StatusMethodNotAllowed},
+		{"GET", "/cache/", "", http.StatusNotFound},
+		{"GET", "/other/k1", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := do(h, tt.method, tt.path, tt.body, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected %d but got %d",
+				tt.method, tt.path, tt.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"math"
+	"time"
+)
+
+// NoExpiration defines the TTL of a value which never expires.
+const NoExpiration = time.Duration(math.MaxInt64)
+
+// A KeyTTL represents a stored key and its remaining lifetime.
+type KeyTTL struct {
+	Key string
+	TTL time.Duration
+}
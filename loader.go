@@ -0,0 +1,115 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A NegativeCache represents a Store which marks keys as known to be absent,
+// like memstore.Store.
+type NegativeCache interface {
+	// AddNegative marks specified key as known to be absent for ttl
+	// duration.
+	AddNegative(key string, ttl time.Duration) error
+}
+
+// A Loader represents a Store whose missing values are loaded on demand,
+// running a single loader per key at a time.
+type Loader struct {
+	Store
+	group singleflight.Group
+	// negativeLifetime is accessed atomically.
+	negativeLifetime int64
+}
+
+// NewLoader returns a Loader which reads and stores loaded values by s.
+func NewLoader(s Store) *Loader {
+	return &Loader{Store: s}
+}
+
+// GetOrLoad gets the value stored by specified key into the value pointed to
+// by ref, or stores the value returned by loader whether the key is missing.
+//
+// Unlike GetOrAdd, concurrent callers missing the same key do not run loader
+// each: only one of them runs it while the others wait, then every caller
+// reads the value it stored. It avoids a thundering herd on an expensive
+// loader even when s cannot hold a lock while loader runs. An error returned
+// by loader is returned to every waiting caller without storing anything.
+//
+// A key known to be absent, like a key marked by memstore.Store.AddNegative,
+// is not loaded: its ErrNegativelyCached is returned as is, since the backing
+// source is known to miss it. A loader returning ErrNotFound marks the key as
+// known to be absent, whether a negative lifetime is defined by
+// SetNegativeLifetime and s is a NegativeCache.
+func (l *Loader) GetOrLoad(key string, ref interface{}, loader FactoryFunc) error {
+	err := l.Store.Get(key, ref)
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		return err
+	}
+
+	_, err, _ = l.group.Do(key, func() (interface{}, error) {
+		// A previous load may have completed since the value was missed
+		if ok, _ := l.Store.Exists(key); ok {
+			return nil, nil
+		}
+
+		value, err := CallFactoryFunc(loader)
+		if err == ErrNotFound {
+			l.addNegative(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = l.Store.Add(key, value)
+		if _, ok := err.(dot.DuplicatedKeyError); ok {
+			// The value added meanwhile by another writer takes precedence
+			return nil, nil
+		}
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.Store.Get(key, ref)
+}
+
+// SetNegativeLifetime defines for how long a key whose loader returned
+// ErrNotFound is known to be absent, whether wrapped store is a NegativeCache.
+// Zero or negative disables negative caching, which is the default.
+func (l *Loader) SetNegativeLifetime(d time.Duration) {
+	atomic.StoreInt64(&l.negativeLifetime, int64(d))
+}
+
+// addNegative marks specified key as known to be absent, whether negative
+// caching is enabled and supported by wrapped store.
+func (l *Loader) addNegative(key string) {
+	d := time.Duration(atomic.LoadInt64(&l.negativeLifetime))
+	nc, ok := l.Store.(NegativeCache)
+	if d < 1 || !ok {
+		return
+	}
+
+	// A value added meanwhile by another writer takes precedence
+	nc.AddNegative(key, d)
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestLoaderSingleFlight(t *testing.T) {
+	loader := data.NewLoader(memstore.New(time.Minute, false))
+	release := make(chan struct{})
+	var calls int32
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "lorem", nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var value string
+			if err := loader.GetOrLoad("k1", &value, load); err != nil {
+				errs <- err
+			} else if value != "lorem" {
+				errs <- errors.New("unexpected value " + value)
+			}
+		}()
+	}
+
+	// Lets every caller miss the value before it is loaded
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Could not load value: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected loader to run once but it ran %d times", calls)
+	}
+}
+
+func TestLoaderNegativelyCached(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	store.AddNegative("k1", time.Minute)
+	loader := data.NewLoader(store)
+
+	var value string
+	err := loader.GetOrLoad("k1", &value, func() (interface{}, error) {
+		t.Error("A negatively cached key should not be loaded")
+		return "lorem", nil
+	})
+	if err != data.ErrNegativelyCached {
+		t.Errorf("Expected ErrNegativelyCached but got %v", err)
+	}
+}
+
+func TestLoaderNotFound(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	loader := data.NewLoader(store)
+	loader.SetNegativeLifetime(time.Millisecond * 100)
+	var calls int
+	load := func() (interface{}, error) {
+		calls++
+		return nil, data.ErrNotFound
+	}
+
+	var value string
+	if err := loader.GetOrLoad("k1", &value, load); err != data.ErrNotFound {
+		t.Errorf("Expected ErrNotFound but got %v", err)
+	}
+	err := loader.GetOrLoad("k1", &value, load)
+	if err != data.ErrNegativelyCached {
+		t.Errorf("Expected ErrNegativelyCached but got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected loader to run once but it ran %d times", calls)
+	}
+
+	time.Sleep(time.Millisecond * 150)
+	if err := loader.GetOrLoad("k1", &value, load); err != data.ErrNotFound {
+		t.Errorf("Expected ErrNotFound once expired but got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected loader to run again but it ran %d times", calls)
+	}
+}
+
+func TestLoaderError(t *testing.T) {
+	loader := data.NewLoader(memstore.New(time.Minute, false))
+	errLoad := errors.New("load failed")
+
+	var value string
+	err := loader.GetOrLoad("k1", &value, func() (interface{}, error) {
+		return nil, errLoad
+	})
+	if err != errLoad {
+		t.Errorf("Expected loader error but got %v", err)
+	}
+	if ok, _ := loader.Exists("k1"); ok {
+		t.Errorf("A failed load should not store anything")
+	}
+}
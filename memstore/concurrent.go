@@ -0,0 +1,699 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/dot.v1"
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// A concurrentEntry represents a value managed by ConcurrentStore. Its encoded
+// value is never changed, a written value replaces the whole entry instead,
+// while its expiration is renewed in place.
+type concurrentEntry struct {
+	// expireAt holds when current value expires as Unix nanoseconds, while
+	// lifetime, generation and keyLifetime are like those of entry. They are
+	// accessed atomically.
+	expireAt    int64
+	lifetime    int64
+	generation  uint64
+	keyLifetime int32
+	value       []byte
+}
+
+// newConcurrentEntry creates a new entry for ConcurrentStore.
+func newConcurrentEntry(
+	now time.Time, lifetime time.Duration, generation uint64, value interface{},
+) (*concurrentEntry, error) {
+	b, err := msgpack.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &concurrentEntry{
+		expireAt:   now.Add(lifetime).UnixNano(),
+		lifetime:   int64(lifetime),
+		generation: generation,
+		value:      b,
+	}, nil
+}
+
+// ExpireAt returns when current value expires.
+func (e *concurrentEntry) ExpireAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&e.expireAt))
+}
+
+// Hit postpones expiration of current value to specified time added to its
+// lifetime, which is replaced by the lifetime of specified generation like
+// entry.SetLifetime does.
+func (e *concurrentEntry) Hit(now time.Time, g lifetimeGeneration) {
+	gen := atomic.LoadUint64(&e.generation)
+	if gen < g.gen && atomic.LoadInt32(&e.keyLifetime) == 0 &&
+		atomic.CompareAndSwapUint64(&e.generation, gen, g.gen) {
+		atomic.StoreInt64(&e.lifetime, int64(g.lifetime))
+	}
+	d := time.Duration(atomic.LoadInt64(&e.lifetime))
+	atomic.StoreInt64(&e.expireAt, now.Add(d).UnixNano())
+}
+
+// SetKeyLifetime defines a lifetime for current value, which is kept
+// regardless of the lifetime of store, and renews it from specified time.
+func (e *concurrentEntry) SetKeyLifetime(now time.Time, d time.Duration) {
+	atomic.StoreInt64(&e.lifetime, int64(d))
+	atomic.StoreInt32(&e.keyLifetime, 1)
+	atomic.StoreInt64(&e.expireAt, now.Add(d).UnixNano())
+}
+
+// IsExpired returns whether current value is expired at specified time.
+func (e *concurrentEntry) IsExpired(now time.Time) bool {
+	return now.UnixNano() > atomic.LoadInt64(&e.expireAt)
+}
+
+// Value decodes current value like entry.Value.
+//
+// Errors:
+// InvalidTypeError when the value cannot be decoded into the type of ref.
+func (e *concurrentEntry) Value(ref interface{}) error {
+	if err := msgpack.Unmarshal(e.value, ref); err != nil {
+		return data.NewInvalidTypeError(ref)
+	}
+
+	if p, ok := ref.(*interface{}); ok {
+		*p = data.GenericValue(*p)
+	}
+	return nil
+}
+
+// WithValue returns a copy of current entry holding specified value.
+func (e *concurrentEntry) WithValue(value interface{}) (*concurrentEntry, error) {
+	b, err := msgpack.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &concurrentEntry{
+		expireAt:    atomic.LoadInt64(&e.expireAt),
+		lifetime:    atomic.LoadInt64(&e.lifetime),
+		generation:  atomic.LoadUint64(&e.generation),
+		keyLifetime: atomic.LoadInt32(&e.keyLifetime),
+		value:       b,
+	}, nil
+}
+
+// A clockHolder holds a data.Clock, so clocks of distinct types can be stored
+// by the same atomic.Value.
+type clockHolder struct {
+	data.Clock
+}
+
+// A ConcurrentStore provides in-memory key:value cache that expires after
+// defined duration of time, like Store, built on a sync.Map instead of a
+// mutex. Values are replaced by compare-and-swap and their expiration is
+// renewed atomically, so readers never wait for writers. It suits read-heavy
+// workloads, at the cost of fewer features than Store.
+//
+// It is a implementation of Store interface.
+type ConcurrentStore struct {
+	values sync.Map
+	// lifetime, isTransient and gcRunning are accessed atomically.
+	lifetime    int64
+	isTransient int32
+	gcRunning   int32
+	clock       atomic.Value
+	// renewal holds the lifetimeGeneration given to existing values when they
+	// are renewed.
+	renewal atomic.Value
+	// gcDone holds the channel closed by Close to stop the running garbage
+	// collector.
+	gcDone atomic.Value
+	gcs    gcGroup
+}
+
+// NewConcurrent creates a new instance of ConcurrentStore and defines the
+// default lifetime for new stored items.
+//
+// If it is specified to not transient then the stored items lifetime are
+// renewed when it is read or written; Otherwise, it is never renewed.
+func NewConcurrent(d time.Duration, isTransient bool) *ConcurrentStore {
+	s := &ConcurrentStore{lifetime: int64(d)}
+	if isTransient {
+		s.isTransient = 1
+	}
+	s.clock.Store(clockHolder{data.SystemClock{}})
+	s.renewal.Store(lifetimeGeneration{})
+	s.gcDone.Store(make(chan struct{}))
+	return s
+}
+
+// Add adds a new key:value to current store.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *ConcurrentStore) Add(key string, value interface{}) error {
+	return s.add(key, value, 0)
+}
+
+// AddWithLifetime adds a new key:value to current store which expires by
+// specified lifetime instead of the lifetime of store, like Store does. A
+// lifetime lower than one defines the lifetime of store, as Add does.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *ConcurrentStore) AddWithLifetime(
+	key string, value interface{}, d time.Duration,
+) error {
+	return s.add(key, value, d)
+}
+
+// add adds a new key:value to current store with its own lifetime, whether
+// lifetime is positive.
+func (s *ConcurrentStore) add(
+	key string, value interface{}, lifetime time.Duration,
+) error {
+	v, err := newConcurrentEntry(
+		s.now(), s.getLifetime(), s.getRenewal().gen, value)
+	if err != nil {
+		return err
+	}
+	if lifetime > 0 {
+		v.SetKeyLifetime(s.now(), lifetime)
+	}
+
+	for {
+		actual, loaded := s.values.LoadOrStore(key, v)
+		if !loaded {
+			s.startGC()
+			return nil
+		}
+		if !actual.(*concurrentEntry).IsExpired(s.now()) {
+			return dot.DuplicatedKeyError(key)
+		}
+		// An expired value not yet collected is replaced
+		if s.values.CompareAndSwap(key, actual, v) {
+			return nil
+		}
+	}
+}
+
+func (s *ConcurrentStore) atomicInteger(key string, inc int) (int, error) {
+	for {
+		v, ok := s.load(key)
+		if !ok {
+			nv, err := newConcurrentEntry(
+				s.now(), s.getLifetime(), s.getRenewal().gen, inc)
+			if err != nil {
+				return 0, err
+			}
+			if _, loaded := s.values.LoadOrStore(key, nv); !loaded {
+				s.startGC()
+				return inc, nil
+			}
+			continue
+		}
+
+		var value int
+		if err := v.Value(&value); err != nil {
+			return 0, err
+		}
+
+		value += inc
+		if ok, err := s.swap(key, v, value); err != nil {
+			return 0, err
+		} else if ok {
+			return value, nil
+		}
+	}
+}
+
+// CompareAndSwap atomically sets the value of specified key to new, whether
+// its current value equals old, reporting whether the value was swapped. The
+// current value is decoded into the type of old and compared by
+// reflect.DeepEqual.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) CompareAndSwap(
+	key string, old, new interface{},
+) (bool, error) {
+	for {
+		v, ok := s.load(key)
+		if !ok {
+			return false, dot.InvalidKeyError(key)
+		}
+		if !equalValue(old, v.Value) {
+			return false, nil
+		}
+
+		// The value is compared again whether it was replaced meanwhile
+		if ok, err := s.swap(key, v, new); err != nil {
+			return false, err
+		} else if ok {
+			return true, nil
+		}
+	}
+}
+
+// Close stops the garbage collector of current store, including the ones
+// started by StartGC, and deletes every stored value, like Flush. The store
+// can still be used afterwards, running its garbage collector again on
+// demand.
+func (s *ConcurrentStore) Close() error {
+	s.gcs.stop()
+	// Values are deleted first, so the stopped collector is not restarted
+	s.Flush()
+	close(s.gcDone.Swap(make(chan struct{})).(chan struct{}))
+	return nil
+}
+
+// Count gets the number of stored values by current instance, excluding
+// expired values not removed by garbage collector yet.
+func (s *ConcurrentStore) Count() (int, error) {
+	now := s.now()
+	count := 0
+	s.values.Range(func(_, v interface{}) bool {
+		if !v.(*concurrentEntry).IsExpired(now) {
+			count++
+		}
+		return true
+	})
+
+	return count, nil
+}
+
+// Decrement atomically gets the value stored by specified key and
+// decrements it by one. If the key does not exist, it is created.
+func (s *ConcurrentStore) Decrement(key string) (int, error) {
+	return s.atomicInteger(key, -1)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value. If the key does not exist, it is created.
+func (s *ConcurrentStore) DecrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, -1*value)
+}
+
+// Delete deletes the specified key:value.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) Delete(key string) error {
+	v, ok := s.values.LoadAndDelete(key)
+	if !ok || v.(*concurrentEntry).IsExpired(s.now()) {
+		return dot.InvalidKeyError(key)
+	}
+
+	return nil
+}
+
+// Exists reports whether a value is stored by specified key, without renewing
+// it.
+func (s *ConcurrentStore) Exists(key string) (bool, error) {
+	_, ok := s.load(key)
+	return ok, nil
+}
+
+// Flush deletes any cached value into current instance.
+func (s *ConcurrentStore) Flush() error {
+	s.values.Range(func(k, _ interface{}) bool {
+		s.values.Delete(k)
+		return true
+	})
+
+	return nil
+}
+
+// FlushPrefix deletes every value whose key starts with prefix, including
+// expired values.
+func (s *ConcurrentStore) FlushPrefix(prefix string) error {
+	s.values.Range(func(k, _ interface{}) bool {
+		if strings.HasPrefix(k.(string), prefix) {
+			s.values.Delete(k)
+		}
+		return true
+	})
+
+	return nil
+}
+
+// GC removes every expired value right now, returning how many values were
+// removed. It is called by the garbage collector, which runs while current
+// store is not empty, hence it only needs to be called to reclaim memory
+// sooner, like by StartGC.
+func (s *ConcurrentStore) GC() int {
+	now := s.now()
+	removed := 0
+	s.values.Range(func(k, v interface{}) bool {
+		// A value replaced meanwhile is kept
+		if v.(*concurrentEntry).IsExpired(now) &&
+			s.values.CompareAndDelete(k, v) {
+			removed++
+		}
+		return true
+	})
+	return removed
+}
+
+// Get gets the value stored by specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) Get(key string, ref interface{}) error {
+	v, ok := s.load(key)
+	if !ok {
+		return dot.InvalidKeyError(key)
+	}
+	s.hit(v)
+
+	// A reference to pointer is filled with a newly allocated value
+	return v.Value(data.IndirectRef(ref))
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero. Unlike Increment, a missing key is not created.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) GetAndReset(key string) (int, error) {
+	for {
+		v, ok := s.load(key)
+		if !ok {
+			return 0, dot.InvalidKeyError(key)
+		}
+
+		var value int
+		if err := v.Value(&value); err != nil {
+			return 0, err
+		}
+
+		if ok, err := s.swap(key, v, 0); err != nil {
+			return 0, err
+		} else if ok {
+			return value, nil
+		}
+	}
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+func (s *ConcurrentStore) Increment(key string) (int, error) {
+	return s.atomicInteger(key, 1)
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created.
+func (s *ConcurrentStore) IncrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, value)
+}
+
+// Keys gets the sorted keys of stored values by current instance, excluding
+// expired values not removed by garbage collector yet.
+func (s *ConcurrentStore) Keys() ([]string, error) {
+	now := s.now()
+	var keys []string
+	s.values.Range(func(k, v interface{}) bool {
+		if !v.(*concurrentEntry).IsExpired(now) {
+			keys = append(keys, k.(string))
+		}
+		return true
+	})
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Range calls fn for each stored value, in no particular order, until fn
+// returns false. Values are not renewed and expired values are skipped. Since
+// no lock is held, fn may call back into current store; values changed
+// meanwhile may or may not be seen.
+//
+// Errors:
+// InvalidTypeError when a value could not be decoded, which stops iteration.
+func (s *ConcurrentStore) Range(fn func(key string, value interface{}) bool) error {
+	now := s.now()
+	var err error
+	s.values.Range(func(k, v interface{}) bool {
+		e := v.(*concurrentEntry)
+		if e.IsExpired(now) {
+			return true
+		}
+
+		var value interface{}
+		if err = e.Value(&value); err != nil {
+			return false
+		}
+		return fn(k.(string), value)
+	})
+
+	return err
+}
+
+// Set sets the value of specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) Set(key string, value interface{}) error {
+	for {
+		v, ok := s.load(key)
+		if !ok {
+			return dot.InvalidKeyError(key)
+		}
+
+		if ok, err := s.swap(key, v, value); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+	}
+}
+
+// SetClock defines the clock used to get the current time, which defaults to
+// system clock. The stored values are expired considering the time provided by
+// the clock, although the garbage collection is scheduled using system clock.
+func (s *ConcurrentStore) SetClock(c data.Clock) {
+	s.clock.Store(clockHolder{c})
+}
+
+// SetLifetime modifies the lifetime for new stored items and, as defined by
+// scope, for existing items. ScopeNew keeps the lifetime of existing items,
+// even when they are renewed. Items with their own lifetime keep it
+// regardless of scope.
+//
+// Errors:
+// NotSupportedError when an unknown scope is specified.
+func (s *ConcurrentStore) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	switch scope {
+	case data.ScopeAll:
+		g := s.getRenewal().next(d)
+		s.renewal.Store(g)
+		s.values.Range(func(_, v interface{}) bool {
+			e := v.(*concurrentEntry)
+			gen := atomic.LoadUint64(&e.generation)
+			if atomic.LoadInt32(&e.keyLifetime) == 0 &&
+				atomic.CompareAndSwapUint64(&e.generation, gen, g.gen) {
+				atomic.StoreInt64(&e.lifetime, int64(d))
+			}
+			return true
+		})
+	case data.ScopeNewAndUpdated:
+		s.renewal.Store(s.getRenewal().next(d))
+	case data.ScopeNew:
+		// Existing values keep their lifetime, which is older than d
+	default:
+		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	}
+
+	atomic.StoreInt64(&s.lifetime, int64(d))
+	return nil
+}
+
+// SetLifetimeFor defines the lifetime of the value stored by specified key,
+// which is renewed from now on, like Store does. A lifetime lower than one
+// restores the lifetime of store for the value.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) SetLifetimeFor(key string, d time.Duration) error {
+	v, ok := s.load(key)
+	if !ok {
+		return dot.InvalidKeyError(key)
+	}
+
+	if d < 1 {
+		atomic.StoreInt32(&v.keyLifetime, 0)
+		atomic.StoreInt64(&v.lifetime, int64(s.getLifetime()))
+		atomic.StoreUint64(&v.generation, s.getRenewal().gen)
+		v.Hit(s.now(), s.getRenewal())
+		return nil
+	}
+	v.SetKeyLifetime(s.now(), d)
+	return nil
+}
+
+// SetTransient defines whether should extends expiration of stored value when
+// it is read or written.
+func (s *ConcurrentStore) SetTransient(value bool) {
+	var flag int32
+	if value {
+		flag = 1
+	}
+	atomic.StoreInt32(&s.isTransient, flag)
+}
+
+// StartGC starts a goroutine which calls GC at specified interval, regardless
+// of the garbage collector scheduled by current store, and returns a function
+// that stops it. The stop function waits the goroutine to finish and it can
+// be called more than once.
+func (s *ConcurrentStore) StartGC(interval time.Duration) (stop func()) {
+	return s.gcs.start(interval, func() { s.GC() })
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key.
+// Expired values are removed when they are accessed, hence they are reported as
+// missing.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) TTL(key string) (time.Duration, error) {
+	v, ok := s.load(key)
+	if !ok {
+		return 0, dot.InvalidKeyError(key)
+	}
+
+	return v.ExpireAt().Sub(s.now()), nil
+}
+
+// Touch renews the lifetime of the value stored by specified key without
+// reading it, even when current store is transient.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ConcurrentStore) Touch(key string) error {
+	v, ok := s.load(key)
+	if !ok {
+		return dot.InvalidKeyError(key)
+	}
+
+	v.Hit(s.now(), s.getRenewal())
+	return nil
+}
+
+// gc removes expired values at intervals of 1/5 of current lifetime, while
+// current store is not empty, or until done is closed by Close.
+func (s *ConcurrentStore) gc(done chan struct{}) {
+	for {
+		select {
+		case <-time.After(gcInterval(s.getLifetime())):
+		case <-done:
+			// A value added after Close needs a new collector
+			atomic.StoreInt32(&s.gcRunning, 0)
+			if s.hasValues() {
+				s.startGC()
+			}
+			return
+		}
+		s.GC()
+
+		if !s.hasValues() {
+			atomic.StoreInt32(&s.gcRunning, 0)
+			// A value added after the values were walked has no collector
+			if s.hasValues() {
+				s.startGC()
+			}
+			return
+		}
+	}
+}
+
+// getLifetime returns the lifetime for new stored items.
+func (s *ConcurrentStore) getLifetime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.lifetime))
+}
+
+// getRenewal returns the lifetimeGeneration given to existing values when they
+// are renewed.
+func (s *ConcurrentStore) getRenewal() lifetimeGeneration {
+	return s.renewal.Load().(lifetimeGeneration)
+}
+
+// hit postpones expiration of specified entry, whether current store is not
+// transient.
+func (s *ConcurrentStore) hit(v *concurrentEntry) {
+	if atomic.LoadInt32(&s.isTransient) == 0 {
+		v.Hit(s.now(), s.getRenewal())
+	}
+}
+
+// hasValues returns whether current store has any value, expired or not.
+func (s *ConcurrentStore) hasValues() bool {
+	hasValues := false
+	s.values.Range(func(_, _ interface{}) bool {
+		hasValues = true
+		return false
+	})
+	return hasValues
+}
+
+// load gets the entry stored by specified key. An expired entry is removed.
+func (s *ConcurrentStore) load(key string) (*concurrentEntry, bool) {
+	v, ok := s.values.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	e := v.(*concurrentEntry)
+	if e.IsExpired(s.now()) {
+		s.values.CompareAndDelete(key, v)
+		return nil, false
+	}
+	return e, true
+}
+
+// now returns the current time from the clock of current store.
+func (s *ConcurrentStore) now() time.Time {
+	return s.clock.Load().(clockHolder).Now()
+}
+
+// startGC starts the garbage collector, whether it is not running.
+func (s *ConcurrentStore) startGC() {
+	if atomic.CompareAndSwapInt32(&s.gcRunning, 0, 1) {
+		go s.gc(s.gcDone.Load().(chan struct{}))
+	}
+}
+
+// swap replaces the entry v stored by specified key by a entry holding
+// specified value, reporting whether v was still stored.
+func (s *ConcurrentStore) swap(
+	key string, v *concurrentEntry, value interface{},
+) (bool, error) {
+	nv, err := v.WithValue(value)
+	if err != nil {
+		return false, err
+	}
+	s.hit(nv)
+
+	return s.values.CompareAndSwap(key, v, nv), nil
+}
+
+var _ data.ResettableStore = (*ConcurrentStore)(nil)
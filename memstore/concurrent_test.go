@@ -0,0 +1,181 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestConcurrentStore(t *testing.T) {
+	clock := testdata.NewClock()
+	store := NewConcurrent(0, false)
+	store.SetClock(clock)
+	testdata.TestExpirationWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestValueHandling(store, t)
+
+	store.Flush()
+	testdata.TestKeyCollision(store, t)
+
+	store.Flush()
+	testdata.TestSetExpiration(store, t)
+
+	store.Flush()
+	testdata.TestPostponeWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTransientWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestAtomic(store, t)
+
+	store.Flush()
+	testdata.TestTypeError(store, t)
+
+	store.Flush()
+	testdata.TestGenericDecode(store, t)
+
+	store.Flush()
+	testdata.TestKeysWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestExistsWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestScopeNewWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTTLWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouchWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestPointerValue(store, t)
+
+	store.Flush()
+	testdata.TestIncrementByWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+
+	store.Flush()
+	testdata.TestAddExpiredWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestCompareAndSwap(store, t)
+
+	store.Flush()
+	testdata.TestFlushPrefix(store, t)
+
+	store.Flush()
+	testdata.TestLifetimeForWithClock(store, clock, t)
+}
+
+func TestConcurrentStoreStartGC(t *testing.T) {
+	clock := testdata.NewClock()
+	store := NewConcurrent(time.Hour, false)
+	store.SetClock(clock)
+	for i := 0; i < 10; i++ {
+		if err := store.Add(strconv.Itoa(i), i); err != nil {
+			t.Fatalf("Could not add value: %v", err)
+		}
+	}
+	clock.Advance(2 * time.Hour)
+
+	stop := store.StartGC(time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for store.hasValues() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expired values were not reclaimed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentStoreClose(t *testing.T) {
+	store := NewConcurrent(time.Hour, false)
+	store.Add("k1", 1)
+	stop := store.StartGC(time.Hour)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Could not close store: %v", err)
+	}
+	stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&store.gcRunning) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("The garbage collector was not stopped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if store.hasValues() {
+		t.Errorf("Expected no values after close")
+	}
+}
+
+func BenchmarkConcurrentStoreAtomicIncrement(b *testing.B) {
+	store := NewConcurrent(0, true)
+	testdata.BenchmarkAtomicIncrement(store, b)
+}
+
+func BenchmarkMemStoreGetParallel(b *testing.B) {
+	benchmarkGetParallel(New(time.Minute, false), b)
+}
+
+func BenchmarkConcurrentStoreGetParallel(b *testing.B) {
+	benchmarkGetParallel(NewConcurrent(time.Minute, false), b)
+}
+
+// benchmarkGetParallel reads stored values from 50 goroutines per CPU, while
+// one of them writes a value every 100 reads.
+func benchmarkGetParallel(store data.Store, b *testing.B) {
+	keys := make([]string, 256)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		store.Add(keys[i], i)
+	}
+
+	b.ResetTimer()
+	b.SetParallelism(50)
+	b.RunParallel(func(pb *testing.PB) {
+		var value int
+		for i := 0; pb.Next(); i++ {
+			key := keys[i%len(keys)]
+			if i%100 == 0 {
+				store.Set(key, i)
+				continue
+			}
+			if err := store.Get(key, &value); err != nil {
+				b.Errorf("Could not get value: %v", err)
+			}
+		}
+	})
+}
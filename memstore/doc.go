@@ -23,16 +23,82 @@ A Store provides in-memory key:value cache that expires after defined duration
 of time. That duration is defined when a new instance is initialized calling
 'memstore.New()' function and it is used to all new stored values.
 
+Values are stored encoded by msgpack, so a stored value is a copy which is not
+changed when the caller later modifies the original, like a slice or a value
+referenced by a pointer, and every read decodes a new copy. Large values can
+be compressed by gzip once encoded calling 'SetCompression()', saving memory at
+the cost of CPU time on each read and write.
+
 The Store can manage an application context. Creating an application context
 its the recommended way to avoid global variables and strict the access to your
 variables to selected functions.
 
 The lifetime for new values and/or existing values can be modified calling
 'SetLifetime()'. The new expiration time will be automatically updated as
-specified by the scope parameter.
+specified by the scope parameter. A single value can be given its own
+lifetime calling 'AddWithLifetime()' or 'SetLifetimeFor()', which is kept when
+the value is renewed.
 
 The expiration behaviour can be changed calling 'SetTransient()' to define
 whether the lifetime of stored value is fixed (transient) or is extended when
 it is read or written (non-transient).
+
+A Store can be split into namespaces calling 'Sub()', which returns a child
+Store that can be flushed and counted apart from its parent, like the values of
+a single tenant. Flushing the parent flushes all its children.
+
+A lookup that misses on the source of values can be cached calling
+'AddNegative()', which marks a key as known to be absent for a short duration.
+Meanwhile, 'Get()' returns 'data.ErrNegativelyCached' for that key, allowing the
+caller to skip the source. A 'data.Loader' marks the keys missed by its loader
+once its negative lifetime is defined.
+
+The methods 'AddContext()', 'GetContext()', 'SetContext()' and
+'DeleteContext()' accept a context, which stops waiting for a contended lock
+once it is done, so the same code path can cancel operations on any store.
+
+Expired values are removed by a garbage collector, which runs at 1/5 intervals
+of current lifetime while the Store is not empty. They can be reclaimed sooner
+calling 'GC()', or at a custom interval by the goroutine started by 'StartGC()',
+which runs until the returned stop function is called. Calling 'Close()' stops
+every garbage collector of the Store and deletes its values.
+
+The effectiveness of a Store as a cache can be measured calling 'Stats()', which
+reports how many Get calls hit or missed and how many values were added or
+evicted. Those counters are updated atomically, so they do not slow down
+concurrent reads, and they can be zeroed calling 'ResetStats()'.
+
+Every value added, replaced, deleted or expired can be observed by the channel
+returned by 'Events()', like by an audit pipeline. Notifications are dropped
+instead of blocking the Store when the consumer is slow, which are counted by
+'DroppedEvents()', and the channel is closed calling 'CloseEvents()'.
+
+The values of a Store can survive a restart calling 'SaveToFile()' before it
+stops and 'LoadFromFile()' after it starts, which keeps the remaining lifetime
+of each value. A Store can also be dumped as JSON by 'json.Marshal()', like by
+a debugging endpoint, mapping each key to its value and expiration time.
+
+RingStore
+
+A RingStore provides in-memory key:value cache with fixed capacity, defined when
+a new instance is initialized calling 'memstore.NewRingStore()' function. When
+it is full the oldest inserted value is evicted to give room to a new one,
+which is suitable to keep a history of recent values.
+
+ShardedStore
+
+A ShardedStore spreads values among independent Store shards by the hash of
+their keys, defined when a new instance is initialized calling
+'memstore.NewSharded()' function. Each shard has its own lock and garbage
+collector, which reduces lock contention when many goroutines access distinct
+keys.
+
+ConcurrentStore
+
+A ConcurrentStore provides the same expiration behaviour of Store built on a
+sync.Map, defined when a new instance is initialized calling
+'memstore.NewConcurrent()' function. Values are replaced by compare-and-swap
+and their expiration is renewed atomically, so readers never wait for writers,
+which suits read-heavy workloads.
 */
 package memstore
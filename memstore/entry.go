@@ -17,30 +17,96 @@
 package memstore
 
 import (
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/codec"
 	"gopkg.in/vmihailenco/msgpack.v2"
+	"gopkg.in/vmihailenco/msgpack.v2/codes"
 )
 
 // A entry represents a in-memory value managed by Store.
 type entry struct {
-	expireAt time.Time
-	lifetime time.Duration
-	value    []byte
+	// hits holds how many times current value was read, which is accessed
+	// atomically.
+	hits      uint64
+	expireAt  time.Time
+	readAt    time.Time
+	createdAt time.Time
+	updatedAt time.Time
+	lifetime  time.Duration
+	uses      int
+	value     []byte
+	version   uint64
+	// refreshing defines whether a fresh value is being loaded to replace
+	// current stale value.
+	refreshing bool
+	// generation defines the lifetimeGeneration of store current lifetime was
+	// given by, which is replaced only by a newer one when the value is
+	// renewed.
+	generation uint64
+	// keyLifetime defines whether current lifetime was defined for its key,
+	// which is kept regardless of the lifetime of store.
+	keyLifetime bool
+	// compressed defines whether value is prefixed by a compression header,
+	// as codec.Compress does.
+	compressed bool
+}
+
+// entryPool holds released entries to be reused by newEntry, which saves an
+// allocation for each value added to a store with high churn.
+var entryPool = sync.Pool{
+	New: func() interface{} { return new(entry) },
 }
 
-// newEntry creates a new entry for Store.
-func newEntry(lifetime time.Duration, value interface{}) (*entry, error) {
+// newEntry creates a new entry for Store, reusing a released entry whether
+// available.
+func newEntry(now time.Time, lifetime time.Duration, value interface{}) (*entry, error) {
 	b, err := msgpack.Marshal(value)
 	if err != nil {
 		return nil, err
 	}
 
-	return &entry{
-		expireAt: time.Now().Add(lifetime),
-		lifetime: lifetime,
-		value:    b,
-	}, nil
+	e := entryPool.Get().(*entry)
+	*e = entry{
+		expireAt:  now.Add(lifetime),
+		readAt:    now,
+		createdAt: now,
+		updatedAt: now,
+		lifetime:  lifetime,
+		value:     b,
+		version:   nextVersion(),
+	}
+	return e, nil
+}
+
+// lastVersion holds the last version assigned to a value, which is accessed
+// atomically.
+var lastVersion uint64
+
+// nextVersion returns a new version for a written value, which is unique
+// among every store of current process.
+func nextVersion() uint64 {
+	return atomic.AddUint64(&lastVersion, 1)
+}
+
+// equalValue reports whether the value decoded by decode into the type of
+// value equals it by reflect.DeepEqual. A value which cannot be decoded into
+// that type is not equal.
+func equalValue(value interface{}, decode func(ref interface{}) error) bool {
+	if value == nil {
+		var current interface{}
+		return decode(&current) == nil && current == nil
+	}
+
+	ref := reflect.New(reflect.TypeOf(value))
+	if err := decode(ref.Interface()); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(ref.Elem().Interface(), value)
 }
 
 // Delete removes current data.
@@ -48,39 +114,204 @@ func (i *entry) Delete() {
 	i.value = nil
 }
 
-// IsExpired returns whether current value is expired.
-func (i *entry) IsExpired() bool {
-	return time.Now().After(i.expireAt)
+// release returns current entry to the pool to be reused by newEntry, after
+// its value is removed so the pool does not retain it. It must be called only
+// once the entry is removed from its store and no longer referenced; an entry
+// being refreshed is kept, since the refresh still references it.
+func (i *entry) release() {
+	if i.refreshing {
+		return
+	}
+
+	i.Delete()
+	entryPool.Put(i)
+}
+
+// ExpireAt returns when current value expires by its lifetime.
+func (i *entry) ExpireAt() time.Time {
+	return i.expireAt
+}
+
+// IsExpired returns whether current value is expired at specified time.
+func (i *entry) IsExpired(now time.Time) bool {
+	return now.After(i.expireAt)
+}
+
+// IsIdle returns whether current value was not read for longer than specified
+// duration.
+func (i *entry) IsIdle(now time.Time, maxIdle time.Duration) bool {
+	return now.Sub(i.readAt) > maxIdle
+}
+
+// Kind returns the kind of current value, as it would be decoded into an
+// empty interface, by peeking its leading MessagePack code.
+func (i *entry) Kind() reflect.Kind {
+	value, err := i.raw()
+	if err != nil || len(value) == 0 {
+		return reflect.Invalid
+	}
+
+	c := value[0]
+	switch {
+	case codes.IsFixedNum(c):
+		return reflect.Int64
+	case codes.IsFixedMap(c):
+		return reflect.Map
+	case codes.IsFixedArray(c):
+		return reflect.Slice
+	case codes.IsFixedString(c):
+		return reflect.String
+	case codes.IsExt(c):
+		return reflect.Struct
+	}
+
+	switch c {
+	case codes.False, codes.True:
+		return reflect.Bool
+	case codes.Float, codes.Double:
+		return reflect.Float64
+	case codes.Uint64:
+		// Only values overflowing int64 are kept unsigned
+		if len(value) > 1 && value[1]&0x80 != 0 {
+			return reflect.Uint64
+		}
+		return reflect.Int64
+	case codes.Uint8, codes.Uint16, codes.Uint32,
+		codes.Int8, codes.Int16, codes.Int32, codes.Int64:
+		return reflect.Int64
+	case codes.Bin8, codes.Bin16, codes.Bin32,
+		codes.Array16, codes.Array32:
+		return reflect.Slice
+	case codes.Str8, codes.Str16, codes.Str32:
+		return reflect.String
+	case codes.Map16, codes.Map32:
+		return reflect.Map
+	}
+
+	return reflect.Invalid
+}
+
+// Hits returns how many times current value was read.
+func (i *entry) Hits() uint64 {
+	return atomic.LoadUint64(&i.hits)
+}
+
+// Lifetime returns the lifetime of current value, which is renewed when it is
+// hit.
+func (i *entry) Lifetime() time.Duration {
+	return i.lifetime
+}
+
+// Read sets the time which current value was last read.
+func (i *entry) Read(now time.Time) {
+	i.readAt = now
+}
+
+// AddHit counts a read of current value, which is safe to be called holding
+// only the read lock.
+func (i *entry) AddHit() {
+	atomic.AddUint64(&i.hits, 1)
 }
 
-// Hit postpone data expiration time to current time added to its lifetime
+// Hit postpone data expiration time to specified time added to its lifetime
 // duration.
-func (i *entry) Hit() {
-	i.expireAt = time.Now().Add(i.lifetime)
+func (i *entry) Hit(now time.Time) {
+	i.expireAt = now.Add(i.lifetime)
 }
 
-// Value of current instance.
+// Value of current instance. A value decoded into an empty interface is
+// converted to generic types by data.GenericValue.
+//
+// Errors:
+// InvalidTypeError when the value cannot be decoded into the type of ref.
 func (i *entry) Value(ref interface{}) error {
-	err := msgpack.Unmarshal(i.value, &ref)
+	value, err := i.raw()
 	if err != nil {
 		return err
 	}
 
+	// Values are always encoded by entry, hence they fail to be decoded only
+	// when the type of ref does not match.
+	if err := msgpack.Unmarshal(value, ref); err != nil {
+		return data.NewInvalidTypeError(ref)
+	}
+
+	if p, ok := ref.(*interface{}); ok {
+		*p = data.GenericValue(*p)
+	}
 	return nil
 }
 
-// SetLifetime sets the lifetime duration for current instance.
-func (i *entry) SetLifetime(d time.Duration) {
+// SetLifetime sets the lifetime of specified generation for current instance,
+// whether it is newer than the generation of current lifetime and current
+// lifetime was not defined for its key.
+func (i *entry) SetLifetime(g lifetimeGeneration) {
+	if i.generation < g.gen && !i.keyLifetime {
+		i.lifetime = g.lifetime
+		i.generation = g.gen
+	}
+}
+
+// A lifetimeGeneration represents the lifetime which the existing values of a
+// store are given when they are renewed, as defined by ScopeAll or
+// ScopeNewAndUpdated. Each scope change increments gen, so values added before
+// it adopt lifetime, while values added afterwards keep the lifetime they were
+// added with, like values added after a ScopeNew change.
+type lifetimeGeneration struct {
+	gen      uint64
+	lifetime time.Duration
+}
+
+// next returns the generation which follows current one, giving specified
+// lifetime.
+func (g lifetimeGeneration) next(d time.Duration) lifetimeGeneration {
+	return lifetimeGeneration{g.gen + 1, d}
+}
+
+// SetKeyLifetime defines a lifetime for current instance, which is kept
+// regardless of the lifetime of store, and renews it from specified time.
+func (i *entry) SetKeyLifetime(now time.Time, d time.Duration) {
 	i.lifetime = d
+	i.keyLifetime = true
+	i.Hit(now)
 }
 
-// SetValue sets the value of current instance.
-func (i *entry) SetValue(value interface{}) error {
+// SetValue sets the value of current instance, which was updated at specified
+// time.
+func (i *entry) SetValue(now time.Time, value interface{}) error {
 	b, err := msgpack.Marshal(value)
 	if err != nil {
 		return err
 	}
 
 	i.value = b
+	i.compressed = false
+	i.updatedAt = now
+	i.version = nextVersion()
 	return nil
 }
+
+// compress compresses current value by gzip whether its encoding is larger
+// than threshold bytes, unless threshold is lower than one.
+func (i *entry) compress(threshold int) error {
+	if threshold < 1 || i.compressed {
+		return nil
+	}
+
+	b, err := codec.Compress(i.value, threshold)
+	if err != nil {
+		return err
+	}
+	i.value = b
+	i.compressed = true
+	return nil
+}
+
+// raw returns the MessagePack encoding of current value, decompressing it
+// whether it is compressed.
+func (i *entry) raw() ([]byte, error) {
+	if !i.compressed {
+		return i.value, nil
+	}
+	return codec.Decompress(i.value)
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestEntryTypeMismatch(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	values := []struct {
+		value interface{}
+		ref   interface{}
+	}{
+		{15, new(string)},
+		{"15", new(int)},
+		{user{"John", 30}, new(int)},
+		{15, new(user)},
+		{[]int{1, 2}, new(user)},
+	}
+
+	for _, v := range values {
+		e, err := newEntry(time.Now(), time.Minute, v.value)
+		if err != nil {
+			t.Fatalf("Could not create entry: %v", err)
+		}
+
+		err = e.Value(v.ref)
+		if err == nil {
+			t.Errorf("The value %v should not be decoded into %T", v.value, v.ref)
+		} else if _, ok := err.(data.InvalidTypeError); !ok {
+			t.Errorf("Expected InvalidTypeError but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import "time"
+
+// An EventType represents the kind of change notified by a StoreEvent.
+type EventType int
+
+const (
+	// EventAdded defines that a new value was stored.
+	EventAdded = EventType(0)
+
+	// EventSet defines that a stored value was replaced by a new value.
+	EventSet = EventType(1)
+
+	// EventDeleted defines that a value was explicitly deleted.
+	EventDeleted = EventType(2)
+
+	// EventExpired defines that a value was removed because its lifetime has
+	// elapsed.
+	EventExpired = EventType(3)
+
+	// EventEvicted defines that a value was removed by another reason than
+	// expiration or deletion, like being read as many times as allowed.
+	EventEvicted = EventType(4)
+)
+
+// eventChannelSize defines the buffer size of events channel.
+const eventChannelSize = 256
+
+// A StoreEvent represents a notification of a change to a value of Store.
+type StoreEvent struct {
+	Key  string
+	Type EventType
+	Time time.Time
+}
+
+// eventOf returns the type of event notifying a value removed by reason.
+func eventOf(reason EvictReason) EventType {
+	switch reason {
+	case EvictDeleted:
+		return EventDeleted
+	case EvictExpired:
+		return EventExpired
+	}
+	return EventEvicted
+}
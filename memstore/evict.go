@@ -0,0 +1,91 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import "gopkg.in/raiqub/data.v0"
+
+// An EvictReason represents the cause of removal of a stored value.
+type EvictReason int
+
+const (
+	// EvictExpired defines that the value was removed because its lifetime
+	// has elapsed.
+	EvictExpired = EvictReason(0)
+
+	// EvictCapacity defines that the value was removed to give room to newer
+	// values on a store with bounded capacity.
+	EvictCapacity = EvictReason(1)
+
+	// EvictDeleted defines that the value was explicitly deleted.
+	EvictDeleted = EvictReason(2)
+
+	// EvictUsedUp defines that the value was removed because it was read as
+	// many times as allowed.
+	EvictUsedUp = EvictReason(3)
+
+	// EvictOverwritten defines that the value was replaced by a new value. It
+	// is only counted by expiry histogram, since the entry is not removed.
+	EvictOverwritten = EvictReason(4)
+)
+
+// evictChannelSize defines the buffer size of eviction channel.
+const evictChannelSize = 64
+
+// An EvictEvent represents a notification of a value removed from Store.
+type EvictEvent struct {
+	Key    string
+	Value  interface{}
+	Reason EvictReason
+}
+
+// An EvictFunc represents a callback notified of a value removed from Store,
+// along with the reason of removal.
+type EvictFunc func(key string, value interface{}, reason EvictReason)
+
+// callEvictFuncs calls fn for every specified event, recovering from any panic
+// raised by it, since there is no caller to return it to. The panic is passed
+// to onError as CallbackPanicError, whether onError is not nil.
+func callEvictFuncs(
+	fn EvictFunc, onError data.ErrorHandler, events []EvictEvent,
+) {
+	for _, e := range events {
+		func() {
+			defer func() {
+				if r := recover(); r != nil && onError != nil {
+					onError("OnEvict", e.Key, data.NewCallbackPanicError(r))
+				}
+			}()
+			fn(e.Key, e.Value, e.Reason)
+		}()
+	}
+}
+
+// sendEvict sends a non-blocking notification of evicted entry to specified
+// channel, whether it is not nil.
+func sendEvict(ch chan EvictEvent, key string, v *entry, reason EvictReason) {
+	if ch == nil {
+		return
+	}
+
+	var value interface{}
+	v.Value(&value)
+
+	select {
+	case ch <- EvictEvent{key, value, reason}:
+	default:
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"sync"
+	"time"
+)
+
+// gcInterval returns the interval between garbage collections for specified
+// lifetime, which is 1/5 of it. A non-positive lifetime is collected every
+// second.
+func gcInterval(lifetime time.Duration) time.Duration {
+	interval := lifetime / 5
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return interval
+}
+
+// startGC starts a goroutine which calls gc at specified interval, until the
+// returned function is called.
+func startGC(interval time.Duration, gc func()) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				gc()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-finished
+	}
+}
+
+// A gcGroup represents the goroutines started by StartGC of a store, which
+// are stopped at once when the store is closed.
+type gcGroup struct {
+	mutex sync.Mutex
+	stops []func()
+}
+
+// start starts a goroutine which calls gc at specified interval, like
+// startGC, and keeps its stop function.
+func (g *gcGroup) start(interval time.Duration, gc func()) (stop func()) {
+	stop = startGC(interval, gc)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.stops = append(g.stops, stop)
+	return stop
+}
+
+// stop stops every goroutine started by current group, waiting them to
+// finish.
+func (g *gcGroup) stop() {
+	g.mutex.Lock()
+	stops := g.stops
+	g.stops = nil
+	g.mutex.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"sort"
+	"time"
+)
+
+// ageBounds defines the upper bounds of histogram buckets of value ages.
+var ageBounds = []time.Duration{
+	time.Second,
+	time.Second * 10,
+	time.Minute,
+	time.Minute * 10,
+	time.Hour,
+	time.Hour * 6,
+	time.Hour * 24,
+}
+
+// An AgeHistogram represents the distribution of ages of values removed or
+// overwritten by a same reason, where the age of a value is the time elapsed
+// since it was written.
+type AgeHistogram struct {
+	// Bounds holds the inclusive upper bound of each bucket, but the last
+	// bucket, which is unbounded.
+	Bounds []time.Duration
+	// Counts holds how many values belong to each bucket, having one item
+	// more than Bounds.
+	Counts []uint64
+	// Sum holds the total age of counted values.
+	Sum time.Duration
+}
+
+// newAgeHistogram creates a new empty AgeHistogram.
+func newAgeHistogram() *AgeHistogram {
+	return &AgeHistogram{
+		Bounds: ageBounds,
+		Counts: make([]uint64, len(ageBounds)+1),
+	}
+}
+
+// Count returns how many values were counted by current instance.
+func (h AgeHistogram) Count() uint64 {
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	return count
+}
+
+// Mean returns the mean age of values counted by current instance.
+func (h AgeHistogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(count)
+}
+
+// observe counts a value having specified age.
+func (h *AgeHistogram) observe(age time.Duration) {
+	i := sort.Search(len(h.Bounds), func(i int) bool {
+		return age <= h.Bounds[i]
+	})
+	h.Counts[i]++
+	h.Sum += age
+}
+
+// clone returns a copy of current instance.
+func (h *AgeHistogram) clone() AgeHistogram {
+	c := *h
+	c.Counts = make([]uint64, len(h.Counts))
+	copy(c.Counts, h.Counts)
+	return c
+}
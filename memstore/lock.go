@@ -0,0 +1,76 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import "context"
+
+// lock acquires the write lock of current instance unless ctx is done before,
+// in which case it returns ctx.Err(). A context that is never done acquires
+// the lock as usual, adding no overhead.
+func (s *Store) lock(ctx context.Context) error {
+	if ctx.Done() == nil {
+		s.mutex.Lock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.mutex.TryLock() {
+		return nil
+	}
+
+	return waitLock(ctx, s.mutex.Lock, s.mutex.Unlock)
+}
+
+// rlock acquires the read lock of current instance unless ctx is done before,
+// like lock does.
+func (s *Store) rlock(ctx context.Context) error {
+	if ctx.Done() == nil {
+		s.mutex.RLock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.mutex.TryRLock() {
+		return nil
+	}
+
+	return waitLock(ctx, s.mutex.RLock, s.mutex.RUnlock)
+}
+
+// waitLock waits for lock to be acquired or ctx to be done, whichever happens
+// first. When ctx is done first the lock is released by unlock as soon as it
+// is acquired.
+func waitLock(ctx context.Context, lock, unlock func()) error {
+	locked := make(chan struct{})
+	go func() {
+		lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			unlock()
+		}()
+		return ctx.Err()
+	}
+}
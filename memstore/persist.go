@@ -0,0 +1,214 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// A savedEntry represents a value saved to a file by SaveToFile.
+type savedEntry struct {
+	Key string `msgpack:"k"`
+	// TTL holds the remaining lifetime of the value when it was saved.
+	TTL      time.Duration `msgpack:"t"`
+	Lifetime time.Duration `msgpack:"l"`
+	// KeyLifetime defines whether Lifetime was defined for the key, like by
+	// AddWithLifetime, and Uses holds the remaining uses of a value added by
+	// AddWithUses.
+	KeyLifetime bool   `msgpack:"kl,omitempty"`
+	Uses        int    `msgpack:"u,omitempty"`
+	Value       []byte `msgpack:"v"`
+}
+
+// A jsonEntry represents a value of the JSON snapshot by MarshalJSON.
+type jsonEntry struct {
+	Value    interface{} `json:"value"`
+	ExpireAt time.Time   `json:"expireAt"`
+}
+
+// LoadFromFile loads the values saved by SaveToFile to specified file into
+// current store, replacing values stored by the same keys. Each value expires
+// after the remaining lifetime it had when it was saved, counted from now, and
+// it is renewed by its saved lifetime afterwards. A lifetime defined for its key
+// and the remaining uses of a value are restored as well.
+//
+// Errors:
+// os.PathError when the file could not be read.
+func (s *Store) LoadFromFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var saved []savedEntry
+	if err := msgpack.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	for _, e := range saved {
+		if e.TTL <= 0 {
+			continue
+		}
+
+		s.values[e.Key] = &entry{
+			expireAt:    now.Add(e.TTL),
+			readAt:      now,
+			createdAt:   now,
+			updatedAt:   now,
+			lifetime:    e.Lifetime,
+			keyLifetime: e.KeyLifetime,
+			uses:        e.Uses,
+			value:       e.Value,
+			generation:  s.renewal.gen,
+			version:     nextVersion(),
+		}
+		delete(s.negatives, e.Key)
+	}
+	if len(s.values) > 0 && !s.gcRunning {
+		go s.gc()
+	}
+
+	return nil
+}
+
+// SaveToFile saves the values of current store to specified file, along with
+// their remaining lifetimes, so they can be loaded by LoadFromFile after a
+// restart. Expired values are skipped. The file is replaced atomically,
+// whether it exists.
+//
+// Errors:
+// os.PathError when the file could not be written.
+func (s *Store) SaveToFile(path string) error {
+	s.mutex.RLock()
+	now := s.clock.Now()
+	saved := make([]savedEntry, 0, len(s.values))
+	for k, v := range s.values {
+		if s.isExpired(v, now) {
+			continue
+		}
+		raw, err := v.raw()
+		if err != nil {
+			continue
+		}
+		saved = append(saved, savedEntry{
+			Key:         k,
+			TTL:         s.expireAt(v).Sub(now),
+			Lifetime:    v.Lifetime(),
+			KeyLifetime: v.keyLifetime,
+			Uses:        v.uses,
+			Value:       raw,
+		})
+	}
+	s.mutex.RUnlock()
+
+	b, err := msgpack.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	// A partially written file never replaces a previous one
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// MarshalJSON returns a JSON object mapping each key of current store to its
+// value, as Range decodes it, and when it expires. Expired values are
+// skipped. The values are read under a read lock, so the snapshot is
+// consistent, and encoded to JSON after the lock is released.
+//
+// Errors:
+// json.UnsupportedTypeError when a value cannot be represented as JSON, like
+// a map whose keys are not strings.
+func (s *Store) MarshalJSON() ([]byte, error) {
+	s.mutex.RLock()
+	now := s.clock.Now()
+	snapshot := make(map[string]jsonEntry, len(s.values))
+	for k, v := range s.values {
+		if s.isExpired(v, now) {
+			continue
+		}
+
+		value, err := s.rangeValue(k, v)
+		if err != nil {
+			s.mutex.RUnlock()
+			return nil, err
+		}
+		snapshot[k] = jsonEntry{value, s.expireAt(v)}
+	}
+	s.mutex.RUnlock()
+
+	return json.Marshal(snapshot)
+}
+
+// UnmarshalJSON loads the values of a JSON snapshot, as MarshalJSON returns,
+// into current store, replacing values stored by the same keys. Each value
+// expires at the time defined by the snapshot, and it is renewed by the
+// lifetime of current store afterwards. Values whose expiration time is past
+// are dropped.
+//
+// JSON numbers are stored as int64 whether they are integers; otherwise, they
+// are stored as float64.
+func (s *Store) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var snapshot map[string]jsonEntry
+	if err := dec.Decode(&snapshot); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	for k, e := range snapshot {
+		if !e.ExpireAt.After(now) {
+			continue
+		}
+
+		v, err := s.newEntry(now, data.GenericValue(e.Value))
+		if err != nil {
+			return err
+		}
+		v.expireAt = e.ExpireAt
+		s.values[k] = v
+		delete(s.negatives, k)
+	}
+	if len(s.values) > 0 && !s.gcRunning {
+		go s.gc()
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestSaveToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memstore")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.dat")
+
+	clock := testdata.NewClock()
+	store := New(time.Minute, true)
+	store.SetClock(clock)
+	store.Add("v1", "lorem")
+	clock.Advance(time.Second * 20)
+	store.Add("v2", 2)
+	store.SetLifetime(time.Second*10, data.ScopeNew)
+	store.Add("v3", "expired")
+	clock.Advance(time.Second * 15)
+
+	if err := store.SaveToFile(path); err != nil {
+		t.Fatalf("Could not save values: %v", err)
+	}
+
+	restored := New(time.Minute, true)
+	restored.SetClock(clock)
+	clock.Advance(time.Hour)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatalf("Could not load values: %v", err)
+	}
+
+	var str string
+	if err := restored.Get("v1", &str); err != nil || str != "lorem" {
+		t.Errorf("Expected 'lorem' for v1 but got %q: %v", str, err)
+	}
+	var num int
+	if err := restored.Get("v2", &num); err != nil || num != 2 {
+		t.Errorf("Expected 2 for v2 but got %d: %v", num, err)
+	}
+	if ok, _ := restored.Exists("v3"); ok {
+		t.Error("A value expired when saved should not be loaded")
+	}
+
+	expected := map[string]time.Duration{
+		"v1": time.Second * 25,
+		"v2": time.Second * 45,
+	}
+	for k, d := range expected {
+		if ttl, _ := restored.TTL(k); ttl != d {
+			t.Errorf("Expected remaining lifetime %v for %s but got %v",
+				d, k, ttl)
+		}
+	}
+}
+
+func TestSaveToFileUsesAndKeyLifetime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memstore")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.dat")
+
+	clock := testdata.NewClock()
+	store := New(time.Minute, false)
+	store.SetClock(clock)
+	store.AddWithUses("uses", 1, 3)
+	store.AddWithLifetime("own", 2, time.Second*10)
+	var num int
+	store.Get("uses", &num)
+
+	if err := store.SaveToFile(path); err != nil {
+		t.Fatalf("Could not save values: %v", err)
+	}
+
+	restored := New(time.Minute, false)
+	restored.SetClock(clock)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatalf("Could not load values: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := restored.Get("uses", &num); err != nil {
+			t.Fatalf("The value should be read %d more times: %v", 2-i, err)
+		}
+	}
+	if ok, _ := restored.Exists("uses"); ok {
+		t.Error("The value should be removed after its remaining uses")
+	}
+
+	restored.SetLifetime(time.Hour, data.ScopeAll)
+	restored.Get("own", &num)
+	if ttl, _ := restored.TTL("own"); ttl != time.Second*10 {
+		t.Errorf("Expected the lifetime of key 10s but got %v", ttl)
+	}
+}
+
+func TestLoadFromMissingFile(t *testing.T) {
+	store := New(time.Minute, false)
+	if err := store.LoadFromFile("missing.dat"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error but got %v", err)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Minute, true)
+	store.SetClock(clock)
+	store.Add("v1", "lorem")
+	store.Add("v2", map[string]interface{}{"n": 2, "f": 1.5})
+	store.SetLifetime(time.Second, data.ScopeNew)
+	store.Add("v3", "expired")
+	clock.Advance(time.Second * 10)
+
+	b, err := json.Marshal(store)
+	if err != nil {
+		t.Fatalf("Could not marshal store: %v", err)
+	}
+	var snapshot map[string]struct {
+		Value    interface{}
+		ExpireAt time.Time
+	}
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		t.Fatalf("Could not unmarshal snapshot: %v", err)
+	}
+	if _, ok := snapshot["v3"]; ok || len(snapshot) != 2 {
+		t.Errorf("Expired values should be skipped: %s", b)
+	}
+	if e := snapshot["v1"]; e.Value != "lorem" ||
+		!e.ExpireAt.Equal(clock.Now().Add(time.Second*50)) {
+		t.Errorf("Unexpected entry for v1: %+v", e)
+	}
+
+	restored := New(time.Minute, true)
+	restored.SetClock(clock)
+	clock.Advance(time.Second * 20)
+	if err := restored.UnmarshalJSON(b); err != nil {
+		t.Fatalf("Could not unmarshal store: %v", err)
+	}
+
+	var str string
+	if err := restored.Get("v1", &str); err != nil || str != "lorem" {
+		t.Errorf("Expected 'lorem' for v1 but got %q: %v", str, err)
+	}
+	var m struct {
+		N int     `msgpack:"n"`
+		F float64 `msgpack:"f"`
+	}
+	if err := restored.Get("v2", &m); err != nil || m.N != 2 || m.F != 1.5 {
+		t.Errorf("Unexpected value for v2 %+v: %v", m, err)
+	}
+	if ttl, _ := restored.TTL("v1"); ttl != time.Second*30 {
+		t.Errorf("Expected remaining lifetime 30s but got %v", ttl)
+	}
+
+	// Values expired since the snapshot are dropped
+	clock.Advance(time.Minute)
+	restored.Flush()
+	if err := restored.UnmarshalJSON(b); err != nil {
+		t.Fatalf("Could not unmarshal store: %v", err)
+	}
+	if count, _ := restored.Count(); count != 0 {
+		t.Errorf("Expected no values but got %d", count)
+	}
+}
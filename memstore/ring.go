@@ -0,0 +1,793 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A ringEntry represents a value managed by RingStore and its position on
+// insertion order.
+type ringEntry struct {
+	*entry
+	elem *list.Element
+}
+
+// A RingStore provides in-memory key:value cache with fixed capacity. When it
+// is full the oldest inserted value is evicted to give room to a new one,
+// regardless of how recently it was accessed.
+//
+// It is a implementation of Store interface.
+type RingStore struct {
+	// evictions is accessed atomically and must be 64-bit aligned.
+	evictions uint64
+	values    map[string]*ringEntry
+	order     *list.List
+	capacity  int
+	lifetime  time.Duration
+	// renewal defines the lifetime given to existing values when they are
+	// renewed.
+	renewal     lifetimeGeneration
+	isTransient bool
+	mutex       sync.Mutex
+	evictCh     chan EvictEvent
+	clock       data.Clock
+	onEvict     EvictFunc
+	// onError handles the panics raised by onEvict.
+	onError data.ErrorHandler
+	// evicted holds the removals not yet notified to onEvict.
+	evicted []EvictEvent
+	// pendingEvicts flags atomically whether evicted is not empty.
+	pendingEvicts int32
+}
+
+// NewRingStore creates a new instance of RingStore which holds up to capacity
+// values.
+//
+// The stored values do not expire until a lifetime is defined by
+// 'SetLifetime()'. Expired values are removed when they are accessed.
+func NewRingStore(capacity int) *RingStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &RingStore{
+		values:   make(map[string]*ringEntry),
+		order:    list.New(),
+		capacity: capacity,
+		clock:    data.SystemClock{},
+	}
+}
+
+// Add adds a new key:value to current store. The oldest value is evicted when
+// current store is full.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *RingStore) Add(key string, value interface{}) error {
+	return s.add(key, value, 0)
+}
+
+// AddWithLifetime adds a new key:value to current store which expires by
+// specified lifetime instead of the lifetime of store, like Store does. A
+// lifetime lower than one defines the lifetime of store, as Add does.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *RingStore) AddWithLifetime(
+	key string, value interface{}, d time.Duration,
+) error {
+	return s.add(key, value, d)
+}
+
+// add adds a new key:value to current store with its own lifetime, whether
+// lifetime is positive.
+func (s *RingStore) add(
+	key string, value interface{}, lifetime time.Duration,
+) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.newEntry(s.clock.Now(), value)
+	if err != nil {
+		return err
+	}
+	if lifetime > 0 {
+		data.SetKeyLifetime(s.clock.Now(), lifetime)
+	}
+
+	if _, err := s.unsafeGet(key); err == nil {
+		return dot.DuplicatedKeyError(key)
+	}
+
+	s.unsafeInsert(key, data)
+	return nil
+}
+
+func (s *RingStore) atomicInteger(key string, inc int) (int, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		data, err := s.newEntry(s.clock.Now(), inc)
+		if err != nil {
+			return 0, err
+		}
+
+		s.unsafeInsert(key, data)
+		return inc, nil
+	}
+
+	var value int
+	if err := v.Value(&value); err != nil {
+		return 0, err
+	}
+
+	value += inc
+	v.SetValue(s.clock.Now(), value)
+	s.unsafeHit(v)
+
+	return value, nil
+}
+
+// CompareAndSwap atomically sets the value of specified key to new, whether
+// its current value equals old, reporting whether the value was swapped. The
+// current value is decoded into the type of old and compared by
+// reflect.DeepEqual. It does not change the insertion order of the key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) CompareAndSwap(key string, old, new interface{}) (bool, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return false, err
+	}
+	if !equalValue(old, v.Value) {
+		return false, nil
+	}
+
+	if err := v.SetValue(s.clock.Now(), new); err != nil {
+		return false, err
+	}
+	s.unsafeHit(v)
+
+	return true, nil
+}
+
+// Close deletes every stored value, like Flush, since a RingStore has no
+// background work to stop. The store can still be used afterwards.
+func (s *RingStore) Close() error {
+	return s.Flush()
+}
+
+// Count gets the number of stored values by current instance.
+func (s *RingStore) Count() (int, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, v := range s.values {
+		if !s.isExpired(v) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Decrement atomically gets the value stored by specified key and
+// decrements it by one. If the key does not exist, it is created.
+func (s *RingStore) Decrement(key string) (int, error) {
+	return s.atomicInteger(key, -1)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value. If the key does not exist, it is created.
+func (s *RingStore) DecrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, -1*value)
+}
+
+// Delete deletes the specified key:value.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) Delete(key string) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	s.unsafeRemove(key, v, EvictDeleted)
+	return nil
+}
+
+// Evictions returns how many values were removed from current instance by
+// other reason than deletion, like capacity or expiration.
+func (s *RingStore) Evictions() uint64 {
+	return atomic.LoadUint64(&s.evictions)
+}
+
+// EvictChannel returns a channel which receives a notification for every value
+// removed by capacity, expiration or deletion. Only removals that happen after
+// first call are notified.
+//
+// The channel is buffered and notifications are sent without blocking, so
+// when the consumer is slower than the store the exceeding notifications are
+// dropped.
+func (s *RingStore) EvictChannel() <-chan EvictEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.evictCh == nil {
+		s.evictCh = make(chan EvictEvent, evictChannelSize)
+	}
+	return s.evictCh
+}
+
+// Exists reports whether a value is stored by specified key, without renewing
+// it.
+func (s *RingStore) Exists(key string) (bool, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.unsafeGet(key)
+	return err == nil, nil
+}
+
+// Flush deletes any cached value into current instance.
+func (s *RingStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values = make(map[string]*ringEntry)
+	s.order.Init()
+	return nil
+}
+
+// FlushPrefix deletes every value whose key starts with prefix, including
+// expired values. Like Flush, the deleted values are not notified.
+func (s *RingStore) FlushPrefix(prefix string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			s.order.Remove(v.elem)
+			delete(s.values, k)
+		}
+	}
+	return nil
+}
+
+// Get gets the value stored by specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) Get(key string, ref interface{}) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+	s.unsafeHit(v)
+
+	return v.Value(ref)
+}
+
+// GetMany gets the values stored by specified keys, holding the lock once for
+// every key. Missing keys are absent from returned values.
+//
+// A value which could not be decoded is skipped without failing other keys;
+// its error is reported by a data.BatchError returned along with the decoded
+// values.
+func (s *RingStore) GetMany(keys []string) (map[string]interface{}, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := make(data.BatchError)
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		v, err := s.unsafeGet(key)
+		if err != nil {
+			continue
+		}
+
+		var value interface{}
+		if err := v.Value(&value); err != nil {
+			errs[key] = err
+			continue
+		}
+		s.unsafeHit(v)
+		values[key] = value
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero. Unlike Increment, a missing key is not created.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) GetAndReset(key string) (int, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var value int
+	if err := v.Value(&value); err != nil {
+		return 0, err
+	}
+
+	if err := v.SetValue(s.clock.Now(), 0); err != nil {
+		return 0, err
+	}
+	s.unsafeHit(v)
+
+	return value, nil
+}
+
+// GetWithVersion gets the value stored by specified key along with its
+// version, which can be passed to SetWithVersion to write the value only
+// whether it was not written meanwhile.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) GetWithVersion(key string, ref interface{}) (uint64, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+	s.unsafeHit(v)
+
+	return v.version, v.Value(ref)
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+func (s *RingStore) Increment(key string) (int, error) {
+	return s.atomicInteger(key, 1)
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created.
+func (s *RingStore) IncrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, value)
+}
+
+// Keys gets the keys of stored values ordered by insertion, from oldest to
+// newest.
+func (s *RingStore) Keys() ([]string, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.values))
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		key := e.Value.(string)
+		if !s.isExpired(s.values[key]) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// OnEvict defines a callback notified of every value removed from current
+// store by other reason than deletion, which is either EvictCapacity for the
+// oldest values dropped to give room to newer ones or EvictExpired. A nil
+// function disables the notification.
+//
+// The callback is called without holding any lock, after the value is removed,
+// so it can call back into current store. A panic raised by it is recovered
+// and passed to the handler defined by SetErrorHandler.
+func (s *RingStore) OnEvict(fn EvictFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.onEvict = fn
+	if fn == nil {
+		s.evicted = nil
+	}
+}
+
+// Range calls fn for each stored value ordered by insertion, from oldest to
+// newest, until fn returns false. Values are not renewed and expired values
+// are skipped.
+//
+// The lock is held while fn is called, so fn must not call back into current
+// store, which would deadlock. Whether it needs to, the keys can be snapshot by
+// Keys beforehand.
+//
+// Errors:
+// InvalidTypeError when a value could not be decoded, which stops iteration.
+func (s *RingStore) Range(fn func(key string, value interface{}) bool) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		key := e.Value.(string)
+		v := s.values[key]
+		if s.isExpired(v) {
+			continue
+		}
+
+		var value interface{}
+		if err := v.Value(&value); err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Set sets the value of specified key. It does not change the insertion order
+// of the key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) Set(key string, value interface{}) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	if err := v.SetValue(s.clock.Now(), value); err != nil {
+		return err
+	}
+	s.unsafeHit(v)
+
+	return nil
+}
+
+// SetMany sets the values of specified keys, holding the lock once for every
+// key. Unlike Set, a missing key is created as Add does, which may evict the
+// oldest values.
+//
+// A value which could not be encoded is skipped without failing other keys;
+// its error is reported by a data.BatchError.
+func (s *RingStore) SetMany(values map[string]interface{}) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := make(data.BatchError)
+	now := s.clock.Now()
+	for key, value := range values {
+		if v, err := s.unsafeGet(key); err == nil {
+			if err := v.SetValue(now, value); err != nil {
+				errs[key] = err
+				continue
+			}
+			s.unsafeHit(v)
+			continue
+		}
+
+		data, err := s.newEntry(now, value)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		s.unsafeInsert(key, data)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetClock defines the clock used to get the current time, which defaults to
+// system clock.
+func (s *RingStore) SetClock(c data.Clock) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clock = c
+}
+
+// SetErrorHandler defines a handler of the panics raised by the callback of
+// OnEvict, which are passed as CallbackPanicError by "OnEvict" operation. A nil
+// handler discards them, which is the default.
+func (s *RingStore) SetErrorHandler(fn data.ErrorHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.onError = fn
+}
+
+// SetLifetime modifies the lifetime for new stored items and, as defined by
+// scope, for existing items. ScopeAll applies it to existing items from when
+// they were last renewed and ScopeNew keeps the lifetime of existing items,
+// even when they are renewed. A zero lifetime disables expiration. Items with
+// their own lifetime keep it regardless of scope.
+//
+// Errors:
+// NotSupportedError when an unknown scope is specified.
+func (s *RingStore) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch scope {
+	case data.ScopeAll:
+		s.renewal = s.renewal.next(d)
+		for _, v := range s.values {
+			if v.keyLifetime {
+				continue
+			}
+			// A value without lifetime expires when it was last renewed
+			renewedAt := v.ExpireAt().Add(-v.Lifetime())
+			v.SetLifetime(s.renewal)
+			v.Hit(renewedAt)
+		}
+	case data.ScopeNewAndUpdated:
+		s.renewal = s.renewal.next(d)
+	case data.ScopeNew:
+		// Existing values keep their lifetime, which is older than d
+	default:
+		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	}
+
+	s.lifetime = d
+	return nil
+}
+
+// SetLifetimeFor defines the lifetime of the value stored by specified key,
+// which is renewed from now on, like Store does. A lifetime lower than one
+// restores the lifetime of store for the value.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) SetLifetimeFor(key string, d time.Duration) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	if d < 1 {
+		v.keyLifetime = false
+		v.lifetime = s.lifetime
+		v.generation = s.renewal.gen
+		v.Hit(now)
+		return nil
+	}
+	v.SetKeyLifetime(now, d)
+	return nil
+}
+
+// SetTransient defines whether should extends expiration of stored value when
+// it is read or written.
+func (s *RingStore) SetTransient(value bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.isTransient = value
+}
+
+// SetWithVersion sets the value of specified key whether its version equals
+// expectedVersion, as returned by GetWithVersion, returning the new version.
+// It does not change the insertion order of the key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// VersionConflictError when the version of stored value differs.
+func (s *RingStore) SetWithVersion(
+	key string, value interface{}, expectedVersion uint64,
+) (uint64, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+	if v.version != expectedVersion {
+		return 0, data.NewVersionConflictError(key, expectedVersion, v.version)
+	}
+
+	if err := v.SetValue(s.clock.Now(), value); err != nil {
+		return 0, err
+	}
+	s.unsafeHit(v)
+
+	return v.version, nil
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key, which
+// is data.NoExpiration when current store has no lifetime. Expired values are
+// removed when they are accessed, hence they are reported as missing.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) TTL(key string) (time.Duration, error) {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+	if v.Lifetime() <= 0 {
+		return data.NoExpiration, nil
+	}
+
+	return v.ExpireAt().Sub(s.clock.Now()), nil
+}
+
+// Touch renews the lifetime of the value stored by specified key without
+// reading it, even when current store is transient. It does not change the
+// insertion order of the key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) Touch(key string) error {
+	defer s.runEvictFunc()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	v.SetLifetime(s.renewal)
+	v.Hit(s.clock.Now())
+	return nil
+}
+
+// isExpired returns whether specified entry has a lifetime and it is elapsed.
+func (s *RingStore) isExpired(v *ringEntry) bool {
+	return v.Lifetime() > 0 && v.IsExpired(s.clock.Now())
+}
+
+// newEntry creates a new entry for value using the lifetime of current store.
+func (s *RingStore) newEntry(now time.Time, value interface{}) (*entry, error) {
+	v, err := newEntry(now, s.lifetime, value)
+	if err != nil {
+		return nil, err
+	}
+	v.generation = s.renewal.gen
+	return v, nil
+}
+
+// unsafeGet gets one entry instance from its key without locking. An expired
+// entry is removed.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *RingStore) unsafeGet(key string) (*ringEntry, error) {
+	v, ok := s.values[key]
+	if !ok {
+		return nil, dot.InvalidKeyError(key)
+	}
+	if s.isExpired(v) {
+		s.unsafeRemove(key, v, EvictExpired)
+		return nil, dot.InvalidKeyError(key)
+	}
+
+	return v, nil
+}
+
+// unsafeHit postpones expiration of specified entry, whether current store is
+// not transient.
+func (s *RingStore) unsafeHit(v *ringEntry) {
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+}
+
+// unsafeInsert inserts a new entry without locking, evicting the oldest ones
+// when capacity is exceeded.
+func (s *RingStore) unsafeInsert(key string, data *entry) {
+	for len(s.values) >= s.capacity {
+		oldest := s.order.Front().Value.(string)
+		s.unsafeRemove(oldest, s.values[oldest], EvictCapacity)
+	}
+
+	s.values[key] = &ringEntry{data, s.order.PushBack(key)}
+}
+
+// unsafeRemove removes specified entry without locking and notifies its
+// eviction.
+func (s *RingStore) unsafeRemove(key string, v *ringEntry, reason EvictReason) {
+	if reason != EvictDeleted {
+		atomic.AddUint64(&s.evictions, 1)
+	}
+	sendEvict(s.evictCh, key, v.entry, reason)
+	if s.onEvict != nil && reason != EvictDeleted {
+		var value interface{}
+		v.Value(&value)
+		s.evicted = append(s.evicted, EvictEvent{key, value, reason})
+		atomic.StoreInt32(&s.pendingEvicts, 1)
+	}
+	s.order.Remove(v.elem)
+	delete(s.values, key)
+}
+
+// runEvictFunc notifies eviction callback of every pending removal. It is
+// deferred before locking, so it runs once the lock is released and the
+// callback can call back into current store.
+func (s *RingStore) runEvictFunc() {
+	if atomic.LoadInt32(&s.pendingEvicts) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	fn, onError, events := s.onEvict, s.onError, s.evicted
+	s.evicted = nil
+	atomic.StoreInt32(&s.pendingEvicts, 0)
+	s.mutex.Unlock()
+
+	if fn != nil {
+		callEvictFuncs(fn, onError, events)
+	}
+}
+
+var _ data.ResettableStore = (*RingStore)(nil)
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestRingStore(t *testing.T) {
+	clock := testdata.NewClock()
+	store := NewRingStore(20)
+	store.SetClock(clock)
+	testdata.TestExpirationWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestValueHandling(store, t)
+
+	store.Flush()
+	testdata.TestKeyCollision(store, t)
+
+	store.Flush()
+	testdata.TestPostponeWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTransientWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTypeError(store, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+
+	store.Flush()
+	testdata.TestAddExpiredWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestPointerValue(store, t)
+
+	store.Flush()
+	testdata.TestScopeNewWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTTLWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestGetSetMany(store, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouchWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestCompareAndSwap(store, t)
+
+	store.Flush()
+	testdata.TestVersion(store, t)
+
+	store.Flush()
+	testdata.TestFlushPrefix(store, t)
+
+	store.Flush()
+	testdata.TestLifetimeForWithClock(store, clock, t)
+}
+
+func TestRingStoreLifetime(t *testing.T) {
+	clock := testdata.NewClock()
+	store := NewRingStore(3)
+	store.SetClock(clock)
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	clock.Advance(time.Second * 2)
+
+	if err := store.SetLifetime(time.Second*3, data.ScopeAll); err != nil {
+		t.Fatalf("Could not set lifetime: %v", err)
+	}
+	var result int
+	if err := store.Get("v1", &result); err != nil {
+		t.Errorf("The value v1 should survive its new lifetime: %v", err)
+	}
+
+	clock.Advance(time.Second * 2)
+	if err := store.SetLifetime(time.Second, data.ScopeAll); err != nil {
+		t.Fatalf("Could not set lifetime: %v", err)
+	}
+	if err := store.Get("v1", &result); err == nil {
+		t.Error("The value v1 should expire by its new lifetime")
+	}
+}
+
+func TestRingStoreOnEvict(t *testing.T) {
+	store := NewRingStore(2)
+	var evicted []EvictEvent
+	store.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		// The callback may call back into the store
+		store.Exists(key)
+		evicted = append(evicted, EvictEvent{key, value, reason})
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := store.Add(strconv.Itoa(i), i); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+	store.Delete("1")
+
+	expected := []EvictEvent{{"0", int64(0), EvictCapacity}}
+	if !reflect.DeepEqual(evicted, expected) {
+		t.Errorf("Unexpected evictions: expected %v got %v", expected, evicted)
+	}
+}
+
+func TestRingStoreEviction(t *testing.T) {
+	store := NewRingStore(3)
+	events := store.EvictChannel()
+
+	for i := 0; i < 5; i++ {
+		if err := store.Add(strconv.Itoa(i), i); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+
+	var result int
+	if err := store.Get("2", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	store.Add("5", 5)
+
+	keys, _ := store.Keys()
+	if expected := []string{"3", "4", "5"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Unexpected keys: expected %v got %v", expected, keys)
+	}
+
+	for _, expected := range []string{"0", "1", "2"} {
+		ev := <-events
+		if ev.Key != expected || ev.Reason != EvictCapacity {
+			t.Errorf("Unexpected eviction event: %v", ev)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// A ShardedStore provides in-memory key:value cache, like Store, whose values
+// are spread among independent shards by the hash of their keys. Each shard
+// has its own lock and garbage collector, which reduces lock contention when
+// many goroutines access distinct keys.
+//
+// It is a implementation of Store interface.
+type ShardedStore struct {
+	shards []*Store
+	gcs    gcGroup
+}
+
+// NewSharded creates a new instance of ShardedStore split into specified
+// number of shards, which defines the default lifetime for new stored items
+// like New.
+func NewSharded(d time.Duration, isTransient bool, shards int) *ShardedStore {
+	if shards < 1 {
+		shards = 1
+	}
+
+	s := &ShardedStore{shards: make([]*Store, shards)}
+	for i := range s.shards {
+		s.shards[i] = New(d, isTransient)
+	}
+	return s
+}
+
+// shardOf returns the shard which holds specified key, selected by its FNV-1a
+// hash.
+func (s *ShardedStore) shardOf(key string) *Store {
+	// FNV-1a is computed inline to avoid allocating a hash.Hash32
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+// Add adds a new key:value to current store.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *ShardedStore) Add(key string, value interface{}) error {
+	return s.shardOf(key).Add(key, value)
+}
+
+// AddWithLifetime adds a new key:value to current store which expires by
+// specified lifetime instead of the lifetime of store, like Store does.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *ShardedStore) AddWithLifetime(
+	key string, value interface{}, d time.Duration,
+) error {
+	return s.shardOf(key).AddWithLifetime(key, value, d)
+}
+
+// CompareAndSwap atomically sets the value of specified key to new, whether
+// its current value equals old, reporting whether the value was swapped.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) CompareAndSwap(
+	key string, old, new interface{},
+) (bool, error) {
+	return s.shardOf(key).CompareAndSwap(key, old, new)
+}
+
+// Close stops the goroutines started by StartGC and closes every shard,
+// deleting their values. The store can still be used afterwards.
+func (s *ShardedStore) Close() error {
+	s.gcs.stop()
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+	return nil
+}
+
+// Count gets the number of stored values by every shard.
+func (s *ShardedStore) Count() (int, error) {
+	count := 0
+	for _, shard := range s.shards {
+		n, err := shard.Count()
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+
+	return count, nil
+}
+
+// Decrement atomically gets the value stored by specified key and
+// decrements it by one. If the key does not exist, it is created.
+func (s *ShardedStore) Decrement(key string) (int, error) {
+	return s.shardOf(key).Decrement(key)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value. If the key does not exist, it is created.
+func (s *ShardedStore) DecrementBy(key string, value int) (int, error) {
+	return s.shardOf(key).DecrementBy(key, value)
+}
+
+// Delete deletes the specified key:value.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) Delete(key string) error {
+	return s.shardOf(key).Delete(key)
+}
+
+// Exists reports whether a value is stored by specified key, without renewing
+// it.
+func (s *ShardedStore) Exists(key string) (bool, error) {
+	return s.shardOf(key).Exists(key)
+}
+
+// Flush deletes any cached value from every shard.
+func (s *ShardedStore) Flush() error {
+	for _, shard := range s.shards {
+		if err := shard.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FlushPrefix deletes every value whose key starts with prefix from every
+// shard.
+func (s *ShardedStore) FlushPrefix(prefix string) error {
+	for _, shard := range s.shards {
+		if err := shard.FlushPrefix(prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GC removes every expired value of each shard right now, returning how many
+// values were removed.
+func (s *ShardedStore) GC() int {
+	removed := 0
+	for _, shard := range s.shards {
+		removed += shard.GC()
+	}
+	return removed
+}
+
+// Get gets the value stored by specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) Get(key string, ref interface{}) error {
+	return s.shardOf(key).Get(key, ref)
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) GetAndReset(key string) (int, error) {
+	return s.shardOf(key).GetAndReset(key)
+}
+
+// GetMany gets the values stored by specified keys, holding the lock of each
+// shard once. Missing keys are absent from returned values.
+//
+// A value which could not be decoded is skipped without failing other keys;
+// its error is reported by a data.BatchError returned along with the decoded
+// values.
+func (s *ShardedStore) GetMany(keys []string) (map[string]interface{}, error) {
+	byShard := make(map[*Store][]string)
+	for _, key := range keys {
+		shard := s.shardOf(key)
+		byShard[shard] = append(byShard[shard], key)
+	}
+
+	errs := make(data.BatchError)
+	values := make(map[string]interface{}, len(keys))
+	for shard, keys := range byShard {
+		found, err := shard.GetMany(keys)
+		if batch, ok := err.(data.BatchError); ok {
+			for k, e := range batch {
+				errs[k] = e
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		for k, v := range found {
+			values[k] = v
+		}
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// GetWithVersion gets the value stored by specified key along with its
+// version, which can be passed to SetWithVersion.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) GetWithVersion(
+	key string, ref interface{},
+) (uint64, error) {
+	return s.shardOf(key).GetWithVersion(key, ref)
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+func (s *ShardedStore) Increment(key string) (int, error) {
+	return s.shardOf(key).Increment(key)
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created.
+func (s *ShardedStore) IncrementBy(key string, value int) (int, error) {
+	return s.shardOf(key).IncrementBy(key, value)
+}
+
+// Keys gets the sorted keys of stored values by every shard, excluding expired
+// values not removed by garbage collector yet.
+func (s *ShardedStore) Keys() ([]string, error) {
+	var keys []string
+	for _, shard := range s.shards {
+		k, err := shard.Keys()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Range calls fn for each stored value, shard by shard, until fn returns
+// false. Values are not renewed and expired values are skipped.
+//
+// The read lock of a shard is held while fn is called for its values, so fn
+// must not call back into current store, which may deadlock. Whether it needs
+// to, the keys can be snapshot by Keys beforehand.
+//
+// Errors:
+// InvalidTypeError when a value could not be decoded, which stops iteration.
+func (s *ShardedStore) Range(fn func(key string, value interface{}) bool) error {
+	stopped := false
+	for _, shard := range s.shards {
+		err := shard.Range(func(key string, value interface{}) bool {
+			stopped = !fn(key, value)
+			return !stopped
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Set sets the value of specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) Set(key string, value interface{}) error {
+	return s.shardOf(key).Set(key, value)
+}
+
+// SetClock defines the clock used by every shard to get the current time,
+// which defaults to system clock.
+func (s *ShardedStore) SetClock(c data.Clock) {
+	for _, shard := range s.shards {
+		shard.SetClock(c)
+	}
+}
+
+// SetLifetime modifies the lifetime of every shard as defined by scope.
+//
+// Errors:
+// NotSupportedError when an unknown scope is specified.
+func (s *ShardedStore) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	for _, shard := range s.shards {
+		if err := shard.SetLifetime(d, scope); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetLifetimeFor defines the lifetime of the value stored by specified key,
+// like Store does.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) SetLifetimeFor(key string, d time.Duration) error {
+	return s.shardOf(key).SetLifetimeFor(key, d)
+}
+
+// SetMany sets the values of specified keys, holding the lock of each shard
+// once. Unlike Set, a missing key is created.
+//
+// A value which could not be stored is skipped without failing other keys;
+// its error is reported by a data.BatchError.
+func (s *ShardedStore) SetMany(values map[string]interface{}) error {
+	byShard := make(map[*Store]map[string]interface{})
+	for key, value := range values {
+		shard := s.shardOf(key)
+		if byShard[shard] == nil {
+			byShard[shard] = make(map[string]interface{})
+		}
+		byShard[shard][key] = value
+	}
+
+	errs := make(data.BatchError)
+	for shard, values := range byShard {
+		err := shard.SetMany(values)
+		if batch, ok := err.(data.BatchError); ok {
+			for k, e := range batch {
+				errs[k] = e
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetTransient defines whether should extends expiration of stored value when
+// it is read or written.
+func (s *ShardedStore) SetTransient(value bool) {
+	for _, shard := range s.shards {
+		shard.SetTransient(value)
+	}
+}
+
+// SetWithVersion sets the value of specified key whether its version equals
+// expectedVersion, returning the new version.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// VersionConflictError when the version of stored value differs.
+func (s *ShardedStore) SetWithVersion(
+	key string, value interface{}, expectedVersion uint64,
+) (uint64, error) {
+	return s.shardOf(key).SetWithVersion(key, value, expectedVersion)
+}
+
+// StartGC starts a goroutine which calls GC at specified interval, regardless
+// of the garbage collector scheduled by each shard, and returns a function that
+// stops it. The stop function waits the goroutine to finish and it can be
+// called more than once.
+func (s *ShardedStore) StartGC(interval time.Duration) (stop func()) {
+	return s.gcs.start(interval, func() { s.GC() })
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) TTL(key string) (time.Duration, error) {
+	return s.shardOf(key).TTL(key)
+}
+
+// Touch renews the lifetime of the value stored by specified key without
+// reading it.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *ShardedStore) Touch(key string) error {
+	return s.shardOf(key).Touch(key)
+}
+
+var _ data.ResettableStore = (*ShardedStore)(nil)
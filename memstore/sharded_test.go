@@ -0,0 +1,119 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memstore
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+)
+
+func TestShardedStore(t *testing.T) {
+	clock := testdata.NewClock()
+	store := NewSharded(time.Millisecond, false, 8)
+	store.SetClock(clock)
+	testdata.TestExpirationWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestAtomic(store, t)
+
+	store.Flush()
+	testdata.TestValueHandling(store, t)
+
+	store.Flush()
+	testdata.TestKeyCollision(store, t)
+
+	store.Flush()
+	testdata.TestPostponeWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTransientWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+
+	store.Flush()
+	testdata.TestKeysWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestExistsWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTTLWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestGetSetMany(store, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouchWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestCompareAndSwap(store, t)
+
+	store.Flush()
+	testdata.TestVersion(store, t)
+
+	store.Flush()
+	testdata.TestFlushPrefix(store, t)
+
+	store.Flush()
+	testdata.TestLifetimeForWithClock(store, clock, t)
+}
+
+func BenchmarkShardedStoreAtomicIncrement(b *testing.B) {
+	store := NewSharded(0, true, 16)
+	testdata.BenchmarkAtomicIncrement(store, b)
+}
+
+func BenchmarkMemStoreIncrementKeys(b *testing.B) {
+	benchmarkIncrementKeys(New(time.Minute, true), b)
+}
+
+func BenchmarkShardedStoreIncrementKeys(b *testing.B) {
+	benchmarkIncrementKeys(NewSharded(time.Minute, true, 16), b)
+}
+
+// benchmarkIncrementKeys increments distinct keys from 50 goroutines per CPU,
+// which contend on the lock of a single-mutex store.
+func benchmarkIncrementKeys(store interface {
+	Increment(key string) (int, error)
+}, b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	var seed int64
+	b.ResetTimer()
+	b.SetParallelism(50)
+	b.RunParallel(func(pb *testing.PB) {
+		// Goroutines start at distinct keys to not contend on the same key
+		i := int(atomic.AddInt64(&seed, 37))
+		for pb.Next() {
+			if _, err := store.Increment(keys[i%len(keys)]); err != nil {
+				b.Errorf("Could not increment value: %v", err)
+			}
+			i++
+		}
+	})
+}
@@ -17,24 +17,73 @@
 package memstore
 
 import (
+	"context"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/raiqub/data.v0"
 	"gopkg.in/raiqub/dot.v1"
+	"gopkg.in/vmihailenco/msgpack.v2"
 )
 
+// defaultGCBatchSize defines how many expired values are removed at once by
+// garbage collector.
+const defaultGCBatchSize = 128
+
 // A Store provides in-memory key:value cache that expires after defined
 // duration of time.
 //
 // It is a implementation of Store interface.
 type Store struct {
-	values      map[string]*entry
-	lifetime    time.Duration
+	// stats is accessed atomically and must be 64-bit aligned.
+	stats data.StatsCounter
+	// droppedEvents is accessed atomically and must be 64-bit aligned.
+	droppedEvents uint64
+	values        map[string]*entry
+	lifetime      time.Duration
+	// renewal defines the lifetime given to existing values when they are
+	// renewed.
+	renewal     lifetimeGeneration
 	isTransient bool
 	mutex       sync.RWMutex
 	gcRunning   bool
+	// gcDone is closed by Close to stop the running garbage collector.
+	gcDone      chan struct{}
+	gcs         gcGroup
+	evictCh     chan EvictEvent
+	eventCh     chan StoreEvent
+	clock       data.Clock
+	maxIdle     time.Duration
+	onStore     data.ValueFunc
+	onLoad      data.ValueFunc
+	validator   data.ValidatorFunc
+	types       data.TypeRegistry
+	deadLetter  data.Store
+	gcBatchSize int
+	children    map[string]*Store
+	staleWindow time.Duration
+	refresher   func(key string) (interface{}, error)
+	// negatives holds when negatively cached keys expire.
+	negatives map[string]time.Time
+	// histogram holds the ages of removed values by reason, whether it is
+	// enabled.
+	histogram map[EvictReason]*AgeHistogram
+	onEvict   EvictFunc
+	// onError handles the errors raised by asynchronous callbacks.
+	onError data.ErrorHandler
+	// evicted holds the removals not yet notified to onEvict.
+	evicted []EvictEvent
+	// pendingEvicts flags atomically whether evicted is not empty, so reads
+	// do not lock again otherwise.
+	pendingEvicts int32
+	// compressAbove is the size of encoded values above which they are
+	// compressed, whether it is greater than zero.
+	compressAbove int
 }
 
 // New creates a new instance of in-memory Store and defines the default
@@ -47,6 +96,8 @@ func New(d time.Duration, isTransient bool) *Store {
 		values:      make(map[string]*entry),
 		lifetime:    d,
 		isTransient: isTransient,
+		clock:       data.SystemClock{},
+		gcBatchSize: defaultGCBatchSize,
 	}
 }
 
@@ -55,15 +106,100 @@ func New(d time.Duration, isTransient bool) *Store {
 // Errors:
 // DuplicatedKeyError when requested key already exists.
 func (s *Store) Add(key string, value interface{}) error {
+	return s.add(context.Background(), key, value, 0, 0)
+}
+
+// AddContext adds a new key:value to current store, like Add, unless ctx is
+// done before the lock is acquired.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+// ctx.Err() when ctx is done before the value is added.
+func (s *Store) AddContext(
+	ctx context.Context, key string, value interface{},
+) error {
+	return s.add(ctx, key, value, 0, 0)
+}
+
+// AddNegative marks specified key as known to be absent for ttl duration,
+// which is usually shorter than the lifetime of values. Meanwhile, Get returns
+// ErrNegativelyCached for the key, unless a value is stored by it. The marker
+// is never renewed and it is removed by Delete.
+//
+// Errors:
+// DuplicatedKeyError when requested key already has a value.
+func (s *Store) AddNegative(key string, ttl time.Duration) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	data, err := newEntry(s.lifetime, value)
+	if _, err := s.unsafeGet(key); err == nil {
+		return dot.DuplicatedKeyError(key)
+	}
+
+	if s.negatives == nil {
+		s.negatives = make(map[string]time.Time)
+	}
+	if !s.gcRunning {
+		go s.gc()
+	}
+	s.negatives[key] = s.clock.Now().Add(ttl)
+	return nil
+}
+
+// AddWithUses adds a new key:value to current store which is removed after
+// being read by Get for maxUses times. The value is also removed when its
+// lifetime is elapsed, whichever happens first. A maxUses lower than one
+// defines unlimited uses, as Add does.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *Store) AddWithUses(key string, value interface{}, maxUses int) error {
+	return s.add(context.Background(), key, value, maxUses, 0)
+}
+
+// AddWithLifetime adds a new key:value to current store which expires by
+// specified lifetime instead of the lifetime of store. The lifetime is kept
+// when the value is renewed, and even when the lifetime of store is modified.
+// A lifetime lower than one defines the lifetime of store, as Add does.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *Store) AddWithLifetime(
+	key string, value interface{}, d time.Duration,
+) error {
+	return s.add(context.Background(), key, value, 0, d)
+}
+
+// add adds a new key:value to current store with limited uses, whether
+// maxUses is positive, and with its own lifetime, whether lifetime is
+// positive.
+func (s *Store) add(
+	ctx context.Context, key string, value interface{}, maxUses int,
+	lifetime time.Duration,
+) error {
+	value, err := s.storeValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mutex.Unlock()
+
+	data, err := s.newEntry(s.clock.Now(), value)
 	if err != nil {
 		return err
 	}
+	if maxUses > 0 {
+		data.uses = maxUses
+	}
+	if lifetime > 0 {
+		data.SetKeyLifetime(s.clock.Now(), lifetime)
+	}
 
-	if _, ok := s.values[key]; ok {
+	// An expired value not yet collected is replaced
+	if v, ok := s.values[key]; ok && !s.isExpired(v, s.clock.Now()) {
 		return dot.DuplicatedKeyError(key)
 	}
 
@@ -71,6 +207,8 @@ func (s *Store) Add(key string, value interface{}) error {
 		go s.gc()
 	}
 	s.values[key] = data
+	delete(s.negatives, key)
+	s.added(key)
 	return nil
 }
 
@@ -80,7 +218,7 @@ func (s *Store) atomicInteger(key string, inc int) (int, error) {
 
 	v, err := s.unsafeGet(key)
 	if err != nil {
-		data, err := newEntry(s.lifetime, inc)
+		data, err := s.newEntry(s.clock.Now(), inc)
 		if err != nil {
 			return 0, err
 		}
@@ -89,6 +227,8 @@ func (s *Store) atomicInteger(key string, inc int) (int, error) {
 			go s.gc()
 		}
 		s.values[key] = data
+		delete(s.negatives, key)
+		s.added(key)
 		return inc, nil
 	}
 
@@ -98,16 +238,102 @@ func (s *Store) atomicInteger(key string, inc int) (int, error) {
 	}
 
 	value += inc
-	v.SetValue(value)
+	if err := s.setValue(key, v, s.clock.Now(), value); err != nil {
+		return 0, err
+	}
 
 	if !s.isTransient {
-		v.SetLifetime(s.lifetime)
-		v.Hit()
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
 	}
 
 	return value, nil
 }
 
+// CompareAndSwap atomically sets the value of specified key to new, whether
+// its current value equals old, reporting whether the value was swapped. The
+// current value is decoded into the type of old, after the load middleware is
+// applied, and compared by reflect.DeepEqual; a value which cannot be decoded
+// into that type does not equal old. The load middleware is called while
+// holding the write lock, hence it must not call current instance.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) CompareAndSwap(key string, old, new interface{}) (bool, error) {
+	new, err := s.storeValue(key, new)
+	if err != nil {
+		return false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return false, err
+	}
+
+	equal := equalValue(old, func(ref interface{}) error {
+		if err := v.Value(ref); err != nil {
+			return err
+		}
+		return data.ApplyValueFunc(s.onLoad, key, ref)
+	})
+	if !equal {
+		return false, nil
+	}
+
+	s.observeAge(v, EvictOverwritten)
+	if err := s.setValue(key, v, s.clock.Now(), new); err != nil {
+		return false, err
+	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	return true, nil
+}
+
+// Close stops the garbage collector of current store, including the ones
+// started by StartGC, and deletes every stored value, like Flush, from
+// current store and its children. The store can still be used afterwards,
+// running its garbage collector again on demand.
+func (s *Store) Close() error {
+	// The goroutines started by StartGC wait the lock to finish
+	s.gcs.stop()
+
+	s.mutex.Lock()
+	if s.gcDone != nil {
+		close(s.gcDone)
+		s.gcDone = nil
+	}
+	s.gcRunning = false
+	s.values = make(map[string]*entry)
+	s.negatives = nil
+	children := make([]*Store, 0, len(s.children))
+	for _, c := range s.children {
+		children = append(children, c)
+	}
+	s.mutex.Unlock()
+
+	for _, c := range children {
+		c.Close()
+	}
+	return nil
+}
+
+// CloseEvents closes the channel returned by Events, whether it was
+// requested, and stops notifying changes until Events is called again.
+func (s *Store) CloseEvents() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.eventCh != nil {
+		close(s.eventCh)
+		s.eventCh = nil
+	}
+}
+
 // Count gets the number of stored values by current instance.
 func (s *Store) Count() (int, error) {
 	s.mutex.RLock()
@@ -134,187 +360,1859 @@ func (s *Store) DecrementBy(key string, value int) (int, error) {
 	return s.atomicInteger(key, -1*value)
 }
 
-// Delete deletes the specified key:value.
+// DecrementAndDeleteAtZero atomically gets the integer value stored by
+// specified key and decrements it by one, deleting the key when the result
+// reaches zero or less. It returns the resulting value and whether the key was
+// deleted, which is suitable to release reference counts.
 //
 // Errors:
 // InvalidKeyError when requested key could not be found.
-func (s *Store) Delete(key string) error {
+// InvalidTypeError when the value stored at key is not integer.
+func (s *Store) DecrementAndDeleteAtZero(key string) (int, bool, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	_, err := s.unsafeGet(key)
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var value int
+	if err := v.Value(&value); err != nil {
+		return 0, false, err
+	}
+
+	value--
+	if value <= 0 {
+		s.notifyEvict(key, v, EvictDeleted)
+		delete(s.values, key)
+		v.release()
+		return value, true, nil
+	}
+
+	if err := s.setValue(key, v, s.clock.Now(), value); err != nil {
+		return 0, false, err
+	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+
+	return value, false, nil
+}
+
+// Delete deletes the specified key:value. A negative marker of the key, added
+// by AddNegative, is removed as well.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes the specified key:value, like Delete, unless ctx is
+// done before the lock is acquired.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// ctx.Err() when ctx is done before the value is deleted.
+func (s *Store) DeleteContext(ctx context.Context, key string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mutex.Unlock()
+
+	delete(s.negatives, key)
+	v, err := s.unsafeGet(key)
 	if err != nil {
 		return err
 	}
 
+	s.notifyEvict(key, v, EvictDeleted)
 	delete(s.values, key)
+	v.release()
 	return nil
 }
 
-// Flush deletes any cached value into current instance.
-func (s *Store) Flush() error {
+// DeletePrefix deletes every value whose key starts with prefix, returning the
+// sorted keys of deleted values. When dryRun is true the matching keys are
+// returned without deleting them, allowing to check which values would be
+// deleted.
+func (s *Store) DeletePrefix(prefix string, dryRun bool) ([]string, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.values = make(map[string]*entry)
-	return nil
+	now := s.clock.Now()
+	var keys []string
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) && !s.isExpired(v, now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if dryRun {
+		return keys, nil
+	}
+
+	for _, k := range keys {
+		v := s.values[k]
+		s.notifyEvict(k, v, EvictDeleted)
+		delete(s.values, k)
+		v.release()
+	}
+	return keys, nil
 }
 
-// Get gets the value stored by specified key.
+// Dequeue atomically removes the first item from the queue stored by specified
+// key and stores it in the value pointed to by ref. The queue is kept when it
+// becomes empty, until it expires like any value.
 //
 // Errors:
-// InvalidKeyError when requested key could not be found.
-func (s *Store) Get(key string, ref interface{}) error {
-	if s.isTransient {
-		s.mutex.RLock()
-		defer s.mutex.RUnlock()
-	} else {
-		s.mutex.Lock()
-		defer s.mutex.Unlock()
+// ErrEmpty when the queue is empty or the key could not be found.
+// InvalidTypeError when the value stored at key is not a queue or the item
+// cannot be decoded into the type of ref.
+func (s *Store) Dequeue(key string, ref interface{}) error {
+	if err := s.dequeue(key, ref); err != nil {
+		return err
 	}
 
+	return s.loadValue(key, ref)
+}
+
+// dequeue removes the first item from the queue stored by specified key
+// without applying load middleware.
+func (s *Store) dequeue(key string, ref interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	v, err := s.unsafeGet(key)
 	if err != nil {
+		return data.ErrEmpty
+	}
+
+	var queue [][]byte
+	if err := v.Value(&queue); err != nil {
 		return err
 	}
-	if !s.isTransient {
-		v.SetLifetime(s.lifetime)
-		v.Hit()
+	if len(queue) == 0 {
+		return data.ErrEmpty
+	}
+	if err := msgpack.Unmarshal(queue[0], ref); err != nil {
+		return data.NewInvalidTypeError(ref)
 	}
 
-	return v.Value(ref)
+	if err := s.setValue(key, v, s.clock.Now(), queue[1:]); err != nil {
+		return err
+	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	return nil
 }
 
-func (s *Store) gc() {
-	s.mutex.Lock()
-	if s.gcRunning {
-		s.mutex.Unlock()
-		return
+// Enqueue atomically appends specified item to the queue stored by specified
+// key, creating the queue when the key does not exist. The queue expires like
+// any value, hence an idle queue is removed after its lifetime.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not a queue.
+func (s *Store) Enqueue(key string, item interface{}) error {
+	item, err := s.storeValue(key, item)
+	if err != nil {
+		return err
+	}
+	b, err := msgpack.Marshal(item)
+	if err != nil {
+		return err
 	}
 
-	// Schedule GC at 1/5 intervals of current lifetime.
-	interval := s.lifetime / 5
-	s.gcRunning = true
-	s.mutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	for {
-		<-time.After(interval)
-
-		writeLocked := false
-		s.mutex.RLock()
-		for i := range s.values {
-			if s.values[i].IsExpired() {
-				if !writeLocked {
-					s.mutex.RUnlock()
-					s.mutex.Lock()
-					writeLocked = true
-				}
-				// TODO: Investigate how buckets are consolidated
-				delete(s.values, i)
-			}
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		v, err := s.newEntry(s.clock.Now(), [][]byte{b})
+		if err != nil {
+			return err
 		}
 
-		interval = s.lifetime / 5
-		isEmpty := len(s.values) == 0
-		if isEmpty {
-			s.gcRunning = false
-		}
-		if writeLocked {
-			s.mutex.Unlock()
-		} else {
-			s.mutex.RUnlock()
+		if !s.gcRunning {
+			go s.gc()
 		}
+		s.values[key] = v
+		delete(s.negatives, key)
+		s.added(key)
+		return nil
+	}
 
-		if isEmpty {
-			return
-		}
+	var queue [][]byte
+	if err := v.Value(&queue); err != nil {
+		return err
+	}
+	if err := s.setValue(key, v, s.clock.Now(), append(queue, b)); err != nil {
+		return err
 	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	return nil
 }
 
-// Increment atomically gets the value stored by specified key and
-// increments it by one. If the key does not exist, it is created.
+// EntriesByExpiry gets up to limit keys with their remaining lifetimes, sorted
+// by how soon they expire. A limit lower than one returns every key.
 //
-// Errors:
-// InvalidTypeError when the value stored at key is not integer.
-func (s *Store) Increment(key string) (int, error) {
-	return s.atomicInteger(key, 1)
+// The result is a snapshot of current instance, which is outdated as soon as
+// stored values are renewed or expire.
+func (s *Store) EntriesByExpiry(limit int) ([]data.KeyTTL, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := s.clock.Now()
+	entries := make([]data.KeyTTL, 0, len(s.values))
+	for k, v := range s.values {
+		if s.isExpired(v, now) {
+			continue
+		}
+		entries = append(entries, data.KeyTTL{Key: k, TTL: s.expireAt(v).Sub(now)})
+	}
+
+	sort.Sort(byTTL(entries))
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
 }
 
-// IncrementBy atomically gets the value stored by specified key and
-// increments it by value. If the key does not exist, it is created.
+// Evictions returns how many values were removed from current instance by
+// other reason than deletion, like expiration.
+func (s *Store) Evictions() uint64 {
+	return s.stats.Evictions()
+}
+
+// DroppedEvents gets the number of notifications to events channel which were
+// dropped because the channel was full. See Events.
+func (s *Store) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&s.droppedEvents)
+}
+
+// EvictChannel returns a channel which receives a notification for every value
+// removed by expiration or deletion. Only removals that happen after first
+// call are notified.
 //
-// Errors:
-// InvalidTypeError when the value stored at key is not integer.
-func (s *Store) IncrementBy(key string, value int) (int, error) {
-	return s.atomicInteger(key, value)
+// The channel is buffered and notifications are sent without blocking, so
+// when the consumer is slower than the store the exceeding notifications are
+// dropped.
+func (s *Store) EvictChannel() <-chan EvictEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.evictCh == nil {
+		s.evictCh = make(chan EvictEvent, evictChannelSize)
+	}
+	return s.evictCh
 }
 
-// Set sets the value of specified key.
+// Events returns a channel which receives a notification for every value
+// added, replaced, deleted or removed by expiration. Only changes that happen
+// after first call are notified, and values removed by Flush, FlushPrefix or
+// Close are not notified. Unlike OnEvict, every change is notified as it
+// happens, in the order they happened.
 //
-// Errors:
-// InvalidKeyError when requested key could not be found.
-func (s *Store) Set(key string, value interface{}) error {
+// The channel is buffered and notifications are sent without blocking, so
+// when the consumer is slower than the store the exceeding notifications are
+// dropped and counted by DroppedEvents. The channel is closed by CloseEvents.
+func (s *Store) Events() <-chan StoreEvent {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	v, err := s.unsafeGet(key)
-	if err != nil {
-		return err
+	if s.eventCh == nil {
+		s.eventCh = make(chan StoreEvent, eventChannelSize)
 	}
+	return s.eventCh
+}
+
+// ExpiryHistogram gets the distribution of ages of values by how they were
+// removed or overwritten, which allows tuning lifetimes: values expiring young
+// while still read suggest a short lifetime, whereas values expiring old
+// suggest a long one. It returns nil unless enabled by SetExpiryHistogram.
+func (s *Store) ExpiryHistogram() map[EvictReason]AgeHistogram {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	v.SetValue(value)
+	if s.histogram == nil {
+		return nil
+	}
 
-	if !s.isTransient {
-		v.SetLifetime(s.lifetime)
-		v.Hit()
+	result := make(map[EvictReason]AgeHistogram, len(s.histogram))
+	for reason, h := range s.histogram {
+		result[reason] = h.clone()
 	}
-	return nil
+	return result
 }
 
-// SetLifetime modifies the lifetime for new stored items or for existing items
-// when it is read or written.
-//
-// Errors:
-// NotSupportedError when ScopeNew is specified.
-func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+// Exists reports whether a value is stored by specified key. Unlike Get, the
+// value is neither renewed nor does it count as a use, even when current store
+// is not transient.
+func (s *Store) Exists(key string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, err := s.unsafeGet(key)
+	return err == nil, nil
+}
+
+// Flush deletes any cached value into current instance, including values of
+// child stores returned by Sub.
+func (s *Store) Flush() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	switch scope {
-	case data.ScopeAll:
-		for _, v := range s.values {
-			v.SetLifetime(d)
-		}
-	case data.ScopeNewAndUpdated:
-	case data.ScopeNew:
-		return dot.NotSupportedError("ScopeNew")
-	default:
-		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	s.values = make(map[string]*entry)
+	s.negatives = nil
+	for _, c := range s.children {
+		c.Flush()
 	}
-
-	s.lifetime = d
 	return nil
 }
 
-// SetTransient defines whether should extends expiration of stored value when
-// it is read or written.
-func (s *Store) SetTransient(value bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// FlushPrefix deletes every value whose key starts with prefix, including
+// expired values and negatively cached keys, holding the write lock once. Like
+// Flush, the deleted values are not notified.
+func (s *Store) FlushPrefix(prefix string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	s.isTransient = value
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.values, k)
+			v.release()
+		}
+	}
+	for k := range s.negatives {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.negatives, k)
+		}
+	}
+	return nil
 }
 
-// unsafeGet gets one entry instance from its key without locking.
+// Get gets the value stored by specified key.
 //
 // Errors:
 // InvalidKeyError when requested key could not be found.
-func (s *Store) unsafeGet(key string) (*entry, error) {
+// ErrNegativelyCached when requested key is known to be absent.
+func (s *Store) Get(key string, ref interface{}) error {
+	return s.GetContext(context.Background(), key, ref)
+}
+
+// GetContext gets the value stored by specified key, like Get, unless ctx is
+// done before the lock is acquired.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// ErrNegativelyCached when requested key is known to be absent.
+// ctx.Err() when ctx is done before the value is read.
+func (s *Store) GetContext(
+	ctx context.Context, key string, ref interface{},
+) error {
+	_, err := s.get(ctx, key, 0, ref)
+	s.runEvictFunc()
+	if err == nil {
+		err = s.loadValue(key, ref)
+	}
+
+	s.stats.Observe(err)
+	return err
+}
+
+// get gets the value stored by specified key without applying load
+// middleware, returning its version. The value is not decoded into ref when
+// its version equals known.
+func (s *Store) get(
+	ctx context.Context, key string, known uint64, ref interface{},
+) (uint64, error) {
+	if ok, version, err := s.readOnlyGet(ctx, key, known, ref); ok {
+		return version, err
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, s.missing(key, err)
+	}
+	v.AddHit()
+	if v.IsExpired(s.clock.Now()) && s.refresher != nil && !v.refreshing {
+		v.refreshing = true
+		go s.refresh(key, v, s.refresher)
+	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	if s.maxIdle > 0 {
+		v.Read(s.clock.Now())
+	}
+
+	version := v.version
+	if version == known {
+		return version, nil
+	}
+	if err := v.Value(ref); err != nil {
+		return 0, err
+	}
+
+	if v.uses > 0 {
+		v.uses--
+		if v.uses == 0 {
+			s.notifyEvict(key, v, EvictUsedUp)
+			delete(s.values, key)
+			v.release()
+		}
+	}
+
+	return version, nil
+}
+
+// GetOrAdd gets the value stored by specified key into the value pointed to by
+// ref, renewing it unless current store is transient. Whether the key is
+// missing, the value computed by factory is stored and then read into ref. The
+// write lock is held while factory runs, so concurrent callers never compute
+// the same value twice, but every other operation waits for it.
+//
+// An error returned by factory is returned without storing anything.
+func (s *Store) GetOrAdd(
+	key string, ref interface{}, factory data.FactoryFunc,
+) error {
+	s.mutex.Lock()
+	err := s.unsafeGetOrAdd(key, ref, factory)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.loadValue(key, ref)
+}
+
+// GetIfChanged gets the value stored by specified key only whether its version
+// differs from knownVersion, reporting whether it changed along with current
+// version. When the version equals knownVersion the value is neither decoded
+// into ref nor counted as a use, which suits conditional requests like HTTP
+// If-None-Match. Every write to a value assigns it a new version, which is
+// never zero, so a zero knownVersion always gets the value.
+//
+// A missing or expired key is not reported as unchanged, it returns an error
+// instead.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) GetIfChanged(
+	key string, knownVersion uint64, ref interface{},
+) (bool, uint64, error) {
+	version, err := s.get(context.Background(), key, knownVersion, ref)
+	s.runEvictFunc()
+	if err != nil {
+		return false, 0, err
+	}
+	if version == knownVersion {
+		return false, version, nil
+	}
+
+	return true, version, s.loadValue(key, ref)
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero. Unlike Increment, a missing key is not created.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) GetAndReset(key string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var value int
+	if err := v.Value(&value); err != nil {
+		return 0, err
+	}
+	v.Read(s.clock.Now())
+
+	if err := s.setValue(key, v, s.clock.Now(), 0); err != nil {
+		return 0, err
+	}
+
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+
+	return value, nil
+}
+
+// GetMany gets the values stored by specified keys, each decoded like
+// GetValue, holding the lock once for every key. Missing keys are absent from
+// returned values.
+//
+// A value which could not be decoded, like a value whose type differs from the
+// type registered for its key, is skipped without failing other keys; its error
+// is reported by a data.BatchError returned along with the decoded values.
+func (s *Store) GetMany(keys []string) (map[string]interface{}, error) {
+	errs := make(data.BatchError)
+	refs := make(map[string]interface{}, len(keys))
+
+	s.mutex.Lock()
+	now := s.clock.Now()
+	for _, key := range keys {
+		v, err := s.unsafeGet(key)
+		if err != nil {
+			continue
+		}
+
+		typ, ok := s.types.TypeOf(key)
+		if !ok {
+			typ = reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		ref := reflect.New(typ).Interface()
+		if err := v.Value(ref); err != nil {
+			errs[key] = err
+			continue
+		}
+		refs[key] = ref
+
+		v.AddHit()
+		if !s.isTransient {
+			v.SetLifetime(s.renewal)
+			v.Hit(now)
+		}
+		if s.maxIdle > 0 {
+			v.Read(now)
+		}
+		if v.uses > 0 {
+			v.uses--
+			if v.uses == 0 {
+				s.notifyEvict(key, v, EvictUsedUp)
+				delete(s.values, key)
+				v.release()
+			}
+		}
+	}
+	s.mutex.Unlock()
+	s.runEvictFunc()
+
+	values := make(map[string]interface{}, len(refs))
+	for key, ref := range refs {
+		if err := data.ApplyValueFunc(s.onLoad, key, ref); err != nil {
+			errs[key] = err
+			continue
+		}
+		values[key] = reflect.ValueOf(ref).Elem().Interface()
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// GetValue gets the value stored by specified key decoded into a new value of
+// the type registered by RegisterType for the key. When no registered prefix
+// matches the key the value is decoded into an empty interface.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) GetValue(key string) (interface{}, error) {
+	return s.types.GetValue(key, s.Get)
+}
+
+// GetWithVersion gets the value stored by specified key along with its
+// version, which can be passed to SetWithVersion to write the value only
+// whether it was not written meanwhile. Every write to a value assigns it a
+// greater version, which is never zero.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) GetWithVersion(key string, ref interface{}) (uint64, error) {
+	version, err := s.get(context.Background(), key, 0, ref)
+	s.runEvictFunc()
+	if err != nil {
+		return 0, err
+	}
+
+	return version, s.loadValue(key, ref)
+}
+
+// GetWithXFetch gets the value stored by specified key, like Get, and reports
+// whether caller should recompute it before it expires, according to
+// data.ShouldRecompute using the remaining lifetime of the value prior to
+// being read.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) GetWithXFetch(key string, ref interface{}, beta float64) (bool, error) {
+	s.mutex.RLock()
+	v, err := s.unsafeGet(key)
+	var ttl, lifetime time.Duration
+	if err == nil {
+		ttl = s.expireAt(v).Sub(s.clock.Now())
+		lifetime = v.Lifetime()
+	}
+	s.mutex.RUnlock()
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.Get(key, ref); err != nil {
+		return false, err
+	}
+
+	return data.ShouldRecompute(ttl, lifetime, beta), nil
+}
+
+func (s *Store) gc() {
+	s.mutex.Lock()
+	// An empty store, like one closed since gc was scheduled, needs no
+	// collector
+	if s.gcRunning || (len(s.values) == 0 && len(s.negatives) == 0) {
+		s.mutex.Unlock()
+		return
+	}
+
+	// Schedule GC at 1/5 intervals of current lifetime.
+	interval := gcInterval(s.lifetime)
+	s.gcRunning = true
+	if s.gcDone == nil {
+		s.gcDone = make(chan struct{})
+	}
+	done := s.gcDone
+	s.mutex.Unlock()
+
+	for {
+		select {
+		case <-time.After(interval):
+		case <-done:
+			return
+		}
+		s.GC()
+
+		s.mutex.Lock()
+		if s.gcDone != done {
+			// Closed meanwhile
+			s.mutex.Unlock()
+			return
+		}
+		interval = gcInterval(s.lifetime)
+		isEmpty := len(s.values) == 0 && len(s.negatives) == 0
+		if isEmpty {
+			s.gcRunning = false
+		}
+		s.mutex.Unlock()
+
+		if isEmpty {
+			return
+		}
+	}
+}
+
+// GC removes every expired value and negatively cached key right now,
+// returning how many values were removed. It is called by the garbage
+// collector, which runs while current store is not empty, hence it only needs
+// to be called to reclaim memory sooner, like by StartGC.
+func (s *Store) GC() int {
+	// Expired keys are collected under read lock and removed afterwards,
+	// since the map could be changed (or replaced by Flush) while the write
+	// lock is not acquired.
+	s.mutex.RLock()
+	now := s.clock.Now()
+	var expired []string
+	for k, v := range s.values {
+		if s.isExpired(v, now) {
+			expired = append(expired, k)
+		}
+	}
+	batchSize := s.gcBatchSize
+	hasNegatives := len(s.negatives) > 0
+	s.mutex.RUnlock()
+
+	// Expired keys are removed in batches, so readers are blocked only while a
+	// single batch is removed.
+	removed := 0
+	for len(expired) > 0 {
+		batch := expired
+		if batchSize > 0 && len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		expired = expired[len(batch):]
+
+		removed += s.removeExpired(batch)
+	}
+
+	if hasNegatives {
+		s.mutex.Lock()
+		for k, at := range s.negatives {
+			if !now.Before(at) {
+				delete(s.negatives, k)
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	return removed
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not integer.
+func (s *Store) Increment(key string) (int, error) {
+	return s.atomicInteger(key, 1)
+}
+
+// IncrementField atomically increments by delta the numeric field of the struct
+// value stored by specified key, returning the resulting field value. The
+// field is identified by its serialized name.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// InvalidFieldError when requested field could not be found.
+// InvalidTypeError when stored value is not a struct or the field is not
+// numeric.
+func (s *Store) IncrementField(key, field string, delta int64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var m map[string]interface{}
+	if err := v.Value(&m); err != nil {
+		return 0, data.NewInvalidTypeError(m)
+	}
+
+	value, err := data.IncrementMapField(m, key, field, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.setValue(key, v, s.clock.Now(), m); err != nil {
+		return 0, err
+	}
+
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+
+	return value, nil
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created. An expired
+// value is handled as a missing key, so it is replaced by value even before
+// being removed by garbage collector.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not integer, which is kept
+// unchanged.
+func (s *Store) IncrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, value)
+}
+
+// Keys gets the sorted keys of stored values by current instance, excluding
+// expired values not removed by garbage collector yet.
+func (s *Store) Keys() ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := s.clock.Now()
+	keys := make([]string, 0, len(s.values))
+	for k, v := range s.values {
+		if !s.isExpired(v, now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// KindOf gets the kind of the value stored by specified key, as it would be
+// decoded into an empty interface, without decoding it. Following
+// data.GenericValue, integers are reported as reflect.Int64, floating-point
+// numbers as reflect.Float64, structs as reflect.Map and nil as
+// reflect.Invalid.
+//
+// Unlike Get, the value is neither renewed nor does it count as a use.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) KindOf(key string) (reflect.Kind, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return reflect.Invalid, err
+	}
+
+	return v.Kind(), nil
+}
+
+// Meta gets the metadata of the value stored by specified key, without
+// counting it as a read. Tracking metadata costs 56 bytes per entry on 64-bit
+// platforms.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) Meta(key string) (data.EntryMeta, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return data.EntryMeta{}, err
+	}
+
+	return data.EntryMeta{
+		CreatedAt: v.createdAt,
+		UpdatedAt: v.updatedAt,
+		ExpiresAt: s.expireAt(v),
+		Hits:      v.Hits(),
+	}, nil
+}
+
+// OnEvict defines a callback notified of every value removed from current store
+// by other reason than deletion, like expiration when it is removed by garbage
+// collector or a value used up by AddWithUses. The reason of removal is
+// reported by an EvictReason; values evicted by capacity are only removed by
+// RingStore, which notifies them by its own OnEvict. A nil function disables
+// the notification.
+//
+// The callback is called without holding any lock, after the value is removed,
+// so it can call back into current store. A panic raised by it is recovered
+// and passed to the handler defined by SetErrorHandler.
+func (s *Store) OnEvict(fn EvictFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.onEvict = fn
+	if fn == nil {
+		s.evicted = nil
+	}
+}
+
+// Range calls fn for each stored value, in no particular order, until fn
+// returns false. Each value is decoded like GetValue, without being renewed nor
+// counting as a use, and expired values not removed by garbage collector yet
+// are skipped.
+//
+// The read lock is held while fn is called, so fn must not call back into
+// current store, which may deadlock. Whether it needs to, the keys can be
+// snapshot by Keys beforehand.
+//
+// Errors:
+// InvalidTypeError when a value could not be decoded, which stops iteration.
+func (s *Store) Range(fn func(key string, value interface{}) bool) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := s.clock.Now()
+	for k, v := range s.values {
+		if s.isExpired(v, now) {
+			continue
+		}
+
+		value, err := s.rangeValue(k, v)
+		if err != nil {
+			return err
+		}
+		if !fn(k, value) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// rangeValue decodes the value of v stored by specified key, as Range does,
+// into the type registered for key or into an empty interface.
+func (s *Store) rangeValue(key string, v *entry) (interface{}, error) {
+	typ, ok := s.types.TypeOf(key)
+	if !ok {
+		typ = reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+	ref := reflect.New(typ).Interface()
+	if err := v.Value(ref); err != nil {
+		return nil, err
+	}
+	if err := data.ApplyValueFunc(s.onLoad, key, ref); err != nil {
+		return nil, err
+	}
+
+	return reflect.ValueOf(ref).Elem().Interface(), nil
+}
+
+// RegisterType sets the type of proto as the type which GetValue decodes values
+// into, for every key starting with prefix. When multiple prefixes match a key
+// the longest one takes precedence. A nil proto removes the prefix.
+func (s *Store) RegisterType(prefix string, proto interface{}) {
+	s.types.Register(prefix, proto)
+}
+
+// RefreshIfExpired atomically checks whether the value stored by specified key
+// is missing or expired and, if so, stores the value returned by fn with a
+// fresh lifetime, reporting whether it was refreshed. Concurrent callers for
+// an expired key refresh it only once.
+//
+// Unlike other callbacks, fn is called while holding the write lock, as well
+// as validator and value middleware for its result, hence they must not call
+// current instance. A panic raised by fn is recovered and returned as
+// CallbackPanicError.
+func (s *Store) RefreshIfExpired(
+	key string, fn func() (interface{}, error),
+) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.unsafeGet(key); err == nil {
+		return false, nil
+	}
+
+	value, err := callRefreshFunc(fn)
+	if err != nil {
+		return false, err
+	}
+	if err := data.CallValidatorFunc(s.validator, key, value); err != nil {
+		return false, err
+	}
+	if s.onStore != nil {
+		if value, err = data.CallValueFunc(s.onStore, key, value); err != nil {
+			return false, err
+		}
+	}
+
+	v, err := s.newEntry(s.clock.Now(), value)
+	if err != nil {
+		return false, err
+	}
+
+	if !s.gcRunning {
+		go s.gc()
+	}
+	s.values[key] = v
+	delete(s.negatives, key)
+	s.added(key)
+	return true, nil
+}
+
+// ResetStats sets every counter reported by Stats to zero, including the
+// counter reported by Evictions.
+func (s *Store) ResetStats() {
+	s.stats.Reset()
+}
+
+// Set sets the value of specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) Set(key string, value interface{}) error {
+	return s.SetContext(context.Background(), key, value)
+}
+
+// SetContext sets the value of specified key, like Set, unless ctx is done
+// before the lock is acquired.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// ctx.Err() when ctx is done before the value is written.
+func (s *Store) SetContext(
+	ctx context.Context, key string, value interface{},
+) error {
+	value, err := s.storeValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	s.observeAge(v, EvictOverwritten)
+	if err := s.setValue(key, v, s.clock.Now(), value); err != nil {
+		return err
+	}
+
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	return nil
+}
+
+// SetMany sets the values of specified keys, holding the lock once for every
+// key. Unlike Set, a missing key is created.
+//
+// A value which could not be stored, like a value rejected by the validator,
+// is skipped without failing other keys; its error is reported by a
+// data.BatchError.
+func (s *Store) SetMany(values map[string]interface{}) error {
+	errs := make(data.BatchError)
+	stored := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		value, err := s.storeValue(key, value)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		stored[key] = value
+	}
+
+	s.mutex.Lock()
+	now := s.clock.Now()
+	for key, value := range stored {
+		// An expired value not yet collected is replaced
+		if v, ok := s.values[key]; ok && !s.isExpired(v, now) {
+			s.observeAge(v, EvictOverwritten)
+			if err := s.setValue(key, v, now, value); err != nil {
+				errs[key] = err
+				continue
+			}
+			if !s.isTransient {
+				v.SetLifetime(s.renewal)
+				v.Hit(now)
+			}
+			continue
+		}
+
+		v, err := s.newEntry(now, value)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		s.values[key] = v
+		delete(s.negatives, key)
+		s.added(key)
+	}
+	if len(s.values) > 0 && !s.gcRunning {
+		go s.gc()
+	}
+	s.mutex.Unlock()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetClock defines the clock used to get the current time, which defaults to
+// system clock. The stored values are expired considering the time provided by
+// the clock, although the garbage collection is scheduled using system clock.
+func (s *Store) SetClock(c data.Clock) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clock = c
+}
+
+// SetCompression defines the size, in bytes, above which new and updated
+// values are compressed by gzip once encoded. Zero or negative disables
+// compression, which is the default. Values already stored are kept as they
+// are.
+//
+// Compression trades CPU time on each read and write for memory, hence it
+// suits stores holding large values which are read less often.
+func (s *Store) SetCompression(threshold int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.compressAbove = threshold
+}
+
+// SetMaxIdle defines the maximum duration which a stored value can stay without
+// being read by Get or GetAndReset, regardless of writes. A zero duration
+// disables idle expiration.
+//
+// A value expires either when its lifetime or its max idle duration is
+// elapsed, whichever happens first. Unlike lifetime, max idle duration is not
+// renewed when the value is written.
+func (s *Store) SetMaxIdle(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maxIdle = d
+}
+
+// SetValidator defines a function to check every value written by Add or Set
+// before it is stored. When it returns an error, the value is not stored and
+// that error is returned to the caller. A nil function disables validation.
+//
+// The validator receives the value provided by caller, before any value
+// middleware is applied. It is called without holding any lock and a panic
+// raised by it is recovered and returned as CallbackPanicError.
+func (s *Store) SetValidator(fn data.ValidatorFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.validator = fn
+}
+
+// SetValueMiddleware defines functions to transform every value written by Add
+// or Set (onStore) and read by Get (onLoad). Any of them can be nil.
+//
+// The middleware handles Go values, hence onStore runs before the value is
+// encoded and onLoad runs after the value is decoded into the reference
+// provided to Get. The result of onLoad must be assignable to that reference.
+// Integer values handled by atomic operations are not transformed.
+//
+// The functions are called without holding any lock and a panic raised by
+// them is recovered and returned as CallbackPanicError.
+func (s *Store) SetValueMiddleware(onStore, onLoad data.ValueFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.onStore = onStore
+	s.onLoad = onLoad
+}
+
+// SetDeadLetter defines a store which receives every value removed by
+// expiration, instead of silently dropping it. A nil store disables it.
+//
+// Expired values are first removed from current instance, and then added to
+// dead-letter store, in the order they are collected and without holding any
+// lock. Values are decoded into an empty interface before being added, so
+// structs are moved as maps. When Add fails, like when the key already exists
+// at dead-letter store, the value is discarded. Only values collected by
+// expiration are moved, deleted or flushed values are not.
+func (s *Store) SetDeadLetter(dst data.Store) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.deadLetter = dst
+}
+
+// SetErrorHandler defines a handler of the errors raised by asynchronous
+// callbacks, which have no caller to return them to. A panic raised by the
+// callback of OnEvict is passed as CallbackPanicError by "OnEvict" operation,
+// and an error returned by the refresher of SetStaleWindow, or a panic raised
+// by it, is passed by "Refresh" operation. A nil handler discards them, which
+// is the default.
+func (s *Store) SetErrorHandler(fn data.ErrorHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.onError = fn
+}
+
+// SetExpiryHistogram defines whether the ages of removed and overwritten values
+// are counted by ExpiryHistogram, which is disabled by default to avoid its
+// overhead on every removal. Disabling it discards counted ages.
+func (s *Store) SetExpiryHistogram(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !enabled {
+		s.histogram = nil
+	} else if s.histogram == nil {
+		s.histogram = make(map[EvictReason]*AgeHistogram)
+	}
+}
+
+// SetGCBatchSize sets how many expired values are removed at once by garbage
+// collector, which is the most values removed while readers are blocked. A
+// size lower than one removes every expired value at once.
+func (s *Store) SetGCBatchSize(size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.gcBatchSize = size
+}
+
+// SetLifetime modifies the lifetime for new stored items and, as defined by
+// scope, for existing items: ScopeAll applies it to every existing item,
+// ScopeNewAndUpdated applies it to existing items when they are read or
+// written and ScopeNew keeps the lifetime of existing items, even when they
+// are renewed. Items with their own lifetime, defined by AddWithLifetime or
+// SetLifetimeFor, keep it regardless of scope.
+//
+// Errors:
+// NotSupportedError when an unknown scope is specified.
+func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch scope {
+	case data.ScopeAll:
+		s.renewal = s.renewal.next(d)
+		for _, v := range s.values {
+			v.SetLifetime(s.renewal)
+		}
+	case data.ScopeNewAndUpdated:
+		s.renewal = s.renewal.next(d)
+	case data.ScopeNew:
+		// Existing values keep their lifetime, which is older than d
+	default:
+		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	}
+
+	s.lifetime = d
+	return nil
+}
+
+// SetLifetimeFor defines the lifetime of the value stored by specified key,
+// which is renewed from now on, like AddWithLifetime does. A lifetime lower
+// than one restores the lifetime of store for the value.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) SetLifetimeFor(key string, d time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	if d < 1 {
+		v.keyLifetime = false
+		v.lifetime = s.lifetime
+		v.generation = s.renewal.gen
+		v.Hit(now)
+		return nil
+	}
+	v.SetKeyLifetime(now, d)
+	return nil
+}
+
+// SetStaleWindow defines for how long a value is kept after its lifetime is
+// over, as a stale value, and a function to load a fresh value for specified
+// key. Zero window disables stale values, which is the default.
+//
+// When a stale value is read it is returned immediately and, whether refresher
+// is not nil, the fresh value is loaded asynchronously to replace it. Only one
+// refresh runs at once for each value and a failed refresh is retried by next
+// read. A stale value which is not refreshed until the end of stale window is
+// removed as expired.
+//
+// The refresher is called without holding any lock and a panic raised by it
+// is handled as a failed refresh. Failed refreshes are passed to the handler
+// defined by SetErrorHandler.
+func (s *Store) SetStaleWindow(
+	d time.Duration, refresher func(key string) (interface{}, error),
+) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.staleWindow = d
+	s.refresher = refresher
+}
+
+// SetTransient defines whether should extends expiration of stored value when
+// it is read or written.
+func (s *Store) SetTransient(value bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.isTransient = value
+}
+
+// SetWithVersion sets the value of specified key whether its version equals
+// expectedVersion, as returned by GetWithVersion, returning the new version.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// VersionConflictError when the version of stored value differs.
+func (s *Store) SetWithVersion(
+	key string, value interface{}, expectedVersion uint64,
+) (uint64, error) {
+	value, err := s.storeValue(key, value)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return 0, err
+	}
+	if v.version != expectedVersion {
+		return 0, data.NewVersionConflictError(key, expectedVersion, v.version)
+	}
+
+	s.observeAge(v, EvictOverwritten)
+	if err := s.setValue(key, v, s.clock.Now(), value); err != nil {
+		return 0, err
+	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	return v.version, nil
+}
+
+// StartGC starts a goroutine which calls GC at specified interval, regardless
+// of the garbage collector scheduled by current store, and returns a function
+// that stops it. The stop function waits the goroutine to finish and it can
+// be called more than once.
+func (s *Store) StartGC(interval time.Duration) (stop func()) {
+	return s.gcs.start(interval, func() { s.GC() })
+}
+
+// Stats gets the hits, misses, additions and evictions counted by current
+// instance since it was created or ResetStats was called, along with the number
+// of stored values. A hit is a Get call which found the requested key, whereas
+// a miss is a Get call which returned InvalidKeyError or ErrNegativelyCached.
+// The returned error is always nil, which is kept to implement
+// data.StatsProvider.
+func (s *Store) Stats() (data.Stats, error) {
+	s.mutex.RLock()
+	size := len(s.values)
+	s.mutex.RUnlock()
+
+	return s.stats.Stats(size), nil
+}
+
+// Sub gets the child store scoped to specified namespace, creating it on first
+// access. A child store is a independent Store, which can be flushed or counted
+// apart from its parent, and may have children of its own.
+//
+// A new child inherits the lifetime, transient mode, clock, max idle duration,
+// value middleware, validator, garbage collector batch size and dead-letter store which
+// its parent has at that time. Later changes to parent are not propagated.
+//
+// Children are kept for as long as their parent, even when they are empty,
+// so a child returned by Sub is always the one its parent manages. An empty
+// child holds no garbage collector running. Flushing a parent flushes all
+// its children.
+func (s *Store) Sub(namespace string) *Store {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c, ok := s.children[namespace]; ok {
+		return c
+	}
+
+	c := &Store{
+		values:        make(map[string]*entry),
+		lifetime:      s.lifetime,
+		isTransient:   s.isTransient,
+		clock:         s.clock,
+		maxIdle:       s.maxIdle,
+		onStore:       s.onStore,
+		onLoad:        s.onLoad,
+		validator:     s.validator,
+		deadLetter:    s.deadLetter,
+		gcBatchSize:   s.gcBatchSize,
+		compressAbove: s.compressAbove,
+	}
+	if s.children == nil {
+		s.children = make(map[string]*Store)
+	}
+	s.children[namespace] = c
+	return c
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key, by its
+// lifetime or by max idle duration, whichever is sooner. It is zero or
+// negative when the value is expired but not removed yet, including stale
+// values.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	v, ok := s.values[key]
 	if !ok {
+		return 0, dot.InvalidKeyError(key)
+	}
+
+	return s.expireAt(v).Sub(s.clock.Now()), nil
+}
+
+// Touch renews the lifetime of the value stored by specified key without
+// reading it, even when current store is transient.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) Touch(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	v.SetLifetime(s.renewal)
+	v.Hit(now)
+	v.Read(now)
+	return nil
+}
+
+// TouchMany renews the lifetime of every existing key from specified keys and
+// returns how many keys were found. Missing keys are skipped.
+func (s *Store) TouchMany(keys []string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	count := 0
+	for _, key := range keys {
+		v, err := s.unsafeGet(key)
+		if err != nil {
+			continue
+		}
+		v.SetLifetime(s.renewal)
+		v.Hit(now)
+		v.Read(now)
+		count++
+	}
+
+	return count, nil
+}
+
+// refresh loads a fresh value for specified key to replace its stale entry.
+// The entry is kept when it was replaced meanwhile or the refresher fails, in
+// which case the error is passed to the error handler without holding the
+// lock.
+func (s *Store) refresh(
+	key string, old *entry, refresher func(key string) (interface{}, error),
+) {
+	value, err := callRefreshFunc(func() (interface{}, error) {
+		return refresher(key)
+	})
+	if err == nil {
+		value, err = s.storeValue(key, value)
+	}
+
+	s.mutex.Lock()
+	onError := s.onError
+	old.refreshing = false
+	if err == nil && s.values[key] == old {
+		var v *entry
+		if v, err = s.newEntry(s.clock.Now(), value); err == nil {
+			v.createdAt = old.createdAt
+			s.values[key] = v
+			old.release()
+		}
+	}
+	s.mutex.Unlock()
+
+	if err != nil && onError != nil {
+		onError("Refresh", key, err)
+	}
+}
+
+// callRefreshFunc calls fn recovering from any panic raised by it, which is
+// returned as CallbackPanicError.
+func callRefreshFunc(fn func() (interface{}, error)) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			v, err = nil, data.NewCallbackPanicError(r)
+		}
+	}()
+
+	return fn()
+}
+
+// Update atomically applies fn to the value stored by specified key, storing
+// its result as the new value. A missing key passes nil to fn and its result
+// is added as a new value; fn can return an error, like dot.InvalidKeyError,
+// to refuse it. When fn returns an error nothing is stored and that error is
+// returned.
+//
+// The current value is decoded into an empty interface, as data.GenericValue
+// defines, after the load middleware is applied. Unlike other callbacks, fn is
+// called while holding the write lock, as well as validator and value
+// middleware, hence they must not call current instance. A panic raised by fn
+// is recovered and returned as CallbackPanicError.
+func (s *Store) Update(key string, fn data.UpdateFunc) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var current interface{}
+	v, err := s.unsafeGet(key)
+	if err == nil {
+		if err := v.Value(&current); err != nil {
+			return err
+		}
+		if err := data.ApplyValueFunc(s.onLoad, key, &current); err != nil {
+			return err
+		}
+	}
+
+	value, err := data.CallUpdateFunc(fn, current)
+	if err != nil {
+		return err
+	}
+	if err := data.CallValidatorFunc(s.validator, key, value); err != nil {
+		return err
+	}
+	if s.onStore != nil {
+		if value, err = data.CallValueFunc(s.onStore, key, value); err != nil {
+			return err
+		}
+	}
+
+	if v == nil {
+		if v, err = s.newEntry(s.clock.Now(), value); err != nil {
+			return err
+		}
+		if !s.gcRunning {
+			go s.gc()
+		}
+		s.values[key] = v
+		delete(s.negatives, key)
+		s.added(key)
+		return nil
+	}
+
+	s.observeAge(v, EvictOverwritten)
+	if err := s.setValue(key, v, s.clock.Now(), value); err != nil {
+		return err
+	}
+	if !s.isTransient {
+		v.SetLifetime(s.renewal)
+		v.Hit(s.clock.Now())
+	}
+	return nil
+}
+
+// expireAt returns when specified entry expires, by its lifetime or by not
+// being read for longer than max idle duration.
+func (s *Store) expireAt(v *entry) time.Time {
+	if s.maxIdle > 0 {
+		idleAt := v.readAt.Add(s.maxIdle)
+		if idleAt.Before(v.ExpireAt()) {
+			return idleAt
+		}
+	}
+	return v.ExpireAt()
+}
+
+// isExpired returns whether specified entry is expired by its lifetime, added
+// to stale window, or by not being read for longer than max idle duration.
+func (s *Store) isExpired(v *entry, now time.Time) bool {
+	return v.IsExpired(now.Add(-s.staleWindow)) ||
+		(s.maxIdle > 0 && v.IsIdle(now, s.maxIdle))
+}
+
+// loadValue applies the load middleware to the value pointed to by ref.
+func (s *Store) loadValue(key string, ref interface{}) error {
+	s.mutex.RLock()
+	fn := s.onLoad
+	s.mutex.RUnlock()
+
+	return data.ApplyValueFunc(fn, key, ref)
+}
+
+// readOnlyGet gets the value stored by specified key holding only the read
+// lock, unless its version equals known. It returns false when the value has
+// limited uses or current store renews values, tracks idle time or refreshes
+// stale values, which require the write lock.
+func (s *Store) readOnlyGet(
+	ctx context.Context, key string, known uint64, ref interface{},
+) (bool, uint64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return true, 0, err
+	}
+	defer s.mutex.RUnlock()
+
+	if !s.isTransient || s.maxIdle > 0 || s.staleWindow > 0 {
+		return false, 0, nil
+	}
+
+	v, err := s.unsafeGet(key)
+	if err != nil {
+		return true, 0, s.missing(key, err)
+	}
+	if v.uses > 0 {
+		return false, 0, nil
+	}
+	v.AddHit()
+	if v.version == known {
+		return true, known, nil
+	}
+
+	return true, v.version, v.Value(ref)
+}
+
+// removeExpired removes specified keys whether they are still expired, moving
+// them to dead-letter store when it is defined.
+func (s *Store) removeExpired(keys []string) int {
+	s.mutex.Lock()
+	now := s.clock.Now()
+	deadLetter := s.deadLetter
+	var dead []deadEntry
+	removed := 0
+	for _, k := range keys {
+		// The value could be renewed, replaced or flushed meanwhile.
+		v, ok := s.values[k]
+		if !ok || !s.isExpired(v, now) {
+			continue
+		}
+
+		s.notifyEvict(k, v, EvictExpired)
+		delete(s.values, k)
+		removed++
+		if deadLetter != nil {
+			dead = append(dead, deadEntry{k, v})
+		} else {
+			v.release()
+		}
+	}
+	s.mutex.Unlock()
+
+	s.runEvictFunc()
+	moveDeadLetters(deadLetter, dead)
+	for _, e := range dead {
+		e.release()
+	}
+	return removed
+}
+
+// missing returns the error for a key whose value could not be found, which is
+// ErrNegativelyCached whether the key is known to be absent; otherwise, err.
+func (s *Store) missing(key string, err error) error {
+	if at, ok := s.negatives[key]; ok && s.clock.Now().Before(at) {
+		return data.ErrNegativelyCached
+	}
+	return err
+}
+
+// moveDeadLetters adds specified expired entries to dead-letter store. It must
+// be called without holding the lock, since dst could be current instance.
+func moveDeadLetters(dst data.Store, entries []deadEntry) {
+	for _, e := range entries {
+		var value interface{}
+		if err := e.Value(&value); err != nil {
+			continue
+		}
+		dst.Add(e.key, value)
+	}
+}
+
+// notifyEvict counts a value removed by other reason than deletion and sends a
+// non-blocking notification to eviction channel, whether it was requested. It
+// must be called while holding the write lock.
+func (s *Store) notifyEvict(key string, v *entry, reason EvictReason) {
+	if reason != EvictDeleted {
+		s.stats.Evicted(1)
+	}
+	s.observeAge(v, reason)
+	sendEvict(s.evictCh, key, v, reason)
+	s.sendEvent(key, eventOf(reason))
+
+	if s.onEvict != nil && reason != EvictDeleted {
+		var value interface{}
+		v.Value(&value)
+		s.evicted = append(s.evicted, EvictEvent{key, value, reason})
+		atomic.StoreInt32(&s.pendingEvicts, 1)
+	}
+}
+
+// runEvictFunc notifies eviction callback of every pending removal. It must be
+// called without holding the lock, so the callback can call back into current
+// store.
+func (s *Store) runEvictFunc() {
+	if atomic.LoadInt32(&s.pendingEvicts) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	fn, onError, events := s.onEvict, s.onError, s.evicted
+	s.evicted = nil
+	atomic.StoreInt32(&s.pendingEvicts, 0)
+	s.mutex.Unlock()
+
+	if fn != nil {
+		callEvictFuncs(fn, onError, events)
+	}
+}
+
+// observeAge counts the age of specified entry, which was removed or
+// overwritten by reason, whether expiry histogram is enabled. It must be
+// called while holding the write lock.
+func (s *Store) observeAge(v *entry, reason EvictReason) {
+	if s.histogram == nil {
+		return
+	}
+
+	h, ok := s.histogram[reason]
+	if !ok {
+		h = newAgeHistogram()
+		s.histogram[reason] = h
+	}
+	h.observe(s.clock.Now().Sub(v.updatedAt))
+}
+
+// storeValue validates specified value and applies the store middleware to
+// it.
+func (s *Store) storeValue(key string, value interface{}) (interface{}, error) {
+	s.mutex.RLock()
+	fn := s.onStore
+	validator := s.validator
+	s.mutex.RUnlock()
+
+	if err := data.CallValidatorFunc(validator, key, value); err != nil {
+		return nil, err
+	}
+	if fn == nil {
+		return value, nil
+	}
+	return data.CallValueFunc(fn, key, value)
+}
+
+// unsafeGetOrAdd gets the value stored by specified key, or stores the value
+// computed by factory, without locking.
+func (s *Store) unsafeGetOrAdd(
+	key string, ref interface{}, factory data.FactoryFunc,
+) error {
+	now := s.clock.Now()
+	if v, err := s.unsafeGet(key); err == nil {
+		v.AddHit()
+		if !s.isTransient {
+			v.SetLifetime(s.renewal)
+			v.Hit(now)
+		}
+		return v.Value(ref)
+	}
+
+	value, err := data.CallFactoryFunc(factory)
+	if err != nil {
+		return err
+	}
+	if err := data.CallValidatorFunc(s.validator, key, value); err != nil {
+		return err
+	}
+	if s.onStore != nil {
+		if value, err = data.CallValueFunc(s.onStore, key, value); err != nil {
+			return err
+		}
+	}
+
+	v, err := s.newEntry(now, value)
+	if err != nil {
+		return err
+	}
+	if !s.gcRunning {
+		go s.gc()
+	}
+	s.values[key] = v
+	delete(s.negatives, key)
+	s.added(key)
+	return v.Value(ref)
+}
+
+// newEntry creates a new entry for value using the lifetime of current
+// store, compressing it whether compression is enabled.
+func (s *Store) newEntry(now time.Time, value interface{}) (*entry, error) {
+	v, err := newEntry(now, s.lifetime, value)
+	if err != nil {
+		return nil, err
+	}
+	v.generation = s.renewal.gen
+	if err := v.compress(s.compressAbove); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// setValue replaces the value of v stored by specified key, compressing it
+// whether compression is enabled. It must be called while holding the write
+// lock.
+func (s *Store) setValue(
+	key string, v *entry, now time.Time, value interface{},
+) error {
+	if err := v.SetValue(now, value); err != nil {
+		return err
+	}
+	if err := v.compress(s.compressAbove); err != nil {
+		return err
+	}
+
+	s.sendEvent(key, EventSet)
+	return nil
+}
+
+// added counts a new value stored by specified key and notifies it to events
+// channel. It must be called while holding the write lock.
+func (s *Store) added(key string) {
+	s.stats.Added(1)
+	s.sendEvent(key, EventAdded)
+}
+
+// sendEvent sends a non-blocking notification of a change to the value of
+// specified key to events channel, whether it was requested, counting it as
+// dropped when the channel is full. It must be called while holding the write
+// lock.
+func (s *Store) sendEvent(key string, typ EventType) {
+	if s.eventCh == nil {
+		return
+	}
+
+	select {
+	case s.eventCh <- StoreEvent{key, typ, s.clock.Now()}:
+	default:
+		atomic.AddUint64(&s.droppedEvents, 1)
+	}
+}
+
+// unsafeGet gets one entry instance from its key without locking. An expired
+// entry not yet collected is considered missing.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *Store) unsafeGet(key string) (*entry, error) {
+	v, ok := s.values[key]
+	if !ok || s.isExpired(v, s.clock.Now()) {
 		return nil, dot.InvalidKeyError(key)
 	}
 	return v, nil
 }
 
-var _ data.Store = (*Store)(nil)
+// A deadEntry represents an expired entry to be moved to dead-letter store.
+type deadEntry struct {
+	key string
+	*entry
+}
+
+// byTTL implements sort.Interface to sort keys by remaining lifetime.
+type byTTL []data.KeyTTL
+
+func (a byTTL) Len() int { return len(a) }
+func (a byTTL) Less(i, j int) bool {
+	if a[i].TTL == a[j].TTL {
+		return a[i].Key < a[j].Key
+	}
+	return a[i].TTL < a[j].TTL
+}
+func (a byTTL) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+var _ data.ResettableStore = (*Store)(nil)
+var _ data.StatsProvider = (*Store)(nil)
+var _ data.NegativeCache = (*Store)(nil)
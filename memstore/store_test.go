@@ -19,14 +19,25 @@
 package memstore
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/raiqub/data/testdata"
+	"gopkg.in/raiqub/data.v0"
 )
 
 func TestMemStore(t *testing.T) {
+	clock := testdata.NewClock()
 	store := New(0, false)
-	testdata.TestExpiration(store, t)
+	store.SetClock(clock)
+	testdata.TestExpirationWithClock(store, clock, t)
 
 	store.Flush()
 	testdata.TestValueHandling(store, t)
@@ -38,16 +49,1104 @@ func TestMemStore(t *testing.T) {
 	testdata.TestSetExpiration(store, t)
 
 	store.Flush()
-	testdata.TestPostpone(store, t)
+	testdata.TestPostponeWithClock(store, clock, t)
 
 	store.Flush()
-	testdata.TestTransient(store, t)
+	testdata.TestTransientWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTouchManyWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestXFetchWithClock(store, clock, t)
 
 	store.Flush()
 	testdata.TestAtomic(store, t)
 
+	store.Flush()
+	testdata.TestMetaWithClock(store, clock, t)
+
 	store.Flush()
 	testdata.TestTypeError(store, t)
+
+	store.Flush()
+	testdata.TestGenericDecode(store, t)
+
+	store.Flush()
+	testdata.TestValueMiddleware(store, t)
+
+	store.Flush()
+	testdata.TestValidator(store, t)
+
+	store.Flush()
+	testdata.TestIncrementField(store, t)
+
+	store.Flush()
+	testdata.TestRegisterType(store, t)
+
+	store.Flush()
+	testdata.TestUpdate(store, t)
+
+	store.Flush()
+	testdata.TestCompareAndSwap(store, t)
+
+	store.Flush()
+	testdata.TestVersion(store, t)
+
+	store.Flush()
+	testdata.TestDeletePrefix(store, t)
+
+	store.Flush()
+	testdata.TestFlushPrefix(store, t)
+
+	store.Flush()
+	testdata.TestLifetimeForWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestKeysWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestExistsWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestScopeNewWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestTTLWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestGetOrAdd(store, t)
+
+	store.Flush()
+	testdata.TestGetSetMany(store, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouchWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestPointerValue(store, t)
+
+	store.Flush()
+	testdata.TestEntriesByExpiryWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestIncrementByWithClock(store, clock, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+
+	store.Flush()
+	testdata.TestDecrementAndDeleteAtZero(store, t)
+
+	store.Flush()
+	testdata.TestAddExpiredWithClock(store, clock, t)
+}
+
+func TestMaxIdle(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Hour, false)
+	store.SetClock(clock)
+	store.SetMaxIdle(time.Second)
+
+	store.Add("v1", 1)
+	store.Add("v2", 2)
+
+	var result int
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Millisecond * 300)
+		if err := store.Set("v1", i); err != nil {
+			t.Errorf("Could not set value: %v", err)
+		}
+		if err := store.Get("v2", &result); err != nil {
+			t.Errorf("Could not get value: %v", err)
+		}
+	}
+
+	clock.Advance(time.Millisecond * 300)
+	if err := store.Get("v1", &result); err == nil {
+		t.Error("The unread value v1 should be expired")
+	}
+	if err := store.Get("v2", &result); err != nil {
+		t.Errorf("The read value v2 should not be expired: %v", err)
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	store := New(time.Minute, false)
+	store.SetValueMiddleware(
+		func(key string, v interface{}) (interface{}, error) {
+			panic("onStore")
+		},
+		func(key string, v interface{}) (interface{}, error) {
+			panic("onLoad")
+		})
+
+	err := store.Add("v1", 1)
+	if _, ok := err.(data.CallbackPanicError); !ok {
+		t.Errorf("The panic of onStore was not recovered: %v", err)
+	}
+
+	store.SetValueMiddleware(nil, func(key string, v interface{}) (interface{}, error) {
+		panic("onLoad")
+	})
+	if err := store.Add("v1", 1); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	var result int
+	err = store.Get("v1", &result)
+	if _, ok := err.(data.CallbackPanicError); !ok {
+		t.Errorf("The panic of onLoad was not recovered: %v", err)
+	}
+
+	// The store must not be locked after a panic
+	if _, err := store.Count(); err != nil {
+		t.Errorf("Could not count values: %v", err)
+	}
+}
+
+func TestAddNegative(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Minute, false)
+	store.SetClock(clock)
+
+	if err := store.AddNegative("v1", time.Second); err != nil {
+		t.Errorf("Could not add negative marker: %v", err)
+	}
+
+	var value int
+	if err := store.Get("v1", &value); err != data.ErrNegativelyCached {
+		t.Errorf("Expected negatively cached error but got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := store.Get("v1", &value); err == data.ErrNegativelyCached {
+		t.Error("The negative marker should be expired")
+	}
+
+	store.AddNegative("v1", time.Second)
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value over negative marker: %v", err)
+	}
+	if err := store.Get("v1", &value); err != nil || value != 1 {
+		t.Errorf("Expected value 1 but got %d: %v", value, err)
+	}
+	if err := store.AddNegative("v1", time.Second); err == nil {
+		t.Error("A stored value should not be marked as absent")
+	}
+}
+
+func TestAddWithUses(t *testing.T) {
+	for _, transient := range []bool{false, true} {
+		store := New(time.Minute, transient)
+		if err := store.AddWithUses("t1", "token", 2); err != nil {
+			t.Fatalf("Could not add value: %v", err)
+		}
+
+		var result string
+		for i := 0; i < 2; i++ {
+			if err := store.Get("t1", &result); err != nil {
+				t.Errorf("Could not get value: %v", err)
+			}
+		}
+
+		if err := store.Get("t1", &result); err == nil {
+			t.Error("The value t1 should be removed after its uses")
+		}
+		if count, _ := store.Count(); count != 0 {
+			t.Errorf("Expected no values but got %d", count)
+		}
+	}
+}
+
+func TestAddWithLifetimeRenewal(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Second, false)
+	store.SetClock(clock)
+	if err := store.AddWithLifetime("v1", 1, time.Minute); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	if err := store.SetLifetime(time.Millisecond, data.ScopeAll); err != nil {
+		t.Fatalf("Could not set lifetime: %v", err)
+	}
+
+	// A read renews the value by its own lifetime
+	var value int
+	clock.Advance(time.Second * 30)
+	if err := store.Get("v1", &value); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	clock.Advance(time.Second * 50)
+	if ttl, err := store.TTL("v1"); err != nil || ttl != time.Second*10 {
+		t.Errorf("Expected TTL of %v but got %v: %v", time.Second*10, ttl, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	store := New(time.Minute, false)
+	store.SetCompression(64)
+	large := strings.Repeat("lorem ipsum ", 100)
+	store.Add("small", "lorem")
+	store.Add("large", large)
+	store.Add("counter", 1)
+	if _, err := store.Increment("counter"); err != nil {
+		t.Fatalf("Could not increment value: %v", err)
+	}
+
+	if v := store.values["large"]; !v.compressed || len(v.value) >= len(large) {
+		t.Errorf("Expected large value to be compressed")
+	}
+
+	var value string
+	if err := store.Get("small", &value); err != nil || value != "lorem" {
+		t.Errorf("Unexpected small value %q: %v", value, err)
+	}
+	if err := store.Get("large", &value); err != nil || value != large {
+		t.Errorf("Unexpected large value: %v", err)
+	}
+	var counter int
+	if err := store.Get("counter", &counter); err != nil || counter != 2 {
+		t.Errorf("Expected counter 2 but got %d: %v", counter, err)
+	}
+
+	// Values compressed are kept readable after compression is disabled
+	store.SetCompression(0)
+	if err := store.Get("large", &value); err != nil || value != large {
+		t.Errorf("Unexpected large value: %v", err)
+	}
+
+	// A value which cannot be encoded is refused, keeping the former one
+	if err := store.Set("small", make(chan int)); err == nil {
+		t.Error("A value which cannot be encoded should not be set")
+	}
+	if err := store.Get("small", &value); err != nil || value != "lorem" {
+		t.Errorf("Expected former value to be kept but got %q: %v",
+			value, err)
+	}
+}
+
+func TestGetManyTypeMismatch(t *testing.T) {
+	type user struct {
+		Name string
+	}
+
+	store := New(time.Minute, false)
+	store.RegisterType("user:", user{})
+	store.Add("user:1", user{"lorem"})
+	store.Add("user:2", "ipsum")
+
+	values, err := store.GetMany([]string{"user:1", "user:2", "user:3"})
+	errs, ok := err.(data.BatchError)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("Expected a batch error for one key but got %v", err)
+	}
+	if _, ok := errs["user:2"].(data.InvalidTypeError); !ok {
+		t.Errorf("Expected type error for mismatched key but got %v",
+			errs["user:2"])
+	}
+
+	if len(values) != 1 || values["user:1"] != (user{"lorem"}) {
+		t.Errorf("Expected only the valid value but got %v", values)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	values := []struct {
+		value interface{}
+		kind  reflect.Kind
+	}{
+		{nil, reflect.Invalid},
+		{true, reflect.Bool},
+		{5, reflect.Int64},
+		{uint64(1) << 63, reflect.Uint64},
+		{-500, reflect.Int64},
+		{1.5, reflect.Float64},
+		{"value", reflect.String},
+		{[]byte("raw"), reflect.Slice},
+		{[]int{1, 2}, reflect.Slice},
+		{map[string]int{"a": 1}, reflect.Map},
+		{struct{ Name string }{"John"}, reflect.Map},
+	}
+
+	store := New(time.Minute, false)
+	for i, v := range values {
+		key := strconv.Itoa(i)
+		if err := store.Add(key, v.value); err != nil {
+			t.Fatalf("Could not add value %v: %v", v.value, err)
+		}
+
+		kind, err := store.KindOf(key)
+		if err != nil {
+			t.Errorf("Could not get kind of %v: %v", v.value, err)
+		}
+		if kind != v.kind {
+			t.Errorf("Expected kind %v for %v but got %v", v.kind, v.value, kind)
+		}
+	}
+
+	if _, err := store.KindOf("missing"); err == nil {
+		t.Error("A missing key should return an error")
+	}
+}
+
+func TestRefreshIfExpired(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Second, true)
+	store.SetClock(clock)
+
+	calls := 0
+	refresh := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		refreshed, err := store.RefreshIfExpired("v1", refresh)
+		if err != nil {
+			t.Fatalf("Could not refresh value: %v", err)
+		}
+		if refreshed != (i == 0) {
+			t.Errorf("Unexpected refresh state at call %d: %v", i, refreshed)
+		}
+	}
+
+	clock.Advance(time.Second * 2)
+	if refreshed, _ := store.RefreshIfExpired("v1", refresh); !refreshed {
+		t.Error("The expired value v1 should be refreshed")
+	}
+
+	var value int
+	if err := store.Get("v1", &value); err != nil || value != 2 {
+		t.Errorf("Expected value 2 but got %d: %v", value, err)
+	}
+
+	_, err := store.RefreshIfExpired("v2", func() (interface{}, error) {
+		panic("refresh failed")
+	})
+	if _, ok := err.(data.CallbackPanicError); !ok {
+		t.Errorf("Expected CallbackPanicError but got %v", err)
+	}
+	if err := store.Get("v2", &value); err == nil {
+		t.Error("A failed refresh should not store a value")
+	}
+}
+
+func TestStaleWindow(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Second, true)
+	store.SetClock(clock)
+
+	refreshed := make(chan string, 1)
+	store.SetStaleWindow(time.Second, func(key string) (interface{}, error) {
+		defer func() { refreshed <- key }()
+		return "fresh", nil
+	})
+
+	store.Add("v1", "stale")
+	store.Add("v2", "stale")
+	clock.Advance(time.Millisecond * 1500)
+
+	var value string
+	if err := store.Get("v1", &value); err != nil || value != "stale" {
+		t.Errorf("Expected stale value but got %q: %v", value, err)
+	}
+
+	select {
+	case key := <-refreshed:
+		if key != "v1" {
+			t.Errorf("Unexpected refreshed key %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The stale value v1 was not refreshed")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for value != "fresh" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+		store.Get("v1", &value)
+	}
+	if value != "fresh" {
+		t.Errorf("Expected fresh value but got %q", value)
+	}
+
+	clock.Advance(time.Second)
+	if err := store.Get("v2", &value); err == nil {
+		t.Error("The value v2 should be expired after stale window")
+	}
+	if err := store.Get("v1", &value); err != nil {
+		t.Errorf("The refreshed value v1 should be kept: %v", err)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Second, true)
+	store.SetClock(clock)
+
+	type handled struct {
+		op, key string
+		err     error
+	}
+	errs := make(chan handled, 2)
+	store.SetErrorHandler(func(op, key string, err error) {
+		errs <- handled{op, key, err}
+	})
+
+	store.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		panic("evict failed")
+	})
+	store.AddWithUses("v1", 1, 1)
+	var value interface{}
+	store.Get("v1", &value)
+
+	got := <-errs
+	if _, ok := got.err.(data.CallbackPanicError); !ok ||
+		got.op != "OnEvict" || got.key != "v1" {
+		t.Errorf("Unexpected eviction error: %+v", got)
+	}
+
+	errRefresh := errors.New("refresh failed")
+	store.SetStaleWindow(time.Second, func(key string) (interface{}, error) {
+		return nil, errRefresh
+	})
+	store.Add("v2", "stale")
+	clock.Advance(time.Millisecond * 1500)
+	store.Get("v2", &value)
+
+	select {
+	case got := <-errs:
+		if got.err != errRefresh || got.op != "Refresh" || got.key != "v2" {
+			t.Errorf("Unexpected refresh error: %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The failed refresh was not handled")
+	}
+}
+
+func TestGetIfChanged(t *testing.T) {
+	for _, transient := range []bool{false, true} {
+		store := New(time.Minute, transient)
+		store.Add("v1", "lorem")
+
+		var value string
+		changed, version, err := store.GetIfChanged("v1", 0, &value)
+		if err != nil {
+			t.Fatalf("Could not get value: %v", err)
+		}
+		if !changed || value != "lorem" {
+			t.Errorf("Expected changed value 'lorem' but got %q", value)
+		}
+
+		value = ""
+		changed, unchanged, err := store.GetIfChanged("v1", version, &value)
+		if err != nil {
+			t.Errorf("Could not get value: %v", err)
+		}
+		if changed || unchanged != version || value != "" {
+			t.Errorf("The value should be unchanged at version %d", version)
+		}
+
+		store.Set("v1", "ipsum")
+		changed, newVersion, _ := store.GetIfChanged("v1", version, &value)
+		if !changed || newVersion == version || value != "ipsum" {
+			t.Errorf("Expected changed value 'ipsum' but got %q", value)
+		}
+
+		if _, _, err := store.GetIfChanged("v2", version, &value); err == nil {
+			t.Error("A missing key should return an error")
+		}
+	}
+}
+
+func TestMetaHits(t *testing.T) {
+	for _, transient := range []bool{false, true} {
+		store := New(time.Minute, transient)
+		store.Add("v1", 1)
+
+		var value int
+		for i := 0; i < 3; i++ {
+			store.Get("v1", &value)
+		}
+		store.Set("v1", 2)
+
+		meta, err := store.Meta("v1")
+		if err != nil {
+			t.Fatalf("Could not get metadata: %v", err)
+		}
+		if meta.Hits != 3 {
+			t.Errorf("Expected 3 hits but got %d", meta.Hits)
+		}
+	}
+}
+
+func TestExpiryHistogram(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Hour, false)
+	store.SetClock(clock)
+
+	store.Add("v1", 1)
+	clock.Advance(time.Second * 2)
+	store.Set("v1", 2)
+	if h := store.ExpiryHistogram(); h != nil {
+		t.Errorf("The histogram should be disabled by default: %v", h)
+	}
+
+	store.SetExpiryHistogram(true)
+	clock.Advance(time.Second * 5)
+	store.Set("v1", 3)
+	store.Add("v2", 1)
+	clock.Advance(time.Second * 30)
+	store.Delete("v2")
+
+	h := store.ExpiryHistogram()
+	overwritten := h[EvictOverwritten]
+	if overwritten.Count() != 1 || overwritten.Counts[1] != 1 {
+		t.Errorf("Expected one overwritten value up to 10s but got %v",
+			overwritten.Counts)
+	}
+	deleted := h[EvictDeleted]
+	if deleted.Count() != 1 || deleted.Mean() != time.Second*30 {
+		t.Errorf("Expected one deleted value aged 30s but got %v",
+			deleted.Counts)
+	}
+	if _, ok := h[EvictExpired]; ok {
+		t.Error("No value was expired")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Minute, false)
+	store.SetClock(clock)
+
+	var item string
+	if err := store.Dequeue("q1", &item); err != data.ErrEmpty {
+		t.Errorf("Expected empty queue error but got %v", err)
+	}
+
+	for _, v := range []string{"lorem", "ipsum", "dolor"} {
+		if err := store.Enqueue("q1", v); err != nil {
+			t.Errorf("Could not enqueue item: %v", err)
+		}
+	}
+	for _, expected := range []string{"lorem", "ipsum", "dolor"} {
+		if err := store.Dequeue("q1", &item); err != nil || item != expected {
+			t.Errorf("Expected item %q but got %q: %v", expected, item, err)
+		}
+	}
+	if err := store.Dequeue("q1", &item); err != data.ErrEmpty {
+		t.Errorf("Expected empty queue error but got %v", err)
+	}
+
+	store.Add("v1", 1)
+	if err := store.Enqueue("v1", "lorem"); err == nil {
+		t.Error("A value which is not a queue should not be enqueued")
+	}
+
+	store.Enqueue("q2", "lorem")
+	clock.Advance(time.Minute * 2)
+	if err := store.Dequeue("q2", &item); err != data.ErrEmpty {
+		t.Errorf("An idle queue should be expired, got %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	store := New(time.Minute, false)
+	if err := store.AddContext(context.Background(), "v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.SetContext(ctx, "v1", 2); err != context.Canceled {
+		t.Errorf("Expected canceled error but got %v", err)
+	}
+
+	// A context done while waiting for the lock stops waiting
+	store.mutex.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	var value int
+	if err := store.GetContext(ctx, "v1", &value); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded error but got %v", err)
+	}
+	store.mutex.Unlock()
+
+	if err := store.Get("v1", &value); err != nil || value != 1 {
+		t.Errorf("Expected value 1 but got %d: %v", value, err)
+	}
+	if err := store.DeleteContext(context.Background(), "v1"); err != nil {
+		t.Errorf("Could not delete value: %v", err)
+	}
+}
+
+func TestGetOrAddConcurrent(t *testing.T) {
+	store := New(time.Minute, false)
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var value int
+			err := store.GetOrAdd("v1", &value, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(time.Millisecond * 10)
+				return 5, nil
+			})
+			if err != nil || value != 5 {
+				t.Errorf("Expected value 5 but got %d: %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected factory called once but got %d calls", calls)
+	}
+}
+
+func TestEvictChannel(t *testing.T) {
+	store := New(time.Millisecond*100, false)
+	events := store.EvictChannel()
+
+	store.Add("v1", 1)
+	store.Add("v2", 2)
+	if err := store.Delete("v2"); err != nil {
+		t.Fatalf("Could not delete value: %v", err)
+	}
+
+	ev := <-events
+	if ev.Key != "v2" || ev.Reason != EvictDeleted {
+		t.Errorf("Unexpected eviction event: %v", ev)
+	}
+
+	select {
+	case ev = <-events:
+		if ev.Key != "v1" || ev.Reason != EvictExpired {
+			t.Errorf("Unexpected eviction event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("The expired value v1 was not notified")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Minute, false)
+	store.SetClock(clock)
+	events := store.Events()
+
+	store.Add("v1", 1)
+	store.Set("v1", 2)
+	store.Add("v2", 2)
+	store.Delete("v2")
+	clock.Advance(time.Hour)
+	store.GC()
+
+	expected := []StoreEvent{
+		{"v1", EventAdded, clock.Now().Add(-time.Hour)},
+		{"v1", EventSet, clock.Now().Add(-time.Hour)},
+		{"v2", EventAdded, clock.Now().Add(-time.Hour)},
+		{"v2", EventDeleted, clock.Now().Add(-time.Hour)},
+		{"v1", EventExpired, clock.Now()},
+	}
+	for _, e := range expected {
+		if ev := <-events; ev != e {
+			t.Errorf("Expected event %v but got %v", e, ev)
+		}
+	}
+
+	// A slow consumer never blocks the store
+	for i := 0; i < eventChannelSize+10; i++ {
+		store.Add(strconv.Itoa(i), i)
+	}
+	if dropped := store.DroppedEvents(); dropped != 10 {
+		t.Errorf("Expected 10 dropped events but got %d", dropped)
+	}
+
+	store.CloseEvents()
+	for range events {
+	}
+	store.Add("v3", 3)
+}
+
+func TestSetLifetimeScopes(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Second, false)
+	store.SetClock(clock)
+
+	store.Add("v1", 1)
+	before := *store.values["v1"]
+	if err := store.SetLifetime(time.Second*3, data.ScopeNew); err != nil {
+		t.Fatalf("Could not set lifetime: %v", err)
+	}
+	if !reflect.DeepEqual(*store.values["v1"], before) {
+		t.Error("ScopeNew should not modify existing values")
+	}
+	store.Add("v2", 2)
+
+	// Renewing v1 keeps its former lifetime
+	var value int
+	clock.Advance(time.Millisecond * 800)
+	store.Get("v1", &value)
+	store.Get("v2", &value)
+	clock.Advance(time.Millisecond * 1500)
+	if err := store.Get("v1", &value); err == nil {
+		t.Error("The value v1 should be expired")
+	}
+	if err := store.Get("v2", &value); err != nil {
+		t.Errorf("The value v2 should not be expired: %v", err)
+	}
+
+	err := store.SetLifetime(time.Millisecond*500, data.ScopeNewAndUpdated)
+	if err != nil {
+		t.Fatalf("Could not set lifetime: %v", err)
+	}
+	store.Get("v2", &value)
+	clock.Advance(time.Millisecond * 700)
+	if err := store.Get("v2", &value); err == nil {
+		t.Error("The renewed value v2 should be expired")
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	store := New(time.Millisecond*100, false)
+	events := make(chan EvictEvent, 4)
+	store.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		// The callback can call back into the store
+		store.Count()
+		events <- EvictEvent{key, value, reason}
+	})
+
+	store.AddWithUses("v1", 1, 1)
+	store.Add("v2", 2)
+	store.Delete("v2")
+
+	var value int
+	if err := store.Get("v1", &value); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	ev := <-events
+	if ev.Key != "v1" || ev.Value != int64(1) || ev.Reason != EvictUsedUp {
+		t.Errorf("Unexpected eviction event: %v", ev)
+	}
+
+	store.Add("v3", 3)
+	select {
+	case ev = <-events:
+		if ev.Key != "v3" || ev.Reason != EvictExpired {
+			t.Errorf("Unexpected eviction event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("The expired value v3 was not notified")
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	store := New(time.Millisecond*100, false)
+	dst := New(time.Minute, false)
+	store.SetDeadLetter(dst)
+
+	dst.Add("v3", "existing")
+	store.Add("v1", 1)
+	store.Add("v2", 2)
+	store.Add("v3", 3)
+	if err := store.Delete("v2"); err != nil {
+		t.Fatalf("Could not delete value: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if count, _ := store.Count(); count == 0 {
+			if count, _ := dst.Count(); count == 2 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The expired values were not moved")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	var value int
+	if err := dst.Get("v1", &value); err != nil || value != 1 {
+		t.Errorf("Expected value 1 for v1 but got %d: %v", value, err)
+	}
+	if err := dst.Get("v2", &value); err == nil {
+		t.Error("The deleted value v2 should not be moved")
+	}
+	var existing string
+	if err := dst.Get("v3", &existing); err != nil || existing != "existing" {
+		t.Errorf("The existing value v3 should be kept but got %q: %v",
+			existing, err)
+	}
+}
+
+func TestSub(t *testing.T) {
+	store := New(time.Minute, false)
+	tenant1 := store.Sub("tenant1")
+	tenant2 := store.Sub("tenant2")
+	if store.Sub("tenant1") != tenant1 {
+		t.Error("The same namespace should return the same child")
+	}
+
+	store.Add("v1", 1)
+	tenant1.Add("v1", 2)
+	tenant1.Add("v2", 3)
+	tenant2.Add("v1", 4)
+	tenant1.Sub("nested").Add("v1", 5)
+
+	var value int
+	if err := tenant1.Get("v1", &value); err != nil || value != 2 {
+		t.Errorf("Expected value 2 but got %d: %v", value, err)
+	}
+	if count, _ := tenant1.Count(); count != 2 {
+		t.Errorf("Expected 2 values but got %d", count)
+	}
+
+	tenant1.Flush()
+	if count, _ := tenant1.Count(); count != 0 {
+		t.Errorf("Expected no values but got %d", count)
+	}
+	if count, _ := tenant2.Count(); count != 1 {
+		t.Errorf("Expected 1 value but got %d", count)
+	}
+	if count, _ := tenant1.Sub("nested").Count(); count != 0 {
+		t.Errorf("Expected no nested values but got %d", count)
+	}
+
+	store.Flush()
+	for _, s := range []*Store{store, tenant2} {
+		if count, _ := s.Count(); count != 0 {
+			t.Errorf("Expected no values but got %d", count)
+		}
+	}
+}
+
+func TestGCDuringAccess(t *testing.T) {
+	store := New(time.Millisecond, false)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			var value int
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				// A small key space keeps values expiring while they are
+				// read and written by other goroutines.
+				key := strconv.Itoa(j % 32)
+				store.Add(key, j)
+				store.Get(key, &value)
+				store.Set(key, id)
+				if j%8 == 0 {
+					store.Delete(key)
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+	close(stop)
+	wg.Wait()
+}
+
+func TestSettersDuringGet(t *testing.T) {
+	store := New(time.Minute, true)
+	store.Add("v1", 1)
+	started, stop := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var value int
+		store.Get("v1", &value)
+		close(started)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			store.Get("v1", &value)
+		}
+	}()
+	<-started
+
+	// Settings are changed while values are read, which is caught by the race
+	// detector when they are read without the lock
+	for i := 0; i < 1000; i++ {
+		store.SetMaxIdle(time.Duration(i%2) * time.Minute)
+		store.SetStaleWindow(time.Duration(i%2)*time.Second, nil)
+		store.SetTransient(i%2 == 0)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestFlushDuringGC(t *testing.T) {
+	store := New(time.Millisecond, false)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				key := strconv.Itoa(id) + ":" + strconv.Itoa(j)
+				if err := store.Add(key, j); err != nil {
+					t.Errorf("Could not add value: %v", err)
+				}
+				if j%50 == 0 {
+					store.Flush()
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+	close(stop)
+	wg.Wait()
+}
+
+func TestValueIsolation(t *testing.T) {
+	store := New(time.Minute, false)
+	values := []int{1, 2, 3}
+	if err := store.Add("v1", values); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	values[0] = 10
+
+	var result []int
+	if err := store.Get("v1", &result); err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	if result[0] != 1 {
+		t.Errorf("Stored value changed by caller to %v", result)
+	}
+	result[1] = 20
+
+	var again []int
+	store.Get("v1", &again)
+	if !reflect.DeepEqual(again, []int{1, 2, 3}) {
+		t.Errorf("Stored value changed by reader to %v", again)
+	}
+}
+
+func TestStartGC(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Hour, false)
+	store.SetClock(clock)
+	for i := 0; i < 10; i++ {
+		if err := store.Add(strconv.Itoa(i), i); err != nil {
+			t.Fatalf("Could not add value: %v", err)
+		}
+	}
+	clock.Advance(2 * time.Hour)
+
+	stop := store.StartGC(time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mutex.RLock()
+		count := len(store.values)
+		store.mutex.RUnlock()
+		if count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expired values were not reclaimed: %d left", count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	if removed := store.GC(); removed != 0 {
+		t.Errorf("Unexpected removed values after GC: %d", removed)
+	}
+}
+
+func TestClose(t *testing.T) {
+	store := New(time.Hour, false)
+	child := store.Sub("child")
+	store.Add("k1", 1)
+	child.Add("k1", 1)
+	stop := store.StartGC(time.Hour)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Could not close store: %v", err)
+	}
+	// The goroutine is already stopped, so stop returns at once
+	stop()
+
+	store.mutex.RLock()
+	running, done := store.gcRunning, store.gcDone
+	store.mutex.RUnlock()
+	if running || done != nil {
+		t.Errorf("The garbage collector should be stopped")
+	}
+	if count, _ := store.Count(); count != 0 {
+		t.Errorf("Expected no values but got %d", count)
+	}
+	if count, _ := child.Count(); count != 0 {
+		t.Errorf("Expected no values on child but got %d", count)
+	}
+
+	// A closed store can still be used
+	if err := store.Add("k1", 2); err != nil {
+		t.Errorf("Could not add value after close: %v", err)
+	}
+	store.Close()
+}
+
+func TestStats(t *testing.T) {
+	clock := testdata.NewClock()
+	store := New(time.Second, false)
+	store.SetClock(clock)
+	store.Add("v1", 1)
+	store.Add("v2", 2)
+
+	var value int
+	store.Get("v1", &value)
+	store.Get("v1", &value)
+	store.Get("missing", &value)
+
+	clock.Advance(2 * time.Second)
+	store.GC()
+
+	expected := data.Stats{Hits: 2, Misses: 1, Additions: 2, Evictions: 2}
+	if stats, _ := store.Stats(); stats != expected {
+		t.Errorf("Unexpected stats: expected %+v got %+v", expected, stats)
+	}
+
+	store.ResetStats()
+	store.Add("v3", 3)
+	expected = data.Stats{Additions: 1, Size: 1}
+	if stats, _ := store.Stats(); stats != expected {
+		t.Errorf("Unexpected stats after reset: expected %+v got %+v",
+			expected, stats)
+	}
 }
 
 func BenchmarkMemStoreAddGet(b *testing.B) {
@@ -64,3 +1163,77 @@ func BenchmarkMemStoreAtomicIncrement(b *testing.B) {
 	store := New(0, true)
 	testdata.BenchmarkAtomicIncrement(store, b)
 }
+
+// BenchmarkMemStoreAddDelete measures the allocations of a store with high
+// churn, whose removed entries are reused by new values.
+func BenchmarkMemStoreAddDelete(b *testing.B) {
+	store := New(time.Minute, false)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Add("key", i)
+		store.Delete("key")
+	}
+}
+
+func BenchmarkMemStoreGetDuringGC(b *testing.B) {
+	benchmarkGetDuringGC(b, defaultGCBatchSize)
+}
+
+func BenchmarkMemStoreGetDuringGCUnbatched(b *testing.B) {
+	benchmarkGetDuringGC(b, 0)
+}
+
+// benchmarkGetDuringGC measures reads of a live value while garbage collector
+// removes lots of expired values, reporting the slowest read.
+func benchmarkGetDuringGC(b *testing.B, batchSize int) {
+	const lifetime = time.Millisecond * 10
+	clock := testdata.NewClock()
+	store := New(lifetime, true)
+	store.SetClock(clock)
+	store.SetGCBatchSize(batchSize)
+	store.Set("hot", 1)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			for j := 0; j < 20000; j++ {
+				store.Add(strconv.Itoa(i)+":"+strconv.Itoa(j), j)
+			}
+			clock.Advance(lifetime * 2)
+			store.Set("hot", 1)
+			time.Sleep(lifetime)
+		}
+	}()
+
+	var slowest int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var value int
+		for pb.Next() {
+			start := time.Now()
+			store.Get("hot", &value)
+			elapsed := int64(time.Since(start))
+			for {
+				max := atomic.LoadInt64(&slowest)
+				if elapsed <= max ||
+					atomic.CompareAndSwapInt64(&slowest, max, elapsed) {
+					break
+				}
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(slowest), "max-ns")
+
+	close(stop)
+	<-done
+}
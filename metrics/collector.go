@@ -0,0 +1,271 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// An evictionCounter represents a store which counts its evicted values.
+type evictionCounter interface {
+	Evictions() uint64
+}
+
+// A Collector wraps a data store, collecting Prometheus metrics from its
+// operations.
+//
+// It is a implementation of both Store and prometheus.Collector interfaces.
+type Collector struct {
+	data.Store
+	// stats is accessed atomically and must be 64-bit aligned.
+	stats     data.StatsCounter
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	hitRatio  *prometheus.Desc
+	latency   *prometheus.HistogramVec
+	evictions *prometheus.Desc
+	items     *prometheus.Desc
+}
+
+// NewCollector creates a new instance of Collector which wraps specified
+// store. Every metric is labeled by name as "store", so the metrics of
+// distinct stores can be registered together.
+func NewCollector(s data.Store, name string) *Collector {
+	labels := prometheus.Labels{"store": name}
+	return &Collector{
+		Store: s,
+		hits: prometheus.NewDesc("data_store_hits_total",
+			"Number of Get calls which found the requested key.",
+			nil, labels),
+		misses: prometheus.NewDesc("data_store_misses_total",
+			"Number of Get calls which did not find the requested key.",
+			nil, labels),
+		hitRatio: prometheus.NewDesc("data_store_hit_ratio",
+			"Ratio of Get calls which found the requested key.",
+			nil, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "data_store_operation_duration_seconds",
+			Help:        "Latency of store operations.",
+			ConstLabels: labels,
+		}, []string{"method"}),
+		evictions: prometheus.NewDesc("data_store_evictions_total",
+			"Number of values removed by other reason than deletion.",
+			nil, labels),
+		items: prometheus.NewDesc("data_store_items",
+			"Number of values currently stored.",
+			nil, labels),
+	}
+}
+
+// Describe sends the descriptors of every metric collected by current
+// instance.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.hitRatio
+	c.latency.Describe(ch)
+	ch <- c.items
+	if _, ok := c.Store.(evictionCounter); ok {
+		ch <- c.evictions
+	}
+}
+
+// Collect sends the current value of every metric, which includes counting
+// the values of wrapped store.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats.Stats(0)
+	ch <- prometheus.MustNewConstMetric(
+		c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(
+		c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(
+		c.hitRatio, prometheus.GaugeValue, hitRatio(stats))
+	c.latency.Collect(ch)
+
+	if count, err := c.Store.Count(); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.items, prometheus.GaugeValue, float64(count))
+	}
+	if ec, ok := c.Store.(evictionCounter); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.evictions, prometheus.CounterValue, float64(ec.Evictions()))
+	}
+}
+
+// Add adds a new key:value to wrapped store.
+func (c *Collector) Add(key string, value interface{}) error {
+	defer c.observe("Add", time.Now())
+	return c.Store.Add(key, value)
+}
+
+// Close closes wrapped store.
+func (c *Collector) Close() error {
+	return c.Store.Close()
+}
+
+// Count gets the number of stored values by wrapped store.
+func (c *Collector) Count() (int, error) {
+	defer c.observe("Count", time.Now())
+	return c.Store.Count()
+}
+
+// Decrement atomically gets the value stored by specified key and decrements
+// it by one.
+func (c *Collector) Decrement(key string) (int, error) {
+	defer c.observe("Decrement", time.Now())
+	as, err := c.atomic("Decrement")
+	if err != nil {
+		return 0, err
+	}
+	return as.Decrement(key)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value.
+func (c *Collector) DecrementBy(key string, value int) (int, error) {
+	defer c.observe("DecrementBy", time.Now())
+	as, err := c.atomic("DecrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.DecrementBy(key, value)
+}
+
+// Delete deletes the specified key:value.
+func (c *Collector) Delete(key string) error {
+	defer c.observe("Delete", time.Now())
+	return c.Store.Delete(key)
+}
+
+// Exists reports whether a value is stored by specified key on wrapped store.
+func (c *Collector) Exists(key string) (bool, error) {
+	defer c.observe("Exists", time.Now())
+	return c.Store.Exists(key)
+}
+
+// Flush deletes any cached value into wrapped store.
+func (c *Collector) Flush() error {
+	defer c.observe("Flush", time.Now())
+	return c.Store.Flush()
+}
+
+// Get gets the value stored by specified key, counting a hit when it is found
+// or a miss when the key is not found.
+func (c *Collector) Get(key string, ref interface{}) error {
+	defer c.observe("Get", time.Now())
+
+	err := c.Store.Get(key, ref)
+	c.stats.Observe(err)
+	return err
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero.
+func (c *Collector) GetAndReset(key string) (int, error) {
+	defer c.observe("GetAndReset", time.Now())
+	rs, ok := c.Store.(data.ResettableStore)
+	if !ok {
+		return 0, dot.NotSupportedError("GetAndReset")
+	}
+	return rs.GetAndReset(key)
+}
+
+// Increment atomically gets the value stored by specified key and increments
+// it by one.
+func (c *Collector) Increment(key string) (int, error) {
+	defer c.observe("Increment", time.Now())
+	as, err := c.atomic("Increment")
+	if err != nil {
+		return 0, err
+	}
+	return as.Increment(key)
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value.
+func (c *Collector) IncrementBy(key string, value int) (int, error) {
+	defer c.observe("IncrementBy", time.Now())
+	as, err := c.atomic("IncrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.IncrementBy(key, value)
+}
+
+// Keys gets the keys of stored values by wrapped store.
+func (c *Collector) Keys() ([]string, error) {
+	defer c.observe("Keys", time.Now())
+	return c.Store.Keys()
+}
+
+// Range calls fn for each stored value by wrapped store.
+func (c *Collector) Range(fn func(key string, value interface{}) bool) error {
+	defer c.observe("Range", time.Now())
+	return c.Store.Range(fn)
+}
+
+// Set sets the value of specified key.
+func (c *Collector) Set(key string, value interface{}) error {
+	defer c.observe("Set", time.Now())
+	return c.Store.Set(key, value)
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key on
+// wrapped store.
+func (c *Collector) TTL(key string) (time.Duration, error) {
+	defer c.observe("TTL", time.Now())
+	return c.Store.TTL(key)
+}
+
+// Touch renews the lifetime of the value stored by specified key on wrapped
+// store.
+func (c *Collector) Touch(key string) error {
+	defer c.observe("Touch", time.Now())
+	return c.Store.Touch(key)
+}
+
+// hitRatio returns the ratio of Get calls counted by stats which found the
+// requested key, which is zero before any call.
+func hitRatio(stats data.Stats) float64 {
+	total := stats.Hits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(total)
+}
+
+// observe records the latency of specified method started at start.
+func (c *Collector) observe(method string, start time.Time) {
+	c.latency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// atomic returns wrapped store as an AtomicStore, whether it supports atomic
+// operations. Otherwise, it returns NotSupportedError for specified method.
+func (c *Collector) atomic(method string) (data.AtomicStore, error) {
+	as, ok := c.Store.(data.AtomicStore)
+	if !ok {
+		return nil, dot.NotSupportedError(method)
+	}
+	return as, nil
+}
+
+var _ data.ResettableStore = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
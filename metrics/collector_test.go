@@ -0,0 +1,113 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestCollector(t *testing.T) {
+	store := memstore.New(time.Millisecond*100, false)
+	c := NewCollector(store, "test")
+
+	c.Add("v1", 1)
+	c.Add("v2", 2)
+
+	var value int
+	c.Get("v1", &value)
+	c.Get("v1", &value)
+	c.Get("v3", &value)
+
+	expected := `
+# HELP data_store_hit_ratio Ratio of Get calls which found the requested key.
+# TYPE data_store_hit_ratio gauge
+data_store_hit_ratio{store="test"} 0.6666666666666666
+# HELP data_store_hits_total Number of Get calls which found the requested key.
+# TYPE data_store_hits_total counter
+data_store_hits_total{store="test"} 2
+# HELP data_store_items Number of values currently stored.
+# TYPE data_store_items gauge
+data_store_items{store="test"} 2
+# HELP data_store_misses_total Number of Get calls which did not find the requested key.
+# TYPE data_store_misses_total counter
+data_store_misses_total{store="test"} 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"data_store_hits_total", "data_store_misses_total",
+		"data_store_hit_ratio", "data_store_items")
+	if err != nil {
+		t.Error(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for store.Evictions() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	expected = `
+# HELP data_store_evictions_total Number of values removed by other reason than deletion.
+# TYPE data_store_evictions_total counter
+data_store_evictions_total{store="test"} 2
+`
+	err = testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"data_store_evictions_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c,
+		"data_store_operation_duration_seconds"); n != 2 {
+		t.Errorf("Expected latency of 2 methods but got %d", n)
+	}
+}
+
+func TestCollectorNegativelyCached(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	store.AddNegative("v1", time.Minute)
+	c := NewCollector(store, "test")
+
+	var value int
+	c.Get("v1", &value)
+
+	expected := `
+# HELP data_store_misses_total Number of Get calls which did not find the requested key.
+# TYPE data_store_misses_total counter
+data_store_misses_total{store="test"} 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"data_store_misses_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorClose(t *testing.T) {
+	store := memstore.New(time.Minute, false)
+	c := NewCollector(store, "test")
+	c.Add("v1", 1)
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Could not close store: %v", err)
+	}
+	if count, _ := store.Count(); count != 0 {
+		t.Errorf("Closing should delete every value but %d remain", count)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package metrics provides Prometheus metrics for data stores.
+
+Collector
+
+A Collector wraps any 'data.Store', timing every operation and counting hits
+and misses of Get, and implements 'prometheus.Collector' to expose them. It is
+initialized calling 'metrics.NewCollector()' function, which names the wrapped
+store, and must be used in place of the wrapped store:
+
+	store := metrics.NewCollector(memstore.New(time.Minute, false), "mycache")
+	prometheus.MustRegister(store)
+
+The following metrics are exposed:
+
+	data_store_hits_total                 Get calls which found the key.
+	data_store_misses_total               Get calls which did not find the key.
+	data_store_hit_ratio                  Ratio of Get calls which found the
+	                                      key.
+	data_store_evictions_total            Values removed by other reason than
+	                                      deletion, like expiration.
+	data_store_items                      Values currently stored.
+	data_store_operation_duration_seconds Latency of operations, by method.
+
+Evictions are exposed only for stores which count them, like 'memstore.Store',
+and items are collected by calling Count of the wrapped store. Every metric is
+labeled by the name of the store as "store", so the metrics of many stores can
+be registered together.
+
+This package is kept apart so that the Prometheus dependency is not required
+by the other packages.
+*/
+package metrics
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"reflect"
+
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A ValueFunc represents a transformation applied to the value of specified
+// key, as a middleware between callers and a store.
+type ValueFunc func(key string, value interface{}) (interface{}, error)
+
+// A ValidatorFunc checks whether the value of specified key is valid to be
+// stored, returning an error otherwise.
+type ValidatorFunc func(key string, value interface{}) error
+
+// CallValidatorFunc calls fn recovering from any panic raised by it, which is
+// returned as CallbackPanicError. Nothing is done when fn is nil.
+func CallValidatorFunc(
+	fn ValidatorFunc, key string, value interface{},
+) (err error) {
+	if fn == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewCallbackPanicError(r)
+		}
+	}()
+
+	return fn(key, value)
+}
+
+// A FactoryFunc computes the value of a missing key, which is stored by
+// stores on demand.
+type FactoryFunc func() (interface{}, error)
+
+// CallFactoryFunc calls fn recovering from any panic raised by it, which is
+// returned as CallbackPanicError.
+func CallFactoryFunc(fn FactoryFunc) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, NewCallbackPanicError(r)
+		}
+	}()
+
+	return fn()
+}
+
+// GetOrAdd gets the value stored by specified key into the value pointed to by
+// ref, or stores the value computed by factory whether the key is missing. It
+// is suitable to stores which cannot hold a lock while factory runs, hence
+// concurrent callers may both run factory, but only the value added first is
+// stored and read by every caller. An error returned by factory is returned
+// without storing anything.
+func GetOrAdd(s Store, key string, ref interface{}, factory FactoryFunc) error {
+	for {
+		err := s.Get(key, ref)
+		if _, ok := err.(dot.InvalidKeyError); !ok {
+			return err
+		}
+
+		value, err := CallFactoryFunc(factory)
+		if err != nil {
+			return err
+		}
+
+		err = s.Add(key, value)
+		if _, ok := err.(dot.DuplicatedKeyError); ok {
+			// The value added meanwhile by another caller takes precedence
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.Get(key, ref)
+	}
+}
+
+// An UpdateFunc represents a transformation of the current value of a key
+// into its new value, which is applied atomically by stores.
+type UpdateFunc func(current interface{}) (interface{}, error)
+
+// CallUpdateFunc calls fn recovering from any panic raised by it, which is
+// returned as CallbackPanicError.
+func CallUpdateFunc(
+	fn UpdateFunc, current interface{},
+) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, NewCallbackPanicError(r)
+		}
+	}()
+
+	return fn(current)
+}
+
+// CallValueFunc calls fn recovering from any panic raised by it, which is
+// returned as CallbackPanicError.
+func CallValueFunc(
+	fn ValueFunc, key string, value interface{},
+) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, NewCallbackPanicError(r)
+		}
+	}()
+
+	return fn(key, value)
+}
+
+// ApplyValueFunc applies fn to the value pointed to by ref and stores the
+// result back. Nothing is done when fn is nil.
+//
+// Errors:
+// InvalidTypeError when ref is not a pointer or when the result of fn is not
+// assignable to the value pointed to by ref.
+// CallbackPanicError when fn panics.
+func ApplyValueFunc(fn ValueFunc, key string, ref interface{}) error {
+	if fn == nil {
+		return nil
+	}
+
+	refVal := reflect.ValueOf(ref)
+	if refVal.Kind() != reflect.Ptr || refVal.IsNil() {
+		return NewInvalidTypeError(ref)
+	}
+	dst := refVal.Elem()
+
+	result, err := CallValueFunc(fn, key, dst.Interface())
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	resultVal := reflect.ValueOf(result)
+	if !resultVal.Type().AssignableTo(dst.Type()) {
+		return NewInvalidTypeError(result)
+	}
+	dst.Set(resultVal)
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"testing"
+
+	"gopkg.in/raiqub/data.v0"
+)
+
+func TestApplyValueFuncTypeMismatch(t *testing.T) {
+	values := []struct {
+		result interface{}
+		ref    interface{}
+	}{
+		{15, new(string)},
+		{"15", new(int)},
+	}
+
+	for _, v := range values {
+		result := v.result
+		fn := func(key string, value interface{}) (interface{}, error) {
+			return result, nil
+		}
+
+		err := data.ApplyValueFunc(fn, "v1", v.ref)
+		if _, ok := err.(data.InvalidTypeError); !ok {
+			t.Errorf("Expected InvalidTypeError assigning %T to %T but got %v",
+				v.result, v.ref, err)
+		}
+	}
+}
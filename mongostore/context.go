@@ -0,0 +1,62 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mongostore
+
+import (
+	"context"
+	"time"
+)
+
+// withContext calls fn with a copy of current store bound to a copy of its
+// session, whose socket timeout is the deadline of ctx. When ctx is done
+// before fn returns, the session copy is closed, aborting the running query,
+// and ctx.Err() is returned instead of the error from MongoDB. A context that
+// is never done calls fn with current store, adding no overhead.
+func (s *Store) withContext(ctx context.Context, fn func(*Store) error) error {
+	if ctx.Done() == nil {
+		return fn(s)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess := s.col.Database.Session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		sess.SetSocketTimeout(time.Until(deadline))
+	}
+	cs := *s
+	cs.col = s.col.With(sess)
+	cs.session = nil
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(&cs)
+	}()
+
+	select {
+	case err := <-done:
+		sess.Close()
+		if err != nil && ctx.Err() != nil {
+			// A socket timeout is reported as the context error
+			return ctx.Err()
+		}
+		return err
+	case <-ctx.Done():
+		sess.Close()
+		return ctx.Err()
+	}
+}
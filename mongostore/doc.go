@@ -23,6 +23,9 @@ A Store provides MongoDB-backed key:value cache that expires after defined
 duration of time. That duration is defined when a new instance is initialized
 calling 'mongostore.New()' function and it is used to all new stored values.
 
+The expiration index is built in background by default, which can be changed
+passing options like 'mongostore.WithBackgroundIndex()' to 'mongostore.New()'.
+
 The Store can manage an application context. Creating an application context
 its the recommended way to avoid global variables and strict the access to your
 variables to selected functions.
@@ -30,8 +33,68 @@ variables to selected functions.
 The lifetime for new values and existing values can be modified calling
 'SetLifetime()'.
 
+A single value can be given its own lifetime calling 'AddWithLifetime()' or
+'SetLifetimeFor()'. Since the expiration index of MongoDB defines one lifetime
+for the whole collection, that lifetime shifts the expiration base time of the
+value. The lifetime is kept by the document and holds when the value is
+renewed.
+
+A Store created by 'mongostore.NewWithExpireAt()' writes to each document when
+it expires, on its expireAt field, and MongoDB expires each document at that
+time instead of by a lifetime defined for the whole collection. It migrates
+the collection from the expiration index created by 'mongostore.New()'.
+
 The expiration behaviour can be changed calling 'SetTransient()' to define
 whether the lifetime of stored value is fixed (transient) or is extended when
 it is read or written (non-transient).
+
+Codecs
+
+Values that are not integers or strings are serialized by msgpack, unless
+another codec is defined calling 'SetCodec()', like 'codec.JSON' to share values
+with services written in other languages.
+
+A codec can be replaced along with fallback codecs calling
+'SetCodecWithFallback()'. Fallback codecs allow reading values written by a
+previous codec, at the cost of a failed decoding attempt for each of them, and
+'SetLazyRewrite()' rewrites those values by the new codec as they are read.
+
+A value read into an empty interface is decoded into generic maps, unless its
+type was registered calling 'mongostore.RegisterType()'. Then the value is
+stored along with a tag naming its type, which is decoded into the original
+type instead.
+
+Large values can be compressed by gzip calling 'SetCompression()', which wraps
+the codec by 'codec.Gzip' and keeps reading values written before.
+
+Encryption
+
+Values can be encrypted calling 'SetCipherFunc()', which selects the cipher by
+key, so each tenant of a shared collection can have its own encryption key.
+Keys are rotated by 'data.RotatedCipher()', which still decrypts values
+encrypted by previous keys.
+
+Contexts
+
+The methods 'AddContext()', 'GetContext()', 'SetContext()' and
+'DeleteContext()' abort the running query when their context is done, returning
+the context error. Each call bound to a cancelable context runs on a copy of the
+session, whose socket timeout follows the context deadline.
+
+Statistics
+
+The effectiveness of a Store as a cache can be measured calling 'Stats()', which
+reports how many Get calls hit or missed and how many values were added or
+evicted. Only the expired values removed by the Store itself are counted as
+evicted, like by 'ReapExpired()', since MongoDB does not report the documents
+it removes.
+
+Sessions
+
+A Store created by 'mongostore.New()' never owns the session of its database,
+so calling 'Close()' keeps it open for other components sharing it. A Store
+created by 'mongostore.NewWithSession()' owns the session only when requested,
+then 'Close()' closes it. A closed Store returns 'data.ErrStoreClosed' from any
+later call which would reach MongoDB.
 */
 package mongostore
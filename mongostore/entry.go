@@ -18,15 +18,72 @@ package mongostore
 
 import "time"
 
-// A entry represents a document stored on MongoDB collection.
-type entry struct {
+// A Data represents a document stored on MongoDB collection, which allows
+// querying the collection directly with compatible decoding. The bson tags of
+// its fields must match the field names used by queries, like valueFieldName.
+//
+// Its fields are stored as:
+//
+//	_id      Key of the value.
+//	at       When the value was last renewed, which is indexed to expire it.
+//	val      Value encoded by the codec of the store, or a string as is, which
+//	         is stored as binary data.
+//	ival     Integer value, which is stored instead of val.
+//	str      Whether val holds a string as is.
+//	type     Tag of the type of val, whether it was registered.
+//	created  When the value was added.
+//	updated  When the value was last written.
+//	ver      Version of the value, which is incremented by every write.
+//	expireAt When the value expires, only for stores created by
+//	         NewWithExpireAt.
+//	ttl      Own lifetime of the value, which is kept when it is renewed.
+type Data struct {
 	CreatedAt time.Time `bson:"at"`
 	Key       string    `bson:"_id"`
-	Value     *string   `bson:"val,omitempty"`
+	Value     []byte    `bson:"val,omitempty"`
 	IntVal    *int      `bson:"ival,omitempty"`
+	// IsString defines whether Value holds a string as is, instead of an
+	// encoded value.
+	IsString bool `bson:"str,omitempty"`
+	// Type holds the tag of the type of encoded value, whether it was
+	// registered by RegisterType.
+	Type string `bson:"type,omitempty"`
+	// Created and Updated define when the value was added and last written,
+	// which are kept as metadata only.
+	Created time.Time `bson:"created,omitempty"`
+	Updated time.Time `bson:"updated,omitempty"`
+	// Version is incremented by every write to the value, which is missing
+	// from documents written before versioning.
+	Version int64 `bson:"ver,omitempty"`
+	// ExpireAt defines when the value expires, which is missing unless the
+	// store was created by NewWithExpireAt.
+	ExpireAt time.Time `bson:"expireAt,omitempty"`
+	// Lifetime defines the own lifetime of the value, as defined by
+	// AddWithLifetime or SetLifetimeFor, which is missing for values
+	// expiring by the lifetime of store.
+	Lifetime time.Duration `bson:"ttl,omitempty"`
 }
 
-// IsExpired returns whether current value is expired.
-func (d *entry) IsExpired(lifetime time.Duration) bool {
+// NewData creates a new document which stores by specified key a value
+// encoded by a codec, like the codec of a store, expiring from now on.
+func NewData(key string, value []byte) Data {
+	now := time.Now()
+	return Data{
+		CreatedAt: now,
+		Key:       key,
+		Value:     value,
+		Created:   now,
+		Updated:   now,
+		Version:   1,
+	}
+}
+
+// IsExpired returns whether current value is expired by specified lifetime,
+// which is the lifetime of the store that wrote it. A value having its
+// expireAt field is expired by that time instead.
+func (d *Data) IsExpired(lifetime time.Duration) bool {
+	if !d.ExpireAt.IsZero() {
+		return time.Now().After(d.ExpireAt)
+	}
 	return time.Now().After(d.CreatedAt.Add(lifetime))
 }
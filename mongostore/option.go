@@ -0,0 +1,125 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mongostore
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// An indexSpec represents the definition of an expiration index, along with
+// the options which mgo.Index does not support.
+type indexSpec struct {
+	mgo.Index
+	// partialFilter limits the index to the documents matching it, whether
+	// it is not nil.
+	partialFilter bson.M
+}
+
+// document returns the definition of current index as expected by the
+// createIndexes command, since mgo omits a zero expiration and partial filters
+// from index definitions.
+func (i indexSpec) document() bson.M {
+	key := bson.D{}
+	for _, k := range i.Key {
+		key = append(key, bson.DocElem{Name: k, Value: 1})
+	}
+
+	spec := bson.M{
+		"key":                key,
+		"name":               i.Name,
+		"expireAfterSeconds": int(i.ExpireAfter / time.Second),
+		"background":         i.Background,
+	}
+	if i.Sparse {
+		spec["sparse"] = true
+	}
+	if i.Collation != nil {
+		spec["collation"] = i.Collation
+	}
+	if i.partialFilter != nil {
+		spec["partialFilterExpression"] = i.partialFilter
+	}
+	return spec
+}
+
+// An IndexOption customizes the expiration index created by New, whose key,
+// name and lifetime are always defined by the store.
+type IndexOption func(*indexSpec)
+
+// WithIndexOptions merges the build options of specified index into the
+// expiration index, like Background, Sparse and Collation. Its key, name,
+// lifetime and uniqueness are ignored.
+func WithIndexOptions(index mgo.Index) IndexOption {
+	return func(i *indexSpec) {
+		i.Background = index.Background
+		i.Sparse = index.Sparse
+		i.Collation = index.Collation
+	}
+}
+
+// WithBackgroundIndex defines whether the expiration index is built in
+// background, which it is by default. Building a large index in foreground
+// blocks the database until it is done.
+func WithBackgroundIndex(value bool) IndexOption {
+	return func(i *indexSpec) {
+		i.Background = value
+	}
+}
+
+// WithSparseIndex defines whether the expiration index skips documents
+// missing its key field.
+func WithSparseIndex(value bool) IndexOption {
+	return func(i *indexSpec) {
+		i.Sparse = value
+	}
+}
+
+// WithCollation defines the collation of the expiration index.
+func WithCollation(collation *mgo.Collation) IndexOption {
+	return func(i *indexSpec) {
+		i.Collation = collation
+	}
+}
+
+// WithPartialFilter limits the expiration index to the documents matching
+// filter, so documents not matching it are never expired by MongoDB. MongoDB
+// does not allow a partial index to be sparse.
+func WithPartialFilter(filter bson.M) IndexOption {
+	return func(i *indexSpec) {
+		i.partialFilter = filter
+	}
+}
+
+// expireIndex returns the definition of the expiration index, which expires
+// documents after specified duration, customized by opts.
+func expireIndex(d time.Duration, opts []IndexOption) indexSpec {
+	index := indexSpec{Index: mgo.Index{
+		Unique:     false,
+		Background: true,
+	}}
+	for _, opt := range opts {
+		opt(&index)
+	}
+
+	index.Key = []string{timeFieldName}
+	index.ExpireAfter = d
+	index.Name = indexName
+	return index
+}
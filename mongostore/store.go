@@ -17,231 +17,2349 @@
 package mongostore
 
 import (
+	"context"
+	"reflect"
+	"regexp"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/sync/singleflight"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/codec"
 	"gopkg.in/raiqub/dot.v1"
-	"gopkg.in/vmihailenco/msgpack.v2"
 )
 
 const (
-	indexName     = "expire_index"
-	keyFieldName  = "_id"
-	timeFieldName = "at"
+	indexName         = "expire_index"
+	expireAtIndexName = "expire_at_index"
+	keyFieldName      = "_id"
+	timeFieldName     = "at"
+	expireAtFieldName = "expireAt"
+	lifetimeFieldName = "ttl"
+
+	// The names of value fields must match the bson tags of Data.
+	valueFieldName  = "val"
+	intFieldName    = "ival"
+	stringFieldName = "str"
+	typeFieldName   = "type"
+
+	createdFieldName = "created"
+	updatedFieldName = "updated"
+	versionFieldName = "ver"
 
 	// MongoDupKeyErrorCode defines MongoDB error code when trying to insert a
 	// duplicated key.
 	MongoDupKeyErrorCode = 11000
+
+	// mongoIndexConflictErrorCode defines MongoDB error code when trying to
+	// create an existing index with different options.
+	mongoIndexConflictErrorCode = 85
+
+	// mongoIndexNotFoundErrorCode defines MongoDB error code when trying to
+	// drop a missing index.
+	mongoIndexNotFoundErrorCode = 27
+
+	// migrateBatchSize defines how many documents are updated at once when
+	// their expiration time is computed by the store.
+	migrateBatchSize = 1000
 )
 
-// A Store provides a MongoDB-backed key:value cache that expires after defined
-// duration of time.
-//
-// It is a implementation of Store interface.
-type Store struct {
-	col            *mgo.Collection
-	lifetime       time.Duration
-	isTransient    bool
-	ensureAccuracy bool
+// A Store provides a MongoDB-backed key:value cache that expires after defined
+// duration of time.
+//
+// It is a implementation of Store interface.
+type Store struct {
+	col            *mgo.Collection
+	lifetime       time.Duration
+	isTransient    bool
+	ensureAccuracy bool
+	codec          data.Codec
+	fallbacks      []data.Codec
+	lazyRewrite    bool
+	cipherFunc     func(key string) data.Cipher
+	onStore        data.ValueFunc
+	onLoad         data.ValueFunc
+	validator      data.ValidatorFunc
+	types          *data.TypeRegistry
+	session        *mgo.Session
+	indexOpts      []IndexOption
+	// useExpireAt defines whether documents expire by their expireAt field,
+	// instead of their expiration base time. See NewWithExpireAt.
+	useExpireAt bool
+	// stats is shared by copies bound to a context.
+	stats *data.StatsCounter
+	// closed is set atomically once Close is called, and shared by copies
+	// bound to a context.
+	closed *int32
+	// loads runs a single factory per key at a time for GetOrAdd, and is
+	// shared by copies bound to a context.
+	loads *singleflight.Group
+}
+
+// New creates a new instance of MongoStore and defines the lifetime of stored
+// items, or returns the error when its expiration index cannot be created.
+// When the collection already has an expiration index with a different
+// lifetime, the index is updated to requested lifetime. The stored items
+// lifetime are renewed when it is read or written.
+//
+// The expiration index is built in background unless opts define otherwise,
+// which are kept to recreate it when the lifetime is modified.
+//
+// The store does not own the session of db, which is kept open by Close and
+// must be closed by caller. Use NewWithSession to transfer the ownership.
+func New(
+	db *mgo.Database, name string, d time.Duration, opts ...IndexOption,
+) (*Store, error) {
+	col := db.C(name)
+	if err := ensureIndex(col, expireIndex(d, opts)); err != nil {
+		return nil, err
+	}
+
+	return newStore(col, d, opts), nil
+}
+
+// NewWithExpireAt creates a new instance of MongoStore like New does, but its
+// documents expire by their own expireAt field, which holds when each of them
+// expires. Its expiration index expires documents as soon as their expireAt
+// time is reached, so documents of the same collection can have distinct
+// lifetimes, like the values added by AddWithLifetime.
+//
+// The collection is migrated from the expiration index created by New: that
+// index is dropped and the expireAt field of documents written by a store
+// created by New is computed from their expiration base time. Once migrated,
+// the collection must not be used by a store created by New anymore, whose
+// index would ignore the lifetime of each document.
+func NewWithExpireAt(
+	db *mgo.Database, name string, d time.Duration, opts ...IndexOption,
+) (*Store, error) {
+	col := db.C(name)
+	if err := ensureExpireAtIndex(col, expireIndex(0, opts)); err != nil {
+		return nil, err
+	}
+
+	s := newStore(col, d, opts)
+	s.useExpireAt = true
+	err := s.updateExpireAt(bson.M{expireAtFieldName: bson.M{"$exists": false}})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newStore creates a new instance of MongoStore on specified collection,
+// whose expiration index must be already created.
+func newStore(col *mgo.Collection, d time.Duration, opts []IndexOption) *Store {
+	return &Store{
+		col:       col,
+		lifetime:  d,
+		indexOpts: opts,
+		codec:     codec.Msgpack{},
+		types:     &data.TypeRegistry{},
+		stats:     &data.StatsCounter{},
+		closed:    new(int32),
+		loads:     &singleflight.Group{},
+	}
+}
+
+// MustNew creates a new instance of MongoStore like New does, but panics when
+// its expiration index cannot be created.
+//
+// Deprecated: Use New and handle its error instead.
+func MustNew(
+	db *mgo.Database, name string, d time.Duration, opts ...IndexOption,
+) *Store {
+	s, err := New(db, name, d, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewWithSession creates a new instance of MongoStore on the collection colName
+// of database dbName from specified session, like New does.
+//
+// When ownSession is true the store owns the session, which is closed by
+// Close and must not be used elsewhere afterwards. Otherwise the session is
+// shared with caller, which remains responsible for closing it after the store
+// is no longer used.
+func NewWithSession(
+	sess *mgo.Session, dbName, colName string, d time.Duration, ownSession bool,
+	opts ...IndexOption,
+) (*Store, error) {
+	s, err := New(sess.DB(dbName), colName, d, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if ownSession {
+		s.session = sess
+	}
+	return s, nil
+}
+
+// decode stores the value of specified document in the value pointed to by
+// ref, reporting whether it was decoded by a fallback codec.
+//
+// Errors:
+// data.InvalidTypeError when the value cannot be decoded into the type of ref.
+func (s *Store) decode(doc *Data, ref interface{}) (bool, error) {
+	var fallback bool
+	var err error
+	switch t := ref.(type) {
+	case *int:
+		if doc.IntVal == nil {
+			return false, data.NewInvalidTypeError(ref)
+		}
+		*t = *doc.IntVal
+	case *string:
+		if doc.Value == nil && !doc.IsString {
+			return false, data.NewInvalidTypeError(ref)
+		}
+		if doc.IsString || s.cipherOf(doc.Key) == nil {
+			*t = string(doc.Value)
+			break
+		}
+		// Encrypted strings are encoded by codec
+		fallback, err = s.unmarshal(doc.Key, doc.Value, t)
+		if err != nil {
+			return false, data.NewInvalidTypeError(ref)
+		}
+	case *interface{}:
+		// Generic values are decoded as data.GenericValue defines
+		if doc.IntVal != nil {
+			*t = int64(*doc.IntVal)
+			break
+		}
+		if doc.IsString {
+			*t = string(doc.Value)
+			break
+		}
+		if doc.Value == nil {
+			return false, data.NewInvalidTypeError(ref)
+		}
+		// A value of registered type is decoded into its original type
+		if typ, ok := typeOfTag(doc.Type); ok {
+			v := reflect.New(typ)
+			fallback, err = s.unmarshal(doc.Key, doc.Value,
+				v.Interface())
+			if err != nil {
+				return false, data.NewInvalidTypeError(ref)
+			}
+			*t = v.Elem().Interface()
+			break
+		}
+		fallback, err = s.unmarshal(doc.Key, doc.Value, t)
+		if err != nil {
+			return false, data.NewInvalidTypeError(ref)
+		}
+		*t = data.GenericValue(*t)
+	default:
+		if doc.Value == nil || doc.IsString {
+			return false, data.NewInvalidTypeError(ref)
+		}
+		fallback, err = s.unmarshal(doc.Key, doc.Value, ref)
+		if err != nil {
+			return false, data.NewInvalidTypeError(ref)
+		}
+	}
+
+	return fallback, nil
+}
+
+// marshal encodes specified value by primary codec, encrypting it by the
+// cipher of specified key whether it is defined.
+func (s *Store) marshal(key string, value interface{}) ([]byte, error) {
+	b, err := s.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if c := s.cipherOf(key); c != nil {
+		return c.Encrypt(b)
+	}
+	return b, nil
+}
+
+// unmarshal decodes b by primary codec, or by the first fallback codec that
+// succeeds when primary codec fails, reporting whether a fallback codec was
+// used. The value is decrypted by the cipher of specified key whether it is
+// defined.
+func (s *Store) unmarshal(key string, b []byte, ref interface{}) (bool, error) {
+	if c := s.cipherOf(key); c != nil {
+		var err error
+		if b, err = c.Decrypt(b); err != nil {
+			return false, err
+		}
+	}
+
+	err := s.codec.Unmarshal(b, ref)
+	if err == nil {
+		return false, nil
+	}
+
+	for _, c := range s.fallbacks {
+		if c.Unmarshal(b, ref) == nil {
+			return true, nil
+		}
+	}
+	return false, err
+}
+
+// rewrite replaces the value of doc, which was decoded into ref by a fallback
+// codec, by its encoding by primary codec, unless it was changed meanwhile.
+func (s *Store) rewrite(doc *Data, ref interface{}) error {
+	b, err := s.marshal(doc.Key, reflect.ValueOf(ref).Elem().Interface())
+	if err != nil {
+		return err
+	}
+
+	selector := bson.M{keyFieldName: doc.Key}
+	selectValue(selector, doc.Value)
+	err = s.col.Update(selector, bson.M{"$set": bson.M{valueFieldName: b}})
+	if err == mgo.ErrNotFound {
+		// Value changed or removed meanwhile
+		return nil
+	}
+	return err
+}
+
+// encode stores specified value into document, as an integer, a string or a
+// value encoded by codec. Strings are encoded by codec too whether they must
+// be encrypted.
+func (s *Store) encode(doc *Data, value interface{}) error {
+	switch t := value.(type) {
+	case int:
+		doc.IntVal = &t
+		return nil
+	case *int:
+		doc.IntVal = t
+		return nil
+	}
+
+	if s.cipherOf(doc.Key) == nil {
+		switch t := value.(type) {
+		case string:
+			doc.Value = []byte(t)
+			doc.IsString = true
+			return nil
+		case *string:
+			doc.Value = []byte(*t)
+			doc.IsString = true
+			return nil
+		}
+	}
+
+	b, err := s.marshal(doc.Key, value)
+	if err != nil {
+		return err
+	}
+	doc.Value = b
+	doc.Type = tagOf(value)
+	return nil
+}
+
+// cipherOf returns the cipher of specified key, or nil whether values are not
+// encrypted.
+func (s *Store) cipherOf(key string) data.Cipher {
+	if s.cipherFunc == nil {
+		return nil
+	}
+	return s.cipherFunc(key)
+}
+
+// updateExpireAt computes the expireAt field of the documents matching
+// selector from their expiration base time and the lifetime of store.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) updateExpireAt(selector bson.M) error {
+	iter := s.col.Find(selector).Select(bson.M{timeFieldName: 1}).Iter()
+	bulk := s.col.Bulk()
+	bulk.Unordered()
+	pending := 0
+	doc := Data{}
+	for iter.Next(&doc) {
+		bulk.Update(bson.M{keyFieldName: doc.Key}, bson.M{
+			"$set": bson.M{expireAtFieldName: doc.CreatedAt.Add(s.lifetime)},
+		})
+		if pending++; pending == migrateBatchSize {
+			if _, err := bulk.Run(); err != nil {
+				iter.Close()
+				return err
+			}
+			bulk = s.col.Bulk()
+			bulk.Unordered()
+			pending = 0
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	if pending > 0 {
+		if _, err := bulk.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectValue restricts selector to documents storing specified value, which
+// also matches a value stored as a string by former versions. An empty value
+// is missing from inserted documents, which are matched unless they store an
+// integer.
+func selectValue(selector bson.M, value []byte) {
+	in := []interface{}{value, string(value)}
+	if len(value) == 0 {
+		in = append(in, nil)
+		selector[intFieldName] = bson.M{"$exists": false}
+	}
+	selector[valueFieldName] = bson.M{"$in": in}
+}
+
+// updateOf returns an update document which replaces the value of a stored
+// document by the value of doc, marking it as updated now and incrementing its
+// version.
+func updateOf(doc *Data) bson.M {
+	set := bson.M{updatedFieldName: time.Now()}
+	unset := bson.M{}
+	if doc.IntVal != nil {
+		set[intFieldName] = *doc.IntVal
+		unset[valueFieldName] = ""
+		unset[stringFieldName] = ""
+		unset[typeFieldName] = ""
+	} else {
+		set[valueFieldName] = doc.Value
+		unset[intFieldName] = ""
+		if doc.IsString {
+			set[stringFieldName] = true
+		} else {
+			unset[stringFieldName] = ""
+		}
+		if doc.Type != "" {
+			set[typeFieldName] = doc.Type
+		} else {
+			unset[typeFieldName] = ""
+		}
+	}
+
+	return bson.M{
+		"$set":   set,
+		"$unset": unset,
+		"$inc":   bson.M{versionFieldName: 1},
+	}
+}
+
+// ensureIndex creates specified expiration index of col, whether it does not
+// exist, and ensures that it expires documents after its lifetime. Since
+// MongoDB does not modify an existing index, the lifetime of a mismatching
+// index is updated by collMod command.
+func ensureIndex(col *mgo.Collection, index indexSpec) error {
+	d := index.ExpireAfter
+	err := createIndex(col, index)
+	if err != nil && errorCode(err) != mongoIndexConflictErrorCode {
+		return err
+	}
+
+	indexes, err := col.Indexes()
+	if err != nil {
+		return err
+	}
+
+	// MongoDB defines index expiration in seconds
+	expected := d / time.Second * time.Second
+	for _, i := range indexes {
+		if i.Name != indexName || i.ExpireAfter == expected {
+			continue
+		}
+
+		return col.Database.Run(bson.D{
+			{Name: "collMod", Value: col.Name},
+			{Name: "index", Value: bson.M{
+				"keyPattern":         bson.M{timeFieldName: 1},
+				"expireAfterSeconds": int(d / time.Second),
+			}},
+		}, nil)
+	}
+
+	return nil
+}
+
+// expiration returns the expiration base time and the expiration time of a
+// value written at specified time, which expires by specified lifetime
+// whether it is positive. The expiration time is zero unless documents expire
+// by their expireAt field; otherwise MongoDB expires the value once its base
+// time is older than the lifetime of store, so a base time shifted forward
+// extends the lifetime of the value.
+func (s *Store) expiration(
+	now time.Time, lifetime time.Duration,
+) (time.Time, time.Time) {
+	if lifetime < 1 {
+		lifetime = s.lifetime
+	}
+	if s.useExpireAt {
+		return now, now.Add(lifetime)
+	}
+	return now.Add(lifetime - s.lifetime), time.Time{}
+}
+
+// expire defines when specified document expires, which is written at
+// specified time with specified lifetime, like expiration does.
+func (s *Store) expire(doc *Data, now time.Time, lifetime time.Duration) {
+	doc.CreatedAt, doc.ExpireAt = s.expiration(now, lifetime)
+}
+
+// expiresAt returns when specified document expires.
+func (s *Store) expiresAt(doc *Data) time.Time {
+	if s.useExpireAt {
+		return doc.ExpireAt
+	}
+	return doc.CreatedAt.Add(s.lifetime)
+}
+
+// expiryFieldName returns the name of the field which sorts documents by how
+// soon they expire.
+func (s *Store) expiryFieldName() string {
+	if s.useExpireAt {
+		return expireAtFieldName
+	}
+	return timeFieldName
+}
+
+// isClosed returns whether Close was called on current store.
+func (s *Store) isClosed() bool {
+	return atomic.LoadInt32(s.closed) == 1
+}
+
+// isExpired returns whether specified document is expired.
+func (s *Store) isExpired(doc *Data) bool {
+	return time.Now().After(s.expiresAt(doc))
+}
+
+// renew renews the lifetime of the documents updated by query from now on,
+// which is done by MongoDB clock unless documents expire by their expireAt
+// field.
+func (s *Store) renew(query bson.M) {
+	if !s.useExpireAt {
+		query["$currentDate"] = bson.M{timeFieldName: true}
+		return
+	}
+
+	set, ok := query["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		query["$set"] = set
+	}
+	s.setExpiration(set, time.Now(), 0)
+}
+
+// renewOwn renews from now on the lifetime of the document stored by
+// specified key by its own lifetime, whether it is positive, since renew
+// renews documents by the lifetime of store.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) renewOwn(key string, lifetime time.Duration) error {
+	if lifetime < 1 {
+		return nil
+	}
+
+	set := bson.M{}
+	s.setExpiration(set, time.Now(), lifetime)
+	err := s.col.UpdateId(key, bson.M{"$set": set})
+	if err == mgo.ErrNotFound {
+		// Value removed meanwhile
+		return nil
+	}
+	return err
+}
+
+// renewOwnAll renews from now on the lifetime of the documents matching
+// selector which have their own lifetime, like renewOwn does.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) renewOwnAll(selector bson.M) error {
+	own := bson.M{lifetimeFieldName: bson.M{"$exists": true}}
+	for k, v := range selector {
+		own[k] = v
+	}
+
+	var docs []Data
+	err := s.col.Find(own).Select(bson.M{lifetimeFieldName: 1}).All(&docs)
+	if err != nil {
+		return err
+	}
+	for i := range docs {
+		if err := s.renewOwn(docs[i].Key, docs[i].Lifetime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectExpired restricts selector to documents expired at specified time.
+func (s *Store) selectExpired(selector bson.M, now time.Time) {
+	if s.useExpireAt {
+		selector[expireAtFieldName] = bson.M{"$lt": now}
+		return
+	}
+	selector[timeFieldName] = bson.M{"$lt": now.Add(-s.lifetime)}
+}
+
+// selectLive restricts selector to documents not expired at specified time.
+func (s *Store) selectLive(selector bson.M, now time.Time) {
+	if s.useExpireAt {
+		selector[expireAtFieldName] = bson.M{"$gte": now}
+		return
+	}
+	selector[timeFieldName] = bson.M{"$gte": now.Add(-s.lifetime)}
+}
+
+// setExpiration sets to fields when a document expires, which is written at
+// specified time with specified lifetime, like expiration does.
+func (s *Store) setExpiration(
+	fields bson.M, now time.Time, lifetime time.Duration,
+) {
+	at, expireAt := s.expiration(now, lifetime)
+	fields[timeFieldName] = at
+	if s.useExpireAt {
+		fields[expireAtFieldName] = expireAt
+	}
+}
+
+// ensureExpireAtIndex creates specified expiration index of col on expireAt
+// field, whether it does not exist, which expires documents once their
+// expireAt time is reached. The expiration index on expiration base time is
+// dropped, since it would expire documents by a single lifetime.
+func ensureExpireAtIndex(col *mgo.Collection, index indexSpec) error {
+	index.Key = []string{expireAtFieldName}
+	index.Name = expireAtIndexName
+	index.ExpireAfter = 0
+	err := createIndex(col, index)
+	if err != nil && errorCode(err) != mongoIndexConflictErrorCode {
+		return err
+	}
+
+	err = col.DropIndexName(indexName)
+	if err != nil && errorCode(err) != mongoIndexNotFoundErrorCode {
+		return err
+	}
+	return nil
+}
+
+// createIndex creates specified index of col by the createIndexes command,
+// which is done directly since mgo.Index does not support every option.
+func createIndex(col *mgo.Collection, index indexSpec) error {
+	return col.Database.Run(bson.D{
+		{Name: "createIndexes", Value: col.Name},
+		{Name: "indexes", Value: []bson.M{index.document()}},
+	}, nil)
+}
+
+// errorCode returns the error code of a MongoDB error, or zero when err is not
+// a MongoDB error.
+func errorCode(err error) int {
+	switch e := err.(type) {
+	case *mgo.LastError:
+		return e.Code
+	case *mgo.QueryError:
+		return e.Code
+	}
+	return 0
+}
+
+// Add adds a new key:value to current store.
+//
+// When accuracy is ensured, an existing key whose value is expired but not yet
+// removed by MongoDB is replaced by the new value.
+//
+// Errors
+//
+// dot.DuplicatedKeyError when requested key already exists.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Add(key string, value interface{}) error {
+	return s.AddContext(context.Background(), key, value)
+}
+
+// AddContext adds a new key:value to current store, like Add, unless ctx is
+// done before the value is added.
+//
+// Errors
+//
+// dot.DuplicatedKeyError when requested key already exists.
+//
+// ctx.Err() when ctx is done before the value is added.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) AddContext(
+	ctx context.Context, key string, value interface{},
+) error {
+	return s.withContext(ctx, func(cs *Store) error {
+		return cs.add(key, value, 0)
+	})
+}
+
+// AddWithLifetime adds a new key:value to current store which expires by
+// specified lifetime instead of the lifetime of store. A lifetime lower than
+// one defines the lifetime of store, as Add does.
+//
+// Unless documents expire by their expireAt field, see NewWithExpireAt, the
+// expiration index of MongoDB defines one lifetime for the whole collection,
+// so the lifetime of the value is defined by shifting its expiration base
+// time. Either way, the lifetime of the value is kept by the document and
+// holds when it is renewed, like by a read from a store which is not
+// transient.
+//
+// Errors
+//
+// dot.DuplicatedKeyError when requested key already exists.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) AddWithLifetime(
+	key string, value interface{}, d time.Duration,
+) error {
+	return s.add(key, value, d)
+}
+
+// add adds a new key:value to current store with its own lifetime, whether
+// lifetime is positive.
+func (s *Store) add(
+	key string, value interface{}, lifetime time.Duration,
+) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	value, err := s.storeValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	doc := Data{
+		Key:     key,
+		Created: now,
+		Updated: now,
+		Version: 1,
+	}
+	if lifetime > 0 {
+		doc.Lifetime = lifetime
+	}
+	s.expire(&doc, now, lifetime)
+
+	if err := s.encode(&doc, value); err != nil {
+		return err
+	}
+
+	if err := s.col.Insert(&doc); err != nil {
+		// Other errors than a duplicated key, like network errors, are not
+		// triggered by MongoDB and have other types
+		mgoerr, ok := err.(*mgo.LastError)
+		if ok && mgoerr.Code == MongoDupKeyErrorCode {
+			if s.ensureAccuracy {
+				return s.replaceExpired(&doc)
+			}
+			return dot.DuplicatedKeyError(key)
+		}
+
+		return err
+	}
+
+	s.stats.Added(1)
+	return nil
+}
+
+func (s *Store) atomicInteger(key string, inc int) (int, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	now := time.Now()
+	onInsert := bson.M{createdFieldName: now}
+	query := bson.M{
+		"$inc":         bson.M{intFieldName: inc, versionFieldName: 1},
+		"$set":         bson.M{updatedFieldName: now},
+		"$setOnInsert": onInsert,
+	}
+	if s.isTransient {
+		s.setExpiration(onInsert, now, 0)
+	} else {
+		s.renew(query)
+	}
+
+	change := mgo.Change{
+		Update:    query,
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	// A document having a non-integer value, or an expired value when
+	// accuracy is ensured, is not matched and fails to be upserted by
+	// duplicated key.
+	selector := bson.M{
+		keyFieldName:    key,
+		valueFieldName:  bson.M{"$exists": false},
+		stringFieldName: bson.M{"$exists": false},
+	}
+	if s.ensureAccuracy {
+		s.selectLive(selector, now)
+	}
+
+	for {
+		// db.runCommand({
+		// 	findAndModify: "col",
+		// 	query: { _id: %key, val: { $exists: false } },
+		// 	update: {
+		// 		$inc: { ival: %inc },
+		// 		$setOnInsert: { at: new ISODate() }
+		// 	},
+		// 	new: true,
+		// 	upsert: true
+		// })
+		doc := Data{}
+		_, err := s.col.Find(selector).Apply(change, &doc)
+		if err == nil {
+			if !s.isTransient {
+				err = s.renewOwn(key, doc.Lifetime)
+			}
+			return *doc.IntVal, err
+		}
+		if !mgo.IsDup(err) {
+			return 0, err
+		}
+
+		if s.ensureAccuracy {
+			newDoc := Data{
+				Key:     key,
+				IntVal:  &inc,
+				Created: now,
+				Updated: now,
+			}
+			s.expire(&newDoc, now, 0)
+			err := s.replaceExpired(&newDoc)
+			if err == nil {
+				return inc, nil
+			}
+			if _, ok := err.(dot.DuplicatedKeyError); !ok {
+				return 0, err
+			}
+		}
+
+		// An empty string is stored without value
+		count, err := s.col.Find(bson.M{
+			keyFieldName: key,
+			intFieldName: bson.M{"$exists": false},
+		}).Count()
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			return 0, data.NewInvalidTypeError(new(int))
+		}
+		// Value added or renewed meanwhile
+	}
+}
+
+// Close releases the resources of current instance, closing its session only
+// when it is owned by current instance. See NewWithSession.
+//
+// A closed store is unusable: every later call which would reach MongoDB
+// returns data.ErrStoreClosed instead. Calling Close again does nothing.
+func (s *Store) Close() error {
+	if !atomic.CompareAndSwapInt32(s.closed, 0, 1) {
+		return nil
+	}
+
+	if s.session != nil {
+		s.session.Close()
+		s.session = nil
+	}
+	return nil
+}
+
+// Codec returns the codec used to serialize values that are not integers or
+// strings.
+func (s *Store) Codec() data.Codec {
+	return s.codec
+}
+
+// CodecName returns the name of codec used to serialize values.
+func (s *Store) CodecName() string {
+	return s.codec.Name()
+}
+
+// CompareAndSwap atomically sets the value of specified key to new, whether
+// its current value equals old, reporting whether the value was swapped. The
+// values are compared by their stored form, hence old is encoded by the codec
+// like new, though neither the validator nor the store middleware is applied
+// to it, and the codec must encode equal values identically; encrypted values
+// never equal, since each encryption differs.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) CompareAndSwap(key string, old, new interface{}) (bool, error) {
+	if s.isClosed() {
+		return false, data.ErrStoreClosed
+	}
+
+	new, err := s.storeValue(key, new)
+	if err != nil {
+		return false, err
+	}
+
+	oldDoc := Data{Key: key}
+	if err := s.encode(&oldDoc, old); err != nil {
+		return false, err
+	}
+	doc := Data{Key: key}
+	if err := s.encode(&doc, new); err != nil {
+		return false, err
+	}
+
+	selector := bson.M{keyFieldName: key}
+	if oldDoc.IntVal != nil {
+		selector[intFieldName] = *oldDoc.IntVal
+	} else {
+		selectValue(selector, oldDoc.Value)
+	}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	query := updateOf(&doc)
+	if !s.isTransient {
+		s.renew(query)
+	}
+
+	change := mgo.Change{Update: query}
+	current := Data{}
+	_, err = s.col.Find(selector).Select(bson.M{
+		lifetimeFieldName: 1,
+	}).Apply(change, &current)
+	if err == nil {
+		if !s.isTransient {
+			return true, s.renewOwn(key, current.Lifetime)
+		}
+		return true, nil
+	}
+	if err != mgo.ErrNotFound {
+		return false, err
+	}
+
+	// The key is either missing or holding another value
+	if s.ensureAccuracy {
+		return false, s.testExpiration(key)
+	}
+	n, err := s.col.FindId(key).Count()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, dot.InvalidKeyError(key)
+	}
+	return false, nil
+}
+
+// Count gets the number of stored values by current instance.
+//
+// When accuracy is ensured the values expired but not removed by MongoDB yet
+// are not counted, which requires scanning the expiration index instead of
+// reading the count kept by the collection, hence it is slower on large
+// collections.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Count() (int, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	if !s.ensureAccuracy {
+		return s.col.Count()
+	}
+
+	selector := bson.M{}
+	s.selectLive(selector, time.Now())
+	return s.col.Find(selector).Count()
+}
+
+// Decrement atomically gets the value stored by specified key and
+// decrements it by one. If the key does not exist, it is created.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not integer.
+func (s *Store) Decrement(key string) (int, error) {
+	return s.atomicInteger(key, -1)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value. If the key does not exist, it is created.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not integer.
+func (s *Store) DecrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, -1*value)
+}
+
+// DecrementAndDeleteAtZero atomically gets the integer value stored by
+// specified key and decrements it by one, deleting the key when the result
+// reaches zero or less. It returns the resulting value and whether the key was
+// deleted, which is suitable to release reference counts.
+//
+// The key is removed only whether its value was not incremented after
+// decremented, in which case it is kept and reported as not deleted.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// data.InvalidTypeError when the value stored at key is not integer.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) DecrementAndDeleteAtZero(key string) (int, bool, error) {
+	if s.isClosed() {
+		return 0, false, data.ErrStoreClosed
+	}
+
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
+			return 0, false, err
+		}
+	}
+
+	query := bson.M{
+		"$inc": bson.M{intFieldName: -1, versionFieldName: 1},
+		"$set": bson.M{updatedFieldName: time.Now()},
+	}
+	if !s.isTransient {
+		s.renew(query)
+	}
+
+	change := mgo.Change{
+		Update:    query,
+		ReturnNew: true,
+	}
+
+	doc := Data{}
+	_, err := s.col.Find(bson.M{
+		keyFieldName: key,
+		intFieldName: bson.M{"$exists": true},
+	}).Apply(change, &doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, false, s.notInteger(key)
+		}
+		return 0, false, err
+	}
+
+	value := *doc.IntVal
+	if value > 0 {
+		if !s.isTransient {
+			err = s.renewOwn(key, doc.Lifetime)
+		}
+		return value, false, err
+	}
+
+	err = s.col.Remove(bson.M{keyFieldName: key, intFieldName: bson.M{"$lte": 0}})
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return value, false, nil
+		}
+		return value, false, err
+	}
+
+	return value, true, nil
+}
+
+// Delete deletes the specified value.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key already exists.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes the specified key:value, like Delete, unless ctx is
+// done before the value is deleted.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// ctx.Err() when ctx is done before the value is deleted.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) DeleteContext(ctx context.Context, key string) error {
+	return s.withContext(ctx, func(cs *Store) error {
+		return cs.delete(key)
+	})
+}
+
+// delete deletes the specified key:value.
+func (s *Store) delete(key string) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
+			return err
+		}
+	}
+
+	err := s.col.RemoveId(key)
+	if err == mgo.ErrNotFound {
+		return dot.InvalidKeyError(key)
+	}
+
+	return err
+}
+
+// DeletePrefix deletes every value whose key starts with prefix, returning the
+// sorted keys of deleted values. When dryRun is true the matching keys are
+// queried without deleting them, allowing to check which values would be
+// deleted.
+//
+// Errors
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) DeletePrefix(prefix string, dryRun bool) ([]string, error) {
+	if s.isClosed() {
+		return nil, data.ErrStoreClosed
+	}
+
+	selector := bson.M{
+		keyFieldName: bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)},
+	}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	var docs []Data
+	err := s.col.Find(selector).Select(bson.M{keyFieldName: 1}).
+		Sort(keyFieldName).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(docs))
+	for i, d := range docs {
+		keys[i] = d.Key
+	}
+	if dryRun || len(keys) == 0 {
+		return keys, nil
+	}
+
+	_, err = s.col.RemoveAll(bson.M{keyFieldName: bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// EntriesByExpiry gets up to limit keys with their remaining lifetimes, sorted
+// by how soon they expire. A limit lower than one returns every key.
+//
+// The result is a snapshot of the collection, which is outdated as soon as
+// stored values are renewed or expire.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) EntriesByExpiry(limit int) ([]data.KeyTTL, error) {
+	if s.isClosed() {
+		return nil, data.ErrStoreClosed
+	}
+
+	now := time.Now()
+	selector := bson.M{}
+	s.selectLive(selector, now)
+	query := s.col.Find(selector).Select(bson.M{
+		keyFieldName:      1,
+		timeFieldName:     1,
+		expireAtFieldName: 1,
+	}).Sort(s.expiryFieldName())
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var docs []Data
+	if err := query.All(&docs); err != nil {
+		return nil, err
+	}
+
+	entries := make([]data.KeyTTL, len(docs))
+	for i, doc := range docs {
+		entries[i] = data.KeyTTL{
+			Key: doc.Key,
+			TTL: s.expiresAt(&doc).Sub(now),
+		}
+	}
+
+	return entries, nil
+}
+
+// EnsureAccuracy enables a double-check for expired values (slower). Because
+// MongoDB does not garantee that expired data will be deleted immediately upon
+// expiration.
+func (s *Store) EnsureAccuracy(value bool) {
+	s.ensureAccuracy = value
+}
+
+// Exists reports whether a value is stored by specified key, without renewing
+// it. Expired values not removed by MongoDB yet are reported as missing only
+// when accuracy is ensured.
+//
+// Errors
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Exists(key string) (bool, error) {
+	if s.isClosed() {
+		return false, data.ErrStoreClosed
+	}
+
+	selector := bson.M{keyFieldName: key}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	count, err := s.col.Find(selector).Limit(1).Count()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Flush deletes any cached value into current instance.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Flush() error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	_, err := s.col.RemoveAll(bson.M{})
+	return err
+}
+
+// FlushPrefix deletes every value whose key starts with prefix, including
+// expired values not removed by MongoDB yet, using a single query.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) FlushPrefix(prefix string) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	_, err := s.col.RemoveAll(bson.M{
+		keyFieldName: bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+	})
+	return err
+}
+
+// Get gets the value stored by specified key and stores the result in the
+// value pointed to by ref.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key already exists.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Get(key string, ref interface{}) error {
+	return s.GetContext(context.Background(), key, ref)
+}
+
+// GetContext gets the value stored by specified key, like Get, unless ctx is
+// done before the value is read.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// ctx.Err() when ctx is done before the value is read.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) GetContext(
+	ctx context.Context, key string, ref interface{},
+) error {
+	err := s.withContext(ctx, func(cs *Store) error {
+		_, err := cs.get(key, ref)
+		return err
+	})
+	s.stats.Observe(err)
+	return err
+}
+
+// get gets the value stored by specified key and stores the result in the
+// value pointed to by ref, returning its version.
+func (s *Store) get(key string, ref interface{}) (uint64, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
+			return 0, err
+		}
+	}
+
+	if !s.isTransient {
+		query := bson.M{}
+		s.renew(query)
+		if err := s.col.UpdateId(key, query); err != nil {
+			if err == mgo.ErrNotFound {
+				return 0, dot.InvalidKeyError(key)
+			}
+			return 0, err
+		}
+	}
+
+	doc := Data{}
+	err := s.col.FindId(key).One(&doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, dot.InvalidKeyError(key)
+		}
+		return 0, err
+	}
+	if !s.isTransient {
+		if err := s.renewOwn(key, doc.Lifetime); err != nil {
+			return 0, err
+		}
+	}
+
+	// A reference to pointer is filled with a newly allocated value
+	ref = data.IndirectRef(ref)
+	fallback, err := s.decode(&doc, ref)
+	if err != nil {
+		return 0, err
+	}
+	if fallback && s.lazyRewrite {
+		// The value is still valid whether it could not be rewritten
+		s.rewrite(&doc, ref)
+	}
+
+	return uint64(doc.Version), data.ApplyValueFunc(s.onLoad, key, ref)
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero. Unlike Increment, a missing key is not created.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// data.InvalidTypeError when the value stored at key is not integer.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) GetAndReset(key string) (int, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
+			return 0, err
+		}
+	}
+
+	query := bson.M{
+		"$set": bson.M{intFieldName: 0, updatedFieldName: time.Now()},
+		"$inc": bson.M{versionFieldName: 1},
+	}
+	if !s.isTransient {
+		s.renew(query)
+	}
+
+	change := mgo.Change{
+		Update:    query,
+		ReturnNew: false,
+	}
+
+	doc := Data{}
+	_, err := s.col.Find(bson.M{
+		keyFieldName: key,
+		intFieldName: bson.M{"$exists": true},
+	}).Apply(change, &doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, s.notInteger(key)
+		}
+		return 0, err
+	}
+	if !s.isTransient {
+		if err := s.renewOwn(key, doc.Lifetime); err != nil {
+			return 0, err
+		}
+	}
+
+	return *doc.IntVal, nil
+}
+
+// GetOrAdd gets the value stored by specified key into the value pointed to by
+// ref, or stores the value computed by factory whether the key is missing.
+// Concurrent callers of current store missing the same key run factory once,
+// like data.Loader does, then every caller reads the value it stored. Other
+// stores sharing the collection may run factory as well, but the value is
+// added only whether the key is still missing, so every caller reads the value
+// added first.
+//
+// An error returned by factory is returned without storing anything.
+func (s *Store) GetOrAdd(
+	key string, ref interface{}, factory data.FactoryFunc,
+) error {
+	err := s.Get(key, ref)
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		return err
+	}
+
+	_, err, _ = s.loads.Do(key, func() (interface{}, error) {
+		// A previous call may have added the value since it was missed
+		if ok, err := s.Exists(key); ok || err != nil {
+			return nil, err
+		}
+
+		value, err := data.CallFactoryFunc(factory)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.Add(key, value)
+		if _, ok := err.(dot.DuplicatedKeyError); ok {
+			// The value added meanwhile by another store takes precedence
+			return nil, nil
+		}
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Get(key, ref)
+}
+
+// GetMany gets the values stored by specified keys using a single query, each
+// decoded like GetValue. Missing keys are absent from returned values.
+//
+// A value which could not be decoded, like a value whose type differs from the
+// type registered for its key, is skipped without failing other keys; its error
+// is reported by a data.BatchError returned along with the decoded values.
+//
+// Errors
+//
+// data.BatchError when some values could not be decoded.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) GetMany(keys []string) (map[string]interface{}, error) {
+	if s.isClosed() {
+		return nil, data.ErrStoreClosed
+	}
+
+	selector := bson.M{keyFieldName: bson.M{"$in": keys}}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	if !s.isTransient {
+		query := bson.M{}
+		s.renew(query)
+		if _, err := s.col.UpdateAll(selector, query); err != nil {
+			return nil, err
+		}
+		if err := s.renewOwnAll(selector); err != nil {
+			return nil, err
+		}
+	}
+
+	var docs []Data
+	if err := s.col.Find(selector).All(&docs); err != nil {
+		return nil, err
+	}
+
+	errs := make(data.BatchError)
+	values := make(map[string]interface{}, len(docs))
+	for i := range docs {
+		doc := &docs[i]
+		typ, ok := s.types.TypeOf(doc.Key)
+		if !ok {
+			typ = reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		ref := reflect.New(typ).Interface()
+
+		fallback, err := s.decode(doc, ref)
+		if err != nil {
+			errs[doc.Key] = err
+			continue
+		}
+		if fallback && s.lazyRewrite {
+			s.rewrite(doc, ref)
+		}
+		if err := data.ApplyValueFunc(s.onLoad, doc.Key, ref); err != nil {
+			errs[doc.Key] = err
+			continue
+		}
+		values[doc.Key] = reflect.ValueOf(ref).Elem().Interface()
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// GetValue gets the value stored by specified key decoded into a new value of
+// the type registered by RegisterType for the key. When no registered prefix
+// matches the key the value is decoded into an empty interface.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) GetValue(key string) (interface{}, error) {
+	return s.types.GetValue(key, s.Get)
+}
+
+// GetWithVersion gets the value stored by specified key along with its
+// version, which can be passed to SetWithVersion to write the value only
+// whether it was not written meanwhile. Every write to a value increments its
+// version; a value written before versioning has version zero.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) GetWithVersion(key string, ref interface{}) (uint64, error) {
+	return s.get(key, ref)
+}
+
+// GetWithXFetch gets the value stored by specified key, like Get, and reports
+// whether caller should recompute it before it expires, according to
+// data.ShouldRecompute using the remaining lifetime of the value prior to
+// being read and its own lifetime, whether it was defined, or the lifetime of
+// store.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) GetWithXFetch(key string, ref interface{}, beta float64) (bool, error) {
+	if s.isClosed() {
+		return false, data.ErrStoreClosed
+	}
+
+	doc := Data{}
+	err := s.col.FindId(key).Select(bson.M{
+		timeFieldName:     1,
+		expireAtFieldName: 1,
+		lifetimeFieldName: 1,
+	}).One(&doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return false, dot.InvalidKeyError(key)
+		}
+		return false, err
+	}
+	ttl := s.expiresAt(&doc).Sub(time.Now())
+	lifetime := s.lifetime
+	if doc.Lifetime > 0 {
+		lifetime = doc.Lifetime
+	}
+
+	if err := s.Get(key, ref); err != nil {
+		return false, err
+	}
+
+	return data.ShouldRecompute(ttl, lifetime, beta), nil
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not integer.
+func (s *Store) Increment(key string) (int, error) {
+	return s.atomicInteger(key, 1)
+}
+
+// IncrementField atomically increments by delta the numeric field of the struct
+// value stored by specified key, returning the resulting field value. The
+// field is identified by its serialized name.
+//
+// Since the value is stored serialized, it is updated only whether it was not
+// changed since it was read; otherwise the increment is retried.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// data.InvalidFieldError when requested field could not be found.
+//
+// data.InvalidTypeError when stored value is not a struct or the field is not
+// numeric.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) IncrementField(key, field string, delta int64) (int64, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
+			return 0, err
+		}
+	}
+
+	for {
+		doc := Data{}
+		if err := s.col.FindId(key).One(&doc); err != nil {
+			if err == mgo.ErrNotFound {
+				return 0, dot.InvalidKeyError(key)
+			}
+			return 0, err
+		}
+		if doc.Value == nil {
+			return 0, data.NewInvalidTypeError(doc.IntVal)
+		}
+
+		var m map[string]interface{}
+		if _, err := s.unmarshal(key, doc.Value, &m); err != nil {
+			return 0, data.NewInvalidTypeError(m)
+		}
+
+		value, err := data.IncrementMapField(m, key, field, delta)
+		if err != nil {
+			return 0, err
+		}
+
+		b, err := s.marshal(key, m)
+		if err != nil {
+			return 0, err
+		}
+
+		query := bson.M{
+			"$set": bson.M{valueFieldName: b, updatedFieldName: time.Now()},
+			"$inc": bson.M{versionFieldName: 1},
+		}
+		if !s.isTransient {
+			s.renew(query)
+		}
+
+		selector := bson.M{keyFieldName: key}
+		selectValue(selector, doc.Value)
+		err = s.col.Update(selector, query)
+		if err == mgo.ErrNotFound {
+			// Value changed or removed meanwhile
+			continue
+		}
+		if err == nil && !s.isTransient {
+			err = s.renewOwn(key, doc.Lifetime)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return value, nil
+	}
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created.
+//
+// When accuracy is ensured an expired value is handled as a missing key, so
+// it is replaced by value. Otherwise, an expired value not yet removed by
+// MongoDB is incremented.
+//
+// Errors:
+// InvalidTypeError when the value stored at key is not integer, which is kept
+// unchanged.
+func (s *Store) IncrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, value)
+}
+
+// Keys gets the sorted keys of stored values. Expired values not removed by
+// MongoDB yet are excluded only when accuracy is ensured.
+//
+// Errors
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Keys() ([]string, error) {
+	if s.isClosed() {
+		return nil, data.ErrStoreClosed
+	}
+
+	selector := bson.M{}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	var docs []Data
+	err := s.col.Find(selector).Select(bson.M{keyFieldName: 1}).
+		Sort(keyFieldName).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(docs))
+	for i, d := range docs {
+		keys[i] = d.Key
+	}
+	return keys, nil
+}
+
+// Meta gets the metadata of the value stored by specified key. MongoDB does not
+// track reads, hence Hits is always zero; values stored before metadata
+// support have zero creation and update times. Tracking metadata costs 34
+// bytes per document.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Meta(key string) (data.EntryMeta, error) {
+	if s.isClosed() {
+		return data.EntryMeta{}, data.ErrStoreClosed
+	}
+
+	doc := Data{}
+	err := s.col.FindId(key).Select(bson.M{
+		timeFieldName:     1,
+		expireAtFieldName: 1,
+		createdFieldName:  1,
+		updatedFieldName:  1,
+	}).One(&doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return data.EntryMeta{}, dot.InvalidKeyError(key)
+		}
+		return data.EntryMeta{}, err
+	}
+	if s.ensureAccuracy && s.isExpired(&doc) {
+		return data.EntryMeta{}, dot.InvalidKeyError(key)
+	}
+
+	return data.EntryMeta{
+		CreatedAt: doc.Created,
+		UpdatedAt: doc.Updated,
+		ExpiresAt: s.expiresAt(&doc),
+	}, nil
+}
+
+// Range calls fn for each stored value ordered by key, until fn returns false.
+// Each value is decoded like GetValue, without being renewed. The documents
+// are read by a cursor, so memory stays bounded regardless of the size of the
+// collection, and fn may call back into current store; values changed
+// meanwhile may or may not be seen.
+//
+// Errors
+//
+// data.InvalidTypeError when a value could not be decoded, which stops
+// iteration.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Range(fn func(key string, value interface{}) bool) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	selector := bson.M{}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	iter := s.col.Find(selector).Sort(keyFieldName).Iter()
+	for {
+		doc := Data{}
+		if !iter.Next(&doc) {
+			break
+		}
+
+		typ, ok := s.types.TypeOf(doc.Key)
+		if !ok {
+			typ = reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		ref := reflect.New(typ).Interface()
+		if _, err := s.decode(&doc, ref); err != nil {
+			iter.Close()
+			return err
+		}
+		if err := data.ApplyValueFunc(s.onLoad, doc.Key, ref); err != nil {
+			iter.Close()
+			return err
+		}
+
+		if !fn(doc.Key, reflect.ValueOf(ref).Elem().Interface()) {
+			break
+		}
+	}
+
+	return iter.Close()
+}
+
+// RegisterType sets the type of proto as the type which GetValue decodes values
+// into, for every key starting with prefix. When multiple prefixes match a key
+// the longest one takes precedence. A nil proto removes the prefix.
+func (s *Store) RegisterType(prefix string, proto interface{}) {
+	s.types.Register(prefix, proto)
+}
+
+// ResetStats sets every counter reported by Stats to zero.
+func (s *Store) ResetStats() {
+	s.stats.Reset()
+}
+
+// Set sets the value of specified key.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key already exists.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Set(key string, value interface{}) error {
+	return s.SetContext(context.Background(), key, value)
+}
+
+// SetContext sets the value of specified key, like Set, unless ctx is done
+// before the value is written.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// ctx.Err() when ctx is done before the value is written.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) SetContext(
+	ctx context.Context, key string, value interface{},
+) error {
+	return s.withContext(ctx, func(cs *Store) error {
+		return cs.set(key, value)
+	})
+}
+
+// set sets the value of specified key.
+func (s *Store) set(key string, value interface{}) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	value, err := s.storeValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	doc := Data{Key: key}
+	if err := s.encode(&doc, value); err != nil {
+		return err
+	}
+
+	query := updateOf(&doc)
+	if !s.isTransient {
+		s.renew(query)
+	}
+
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
+			return err
+		}
+	}
+
+	change := mgo.Change{Update: query}
+	current := Data{}
+	_, err = s.col.FindId(key).Select(bson.M{
+		lifetimeFieldName: 1,
+	}).Apply(change, &current)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return dot.InvalidKeyError(key)
+		}
+		return err
+	}
+	if !s.isTransient {
+		return s.renewOwn(key, current.Lifetime)
+	}
+
+	return nil
+}
+
+// ReapExpired removes every expired value which was not removed by MongoDB
+// yet, returning the number of removed values. It can be called on a schedule
+// to keep the collection accurate between MongoDB sweeps, since MongoDB
+// removes expired documents at intervals of 60 seconds.
+//
+// Errors:
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) ReapExpired() (int, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	selector := bson.M{}
+	s.selectExpired(selector, time.Now())
+	info, err := s.col.RemoveAll(selector)
+	if err != nil {
+		return 0, err
+	}
+
+	s.stats.Evicted(info.Removed)
+	return info.Removed, nil
+}
+
+// replaceExpired replaces the stored document having same key of doc, whether
+// it is expired. Otherwise, it returns DuplicatedKeyError.
+func (s *Store) replaceExpired(doc *Data) error {
+	selector := bson.M{keyFieldName: doc.Key}
+	s.selectExpired(selector, time.Now())
+
+	// When the document is removed meanwhile it is inserted; when it is
+	// renewed the insertion fails by duplicated key.
+	info, err := s.col.Upsert(selector, doc)
+	if err != nil {
+		if mgo.IsDup(err) {
+			return dot.DuplicatedKeyError(doc.Key)
+		}
+		return err
+	}
+
+	if info.Updated > 0 {
+		s.stats.Evicted(1)
+	}
+	s.stats.Added(1)
+	return nil
+}
+
+// SetMany sets the values of specified keys using a single bulk operation.
+// Unlike Set, a missing key is created.
+//
+// A value which could not be stored, like a value rejected by the validator,
+// is skipped without failing other keys; its error is reported by a
+// data.BatchError.
+//
+// Errors
+//
+// data.BatchError when some values could not be stored.
+//
+// mgo.BulkError when a error from MongoDB is triggered.
+func (s *Store) SetMany(values map[string]interface{}) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	errs := make(data.BatchError)
+	keys := make([]string, 0, len(values))
+	bulk := s.col.Bulk()
+	bulk.Unordered()
+	now := time.Now()
+	for key, value := range values {
+		value, err := s.storeValue(key, value)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+
+		doc := Data{Key: key}
+		if err := s.encode(&doc, value); err != nil {
+			errs[key] = err
+			continue
+		}
+
+		query := updateOf(&doc)
+		onInsert := bson.M{createdFieldName: now}
+		if s.isTransient {
+			s.setExpiration(onInsert, now, 0)
+		} else {
+			s.renew(query)
+		}
+		query["$setOnInsert"] = onInsert
+		bulk.Upsert(bson.M{keyFieldName: key}, query)
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 0 {
+		if s.ensureAccuracy && s.isTransient {
+			// Expired documents not yet removed by MongoDB would keep their
+			// time, so they are removed to be created again
+			selector := bson.M{keyFieldName: bson.M{"$in": keys}}
+			s.selectExpired(selector, now)
+			_, err := s.col.RemoveAll(selector)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := bulk.Run(); err != nil {
+			return err
+		}
+		if !s.isTransient {
+			selector := bson.M{keyFieldName: bson.M{"$in": keys}}
+			if err := s.renewOwnAll(selector); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetValidator defines a function to check every value written by Add or Set
+// before it is stored. When it returns an error, the value is not stored and
+// that error is returned to the caller. A nil function disables validation.
+//
+// The validator receives the value provided by caller, before any value
+// middleware is applied. A panic raised by it is recovered and returned as
+// CallbackPanicError.
+func (s *Store) SetValidator(fn data.ValidatorFunc) {
+	s.validator = fn
+}
+
+// SetValueMiddleware defines functions to transform every value written by Add
+// or Set (onStore) and read by Get (onLoad). Any of them can be nil.
+//
+// The middleware handles Go values, hence onStore runs before the value is
+// encoded and onLoad runs after the value is decoded into the reference
+// provided to Get. The result of onLoad must be assignable to that reference.
+// Integer values handled by atomic operations are not transformed.
+//
+// A panic raised by the functions is recovered and returned as
+// CallbackPanicError.
+func (s *Store) SetValueMiddleware(onStore, onLoad data.ValueFunc) {
+	s.onStore = onStore
+	s.onLoad = onLoad
+}
+
+// SetCipherFunc defines a function which returns the cipher used to encrypt
+// the value of each key, which is called on every write and read. It allows
+// selecting the encryption key by the namespace of a key, so values of
+// distinct tenants are encrypted by distinct keys. When fn is nil, or returns
+// nil for a key, the value of that key is not encrypted.
+//
+// Integers are never encrypted, since MongoDB must read them to increment
+// atomically; every other value, including strings, is encoded by codec
+// before being encrypted. Values stored before the cipher was defined cannot
+// be read anymore.
+//
+// To rotate the key of a namespace, return a data.RotatedCipher which encrypts
+// by the new key and decrypts by the previous keys too, until every value
+// encrypted by a previous key was written again or expired.
+func (s *Store) SetCipherFunc(fn func(key string) data.Cipher) {
+	s.cipherFunc = fn
+}
+
+// SetCodec defines the codec used to serialize values that are not integers or
+// strings, which is msgpack by default. Values serialized by a previous codec
+// cannot be read, unless it is defined as fallback by SetCodecWithFallback.
+func (s *Store) SetCodec(c data.Codec) {
+	s.SetCodecWithFallback(c)
+}
+
+// SetCodecWithFallback defines the codec used to serialize values that are not
+// integers or strings, along with fallback codecs to decode values that were
+// serialized by a previous codec. When primary codec fails to decode a value,
+// each fallback codec is tried in order before failing, which allows migrating
+// a populated collection to another codec without downtime.
+//
+// Every value that cannot be decoded by primary codec costs a decoding attempt
+// for each fallback codec tried, hence fallbacks should be removed once the
+// migration is completed. See SetLazyRewrite to migrate values as they are
+// read.
+func (s *Store) SetCodecWithFallback(primary data.Codec, fallbacks ...data.Codec) {
+	s.codec = primary
+	s.fallbacks = fallbacks
+}
+
+// SetCompression defines the size, in bytes, above which the encodings of
+// values are compressed by gzip, wrapping current codec by codec.Gzip. Zero or
+// negative disables compression, unwrapping current codec.
+//
+// The codec previously defined is kept as fallback codec, so values written
+// before compression was enabled or disabled are still read. Integers and
+// strings not encrypted are never compressed, since they are not encoded by
+// codec.
+func (s *Store) SetCompression(threshold int) {
+	base := s.codec
+	if g, ok := base.(codec.Gzip); ok {
+		base = g.Codec
+		if base == nil {
+			base = codec.Msgpack{}
+		}
+	}
+
+	primary, fallback := data.Codec(codec.Gzip{
+		Codec:     base,
+		Threshold: threshold,
+	}), base
+	if threshold < 1 {
+		primary, fallback = base, codec.Gzip{Codec: base}
+	}
+
+	// Fallbacks are kept once, so toggling compression does not grow them
+	fallbacks := []data.Codec{fallback}
+	for _, c := range s.fallbacks {
+		if c.Name() != primary.Name() && c.Name() != fallback.Name() {
+			fallbacks = append(fallbacks, c)
+		}
+	}
+	s.SetCodecWithFallback(primary, fallbacks...)
+}
+
+// SetLazyRewrite defines whether a value decoded by a fallback codec is
+// rewritten by primary codec when it is read by Get, so later reads do not try
+// fallback codecs. Each rewrite costs an additional write to MongoDB, and a
+// value changed meanwhile is not rewritten.
+func (s *Store) SetLazyRewrite(value bool) {
+	s.lazyRewrite = value
+}
+
+// SetLifetime modifies the lifetime for new and existing stored items.
+//
+// When documents expire by their expireAt field, see NewWithExpireAt,
+// ScopeAll computes the expiration time of every document again, except the
+// documents having their own lifetime, and ScopeNewAndUpdated applies the
+// lifetime to existing items when they are renewed.
+//
+// Errors
+//
+// dot.NotSupportedError when ScopeNew is specified, or ScopeNewAndUpdated
+// unless documents expire by their expireAt field.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	switch scope {
+	case data.ScopeAll:
+		if s.useExpireAt {
+			s.lifetime = d
+			return s.updateExpireAt(bson.M{
+				lifetimeFieldName: bson.M{"$exists": false},
+			})
+		}
+		s.col.DropIndexName(indexName)
+		createIndex(s.col, expireIndex(d, s.indexOpts))
+	case data.ScopeNewAndUpdated:
+		if s.useExpireAt {
+			break
+		}
+		return dot.NotSupportedError("ScopeNewAndUpdated")
+	case data.ScopeNew:
+		return dot.NotSupportedError("ScopeNew")
+	default:
+		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	}
+
+	s.lifetime = d
+	return nil
 }
 
-// New creates a new instance of MongoStore and defines the lifetime whether it
-// is not already defined. The stored items lifetime are renewed when it is read
-// or written.
-func New(db *mgo.Database, name string, d time.Duration) *Store {
-	col := db.C(name)
-	index := mgo.Index{
-		Key:         []string{timeFieldName},
-		Unique:      false,
-		Background:  true,
-		ExpireAfter: d,
-		Name:        indexName,
+// SetLifetimeFor defines the lifetime of the value stored by specified key,
+// which is renewed from now on, like AddWithLifetime does. A lifetime lower
+// than one restores the lifetime of store for the value.
+//
+// Errors
+//
+// dot.InvalidKeyError when requested key could not be found.
+//
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) SetLifetimeFor(key string, d time.Duration) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
 	}
-	err := col.EnsureIndex(index)
-	if err != nil {
-		return nil
+
+	now := time.Now()
+	selector := bson.M{keyFieldName: key}
+	if s.ensureAccuracy {
+		s.selectLive(selector, now)
 	}
 
-	return &Store{
-		col,
-		d,
-		false,
-		false,
+	set := bson.M{}
+	s.setExpiration(set, now, d)
+	query := bson.M{"$set": set}
+	if d > 0 {
+		set[lifetimeFieldName] = d
+	} else {
+		query["$unset"] = bson.M{lifetimeFieldName: ""}
+	}
+	err := s.col.Update(selector, query)
+	if err == mgo.ErrNotFound {
+		return dot.InvalidKeyError(key)
 	}
+	return err
 }
 
-// Add adds a new key:value to current store.
+// SetTransient defines whether should extends expiration of stored value
+// when it is read or written.
+func (s *Store) SetTransient(value bool) {
+	s.isTransient = value
+}
+
+// SetWithVersion sets the value of specified key whether its version equals
+// expectedVersion, as returned by GetWithVersion, returning the new version.
+// The version is compared and incremented by a single atomic update.
 //
 // Errors
 //
-// dot.DuplicatedKeyError when requested key already exists.
+// dot.InvalidKeyError when requested key could not be found.
+//
+// data.VersionConflictError when the version of stored value differs.
 //
 // mgo.LastError when a error from MongoDB is triggered.
-func (s *Store) Add(key string, value interface{}) error {
-	doc := entry{
-		time.Now(),
-		key,
-		nil,
-		nil,
+func (s *Store) SetWithVersion(
+	key string, value interface{}, expectedVersion uint64,
+) (uint64, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
 	}
 
-	switch t := value.(type) {
-	case int:
-		doc.IntVal = &t
-	case *int:
-		doc.IntVal = t
-	case string:
-		doc.Value = &t
-	case *string:
-		doc.Value = t
-	default:
-		b, err := msgpack.Marshal(value)
-		if err != nil {
-			return err
-		}
-		strValue := string(b)
-		doc.Value = &strValue
+	value, err := s.storeValue(key, value)
+	if err != nil {
+		return 0, err
 	}
 
-	if err := s.col.Insert(&doc); err != nil {
-		mgoerr := err.(*mgo.LastError)
-		if mgoerr.Code == MongoDupKeyErrorCode {
-			return dot.DuplicatedKeyError(key)
-		}
-
-		return err
+	doc := Data{Key: key}
+	if err := s.encode(&doc, value); err != nil {
+		return 0, err
 	}
 
-	return nil
-}
+	query := updateOf(&doc)
+	if !s.isTransient {
+		s.renew(query)
+	}
 
-func (s *Store) atomicInteger(key string, inc int) (int, error) {
-	query := bson.M{"$inc": bson.M{"ival": inc}}
-	if s.isTransient {
-		query["$setOnInsert"] = bson.M{"at": time.Now()}
+	selector := bson.M{keyFieldName: key}
+	if expectedVersion == 0 {
+		selector[versionFieldName] = bson.M{"$exists": false}
 	} else {
-		query["$currentDate"] = bson.M{"at": true}
+		selector[versionFieldName] = int64(expectedVersion)
+	}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
 	}
 
 	change := mgo.Change{
 		Update:    query,
-		Upsert:    true,
 		ReturnNew: true,
 	}
 
-	// db.runCommand({
-	// 	findAndModify: "col",
-	// 	query: { _id: %key },
-	// 	update: {
-	// 		$inc: { val: %inc },
-	// 		$setOnInsert: { at: new ISODate() }
-	// 	},
-	// 	new: true,
-	// 	upsert: true
-	// })
-	doc := entry{}
-	_, err := s.col.FindId(key).Apply(change, &doc)
+	_, err = s.col.Find(selector).Apply(change, &doc)
+	if err == nil {
+		if !s.isTransient {
+			err = s.renewOwn(key, doc.Lifetime)
+		}
+		return uint64(doc.Version), err
+	}
+	if err != mgo.ErrNotFound {
+		return 0, err
+	}
+
+	// The key is either missing or holding another version
+	current := Data{}
+	err = s.col.FindId(key).Select(bson.M{
+		timeFieldName:     1,
+		expireAtFieldName: 1,
+		versionFieldName:  1,
+	}).One(&current)
+	if err == mgo.ErrNotFound ||
+		(err == nil && s.ensureAccuracy && s.isExpired(&current)) {
+		return 0, dot.InvalidKeyError(key)
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	return *doc.IntVal, nil
+	return 0, data.NewVersionConflictError(
+		key, expectedVersion, uint64(current.Version))
 }
 
-// Count gets the number of stored values by current instance.
+// Stats gets the hits, misses, additions and evictions counted by current
+// instance since it was created or ResetStats was called, along with the number
+// of stored values. A hit is a Get call which found the requested key, whereas
+// a miss is a Get call which returned InvalidKeyError.
 //
-// Errors:
-// mgo.LastError when a error from MongoDB is triggered.
-func (s *Store) Count() (int, error) {
-	return s.col.Count()
-}
-
-// Decrement atomically gets the value stored by specified key and
-// decrements it by one. If the key does not exist, it is created.
+// Evictions only count the expired values removed by current instance, like by
+// ReapExpired, since the values removed by MongoDB are not reported.
 //
 // Errors:
-// InvalidTypeError when the value stored at key is not integer.
-func (s *Store) Decrement(key string) (int, error) {
-	return s.atomicInteger(key, -1)
-}
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) Stats() (data.Stats, error) {
+	size, err := s.Count()
+	if err != nil {
+		return data.Stats{}, err
+	}
 
-// DecrementBy atomically gets the value stored by specified key and
-// decrements it by value. If the key does not exist, it is created.
-//
-// Errors:
-// InvalidTypeError when the value stored at key is not integer.
-func (s *Store) DecrementBy(key string, value int) (int, error) {
-	return s.atomicInteger(key, -1*value)
+	return s.stats.Stats(size), nil
 }
 
-// Delete deletes the specified value.
+// TTL gets the remaining lifetime of the value stored by specified key, which
+// is zero or negative when it is expired but not removed by MongoDB yet.
 //
 // Errors
 //
-// dot.InvalidKeyError when requested key already exists.
+// dot.InvalidKeyError when requested key could not be found.
 //
 // mgo.LastError when a error from MongoDB is triggered.
-func (s *Store) Delete(key string) error {
-	if s.ensureAccuracy {
-		if err := s.testExpiration(key); err != nil {
-			return err
-		}
+func (s *Store) TTL(key string) (time.Duration, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
 	}
 
-	err := s.col.RemoveId(key)
-	if err == mgo.ErrNotFound {
-		return dot.InvalidKeyError(key)
+	doc := Data{}
+	err := s.col.FindId(key).
+		Select(bson.M{timeFieldName: 1, expireAtFieldName: 1}).One(&doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, dot.InvalidKeyError(key)
+		}
+		return 0, err
 	}
 
-	return err
-}
-
-// EnsureAccuracy enables a double-check for expired values (slower). Because
-// MongoDB does not garantee that expired data will be deleted immediately upon
-// expiration.
-func (s *Store) EnsureAccuracy(value bool) {
-	s.ensureAccuracy = value
-}
-
-// Flush deletes any cached value into current instance.
-//
-// Errors:
-// mgo.LastError when a error from MongoDB is triggered.
-func (s *Store) Flush() error {
-	_, err := s.col.RemoveAll(bson.M{})
-	return err
+	return s.expiresAt(&doc).Sub(time.Now()), nil
 }
 
-// Get gets the value stored by specified key and stores the result in the
-// value pointed to by ref.
+// Touch renews the lifetime of the value stored by specified key without
+// reading it, even when current store is transient.
 //
 // Errors
 //
-// dot.InvalidKeyError when requested key already exists.
+// dot.InvalidKeyError when requested key could not be found.
 //
 // mgo.LastError when a error from MongoDB is triggered.
-func (s *Store) Get(key string, ref interface{}) error {
-	if s.ensureAccuracy {
-		if err := s.testExpiration(key); err != nil {
-			return err
-		}
+func (s *Store) Touch(key string) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
 	}
 
-	if !s.isTransient {
-		query := bson.M{"$currentDate": bson.M{"at": true}}
-		if err := s.col.UpdateId(key, query); err != nil {
-			if err == mgo.ErrNotFound {
-				return dot.InvalidKeyError(key)
-			}
+	if s.ensureAccuracy {
+		if err := s.testExpiration(key); err != nil {
 			return err
 		}
 	}
 
-	doc := entry{}
-	err := s.col.FindId(key).One(&doc)
+	query := bson.M{}
+	s.renew(query)
+	change := mgo.Change{Update: query}
+	doc := Data{}
+	_, err := s.col.FindId(key).Select(bson.M{
+		lifetimeFieldName: 1,
+	}).Apply(change, &doc)
 	if err != nil {
 		if err == mgo.ErrNotFound {
 			return dot.InvalidKeyError(key)
@@ -249,138 +2367,172 @@ func (s *Store) Get(key string, ref interface{}) error {
 		return err
 	}
 
-	switch t := ref.(type) {
-	case *int:
-		if doc.IntVal == nil {
-			return data.NewInvalidTypeError(ref)
-		}
-		*t = *doc.IntVal
-	case *string:
-		if doc.Value == nil {
-			return data.NewInvalidTypeError(ref)
-		}
-		*t = *doc.Value
-	default:
-		if doc.Value == nil {
-			return data.NewInvalidTypeError(ref)
-		}
-		err = msgpack.Unmarshal([]byte(*doc.Value), ref)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return s.renewOwn(key, doc.Lifetime)
 }
 
-// Increment atomically gets the value stored by specified key and
-// increments it by one. If the key does not exist, it is created.
+// TouchMany renews the lifetime of every existing key from specified keys and
+// returns how many keys were found. Missing keys are skipped.
 //
 // Errors:
-// InvalidTypeError when the value stored at key is not integer.
-func (s *Store) Increment(key string) (int, error) {
-	return s.atomicInteger(key, 1)
+// mgo.LastError when a error from MongoDB is triggered.
+func (s *Store) TouchMany(keys []string) (int, error) {
+	if s.isClosed() {
+		return 0, data.ErrStoreClosed
+	}
+
+	selector := bson.M{keyFieldName: bson.M{"$in": keys}}
+	if s.ensureAccuracy {
+		s.selectLive(selector, time.Now())
+	}
+
+	query := bson.M{}
+	s.renew(query)
+	info, err := s.col.UpdateAll(selector, query)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.renewOwnAll(selector); err != nil {
+		return 0, err
+	}
+
+	return info.Matched, nil
 }
 
-// IncrementBy atomically gets the value stored by specified key and
-// increments it by value. If the key does not exist, it is created.
-//
-// Errors:
-// InvalidTypeError when the value stored at key is not integer.
-func (s *Store) IncrementBy(key string, value int) (int, error) {
-	return s.atomicInteger(key, value)
+// storeValue validates specified value and applies the store middleware to
+// it.
+func (s *Store) storeValue(key string, value interface{}) (interface{}, error) {
+	if err := data.CallValidatorFunc(s.validator, key, value); err != nil {
+		return nil, err
+	}
+	if s.onStore == nil {
+		return value, nil
+	}
+	return data.CallValueFunc(s.onStore, key, value)
 }
 
-// Set sets the value of specified key.
+// Update atomically applies fn to the value stored by specified key, storing
+// its result as the new value. A missing key passes nil to fn and its result
+// is added as a new value; fn can return an error, like dot.InvalidKeyError,
+// to refuse it. When fn returns an error nothing is stored and that error is
+// returned.
 //
-// Errors
+// The current value is decoded into an empty interface, as data.GenericValue
+// defines, after the load middleware is applied. The update is optimistic: the
+// new value is stored only whether the value was not changed since it was
+// read; otherwise fn is called again with the changed value. A panic raised by
+// fn is recovered and returned as CallbackPanicError.
 //
-// dot.InvalidKeyError when requested key already exists.
+// Errors
 //
 // mgo.LastError when a error from MongoDB is triggered.
-func (s *Store) Set(key string, value interface{}) error {
-	qSet := bson.M{}
-	unset := bson.M{}
-	switch t := value.(type) {
-	case int:
-		qSet["ival"] = t
-		unset["val"] = ""
-	case *int:
-		qSet["ival"] = *t
-		unset["val"] = ""
-	case string:
-		qSet["val"] = t
-		unset["ival"] = ""
-	case *string:
-		qSet["val"] = *t
-		unset["ival"] = ""
-	default:
-		b, err := msgpack.Marshal(value)
-		if err != nil {
+func (s *Store) Update(key string, fn data.UpdateFunc) error {
+	if s.isClosed() {
+		return data.ErrStoreClosed
+	}
+
+	for {
+		doc := Data{}
+		exists := true
+		if err := s.col.FindId(key).One(&doc); err == mgo.ErrNotFound {
+			exists = false
+		} else if err != nil {
 			return err
 		}
-		qSet["val"] = string(b)
-		unset["ival"] = ""
-	}
+		expired := exists && s.ensureAccuracy && s.isExpired(&doc)
 
-	query := bson.M{"$set": qSet, "$unset": unset}
-	if !s.isTransient {
-		query["$currentDate"] = bson.M{"at": true}
-	}
+		var current interface{}
+		if exists && !expired {
+			if _, err := s.decode(&doc, &current); err != nil {
+				return err
+			}
+			err := data.ApplyValueFunc(s.onLoad, key, &current)
+			if err != nil {
+				return err
+			}
+		}
 
-	if s.ensureAccuracy {
-		if err := s.testExpiration(key); err != nil {
+		value, err := data.CallUpdateFunc(fn, current)
+		if err != nil {
+			return err
+		}
+		if value, err = s.storeValue(key, value); err != nil {
 			return err
 		}
-	}
 
-	if err := s.col.UpdateId(key, query); err != nil {
-		if err == mgo.ErrNotFound {
-			return dot.InvalidKeyError(key)
+		now := time.Now()
+		newDoc := Data{
+			Key:     key,
+			Created: now,
+			Updated: now,
+			Version: 1,
+		}
+		s.expire(&newDoc, now, 0)
+		if err := s.encode(&newDoc, value); err != nil {
+			return err
 		}
-		return err
-	}
 
-	return nil
-}
+		if !exists {
+			err := s.col.Insert(&newDoc)
+			if mgo.IsDup(err) {
+				// Value added meanwhile
+				continue
+			}
+			return err
+		}
 
-// SetLifetime modifies the lifetime for new and existing stored items.
-//
-// Errors:
-// NotSupportedError when ScopeNewAndUpdate or ScopeNew is specified.
-func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
-	switch scope {
-	case data.ScopeAll:
-		s.col.DropIndexName(indexName)
+		// The value is replaced only whether it is unchanged
+		selector := bson.M{
+			keyFieldName:   key,
+			valueFieldName: bson.M{"$exists": false},
+			intFieldName:   bson.M{"$exists": false},
+		}
+		if doc.Value != nil {
+			selectValue(selector, doc.Value)
+		}
+		if doc.IntVal != nil {
+			selector[intFieldName] = *doc.IntVal
+		}
 
-		index := mgo.Index{
-			Key:         []string{timeFieldName},
-			Unique:      false,
-			Background:  true,
-			ExpireAfter: d,
-			Name:        indexName,
+		query := updateOf(&newDoc)
+		if expired {
+			// The replaced value does not keep the lifetime of expired one
+			query["$unset"].(bson.M)[lifetimeFieldName] = ""
+		}
+		if !s.isTransient || expired {
+			s.renew(query)
 		}
-		s.col.EnsureIndex(index)
-	case data.ScopeNewAndUpdated:
-		return dot.NotSupportedError("ScopeNewAndUpdated")
-	case data.ScopeNew:
-		return dot.NotSupportedError("ScopeNew")
-	default:
-		return dot.NotSupportedError(strconv.Itoa(int(scope)))
-	}
 
-	s.lifetime = d
-	return nil
+		err = s.col.Update(selector, query)
+		if err == mgo.ErrNotFound {
+			// Value changed or removed meanwhile
+			continue
+		}
+		if err == nil && !s.isTransient && !expired {
+			err = s.renewOwn(key, doc.Lifetime)
+		}
+		return err
+	}
 }
 
-// SetTransient defines whether should extends expiration of stored value
-// when it is read or written.
-func (s *Store) SetTransient(value bool) {
-	s.isTransient = value
+// notInteger returns the error for specified key whose integer value was not
+// found, which is data.InvalidTypeError whether the key has a value other than
+// integer; otherwise, dot.InvalidKeyError.
+func (s *Store) notInteger(key string) error {
+	count, err := s.col.Find(bson.M{
+		keyFieldName: key,
+		intFieldName: bson.M{"$exists": false},
+	}).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return data.NewInvalidTypeError(new(int))
+	}
+	return dot.InvalidKeyError(key)
 }
 
 func (s *Store) testExpiration(key string) error {
-	doc := entry{}
+	doc := Data{}
 
 	err := s.col.FindId(key).One(&doc)
 	if err != nil {
@@ -389,11 +2541,12 @@ func (s *Store) testExpiration(key string) error {
 		}
 		return err
 	}
-	if doc.IsExpired(s.lifetime) {
+	if s.isExpired(&doc) {
 		return dot.InvalidKeyError(key)
 	}
 
 	return nil
 }
 
-var _ data.Store = (*Store)(nil)
+var _ data.ResettableStore = (*Store)(nil)
+var _ data.StatsProvider = (*Store)(nil)
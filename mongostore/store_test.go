@@ -17,13 +17,23 @@
 package mongostore
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/raiqub/data/testdata"
 	"github.com/skarllot/raiqub/test"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/codec"
 	"gopkg.in/raiqub/dot.v1"
 )
 
@@ -52,7 +62,10 @@ func TestMongoStore(t *testing.T) {
 	//	}
 	//	defer session.Close()
 
-	store := New(session.DB(""), colName, time.Millisecond)
+	store, err := New(session.DB(""), colName, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
 	store.EnsureAccuracy(true)
 
 	testdata.TestAtomic(store, t)
@@ -75,15 +88,906 @@ func TestMongoStore(t *testing.T) {
 	store.Flush()
 	testdata.TestTransient(store, t)
 
+	store.Flush()
+	testdata.TestTouchMany(store, t)
+
+	store.Flush()
+	testdata.TestXFetch(store, t)
+
+	store.Flush()
+	testdata.TestMeta(store, t)
+
 	store.Flush()
 	testdata.TestTypeError(store, t)
+
+	store.Flush()
+	testdata.TestGenericDecode(store, t)
+
+	store.Flush()
+	testdata.TestValueMiddleware(store, t)
+
+	store.Flush()
+	testdata.TestValidator(store, t)
+
+	store.Flush()
+	testdata.TestIncrementField(store, t)
+
+	store.Flush()
+	testdata.TestRegisterType(store, t)
+
+	store.Flush()
+	testdata.TestUpdate(store, t)
+
+	store.Flush()
+	testdata.TestCompareAndSwap(store, t)
+
+	store.Flush()
+	testdata.TestVersion(store, t)
+
+	store.Flush()
+	testdata.TestDeletePrefix(store, t)
+
+	store.Flush()
+	testdata.TestFlushPrefix(store, t)
+
+	store.Flush()
+	testdata.TestLifetimeFor(store, t)
+
+	store.Flush()
+	testdata.TestKeys(store, t)
+
+	store.Flush()
+	testdata.TestExists(store, t)
+
+	store.Flush()
+	testdata.TestGetOrAdd(store, t)
+
+	store.Flush()
+	testdata.TestTTL(store, t)
+
+	store.Flush()
+	testdata.TestGetSetMany(store, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouch(store, t)
+
+	store.Flush()
+	testdata.TestPointerValue(store, t)
+
+	store.Flush()
+	testdata.TestEntriesByExpiry(store, t)
+
+	store.Flush()
+	testdata.TestIncrementBy(store, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+
+	store.Flush()
+	testdata.TestDecrementAndDeleteAtZero(store, t)
+
+	store.Flush()
+	testdata.TestAddExpired(store, t)
+}
+
+func TestMongoStoreExpireAt(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := NewWithExpireAt(session.DB(""), colName, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.EnsureAccuracy(true)
+
+	testdata.TestExpiration(store, t)
+
+	store.Flush()
+	testdata.TestValueHandling(store, t)
+
+	store.Flush()
+	testdata.TestTouch(store, t)
+
+	store.Flush()
+	testdata.TestEntriesByExpiry(store, t)
+
+	store.Flush()
+	testdata.TestAddExpired(store, t)
+
+	store.Flush()
+	testdata.TestLifetimeFor(store, t)
+}
+
+func TestExpireAtMigration(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	legacy, err := New(session.DB(""), colName, time.Hour)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	legacy.Flush()
+	if err := legacy.Add("v1", 1); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	store, err := NewWithExpireAt(session.DB(""), colName, time.Hour)
+	if err != nil {
+		t.Fatalf("Could not migrate store: %v", err)
+	}
+	if ttl, err := store.TTL("v1"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Unexpected TTL of migrated value: %v: %v", ttl, err)
+	}
+
+	indexes, err := session.DB("").C(colName).Indexes()
+	if err != nil {
+		t.Fatalf("Could not list indexes: %v", err)
+	}
+	for _, i := range indexes {
+		if i.Name == indexName {
+			t.Error("The expiration index of New should be dropped")
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	now := time.Now()
+	legacy := &Store{lifetime: time.Minute}
+	at, expireAt := legacy.expiration(now, time.Hour)
+	if !at.Equal(now.Add(time.Hour-time.Minute)) || !expireAt.IsZero() {
+		t.Errorf("Unexpected expiration: %v, %v", at, expireAt)
+	}
+
+	store := &Store{lifetime: time.Minute, useExpireAt: true}
+	at, expireAt = store.expiration(now, 0)
+	if !at.Equal(now) || !expireAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Unexpected expiration: %v, %v", at, expireAt)
+	}
+	at, expireAt = store.expiration(now, time.Hour)
+	if !at.Equal(now) || !expireAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected expiration by own lifetime: %v, %v", at, expireAt)
+	}
+}
+
+func TestIndexLifetimeMismatch(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	if _, err := New(session.DB(""), colName, time.Hour); err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	if _, err := New(session.DB(""), colName, time.Minute*5); err != nil {
+		t.Fatalf("Could not create store with different lifetime: %v", err)
+	}
+
+	indexes, err := session.DB("").C(colName).Indexes()
+	if err != nil {
+		t.Fatalf("Could not list indexes: %v", err)
+	}
+	for _, i := range indexes {
+		if i.Name == indexName && i.ExpireAfter != time.Minute*5 {
+			t.Errorf("Expected index lifetime of %v but got %v",
+				time.Minute*5, i.ExpireAfter)
+		}
+	}
+}
+
+func TestExpireIndexOptions(t *testing.T) {
+	index := expireIndex(time.Minute, nil)
+	if !index.Background || index.Sparse {
+		t.Errorf("Unexpected default index options: %+v", index)
+	}
+
+	index = expireIndex(time.Minute, []IndexOption{
+		WithIndexOptions(mgo.Index{
+			Key:    []string{"other"},
+			Name:   "other",
+			Sparse: true,
+		}),
+		WithBackgroundIndex(true),
+	})
+	if !index.Background || !index.Sparse {
+		t.Errorf("Index options were not merged: %+v", index)
+	}
+	if index.Name != indexName || index.ExpireAfter != time.Minute ||
+		len(index.Key) != 1 || index.Key[0] != timeFieldName {
+		t.Errorf("Expiration index was modified by options: %+v", index)
+	}
+	if _, ok := index.document()["partialFilterExpression"]; ok {
+		t.Errorf("Unexpected partial filter: %v", index.document())
+	}
+
+	filter := bson.M{"kind": "session"}
+	index = expireIndex(time.Minute, []IndexOption{WithPartialFilter(filter)})
+	doc := index.document()
+	if !reflect.DeepEqual(doc["partialFilterExpression"], filter) {
+		t.Errorf("Expected partial filter %v but got %v", filter, doc)
+	}
+	if doc["expireAfterSeconds"] != 60 || doc["name"] != indexName {
+		t.Errorf("Unexpected index definition: %v", doc)
+	}
+}
+
+func TestSessionOwnership(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	shared, err := NewWithSession(session, "", colName, time.Minute, false)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	shared.Close()
+	if err := session.Ping(); err != nil {
+		t.Errorf("A shared session should not be closed: %v", err)
+	}
+
+	owned, err := NewWithSession(session.Copy(), "", colName, time.Minute, true)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	if err := owned.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	owned.Close()
+	if owned.session != nil {
+		t.Error("An owned session should be closed")
+	}
+}
+
+func TestCodecFallback(t *testing.T) {
+	type user struct {
+		Name string
+	}
+
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+	store.SetCodecWithFallback(gobCodec{})
+	if err := store.Add("u1", user{"lorem"}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	store.SetCodecWithFallback(codec.Msgpack{}, gobCodec{})
+	store.SetLazyRewrite(true)
+	var result user
+	if err := store.Get("u1", &result); err != nil || result.Name != "lorem" {
+		t.Errorf("Expected value decoded by fallback but got %v: %v",
+			result, err)
+	}
+
+	store.SetCodecWithFallback(codec.Msgpack{})
+	result = user{}
+	if err := store.Get("u1", &result); err != nil || result.Name != "lorem" {
+		t.Errorf("Expected value rewritten by primary codec but got %v: %v",
+			result, err)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+	}
+
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+	store.SetCodec(codec.JSON{})
+	if err := store.Add("u1", user{"lorem"}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	var result user
+	if err := store.Get("u1", &result); err != nil || result.Name != "lorem" {
+		t.Errorf("Expected value decoded by JSON but got %v: %v", result, err)
+	}
+
+	doc := Data{}
+	session.DB("").C(colName).FindId("u1").One(&doc)
+	if string(doc.Value) != `{"name":"lorem"}` {
+		t.Errorf("The stored value should be JSON-encoded: %s", doc.Value)
+	}
+}
+
+// A taggedUser represents a value whose type is registered by RegisterType.
+type taggedUser struct {
+	Name string
+	Age  int
+}
+
+func TestRegisterTypeTag(t *testing.T) {
+	RegisterType(taggedUser{})
+
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+	if err := store.Add("u1", taggedUser{"lorem", 30}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	if err := store.Add("u2", &taggedUser{"ipsum", 31}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	var result interface{}
+	store.Get("u1", &result)
+	if expected := (taggedUser{"lorem", 30}); result != expected {
+		t.Errorf("Expected %#v got %#v", expected, result)
+	}
+	store.Get("u2", &result)
+	if expected := (taggedUser{"ipsum", 31}); result != expected {
+		t.Errorf("Expected %#v got %#v", expected, result)
+	}
+
+	if err := store.Set("u1", map[string]int{"a": 1}); err != nil {
+		t.Fatalf("Could not set value: %v", err)
+	}
+	store.Get("u1", &result)
+	if _, ok := result.(map[string]interface{}); !ok {
+		t.Errorf("A value of unregistered type should be generic: %#v",
+			result)
+	}
+}
+
+func TestContext(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := store.AddContext(ctx, "v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	var value int
+	if err := store.GetContext(ctx, "v1", &value); err != nil || value != 1 {
+		t.Errorf("Expected value 1 but got %d: %v", value, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := store.SetContext(ctx, "v1", 2); err != context.Canceled {
+		t.Errorf("Expected canceled error but got %v", err)
+	}
+
+	// A deadline too short for the query aborts it
+	ctx, cancel = context.WithTimeout(context.Background(), time.Microsecond)
+	defer cancel()
+	err = store.DeleteContext(ctx, "v1")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded error but got %v", err)
+	}
+}
+
+func TestCipherFunc(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	keyA, _ := data.NewAESCipher(bytes.Repeat([]byte{1}, 16))
+	keyB, _ := data.NewAESCipher(bytes.Repeat([]byte{2}, 16))
+	ciphers := map[string]data.Cipher{"a:": keyA, "b:": keyB}
+	store.SetCipherFunc(func(key string) data.Cipher {
+		return ciphers[key[:2]]
+	})
+
+	if err := store.Add("a:1", "lorem"); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	var result string
+	if err := store.Get("a:1", &result); err != nil || result != "lorem" {
+		t.Errorf("Expected 'lorem' but got %q: %v", result, err)
+	}
+
+	doc := Data{}
+	session.DB("").C(colName).FindId("a:1").One(&doc)
+	if doc.IsString || doc.Value == nil ||
+		bytes.Contains(doc.Value, []byte("lorem")) {
+		t.Error("The stored value should be encrypted")
+	}
+
+	// A value encrypted by another key cannot be read
+	ciphers["a:"] = keyB
+	if err := store.Get("a:1", &result); err == nil {
+		t.Error("The value should not be decrypted by another key")
+	}
+
+	ciphers["a:"] = data.RotatedCipher(keyB, keyA)
+	if err := store.Get("a:1", &result); err != nil || result != "lorem" {
+		t.Errorf("Expected 'lorem' by rotated key but got %q: %v",
+			result, err)
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Millisecond*100)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	store.Add("v1", 1)
+	store.Add("v2", 2)
+	time.Sleep(time.Millisecond * 200)
+	store.Add("v3", 3)
+
+	count, err := store.ReapExpired()
+	if err != nil {
+		t.Fatalf("Could not reap expired values: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 reaped values but got %d", count)
+	}
+
+	if count, _ := store.Count(); count != 1 {
+		t.Errorf("Expected 1 remaining value but got %d", count)
+	}
+}
+
+func TestDataFieldNames(t *testing.T) {
+	fields := map[string]string{
+		"CreatedAt": timeFieldName,
+		"Key":       keyFieldName,
+		"Value":     valueFieldName,
+		"IntVal":    intFieldName,
+		"IsString":  stringFieldName,
+		"Type":      typeFieldName,
+		"Created":   createdFieldName,
+		"Updated":   updatedFieldName,
+		"Version":   versionFieldName,
+	}
+
+	typ := reflect.TypeOf(Data{})
+	for name, expected := range fields {
+		f, ok := typ.FieldByName(name)
+		if !ok {
+			t.Errorf("Field %s not found", name)
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("bson"), ",")[0]
+		if tag != expected {
+			t.Errorf("Field %s is stored as '%s' instead of '%s'",
+				name, tag, expected)
+		}
+	}
+}
+
+func TestNewData(t *testing.T) {
+	d := NewData("key", []byte("value"))
+	if d.Key != "key" || string(d.Value) != "value" {
+		t.Errorf("Unexpected document: %+v", d)
+	}
+	if d.IsExpired(time.Minute) {
+		t.Error("New document should not be expired")
+	}
+
+	d.CreatedAt = d.CreatedAt.Add(-2 * time.Minute)
+	if !d.IsExpired(time.Minute) {
+		t.Error("Document should be expired by its lifetime")
+	}
+
+	d.ExpireAt = time.Now().Add(time.Minute)
+	if d.IsExpired(time.Minute) {
+		t.Error("Document should not be expired before its expireAt time")
+	}
+	d.ExpireAt = time.Now().Add(-time.Second)
+	if !d.IsExpired(time.Hour) {
+		t.Error("Document should be expired by its expireAt time")
+	}
+}
+
+func TestClose(t *testing.T) {
+	// A closed store never reaches MongoDB, hence it needs no collection
+	store := newStore(nil, time.Minute, nil)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Could not close store: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Closing again should do nothing: %v", err)
+	}
+
+	var value int
+	if err := store.Get("k1", &value); err != data.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed on Get but got %v", err)
+	}
+	if err := store.Add("k1", 1); err != data.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed on Add but got %v", err)
+	}
+	if _, err := store.Increment("k1"); err != data.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed on Increment but got %v", err)
+	}
+	if _, err := store.Count(); err != data.ErrStoreClosed {
+		t.Errorf("Expected ErrStoreClosed on Count but got %v", err)
+	}
+}
+
+func TestSetCompression(t *testing.T) {
+	store := &Store{codec: codec.JSON{}}
+	store.SetCompression(1024)
+	store.SetCompression(512)
+	if store.CodecName() != "json+gzip" || len(store.fallbacks) != 1 ||
+		store.fallbacks[0].Name() != "json" {
+		t.Errorf("Unexpected codec %s with fallbacks %v",
+			store.CodecName(), store.fallbacks)
+	}
+
+	b, err := store.codec.Marshal(strings.Repeat("lorem ipsum ", 100))
+	if err != nil {
+		t.Fatalf("Could not marshal value: %v", err)
+	}
+
+	// Compressed values are still read after compression is disabled
+	store.SetCompression(0)
+	if store.CodecName() != "json" || len(store.fallbacks) != 1 {
+		t.Errorf("Unexpected codec %s with fallbacks %v",
+			store.CodecName(), store.fallbacks)
+	}
+	var value string
+	if _, err := store.unmarshal("key", b, &value); err != nil ||
+		value != strings.Repeat("lorem ipsum ", 100) {
+		t.Errorf("Could not read compressed value: %v", err)
+	}
+
+	// Values of the default codec are never misread by the plain codec, like
+	// an encoding with uncompressed header being read as the integer zero
+	store = &Store{codec: codec.Msgpack{}}
+	store.SetCompression(512)
+	small, err := store.codec.Marshal("abc")
+	if err != nil {
+		t.Fatalf("Could not marshal value: %v", err)
+	}
+	large, err := store.codec.Marshal(strings.Repeat("lorem ipsum ", 100))
+	if err != nil {
+		t.Fatalf("Could not marshal value: %v", err)
+	}
+	store.SetCompression(0)
+	if store.CodecName() != "msgpack" {
+		t.Errorf("Unexpected codec %s", store.CodecName())
+	}
+	expected := map[string][]byte{
+		"abc":                               small,
+		strings.Repeat("lorem ipsum ", 100): large,
+	}
+	for str, b := range expected {
+		var generic interface{}
+		if _, err := store.unmarshal("key", b, &generic); err != nil ||
+			generic != str {
+			t.Errorf("Expected %.10q but got %#v: %v", str, generic, err)
+		}
+	}
+}
+
+func TestBinaryValue(t *testing.T) {
+	// Encoded values are not valid UTF-8, like the msgpack encoding of 255
+	value := []byte{0xcc, 0xff, 0xfe}
+	b, err := bson.Marshal(NewData("key", value))
+	if err != nil {
+		t.Fatalf("Could not marshal document: %v", err)
+	}
+
+	var raw bson.M
+	if err := bson.Unmarshal(b, &raw); err != nil {
+		t.Fatalf("Could not unmarshal document: %v", err)
+	}
+	if _, ok := raw[valueFieldName].([]byte); !ok {
+		t.Errorf("The value should be stored as binary data but got %T",
+			raw[valueFieldName])
+	}
+
+	var doc Data
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("Could not unmarshal document: %v", err)
+	}
+	if !bytes.Equal(doc.Value, value) {
+		t.Errorf("Expected value %v but got %v", value, doc.Value)
+	}
+
+	// Values stored as strings by former versions are still decoded
+	b, err = bson.Marshal(bson.M{
+		keyFieldName:   "key",
+		valueFieldName: string(value),
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal document: %v", err)
+	}
+	doc = Data{}
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("Could not unmarshal document: %v", err)
+	}
+	if !bytes.Equal(doc.Value, value) {
+		t.Errorf("Expected value %v but got %v", value, doc.Value)
+	}
+}
+
+func TestNonUTF8Value(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	// The msgpack encoding of these values is not valid UTF-8
+	values := map[string]interface{}{
+		"v1": uint8(255),
+		"v2": []byte{0xff, 0xfe, 0xfd},
+		"v3": "",
+	}
+	for key, value := range values {
+		if err := store.Add(key, value); err != nil {
+			t.Fatalf("Could not add value: %v", err)
+		}
+	}
+
+	var u uint8
+	if err := store.Get("v1", &u); err != nil || u != 255 {
+		t.Errorf("Expected value 255 but got %d: %v", u, err)
+	}
+	var b []byte
+	if err := store.Get("v2", &b); err != nil ||
+		!bytes.Equal(b, values["v2"].([]byte)) {
+		t.Errorf("Expected value %v but got %v: %v", values["v2"], b, err)
+	}
+	str := "-"
+	if err := store.Get("v3", &str); err != nil || str != "" {
+		t.Errorf("Expected empty string but got %q: %v", str, err)
+	}
+	if swapped, err := store.CompareAndSwap("v3", "", "lorem"); err != nil ||
+		!swapped {
+		t.Errorf("Empty string should be swapped: %v", err)
+	}
+	if _, err := store.Increment("v3"); err == nil {
+		t.Error("A string should not be incremented")
+	}
+}
+
+func TestSetAfterAdd(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Second)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	values := []interface{}{1, "lorem", []int{1, 2}}
+	updated := []interface{}{2, "ipsum", []int{3}}
+	for i := range values {
+		key := fmt.Sprint("v", i)
+		if err := store.Add(key, values[i]); err != nil {
+			t.Fatalf("Could not add value: %v", err)
+		}
+		if err := store.Set(key, updated[i]); err != nil {
+			t.Fatalf("Could not set value: %v", err)
+		}
+
+		result := reflect.New(reflect.TypeOf(updated[i]))
+		if err := store.Get(key, result.Interface()); err != nil {
+			t.Fatalf("Could not get value: %v", err)
+		}
+		if !reflect.DeepEqual(result.Elem().Interface(), updated[i]) {
+			t.Errorf("Expected '%v' got '%v'", updated[i],
+				result.Elem().Interface())
+		}
+	}
+}
+
+func TestAddNetworkError(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	sess := session.Copy()
+	defer sess.Close()
+	store, err := NewWithSession(sess, "", colName, time.Second, false)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	// Every operation times out before MongoDB replies
+	sess.SetSocketTimeout(time.Nanosecond)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Add should not panic on a network error: %v", r)
+		}
+	}()
+
+	err = store.Add("v1", 1)
+	if err == nil {
+		t.Fatal("Add should fail on a network error")
+	}
+	if _, ok := err.(dot.DuplicatedKeyError); ok {
+		t.Errorf("A network error should not be reported as duplicated key")
+	}
+}
+
+func TestCountAccuracy(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Millisecond*100)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+	store.EnsureAccuracy(true)
+
+	store.Add("v1", 1)
+	time.Sleep(time.Millisecond * 200)
+	store.Add("v2", 2)
+
+	// MongoDB removes expired documents at intervals of 60 seconds
+	if count, err := store.Count(); err != nil || count != 1 {
+		t.Errorf("Expected 1 live value but got %d: %v", count, err)
+	}
+
+	store.EnsureAccuracy(false)
+	if count, err := store.Count(); err != nil || count != 2 {
+		t.Errorf("Expected 2 stored values but got %d: %v", count, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Millisecond*100)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	store.Add("v1", 1)
+	store.Add("v2", 2)
+
+	var value int
+	store.Get("v1", &value)
+	store.Get("missing", &value)
+
+	time.Sleep(time.Millisecond * 200)
+	store.ReapExpired()
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Could not get stats: %v", err)
+	}
+	expected := data.Stats{Hits: 1, Misses: 1, Additions: 2, Evictions: 2}
+	if stats != expected {
+		t.Errorf("Unexpected stats: expected %+v got %+v", expected, stats)
+	}
+
+	store.ResetStats()
+	if stats, _ := store.Stats(); stats != (data.Stats{}) {
+		t.Errorf("Unexpected stats after reset: %+v", stats)
+	}
+}
+
+func TestCompareAndSwapMiddleware(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+	exclaim := func(key string, value interface{}) (interface{}, error) {
+		return fmt.Sprint(value, "!"), nil
+	}
+	store.SetValueMiddleware(exclaim, nil)
+
+	if err := store.Add("v1", "lorem"); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	// The stored form of value is compared, which the middleware wrote
+	swapped, err := store.CompareAndSwap("v1", "lorem!", "ipsum")
+	if err != nil || !swapped {
+		t.Errorf("Expected value swapped but got %v: %v", swapped, err)
+	}
+}
+
+func TestXFetchOwnLifetime(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Second)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	// Recomputing a value far from expiring is likely only by its own
+	// lifetime, which is much longer than the lifetime of store
+	if err := store.AddWithLifetime("v1", 1, time.Hour); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	recompute := false
+	var value int
+	for i := 0; i < 50 && !recompute; i++ {
+		if recompute, err = store.GetWithXFetch("v1", &value, 1); err != nil {
+			t.Fatalf("Could not get value: %v", err)
+		}
+	}
+	if !recompute {
+		t.Error("The value should be recomputed by its own lifetime")
+	}
+}
+
+func TestGetOrAddConcurrent(t *testing.T) {
+	session, env := prepareMongoEnvironment(t)
+	defer env.Dispose()
+
+	store, err := New(session.DB(""), colName, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not create store: %v", err)
+	}
+	store.Flush()
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var value int
+			err := store.GetOrAdd("v1", &value, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(time.Millisecond * 10)
+				return 5, nil
+			})
+			if err != nil || value != 5 {
+				t.Errorf("Expected value 5 but got %d: %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected factory called once but got %d calls", calls)
+	}
 }
 
 func BenchmarkMongoStoreAddGet(b *testing.B) {
 	session, env := prepareMongoEnvironment(b)
 	defer env.Dispose()
 
-	store := New(session.DB(""), colName, time.Second)
+	store, err := New(session.DB(""), colName, time.Second)
+	if err != nil {
+		b.Fatalf("Could not create store: %v", err)
+	}
 	testdata.BenchmarkAddGet(store, b)
 }
 
@@ -91,7 +995,10 @@ func BenchmarkMongoStoreAddGetTransient(b *testing.B) {
 	session, env := prepareMongoEnvironment(b)
 	defer env.Dispose()
 
-	store := New(session.DB(""), colName, time.Second)
+	store, err := New(session.DB(""), colName, time.Second)
+	if err != nil {
+		b.Fatalf("Could not create store: %v", err)
+	}
 	store.SetTransient(true)
 	testdata.BenchmarkAddGet(store, b)
 }
@@ -100,11 +1007,31 @@ func BenchmarkMongoAtomicIncrement(b *testing.B) {
 	session, env := prepareMongoEnvironment(b)
 	defer env.Dispose()
 
-	store := New(session.DB(""), colName, time.Second)
+	store, err := New(session.DB(""), colName, time.Second)
+	if err != nil {
+		b.Fatalf("Could not create store: %v", err)
+	}
 	store.SetTransient(true)
 	testdata.BenchmarkAtomicIncrement(store, b)
 }
 
+// A gobCodec encodes values using gob, as a codec used before migration.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
 func openSession(url string) (*mgo.Session, error) {
 	session, err := mgo.Dial(url)
 	if err != nil {
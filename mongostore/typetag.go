@@ -0,0 +1,88 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mongostore
+
+import (
+	"reflect"
+	"sync"
+)
+
+// taggedTypes holds the types registered by RegisterType by their tags.
+var taggedTypes = struct {
+	sync.RWMutex
+	byTag map[string]reflect.Type
+}{byTag: make(map[string]reflect.Type)}
+
+// RegisterType registers the type of proto, so a value of that type, or a
+// pointer to it, is stored along with a tag naming its type. Such value is
+// decoded into its original type when it is read into an empty interface,
+// rather than into a generic map. The types must be registered by every
+// process reading the values, usually on init.
+//
+// Unlike Store.RegisterType, which selects the type by key prefix for
+// GetValue, the type is tagged on each stored value, so it works for any key.
+func RegisterType(proto interface{}) {
+	t := indirectType(reflect.TypeOf(proto))
+	if t == nil {
+		return
+	}
+
+	taggedTypes.Lock()
+	defer taggedTypes.Unlock()
+	taggedTypes.byTag[typeTag(t)] = t
+}
+
+// indirectType returns the type pointed to by t, whether t is a pointer.
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// typeTag returns the tag which identifies specified type.
+func typeTag(t reflect.Type) string {
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+// tagOf returns the tag of the type of specified value, or an empty string
+// whether its type is not registered.
+func tagOf(value interface{}) string {
+	t := indirectType(reflect.TypeOf(value))
+	if t == nil {
+		return ""
+	}
+
+	tag := typeTag(t)
+	taggedTypes.RLock()
+	defer taggedTypes.RUnlock()
+	if _, ok := taggedTypes.byTag[tag]; !ok {
+		return ""
+	}
+	return tag
+}
+
+// typeOfTag returns the type registered by specified tag.
+func typeOfTag(tag string) (reflect.Type, bool) {
+	taggedTypes.RLock()
+	defer taggedTypes.RUnlock()
+	t, ok := taggedTypes.byTag[tag]
+	return t, ok
+}
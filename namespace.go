@@ -0,0 +1,204 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// A prefixFlusher represents a store which deletes every value whose key
+// starts with a prefix at once.
+type prefixFlusher interface {
+	FlushPrefix(prefix string) error
+}
+
+// A namespacedStore represents a Store view which prepends a namespace to
+// every key.
+type namespacedStore struct {
+	Store
+	prefix string
+}
+
+// Namespace returns a Store view of s which prepends prefix and a colon to
+// every key, and strips them from the keys returned by Keys and Range, so many
+// logical stores can share a single backend without colliding keys.
+//
+// Count, Keys, Range and Flush only handle the values of the namespace. Flush
+// deletes them at once whether s provides FlushPrefix; otherwise, it deletes
+// each key returned by Keys. The lifetime and the expiration behaviour are
+// shared by every namespace of s.
+func Namespace(s Store, prefix string) Store {
+	return &namespacedStore{s, prefix + ":"}
+}
+
+// Add adds a new value to wrapped store by the namespaced key.
+func (s *namespacedStore) Add(key string, value interface{}) error {
+	return s.Store.Add(s.prefix+key, value)
+}
+
+// Close does nothing, since wrapped store is shared by other namespaces and
+// must be closed by its owner.
+func (s *namespacedStore) Close() error {
+	return nil
+}
+
+// Count gets the number of values stored by the namespace.
+//
+// Errors:
+// NotSupportedError when wrapped store does not support Keys.
+func (s *namespacedStore) Count() (int, error) {
+	keys, err := s.Keys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// Decrement decrements the value stored by the namespaced key.
+func (s *namespacedStore) Decrement(key string) (int, error) {
+	as, err := atomicOf(s.Store, "Decrement")
+	if err != nil {
+		return 0, err
+	}
+	return as.Decrement(s.prefix + key)
+}
+
+// DecrementBy decrements the value stored by the namespaced key.
+func (s *namespacedStore) DecrementBy(key string, value int) (int, error) {
+	as, err := atomicOf(s.Store, "DecrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.DecrementBy(s.prefix+key, value)
+}
+
+// Delete deletes the value stored by the namespaced key.
+func (s *namespacedStore) Delete(key string) error {
+	return s.Store.Delete(s.prefix + key)
+}
+
+// Exists reports whether a value is stored by the namespaced key.
+func (s *namespacedStore) Exists(key string) (bool, error) {
+	return s.Store.Exists(s.prefix + key)
+}
+
+// Flush deletes every value stored by the namespace, keeping the values of
+// other namespaces.
+//
+// Errors:
+// NotSupportedError when wrapped store supports neither FlushPrefix nor Keys.
+func (s *namespacedStore) Flush() error {
+	if pf, ok := s.Store.(prefixFlusher); ok {
+		return pf.FlushPrefix(s.prefix)
+	}
+
+	keys, err := s.Store.Keys()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, s.prefix) {
+			continue
+		}
+		// A value expired meanwhile is already deleted
+		err := s.Store.Delete(k)
+		if _, ok := err.(dot.InvalidKeyError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get gets the value stored by the namespaced key.
+func (s *namespacedStore) Get(key string, ref interface{}) error {
+	return s.Store.Get(s.prefix+key, ref)
+}
+
+// GetAndReset gets and resets the value stored by the namespaced key.
+func (s *namespacedStore) GetAndReset(key string) (int, error) {
+	rs, err := resettableOf(s.Store)
+	if err != nil {
+		return 0, err
+	}
+	return rs.GetAndReset(s.prefix + key)
+}
+
+// Increment increments the value stored by the namespaced key.
+func (s *namespacedStore) Increment(key string) (int, error) {
+	as, err := atomicOf(s.Store, "Increment")
+	if err != nil {
+		return 0, err
+	}
+	return as.Increment(s.prefix + key)
+}
+
+// IncrementBy increments the value stored by the namespaced key.
+func (s *namespacedStore) IncrementBy(key string, value int) (int, error) {
+	as, err := atomicOf(s.Store, "IncrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.IncrementBy(s.prefix+key, value)
+}
+
+// Keys gets the keys of values stored by the namespace, without the namespace.
+//
+// Errors:
+// NotSupportedError when wrapped store does not support Keys.
+func (s *namespacedStore) Keys() ([]string, error) {
+	all, err := s.Store.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(all))
+	for _, k := range all {
+		if strings.HasPrefix(k, s.prefix) {
+			keys = append(keys, k[len(s.prefix):])
+		}
+	}
+	return keys, nil
+}
+
+// Range calls fn for each value stored by the namespace along with its key
+// without the namespace.
+func (s *namespacedStore) Range(fn func(key string, value interface{}) bool) error {
+	return s.Store.Range(func(key string, value interface{}) bool {
+		if !strings.HasPrefix(key, s.prefix) {
+			return true
+		}
+		return fn(key[len(s.prefix):], value)
+	})
+}
+
+// Set sets the value stored by the namespaced key.
+func (s *namespacedStore) Set(key string, value interface{}) error {
+	return s.Store.Set(s.prefix+key, value)
+}
+
+// Touch renews the lifetime of the value stored by the namespaced key.
+func (s *namespacedStore) Touch(key string) error {
+	return s.Store.Touch(s.prefix + key)
+}
+
+// TTL gets the remaining lifetime of the value stored by the namespaced key.
+func (s *namespacedStore) TTL(key string) (time.Duration, error) {
+	return s.Store.TTL(s.prefix + key)
+}
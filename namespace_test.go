@@ -0,0 +1,90 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestNamespace(t *testing.T) {
+	backends := map[string]data.Store{
+		"memstore": memstore.New(time.Minute, false),
+		"ring":     memstore.NewRingStore(10),
+		// The wrapper hides FlushPrefix of wrapped store
+		"wrapped": data.RateLimited(memstore.NewRingStore(10), 0),
+	}
+
+	for name, backend := range backends {
+		a := data.Namespace(backend, "a")
+		b := data.Namespace(backend, "b")
+		backend.Add("other", 0)
+
+		for i, key := range []string{"k1", "k2"} {
+			if err := a.Add(key, i); err != nil {
+				t.Fatalf("%s: Could not add value: %v", name, err)
+			}
+		}
+		if err := b.Add("k1", 10); err != nil {
+			t.Fatalf("%s: The same key should be added to other namespace: %v",
+				name, err)
+		}
+
+		var value int
+		if err := a.Get("k1", &value); err != nil || value != 0 {
+			t.Errorf("%s: Expected 0 got %d: %v", name, value, err)
+		}
+		if err := backend.Get("b:k1", &value); err != nil || value != 10 {
+			t.Errorf("%s: Expected 10 got %d: %v", name, value, err)
+		}
+
+		keys, err := a.Keys()
+		sort.Strings(keys)
+		if err != nil || !reflect.DeepEqual(keys, []string{"k1", "k2"}) {
+			t.Errorf("%s: Unexpected keys %v: %v", name, keys, err)
+		}
+
+		ranged := make(map[string]interface{})
+		a.Range(func(key string, value interface{}) bool {
+			ranged[key] = value
+			return true
+		})
+		if len(ranged) != 2 || ranged["k2"] != int64(1) {
+			t.Errorf("%s: Unexpected ranged values %v", name, ranged)
+		}
+
+		if err := a.Flush(); err != nil {
+			t.Errorf("%s: Could not flush namespace: %v", name, err)
+		}
+		if count, _ := a.Count(); count != 0 {
+			t.Errorf("%s: Expected empty namespace but got %d values",
+				name, count)
+		}
+		if count, _ := b.Count(); count != 1 {
+			t.Errorf("%s: Expected 1 value on other namespace but got %d",
+				name, count)
+		}
+		if ok, _ := backend.Exists("other"); !ok {
+			t.Errorf("%s: A value outside namespaces should be kept", name)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// A RateLimitedStore represents a Store which limits the rate of writes
+// delegated to wrapped store, using a token bucket. Reads are never limited.
+type RateLimitedStore struct {
+	Store
+	mutex    sync.Mutex
+	rate     int
+	tokens   float64
+	last     time.Time
+	blocking bool
+	clock    Clock
+}
+
+// RateLimited returns a Store which allows up to writesPerSec writes per
+// second to reach s, protecting it from write storms. Every operation that
+// modifies a value, like Add, Set, Delete or Increment, takes a token from a
+// bucket holding up to writesPerSec tokens, which is refilled continuously at
+// the same rate. A write finding no token is refused by ErrRateLimited, unless
+// blocking is enabled by SetBlocking.
+//
+// A rate lower than one disables the limit. The rate can be modified at any
+// time calling SetRate.
+func RateLimited(s Store, writesPerSec int) *RateLimitedStore {
+	r := &RateLimitedStore{Store: s, clock: SystemClock{}}
+	r.SetRate(writesPerSec)
+	return r
+}
+
+// SetBlocking defines whether a write exceeding the rate limit waits until it
+// is allowed, instead of being refused by ErrRateLimited.
+func (s *RateLimitedStore) SetBlocking(value bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.blocking = value
+}
+
+// SetClock defines the clock used to refill the bucket of tokens, which
+// defaults to system clock. Blocked writes always wait for real elapsed time.
+func (s *RateLimitedStore) SetClock(c Clock) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clock = c
+	s.last = c.Now()
+}
+
+// SetRate modifies the number of writes allowed per second. The tokens already
+// available are kept up to the new rate. A rate lower than one disables the
+// limit.
+func (s *RateLimitedStore) SetRate(writesPerSec int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.last.IsZero() {
+		s.tokens = float64(writesPerSec)
+		s.last = s.clock.Now()
+	} else {
+		s.refill()
+	}
+
+	s.rate = writesPerSec
+	if s.tokens > float64(writesPerSec) {
+		s.tokens = float64(writesPerSec)
+	}
+}
+
+// Add adds a new key:value to wrapped store, whether it is allowed by rate
+// limit.
+func (s *RateLimitedStore) Add(key string, value interface{}) error {
+	if err := s.wait(); err != nil {
+		return err
+	}
+	return s.Store.Add(key, value)
+}
+
+// Decrement decrements the value stored by specified key, whether it is
+// allowed by rate limit.
+func (s *RateLimitedStore) Decrement(key string) (int, error) {
+	as, err := s.atomic("Decrement")
+	if err != nil {
+		return 0, err
+	}
+	return as.Decrement(key)
+}
+
+// DecrementBy decrements by value the value stored by specified key, whether
+// it is allowed by rate limit.
+func (s *RateLimitedStore) DecrementBy(key string, value int) (int, error) {
+	as, err := s.atomic("DecrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.DecrementBy(key, value)
+}
+
+// Delete deletes the specified value, whether it is allowed by rate limit.
+func (s *RateLimitedStore) Delete(key string) error {
+	if err := s.wait(); err != nil {
+		return err
+	}
+	return s.Store.Delete(key)
+}
+
+// Flush deletes any value from wrapped store, whether it is allowed by rate
+// limit.
+func (s *RateLimitedStore) Flush() error {
+	if err := s.wait(); err != nil {
+		return err
+	}
+	return s.Store.Flush()
+}
+
+// GetAndReset gets and resets the value stored by specified key, whether it
+// is allowed by rate limit.
+func (s *RateLimitedStore) GetAndReset(key string) (int, error) {
+	rs, err := resettableOf(s.Store)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.wait(); err != nil {
+		return 0, err
+	}
+	return rs.GetAndReset(key)
+}
+
+// Increment increments the value stored by specified key, whether it is
+// allowed by rate limit.
+func (s *RateLimitedStore) Increment(key string) (int, error) {
+	as, err := s.atomic("Increment")
+	if err != nil {
+		return 0, err
+	}
+	return as.Increment(key)
+}
+
+// IncrementBy increments by value the value stored by specified key, whether
+// it is allowed by rate limit.
+func (s *RateLimitedStore) IncrementBy(key string, value int) (int, error) {
+	as, err := s.atomic("IncrementBy")
+	if err != nil {
+		return 0, err
+	}
+	return as.IncrementBy(key, value)
+}
+
+// Set sets the value of specified key, whether it is allowed by rate limit.
+func (s *RateLimitedStore) Set(key string, value interface{}) error {
+	if err := s.wait(); err != nil {
+		return err
+	}
+	return s.Store.Set(key, value)
+}
+
+// atomic returns wrapped store as an AtomicStore, whether it supports atomic
+// operations and the operation is allowed by rate limit.
+func (s *RateLimitedStore) atomic(method string) (AtomicStore, error) {
+	as, err := atomicOf(s.Store, method)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.wait(); err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// refill adds the tokens earned since last refill, up to the rate limit.
+func (s *RateLimitedStore) refill() {
+	now := s.clock.Now()
+	s.tokens += now.Sub(s.last).Seconds() * float64(s.rate)
+	if s.tokens > float64(s.rate) {
+		s.tokens = float64(s.rate)
+	}
+	s.last = now
+}
+
+// reserve takes a token, whether one is available. Otherwise, it returns how
+// long until a token is available and whether a write should wait for it.
+func (s *RateLimitedStore) reserve() (bool, time.Duration, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rate < 1 {
+		return true, 0, false
+	}
+
+	s.refill()
+	if s.tokens >= 1 {
+		s.tokens--
+		return true, 0, false
+	}
+
+	wait := time.Duration((1 - s.tokens) / float64(s.rate) * float64(time.Second))
+	return false, wait, s.blocking
+}
+
+// wait takes a token to write, waiting until one is available whether
+// blocking is enabled.
+//
+// Errors:
+// ErrRateLimited when no token is available and blocking is disabled.
+func (s *RateLimitedStore) wait() error {
+	for {
+		ok, wait, blocking := s.reserve()
+		if ok {
+			return nil
+		}
+		if !blocking {
+			return ErrRateLimited
+		}
+		time.Sleep(wait)
+	}
+}
+
+var _ ResettableStore = (*RateLimitedStore)(nil)
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestRateLimited(t *testing.T) {
+	clock := testdata.NewClock()
+	store := data.RateLimited(memstore.New(time.Minute, false), 10)
+	store.SetClock(clock)
+
+	// A full bucket admits a burst of the configured rate
+	for i := 0; i < 10; i++ {
+		if _, err := store.Increment("c1"); err != nil {
+			t.Fatalf("Write %d should be allowed: %v", i, err)
+		}
+	}
+	if err := store.Set("c1", 0); err != data.ErrRateLimited {
+		t.Errorf("Expected rate limit error but got %v", err)
+	}
+
+	var value int
+	if err := store.Get("c1", &value); err != nil || value != 10 {
+		t.Errorf("Reads should not be limited, got %d: %v", value, err)
+	}
+
+	// Tokens are refilled at the configured rate
+	clock.Advance(time.Millisecond * 500)
+	for i := 0; i < 5; i++ {
+		if err := store.Set("c1", i); err != nil {
+			t.Errorf("Write %d should be allowed: %v", i, err)
+		}
+	}
+	if err := store.Delete("c1"); err != data.ErrRateLimited {
+		t.Errorf("Expected rate limit error but got %v", err)
+	}
+
+	store.SetRate(0)
+	if err := store.Delete("c1"); err != nil {
+		t.Errorf("Writes should not be limited without rate: %v", err)
+	}
+}
+
+func TestRateLimitedBlocking(t *testing.T) {
+	store := data.RateLimited(memstore.New(time.Minute, false), 20)
+	store.SetBlocking(true)
+
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		if _, err := store.Increment("c1"); err != nil {
+			t.Fatalf("Blocked write should be allowed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*200 {
+		t.Errorf("Expected writes to wait about 250ms but took %v", elapsed)
+	}
+}
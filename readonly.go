@@ -0,0 +1,142 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import "time"
+
+// A readOnlyStore represents a Store view which refuses every operation that
+// would modify wrapped store. It does not embed wrapped store, so a method
+// added to Store is never delegated before it is reviewed.
+type readOnlyStore struct {
+	store Store
+}
+
+// ReadOnly returns a Store view of s which reads its values, like by Get,
+// Count, Keys or Exists, while every operation that would modify s returns a
+// ReadOnlyError naming that operation, like Add, Set, Delete or Flush. It
+// allows handing a store to code which must never modify it.
+//
+// Reading a value still renews its lifetime when s is not transient, since it
+// is how s expires its values. Likewise Get consumes a use of a value added by
+// AddWithUses, deleting it once its uses are exhausted, as Get of s does.
+// SetTransient and Close do nothing, since they cannot return an error and
+// wrapped store is closed by its owner.
+func ReadOnly(s Store) Store {
+	return &readOnlyStore{s}
+}
+
+// Add returns ReadOnlyError.
+func (s *readOnlyStore) Add(key string, value interface{}) error {
+	return ReadOnlyError("Add")
+}
+
+// Close does nothing, since wrapped store is closed by its owner.
+func (s *readOnlyStore) Close() error {
+	return nil
+}
+
+// Count gets the number of values stored by wrapped store.
+func (s *readOnlyStore) Count() (int, error) {
+	return s.store.Count()
+}
+
+// Decrement returns ReadOnlyError.
+func (s *readOnlyStore) Decrement(key string) (int, error) {
+	return 0, ReadOnlyError("Decrement")
+}
+
+// DecrementBy returns ReadOnlyError.
+func (s *readOnlyStore) DecrementBy(key string, value int) (int, error) {
+	return 0, ReadOnlyError("DecrementBy")
+}
+
+// Delete returns ReadOnlyError.
+func (s *readOnlyStore) Delete(key string) error {
+	return ReadOnlyError("Delete")
+}
+
+// Exists reports whether a value is stored by specified key on wrapped store.
+func (s *readOnlyStore) Exists(key string) (bool, error) {
+	return s.store.Exists(key)
+}
+
+// Flush returns ReadOnlyError.
+func (s *readOnlyStore) Flush() error {
+	return ReadOnlyError("Flush")
+}
+
+// Get gets the value stored by specified key on wrapped store, which renews
+// its lifetime and consumes its uses like Get of wrapped store does.
+func (s *readOnlyStore) Get(key string, ref interface{}) error {
+	return s.store.Get(key, ref)
+}
+
+// GetAndReset returns ReadOnlyError.
+func (s *readOnlyStore) GetAndReset(key string) (int, error) {
+	return 0, ReadOnlyError("GetAndReset")
+}
+
+// Increment returns ReadOnlyError.
+func (s *readOnlyStore) Increment(key string) (int, error) {
+	return 0, ReadOnlyError("Increment")
+}
+
+// IncrementBy returns ReadOnlyError.
+func (s *readOnlyStore) IncrementBy(key string, value int) (int, error) {
+	return 0, ReadOnlyError("IncrementBy")
+}
+
+// Keys gets the keys of values stored by wrapped store.
+func (s *readOnlyStore) Keys() ([]string, error) {
+	return s.store.Keys()
+}
+
+// Range calls fn for each value stored by wrapped store.
+func (s *readOnlyStore) Range(
+	fn func(key string, value interface{}) bool,
+) error {
+	return s.store.Range(fn)
+}
+
+// Set returns ReadOnlyError.
+func (s *readOnlyStore) Set(key string, value interface{}) error {
+	return ReadOnlyError("Set")
+}
+
+// SetLifetime returns ReadOnlyError.
+func (s *readOnlyStore) SetLifetime(
+	d time.Duration, scope LifetimeScope,
+) error {
+	return ReadOnlyError("SetLifetime")
+}
+
+// SetTransient does nothing, since it cannot return ReadOnlyError.
+func (s *readOnlyStore) SetTransient(value bool) {
+}
+
+// Touch returns ReadOnlyError, since it renews the lifetime of a value.
+func (s *readOnlyStore) Touch(key string) error {
+	return ReadOnlyError("Touch")
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key on
+// wrapped store.
+func (s *readOnlyStore) TTL(key string) (time.Duration, error) {
+	return s.store.TTL(key)
+}
+
+var _ ResettableStore = (*readOnlyStore)(nil)
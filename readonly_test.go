@@ -0,0 +1,77 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestReadOnly(t *testing.T) {
+	backend := memstore.New(time.Minute, false)
+	backend.Add("k1", 1)
+	store := data.ReadOnly(backend)
+	as := store.(data.ResettableStore)
+
+	mutators := map[string]func() error{
+		"Add":         func() error { return store.Add("k2", 2) },
+		"Set":         func() error { return store.Set("k1", 2) },
+		"Delete":      func() error { return store.Delete("k1") },
+		"Flush":       store.Flush,
+		"Touch":       func() error { return store.Touch("k1") },
+		"SetLifetime": func() error { return store.SetLifetime(time.Second, data.ScopeAll) },
+		"Increment": func() error {
+			_, err := as.Increment("k1")
+			return err
+		},
+		"Decrement": func() error {
+			_, err := as.Decrement("k1")
+			return err
+		},
+		"GetAndReset": func() error {
+			_, err := as.GetAndReset("k1")
+			return err
+		},
+	}
+	for name, fn := range mutators {
+		if err := fn(); err != data.ReadOnlyError(name) {
+			t.Errorf("%s: expected ReadOnlyError but got %v", name, err)
+		}
+	}
+	store.SetTransient(true)
+	store.Close()
+
+	var value int
+	if err := store.Get("k1", &value); err != nil || value != 1 {
+		t.Errorf("Expected 1 got %d: %v", value, err)
+	}
+	if count, err := store.Count(); err != nil || count != 1 {
+		t.Errorf("Expected 1 value got %d: %v", count, err)
+	}
+	if keys, err := store.Keys(); err != nil || len(keys) != 1 || keys[0] != "k1" {
+		t.Errorf("Unexpected keys %v: %v", keys, err)
+	}
+	if ok, err := store.Exists("k1"); err != nil || !ok {
+		t.Errorf("The value should exist: %v", err)
+	}
+	if ok, _ := store.Exists("k2"); ok {
+		t.Errorf("The value should not be added")
+	}
+}
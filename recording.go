@@ -0,0 +1,353 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/raiqub/dot.v1"
+)
+
+// An Op represents an operation received by a Store.
+type Op struct {
+	Time      time.Time
+	Method    string
+	Key       string        `json:",omitempty"`
+	Value     interface{}   `json:",omitempty"`
+	Digest    string        `json:",omitempty"`
+	Delta     int           `json:",omitempty"`
+	Lifetime  time.Duration `json:",omitempty"`
+	Scope     LifetimeScope `json:",omitempty"`
+	Transient bool          `json:",omitempty"`
+	Error     string        `json:",omitempty"`
+}
+
+// An OpLog accumulates the operations received by a Store, which can be
+// saved and replayed later.
+type OpLog struct {
+	ops           []Op
+	captureValues bool
+	mutex         sync.Mutex
+}
+
+// LoadOpLog reads an OpLog previously saved to r.
+func LoadOpLog(r io.Reader) (*OpLog, error) {
+	log := &OpLog{}
+	if err := json.NewDecoder(r).Decode(&log.ops); err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+// CaptureValues defines whether full values should be recorded. Otherwise,
+// only a digest of each value is recorded to bound memory usage.
+func (l *OpLog) CaptureValues(value bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.captureValues = value
+}
+
+// Ops returns a copy of recorded operations.
+func (l *OpLog) Ops() []Op {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ops := make([]Op, len(l.ops))
+	copy(ops, l.ops)
+	return ops
+}
+
+// Save writes recorded operations to w as JSON.
+func (l *OpLog) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(l.Ops())
+}
+
+// record appends specified operation, filling its time, value and error.
+func (l *OpLog) record(op Op, value interface{}, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	op.Time = time.Now()
+	if value != nil {
+		if l.captureValues {
+			op.Value = value
+		} else {
+			sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", value)))
+			op.Digest = hex.EncodeToString(sum[:])
+		}
+	}
+	if err != nil {
+		op.Error = err.Error()
+	}
+
+	l.ops = append(l.ops, op)
+}
+
+// A recordingStore represents a Store which records every received operation.
+type recordingStore struct {
+	Store
+	log *OpLog
+}
+
+// Recording returns a Store which records every operation received before
+// delegating it to s.
+func Recording(s Store) (Store, *OpLog) {
+	log := &OpLog{}
+	return &recordingStore{s, log}, log
+}
+
+// Add records the operation and delegates it to wrapped store.
+func (s *recordingStore) Add(key string, value interface{}) error {
+	err := s.Store.Add(key, value)
+	s.log.record(Op{Method: "Add", Key: key}, value, err)
+	return err
+}
+
+// Close delegates to wrapped store without recording, since replaying it would
+// close the target store.
+func (s *recordingStore) Close() error {
+	return s.Store.Close()
+}
+
+// Count records the operation and delegates it to wrapped store.
+func (s *recordingStore) Count() (int, error) {
+	count, err := s.Store.Count()
+	s.log.record(Op{Method: "Count"}, nil, err)
+	return count, err
+}
+
+// Decrement records the operation and delegates it to wrapped store.
+func (s *recordingStore) Decrement(key string) (int, error) {
+	as, err := atomicOf(s.Store, "Decrement")
+	value := 0
+	if err == nil {
+		value, err = as.Decrement(key)
+	}
+	s.log.record(Op{Method: "Decrement", Key: key}, nil, err)
+	return value, err
+}
+
+// DecrementBy records the operation and delegates it to wrapped store.
+func (s *recordingStore) DecrementBy(key string, value int) (int, error) {
+	as, err := atomicOf(s.Store, "DecrementBy")
+	result := 0
+	if err == nil {
+		result, err = as.DecrementBy(key, value)
+	}
+	s.log.record(Op{Method: "DecrementBy", Key: key, Delta: value}, nil, err)
+	return result, err
+}
+
+// Delete records the operation and delegates it to wrapped store.
+func (s *recordingStore) Delete(key string) error {
+	err := s.Store.Delete(key)
+	s.log.record(Op{Method: "Delete", Key: key}, nil, err)
+	return err
+}
+
+// Exists records the operation and delegates it to wrapped store.
+func (s *recordingStore) Exists(key string) (bool, error) {
+	found, err := s.Store.Exists(key)
+	s.log.record(Op{Method: "Exists", Key: key}, nil, err)
+	return found, err
+}
+
+// Flush records the operation and delegates it to wrapped store.
+func (s *recordingStore) Flush() error {
+	err := s.Store.Flush()
+	s.log.record(Op{Method: "Flush"}, nil, err)
+	return err
+}
+
+// Get records the operation and delegates it to wrapped store.
+func (s *recordingStore) Get(key string, ref interface{}) error {
+	err := s.Store.Get(key, ref)
+	s.log.record(Op{Method: "Get", Key: key}, nil, err)
+	return err
+}
+
+// GetAndReset records the operation and delegates it to wrapped store.
+func (s *recordingStore) GetAndReset(key string) (int, error) {
+	rs, err := resettableOf(s.Store)
+	value := 0
+	if err == nil {
+		value, err = rs.GetAndReset(key)
+	}
+	s.log.record(Op{Method: "GetAndReset", Key: key}, nil, err)
+	return value, err
+}
+
+// Increment records the operation and delegates it to wrapped store.
+func (s *recordingStore) Increment(key string) (int, error) {
+	as, err := atomicOf(s.Store, "Increment")
+	value := 0
+	if err == nil {
+		value, err = as.Increment(key)
+	}
+	s.log.record(Op{Method: "Increment", Key: key}, nil, err)
+	return value, err
+}
+
+// IncrementBy records the operation and delegates it to wrapped store.
+func (s *recordingStore) IncrementBy(key string, value int) (int, error) {
+	as, err := atomicOf(s.Store, "IncrementBy")
+	result := 0
+	if err == nil {
+		result, err = as.IncrementBy(key, value)
+	}
+	s.log.record(Op{Method: "IncrementBy", Key: key, Delta: value}, nil, err)
+	return result, err
+}
+
+// Keys records the operation and delegates it to wrapped store.
+func (s *recordingStore) Keys() ([]string, error) {
+	keys, err := s.Store.Keys()
+	s.log.record(Op{Method: "Keys"}, nil, err)
+	return keys, err
+}
+
+// Range records the operation and delegates it to wrapped store.
+func (s *recordingStore) Range(fn func(key string, value interface{}) bool) error {
+	err := s.Store.Range(fn)
+	s.log.record(Op{Method: "Range"}, nil, err)
+	return err
+}
+
+// Set records the operation and delegates it to wrapped store.
+func (s *recordingStore) Set(key string, value interface{}) error {
+	err := s.Store.Set(key, value)
+	s.log.record(Op{Method: "Set", Key: key}, value, err)
+	return err
+}
+
+// SetLifetime records the operation and delegates it to wrapped store.
+func (s *recordingStore) SetLifetime(d time.Duration, scope LifetimeScope) error {
+	err := s.Store.SetLifetime(d, scope)
+	s.log.record(Op{Method: "SetLifetime", Lifetime: d, Scope: scope}, nil, err)
+	return err
+}
+
+// SetTransient records the operation and delegates it to wrapped store.
+func (s *recordingStore) SetTransient(value bool) {
+	s.Store.SetTransient(value)
+	s.log.record(Op{Method: "SetTransient", Transient: value}, nil, nil)
+}
+
+// TTL records the operation and delegates it to wrapped store.
+func (s *recordingStore) TTL(key string) (time.Duration, error) {
+	ttl, err := s.Store.TTL(key)
+	s.log.record(Op{Method: "TTL", Key: key}, nil, err)
+	return ttl, err
+}
+
+// Touch records the operation and delegates it to wrapped store.
+func (s *recordingStore) Touch(key string) error {
+	err := s.Store.Touch(key)
+	s.log.record(Op{Method: "Touch", Key: key}, nil, err)
+	return err
+}
+
+// Replay applies the operations recorded by log to target, in the same order,
+// and returns the number of operations whose outcome (success or failure)
+// differs from the recorded one. When values were not captured, the digest of
+// each value is written instead.
+//
+// Errors:
+// NotSupportedError when log has an unknown operation, or an atomic operation
+// and target is not an AtomicStore, or GetAndReset and target is not a
+// ResettableStore.
+func Replay(log *OpLog, target Store) (int, error) {
+	as, _ := target.(AtomicStore)
+	rs, _ := target.(ResettableStore)
+	mismatches := 0
+	for _, op := range log.Ops() {
+		value := op.Value
+		if value == nil {
+			value = op.Digest
+		}
+
+		switch op.Method {
+		case "Decrement", "DecrementBy", "Increment", "IncrementBy":
+			if as == nil {
+				return mismatches, dot.NotSupportedError(op.Method)
+			}
+		case "GetAndReset":
+			if rs == nil {
+				return mismatches, dot.NotSupportedError(op.Method)
+			}
+		}
+
+		var err error
+		switch op.Method {
+		case "Add":
+			err = target.Add(op.Key, value)
+		case "Count":
+			_, err = target.Count()
+		case "Decrement":
+			_, err = as.Decrement(op.Key)
+		case "DecrementBy":
+			_, err = as.DecrementBy(op.Key, op.Delta)
+		case "Delete":
+			err = target.Delete(op.Key)
+		case "Exists":
+			_, err = target.Exists(op.Key)
+		case "Flush":
+			err = target.Flush()
+		case "Get":
+			var ref interface{}
+			err = target.Get(op.Key, &ref)
+		case "GetAndReset":
+			_, err = rs.GetAndReset(op.Key)
+		case "Increment":
+			_, err = as.Increment(op.Key)
+		case "IncrementBy":
+			_, err = as.IncrementBy(op.Key, op.Delta)
+		case "Keys":
+			_, err = target.Keys()
+		case "Range":
+			err = target.Range(func(string, interface{}) bool {
+				return true
+			})
+		case "Set":
+			err = target.Set(op.Key, value)
+		case "SetLifetime":
+			err = target.SetLifetime(op.Lifetime, op.Scope)
+		case "SetTransient":
+			target.SetTransient(op.Transient)
+		case "TTL":
+			_, err = target.TTL(op.Key)
+		case "Touch":
+			err = target.Touch(op.Key)
+		default:
+			return mismatches, dot.NotSupportedError(op.Method)
+		}
+
+		if (err != nil) != (op.Error != "") {
+			mismatches++
+		}
+	}
+
+	return mismatches, nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+)
+
+func TestRecording(t *testing.T) {
+	store, log := data.Recording(memstore.New(time.Minute, false))
+	log.CaptureValues(true)
+
+	store.Add("v1", "lorem")
+	store.Add("v1", "ipsum")
+	store.Set("v1", "dolor")
+	store.(data.AtomicStore).IncrementBy("c1", 5)
+	store.Delete("v2")
+
+	ops := log.Ops()
+	if len(ops) != 5 {
+		t.Fatalf("Expected 5 recorded operations but got %d", len(ops))
+	}
+	if ops[1].Method != "Add" || ops[1].Error == "" {
+		t.Errorf("The failed Add was not recorded: %v", ops[1])
+	}
+	if ops[3].Method != "IncrementBy" || ops[3].Delta != 5 {
+		t.Errorf("The IncrementBy was not recorded: %v", ops[3])
+	}
+
+	var buf bytes.Buffer
+	if err := log.Save(&buf); err != nil {
+		t.Fatalf("Could not save operation log: %v", err)
+	}
+	loaded, err := data.LoadOpLog(&buf)
+	if err != nil {
+		t.Fatalf("Could not load operation log: %v", err)
+	}
+
+	target := memstore.New(time.Minute, false)
+	mismatches, err := data.Replay(loaded, target)
+	if err != nil {
+		t.Fatalf("Could not replay operation log: %v", err)
+	}
+	if mismatches != 0 {
+		t.Errorf("Expected no mismatches but got %d", mismatches)
+	}
+
+	var result string
+	if err := target.Get("v1", &result); err != nil || result != "dolor" {
+		t.Errorf("The value v1 was not replayed: '%s' %v", result, err)
+	}
+	var counter int
+	if err := target.Get("c1", &counter); err != nil || counter != 5 {
+		t.Errorf("The value c1 was not replayed: %d %v", counter, err)
+	}
+}
+
+func TestRecordingDigest(t *testing.T) {
+	store, log := data.Recording(memstore.New(time.Minute, false))
+	store.Add("v1", "lorem")
+
+	op := log.Ops()[0]
+	if op.Value != nil || op.Digest == "" {
+		t.Errorf("Only the value digest should be recorded: %v", op)
+	}
+}
+
+func TestRecordingClose(t *testing.T) {
+	store, log := data.Recording(memstore.New(time.Minute, false))
+	store.Add("v1", "lorem")
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Could not close store: %v", err)
+	}
+	if count, _ := store.Count(); count != 0 {
+		t.Errorf("Closing should delete every value but %d remain", count)
+	}
+	if len(log.Ops()) != 2 {
+		t.Errorf("Close should not be recorded: %v", log.Ops())
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package redisstore provides Redis-backed data store implementation.
+
+Store
+
+A Store provides Redis-backed key:value cache that expires after defined
+duration of time. That duration is defined when a new instance is initialized
+calling 'redisstore.New()' function and it is used to all new stored values.
+The expiration is handled by Redis itself, which removes a key when its TTL is
+elapsed.
+
+Every key is stored prefixed by the key prefix of Store, so multiple stores can
+share the same Redis database. Counting and flushing values only touch the keys
+of current Store.
+
+The lifetime for new values and existing values can be modified calling
+'SetLifetime()'.
+
+The expiration behaviour can be changed calling 'SetTransient()' to define
+whether the lifetime of stored value is fixed (transient) or is extended when
+it is read or written (non-transient).
+
+Values
+
+Integers are stored as Redis integers, so they can be changed by atomic
+operations. Any other value is serialized by msgpack, as MongoDB store does,
+unless another codec is defined calling 'SetCodec()'. Serialized values are
+prefixed by a zero byte, so a serialized value is never read as an integer.
+*/
+package redisstore
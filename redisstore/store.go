@@ -0,0 +1,686 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package redisstore
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/codec"
+	"gopkg.in/raiqub/dot.v1"
+	"gopkg.in/redis.v5"
+)
+
+const (
+	// scanCount defines how many keys are requested to Redis by each SCAN
+	// iteration.
+	scanCount = 100
+
+	// notIntegerErrorPrefix defines the prefix of Redis error when an atomic
+	// operation is applied to a value that is not integer.
+	notIntegerErrorPrefix = "ERR value is not an integer"
+
+	// encodedPrefix marks values serialized by the codec, which are never
+	// read as integers, even when their representation is numeric.
+	encodedPrefix = "\x00"
+)
+
+// patternEscaper escapes glob-style characters of key prefix for SCAN.
+var patternEscaper = strings.NewReplacer(
+	`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// errRangeStopped stops scanning keys when the function given to Range returns
+// false.
+var errRangeStopped = errors.New("range stopped")
+
+// A Store provides a Redis-backed key:value cache that expires after defined
+// duration of time.
+//
+// It is a implementation of Store interface.
+type Store struct {
+	client      *redis.Client
+	prefix      string
+	lifetime    time.Duration
+	isTransient bool
+	codec       data.Codec
+}
+
+// New creates a new instance of Redis Store and defines the lifetime of stored
+// items. Every key is stored prefixed by keyPrefix. The stored items lifetime
+// are renewed when it is read or written.
+//
+// The store does not own client, which must be closed by caller.
+func New(client *redis.Client, keyPrefix string, d time.Duration) *Store {
+	return &Store{
+		client:   client,
+		prefix:   keyPrefix,
+		lifetime: d,
+		codec:    codec.Msgpack{},
+	}
+}
+
+// decode stores the value represented by raw in the value pointed to by ref.
+//
+// Errors:
+// data.InvalidTypeError when the value cannot be decoded into the type of ref.
+func (s *Store) decode(raw string, ref interface{}) error {
+	if !strings.HasPrefix(raw, encodedPrefix) {
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return data.NewInvalidTypeError(ref)
+		}
+		switch t := ref.(type) {
+		case *int:
+			*t = i
+		case *interface{}:
+			// Generic values are decoded as data.GenericValue defines
+			*t = int64(i)
+		default:
+			return data.NewInvalidTypeError(ref)
+		}
+		return nil
+	}
+
+	if _, ok := ref.(*int); ok {
+		return data.NewInvalidTypeError(ref)
+	}
+	b := []byte(raw[len(encodedPrefix):])
+	if err := s.codec.Unmarshal(b, ref); err != nil {
+		return data.NewInvalidTypeError(ref)
+	}
+	if t, ok := ref.(*interface{}); ok {
+		*t = data.GenericValue(*t)
+	}
+	return nil
+}
+
+// encode returns the representation of value stored by Redis. Integers are
+// kept as Redis integers, so they can be changed by atomic operations. Other
+// values are serialized by the codec and prefixed by encodedPrefix.
+func (s *Store) encode(value interface{}) (string, error) {
+	if i, ok := value.(int); ok {
+		return strconv.Itoa(i), nil
+	}
+
+	b, err := s.codec.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return encodedPrefix + string(b), nil
+}
+
+// replace replaces the value stored by specified key by the value returned by
+// fn, which receives the current value. The lifetime of value is renewed
+// unless the store is transient, then the remaining TTL is kept. It is retried
+// while the key is changed by another client.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) replace(key string, fn func(raw string) (string, error)) error {
+	pkey := s.prefix + key
+	for {
+		err := s.client.Watch(func(tx *redis.Tx) error {
+			ttl, err := tx.PTTL(pkey).Result()
+			if err != nil {
+				return err
+			}
+			raw, err := tx.Get(pkey).Result()
+			if err == redis.Nil {
+				return dot.InvalidKeyError(key)
+			} else if err != nil {
+				return err
+			}
+
+			value, err := fn(raw)
+			if err != nil {
+				return err
+			}
+
+			lifetime := s.lifetime
+			if s.isTransient {
+				// A value about to expire keeps the shortest TTL instead of
+				// none
+				lifetime = ttl
+				if lifetime == 0 {
+					lifetime = time.Millisecond
+				}
+			}
+			_, err = tx.Pipelined(func(pipe *redis.Pipeline) error {
+				pipe.Set(pkey, value, lifetime)
+				return nil
+			})
+			return err
+		}, pkey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// scan calls fn for each batch of keys stored by current instance, which are
+// prefixed by key prefix.
+func (s *Store) scan(fn func(keys []string) error) error {
+	match := patternEscaper.Replace(s.prefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(cursor, match, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Add adds a new key:value to current store.
+//
+// Errors:
+// dot.DuplicatedKeyError when requested key already exists.
+func (s *Store) Add(key string, value interface{}) error {
+	raw, err := s.encode(value)
+	if err != nil {
+		return err
+	}
+
+	ok, err := s.client.SetNX(s.prefix+key, raw, s.lifetime).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dot.DuplicatedKeyError(key)
+	}
+
+	return nil
+}
+
+func (s *Store) atomicInteger(key string, inc int) (int, error) {
+	pkey := s.prefix + key
+	var incr *redis.IntCmd
+	var ttl *redis.DurationCmd
+	s.client.TxPipelined(func(pipe *redis.Pipeline) error {
+		incr = pipe.IncrBy(pkey, int64(inc))
+		if s.isTransient {
+			ttl = pipe.PTTL(pkey)
+		} else {
+			pipe.PExpire(pkey, s.lifetime)
+		}
+		return nil
+	})
+
+	value, err := incr.Result()
+	if err != nil {
+		if strings.HasPrefix(err.Error(), notIntegerErrorPrefix) {
+			return 0, data.NewInvalidTypeError(new(int))
+		}
+		return 0, err
+	}
+
+	// A key created by INCRBY has no TTL
+	if ttl != nil && ttl.Val() == -time.Millisecond {
+		if err := s.client.PExpire(pkey, s.lifetime).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(value), nil
+}
+
+// Close does nothing, since current store does not own its client, which must
+// be closed by caller.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Count gets the number of stored values by current instance.
+func (s *Store) Count() (int, error) {
+	// SCAN may return the same key more than once
+	found := make(map[string]struct{})
+	err := s.scan(func(keys []string) error {
+		for _, k := range keys {
+			found[k] = struct{}{}
+		}
+		return nil
+	})
+	return len(found), err
+}
+
+// Decrement atomically gets the value stored by specified key and
+// decrements it by one. If the key does not exist, it is created.
+//
+// Errors:
+// data.InvalidTypeError when the value stored at key is not integer.
+func (s *Store) Decrement(key string) (int, error) {
+	return s.atomicInteger(key, -1)
+}
+
+// DecrementBy atomically gets the value stored by specified key and
+// decrements it by value. If the key does not exist, it is created.
+//
+// Errors:
+// data.InvalidTypeError when the value stored at key is not integer.
+func (s *Store) DecrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, -1*value)
+}
+
+// Delete deletes the specified value.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Delete(key string) error {
+	n, err := s.client.Del(s.prefix + key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return dot.InvalidKeyError(key)
+	}
+
+	return nil
+}
+
+// Exists reports whether a value is stored by specified key, without renewing
+// it.
+func (s *Store) Exists(key string) (bool, error) {
+	return s.client.Exists(s.prefix + key).Result()
+}
+
+// Flush deletes any value stored by current instance, keeping the keys of other
+// prefixes.
+func (s *Store) Flush() error {
+	return s.scan(func(keys []string) error {
+		return s.client.Del(keys...).Err()
+	})
+}
+
+// Get gets the value stored by specified key and stores the result in the
+// value pointed to by ref.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+//
+// data.InvalidTypeError when the value cannot be decoded into the type of ref.
+func (s *Store) Get(key string, ref interface{}) error {
+	pkey := s.prefix + key
+	var get *redis.StringCmd
+	s.client.TxPipelined(func(pipe *redis.Pipeline) error {
+		get = pipe.Get(pkey)
+		if !s.isTransient {
+			pipe.PExpire(pkey, s.lifetime)
+		}
+		return nil
+	})
+
+	raw, err := get.Result()
+	if err == redis.Nil {
+		return dot.InvalidKeyError(key)
+	} else if err != nil {
+		return err
+	}
+
+	// A reference to pointer is filled with a newly allocated value
+	return s.decode(raw, data.IndirectRef(ref))
+}
+
+// GetMany gets the values stored by specified keys using a single MGET
+// command. Missing keys are absent from returned values.
+//
+// A value which could not be decoded is skipped without failing other keys;
+// its error is reported by a data.BatchError returned along with the decoded
+// values.
+func (s *Store) GetMany(keys []string) (map[string]interface{}, error) {
+	if len(keys) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	pkeys := make([]string, len(keys))
+	for i, key := range keys {
+		pkeys[i] = s.prefix + key
+	}
+
+	var mget *redis.SliceCmd
+	_, err := s.client.TxPipelined(func(pipe *redis.Pipeline) error {
+		mget = pipe.MGet(pkeys...)
+		if !s.isTransient {
+			for _, pkey := range pkeys {
+				pipe.PExpire(pkey, s.lifetime)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(data.BatchError)
+	values := make(map[string]interface{}, len(keys))
+	for i, raw := range mget.Val() {
+		str, ok := raw.(string)
+		if !ok {
+			continue
+		}
+
+		var value interface{}
+		if err := s.decode(str, &value); err != nil {
+			errs[keys[i]] = err
+			continue
+		}
+		values[keys[i]] = value
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// GetAndReset atomically gets the integer value stored by specified key and
+// resets it to zero.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+// data.InvalidTypeError when the value stored at key is not integer.
+func (s *Store) GetAndReset(key string) (int, error) {
+	var value int
+	err := s.replace(key, func(raw string) (string, error) {
+		var err error
+		if value, err = strconv.Atoi(raw); err != nil {
+			return "", data.NewInvalidTypeError(new(int))
+		}
+		return "0", nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// GetOrAdd gets the value stored by specified key into the value pointed to by
+// ref, or stores the value computed by factory whether the key is missing.
+// The value is added only whether the key is still missing, then concurrent
+// callers may both run factory, but every caller reads the value added first.
+//
+// An error returned by factory is returned without storing anything.
+func (s *Store) GetOrAdd(
+	key string, ref interface{}, factory data.FactoryFunc,
+) error {
+	return data.GetOrAdd(s, key, ref, factory)
+}
+
+// Increment atomically gets the value stored by specified key and
+// increments it by one. If the key does not exist, it is created.
+//
+// Errors:
+// data.InvalidTypeError when the value stored at key is not integer.
+func (s *Store) Increment(key string) (int, error) {
+	return s.atomicInteger(key, 1)
+}
+
+// IncrementBy atomically gets the value stored by specified key and
+// increments it by value. If the key does not exist, it is created. An expired
+// value is removed by Redis, so it is handled as a missing key.
+//
+// Errors:
+// data.InvalidTypeError when the value stored at key is not integer, which is
+// kept unchanged.
+func (s *Store) IncrementBy(key string, value int) (int, error) {
+	return s.atomicInteger(key, value)
+}
+
+// Keys gets the sorted keys of stored values by current instance, without key
+// prefix.
+func (s *Store) Keys() ([]string, error) {
+	// SCAN may return the same key more than once
+	found := make(map[string]struct{})
+	err := s.scan(func(keys []string) error {
+		for _, k := range keys {
+			found[strings.TrimPrefix(k, s.prefix)] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Range calls fn for each stored value, in no particular order, until fn
+// returns false. The keys are scanned in batches, whose values are read by a
+// single MGET command, and the values are not renewed. Since no lock is held,
+// fn may call back into current store; values changed meanwhile may or may not
+// be seen.
+//
+// Errors:
+// data.InvalidTypeError when a value could not be decoded, which stops
+// iteration.
+func (s *Store) Range(fn func(key string, value interface{}) bool) error {
+	// SCAN may return the same key more than once
+	seen := make(map[string]struct{})
+	err := s.scan(func(keys []string) error {
+		raws, err := s.client.MGet(keys...).Result()
+		if err != nil {
+			return err
+		}
+
+		for i, raw := range raws {
+			str, ok := raw.(string)
+			if !ok {
+				continue
+			}
+			key := strings.TrimPrefix(keys[i], s.prefix)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			var value interface{}
+			if err := s.decode(str, &value); err != nil {
+				return err
+			}
+			if !fn(key, value) {
+				return errRangeStopped
+			}
+		}
+		return nil
+	})
+	if err == errRangeStopped {
+		return nil
+	}
+	return err
+}
+
+// Set sets the value of specified key.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Set(key string, value interface{}) error {
+	raw, err := s.encode(value)
+	if err != nil {
+		return err
+	}
+
+	if s.isTransient {
+		return s.replace(key, func(string) (string, error) {
+			return raw, nil
+		})
+	}
+
+	ok, err := s.client.SetXX(s.prefix+key, raw, s.lifetime).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dot.InvalidKeyError(key)
+	}
+
+	return nil
+}
+
+// SetMany sets the values of specified keys using a single transaction.
+// Unlike Set, a missing key is created. When current store is transient the
+// remaining lifetime of existing values is kept, although it is read apart from
+// the transaction.
+//
+// A value which could not be encoded is skipped without failing other keys;
+// its error is reported by a data.BatchError.
+func (s *Store) SetMany(values map[string]interface{}) error {
+	errs := make(data.BatchError)
+	raws := make(map[string]string, len(values))
+	for key, value := range values {
+		raw, err := s.encode(value)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		raws[s.prefix+key] = raw
+	}
+
+	ttls := make(map[string]*redis.DurationCmd, len(raws))
+	if s.isTransient && len(raws) > 0 {
+		_, err := s.client.Pipelined(func(pipe *redis.Pipeline) error {
+			for pkey := range raws {
+				ttls[pkey] = pipe.PTTL(pkey)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(raws) > 0 {
+		_, err := s.client.TxPipelined(func(pipe *redis.Pipeline) error {
+			for pkey, raw := range raws {
+				lifetime := s.lifetime
+				if ttl, ok := ttls[pkey]; ok && ttl.Val() > 0 {
+					lifetime = ttl.Val()
+				}
+				pipe.Set(pkey, raw, lifetime)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SetCodec defines the codec used to serialize values that are not integers,
+// which is msgpack by default.
+func (s *Store) SetCodec(c data.Codec) {
+	s.codec = c
+}
+
+// SetLifetime modifies the lifetime for new stored items or for existing items
+// when it is read or written. The ScopeAll renews the TTL of every existing
+// item to new lifetime.
+//
+// Errors:
+// dot.NotSupportedError when ScopeNew is specified.
+func (s *Store) SetLifetime(d time.Duration, scope data.LifetimeScope) error {
+	switch scope {
+	case data.ScopeAll:
+		err := s.scan(func(keys []string) error {
+			_, err := s.client.Pipelined(func(pipe *redis.Pipeline) error {
+				for _, k := range keys {
+					pipe.PExpire(k, d)
+				}
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	case data.ScopeNewAndUpdated:
+	case data.ScopeNew:
+		return dot.NotSupportedError("ScopeNew")
+	default:
+		return dot.NotSupportedError(strconv.Itoa(int(scope)))
+	}
+
+	s.lifetime = d
+	return nil
+}
+
+// SetTransient defines whether should extends expiration of stored value
+// when it is read or written.
+func (s *Store) SetTransient(value bool) {
+	s.isTransient = value
+}
+
+// TTL gets the remaining lifetime of the value stored by specified key, which
+// is data.NoExpiration when it has no TTL. Expired values are removed by Redis,
+// hence they are reported as missing.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(s.prefix + key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	switch ttl {
+	case -2 * time.Millisecond:
+		return 0, dot.InvalidKeyError(key)
+	case -time.Millisecond:
+		return data.NoExpiration, nil
+	}
+	return ttl, nil
+}
+
+// Touch renews the lifetime of the value stored by specified key without
+// reading it, even when current store is transient.
+//
+// Errors:
+// dot.InvalidKeyError when requested key could not be found.
+func (s *Store) Touch(key string) error {
+	ok, err := s.client.PExpire(s.prefix+key, s.lifetime).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dot.InvalidKeyError(key)
+	}
+
+	return nil
+}
+
+var _ data.ResettableStore = (*Store)(nil)
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package redisstore
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/raiqub/data/testdata"
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/codec"
+	"gopkg.in/redis.v5"
+)
+
+const (
+	keyPrefix   = "raiqub:"
+	defaultAddr = "localhost:6379"
+)
+
+func TestRedisStore(t *testing.T) {
+	client := prepareRedisClient(t)
+	defer client.Close()
+
+	store := New(client, keyPrefix, time.Millisecond)
+
+	testdata.TestAtomic(store, t)
+
+	store.Flush()
+	testdata.TestExpiration(store, t)
+
+	store.Flush()
+	testdata.TestValueHandling(store, t)
+
+	store.Flush()
+	testdata.TestKeyCollision(store, t)
+
+	store.Flush()
+	testdata.TestPostpone(store, t)
+
+	store.Flush()
+	testdata.TestTransient(store, t)
+
+	store.Flush()
+	testdata.TestKeys(store, t)
+
+	store.Flush()
+	testdata.TestExists(store, t)
+
+	store.Flush()
+	testdata.TestGetOrAdd(store, t)
+
+	store.Flush()
+	testdata.TestTTL(store, t)
+
+	store.Flush()
+	testdata.TestGetSetMany(store, t)
+
+	store.Flush()
+	testdata.TestRange(store, t)
+
+	store.Flush()
+	testdata.TestTouch(store, t)
+
+	store.Flush()
+	testdata.TestTypeError(store, t)
+
+	store.Flush()
+	testdata.TestGenericDecode(store, t)
+
+	store.Flush()
+	testdata.TestIncrementBy(store, t)
+
+	store.Flush()
+	testdata.TestGetAndReset(store, t)
+}
+
+func TestFlushKeepsOtherPrefixes(t *testing.T) {
+	client := prepareRedisClient(t)
+	defer client.Close()
+
+	store := New(client, keyPrefix, time.Minute)
+	other := New(client, "other:", time.Minute)
+	store.Flush()
+	other.Flush()
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	if err := other.Add("v1", 2); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Could not flush values: %v", err)
+	}
+	if count, _ := store.Count(); count != 0 {
+		t.Errorf("Expected no remaining value but got %d", count)
+	}
+
+	var result int
+	if err := other.Get("v1", &result); err != nil || result != 2 {
+		t.Errorf("Expected value 2 of other prefix but got %d: %v",
+			result, err)
+	}
+	other.Flush()
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	// Encoding needs no Redis connection
+	store := New(nil, keyPrefix, time.Minute)
+	values := []interface{}{int64(2), int64(50), int64(-7), "123", "-5"}
+	for _, c := range []data.Codec{codec.Msgpack{}, codec.JSON{}} {
+		store.SetCodec(c)
+		for _, value := range values {
+			raw, err := store.encode(value)
+			if err != nil {
+				t.Fatalf("Could not encode %v by %s: %v", value, c.Name(), err)
+			}
+
+			ref := reflect.New(reflect.TypeOf(value))
+			if err := store.decode(raw, ref.Interface()); err != nil {
+				t.Errorf("Could not decode %v by %s: %v", value, c.Name(), err)
+			}
+			if result := ref.Elem().Interface(); result != value {
+				t.Errorf("Expected %#v decoded by %s but got %#v",
+					value, c.Name(), result)
+			}
+		}
+	}
+
+	raw, err := store.encode(42)
+	if err != nil || raw != "42" {
+		t.Errorf("Expected integer stored as is but got %q: %v", raw, err)
+	}
+	var result int
+	if err := store.decode(raw, &result); err != nil || result != 42 {
+		t.Errorf("Expected integer 42 but got %d: %v", result, err)
+	}
+}
+
+func BenchmarkRedisStoreAddGet(b *testing.B) {
+	client := prepareRedisClient(b)
+	defer client.Close()
+
+	store := New(client, keyPrefix, time.Second)
+	testdata.BenchmarkAddGet(store, b)
+}
+
+func BenchmarkRedisAtomicIncrement(b *testing.B) {
+	client := prepareRedisClient(b)
+	defer client.Close()
+
+	store := New(client, keyPrefix, time.Second)
+	store.SetTransient(true)
+	testdata.BenchmarkAtomicIncrement(store, b)
+}
+
+// prepareRedisClient connects to the Redis server defined by REDIS_ADDR
+// environment variable, skipping the test when it is not reachable.
+func prepareRedisClient(tb testing.TB) *redis.Client {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		tb.Skipf("This test cannot be run because Redis is not acessible: %v",
+			err)
+	}
+
+	return client
+}
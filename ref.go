@@ -0,0 +1,39 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import "reflect"
+
+// IndirectRef returns a pointer to the innermost value referenced by ref,
+// allocating every nil pointer on the way. For instance, when ref is a **T the
+// result is a *T which is also stored into ref. Any other ref is returned
+// unchanged.
+func IndirectRef(ref interface{}) interface{} {
+	v := reflect.ValueOf(ref)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ref
+	}
+
+	for v.Elem().Kind() == reflect.Ptr {
+		if v.Elem().IsNil() {
+			v.Elem().Set(reflect.New(v.Elem().Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	return v.Interface()
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import "sync/atomic"
+
+// Stats represents the effectiveness of a store as a cache, counted since it
+// was created or its statistics were reset.
+type Stats struct {
+	// Hits is the number of Get calls which found the requested key.
+	Hits uint64
+	// Misses is the number of Get calls which did not find the requested key.
+	Misses uint64
+	// Additions is the number of values added.
+	Additions uint64
+	// Evictions is the number of values removed by other reason than
+	// deletion, like expiration.
+	Evictions uint64
+	// Size is the number of values currently stored.
+	Size int
+}
+
+// A StatsProvider represents a store which reports its effectiveness as a
+// cache.
+type StatsProvider interface {
+	// Stats gets the statistics counted since the store was created or its
+	// statistics were reset, along with the number of stored values.
+	Stats() (Stats, error)
+}
+
+// A StatsCounter counts the operations reported by Stats using atomic
+// operations, so it can be updated by concurrent operations without locking.
+// The zero value is ready to use.
+type StatsCounter struct {
+	// Fields are accessed atomically and must be 64-bit aligned.
+	hits      uint64
+	misses    uint64
+	additions uint64
+	evictions uint64
+}
+
+// Added counts the addition of n values.
+func (c *StatsCounter) Added(n int) {
+	atomic.AddUint64(&c.additions, uint64(n))
+}
+
+// Evicted counts the eviction of n values.
+func (c *StatsCounter) Evicted(n int) {
+	atomic.AddUint64(&c.evictions, uint64(n))
+}
+
+// Evictions returns the number of evicted values.
+func (c *StatsCounter) Evictions() uint64 {
+	return atomic.LoadUint64(&c.evictions)
+}
+
+// Observe counts the result of a Get call: a call returning no error is a hit
+// and a call returning InvalidKeyError or ErrNegativelyCached is a miss. Other
+// errors are not counted.
+func (c *StatsCounter) Observe(err error) {
+	if err == nil {
+		atomic.AddUint64(&c.hits, 1)
+	} else if isMissing(err) {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+// Reset sets every counter to zero.
+func (c *StatsCounter) Reset() {
+	atomic.StoreUint64(&c.hits, 0)
+	atomic.StoreUint64(&c.misses, 0)
+	atomic.StoreUint64(&c.additions, 0)
+	atomic.StoreUint64(&c.evictions, 0)
+}
+
+// Stats returns the current value of every counter along with specified size.
+func (c *StatsCounter) Stats(size int) Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Additions: atomic.LoadUint64(&c.additions),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Size:      size,
+	}
+}
@@ -16,7 +16,11 @@
 
 package data
 
-import "time"
+import (
+	"time"
+
+	"gopkg.in/raiqub/dot.v1"
+)
 
 // A Store represents a data store whose its stored values expires after
 // specific elapsed time since its creation or last access.
@@ -27,26 +31,30 @@ type Store interface {
 	// DuplicatedKeyError when requested key already exists.
 	Add(key string, value interface{}) error
 
+	// Close releases the resources of current store and stops its
+	// background work, like garbage collectors. What a store does once it is
+	// closed depends on the implementation.
+	Close() error
+
 	// Count gets the number of stored values by current instance.
 	//
 	// Errors:
 	// NotSupportedError when current method cannot be implemented.
 	Count() (int, error)
 
-	// Decrement atomically gets the value stored by specified key and
-	// decrements it by one. If the key does not exist, it is created.
-	Decrement(key string) (int, error)
-
-	// DecrementBy atomically gets the value stored by specified key and
-	// decrements it by value. If the key does not exist, it is created.
-	DecrementBy(key string, value int) (int, error)
-
 	// Delete deletes the specified value.
 	//
 	// Errors:
 	// InvalidKeyError when requested key could not be found.
 	Delete(key string) error
 
+	// Exists reports whether a value is stored by specified key, without
+	// decoding it nor extending its expiration.
+	//
+	// Errors:
+	// NotSupportedError when current method cannot be implemented.
+	Exists(key string) (bool, error)
+
 	// Flush deletes any cached value into current instance.
 	//
 	// Errors:
@@ -60,13 +68,20 @@ type Store interface {
 	// InvalidKeyError when requested key could not be found.
 	Get(key string, ref interface{}) error
 
-	// Increment atomically gets the value stored by specified key and
-	// increments it by one. If the key does not exist, it is created.
-	Increment(key string) (int, error)
+	// Keys gets the keys of stored values by current instance, excluding
+	// expired values.
+	//
+	// Errors:
+	// NotSupportedError when current method cannot be implemented.
+	Keys() ([]string, error)
 
-	// IncrementBy atomically gets the value stored by specified key and
-	// increments it by value. If the key does not exist, it is created.
-	IncrementBy(key string, value int) (int, error)
+	// Range calls fn for each stored value by current instance, excluding
+	// expired values, until fn returns false. The values are decoded into an
+	// empty interface, as GenericValue defines, and they are not renewed.
+	//
+	// Errors:
+	// NotSupportedError when current method cannot be implemented.
+	Range(fn func(key string, value interface{}) bool) error
 
 	// Set sets the value of specified key.
 	//
@@ -83,4 +98,81 @@ type Store interface {
 	// SetTransient defines whether should extends expiration of stored value
 	// when it is read or written.
 	SetTransient(bool)
+
+	// TTL gets the remaining lifetime of the value stored by specified key,
+	// which is zero or negative when it is expired but not removed yet.
+	//
+	// Errors:
+	// InvalidKeyError when requested key could not be found.
+	// NotSupportedError when current method cannot be implemented.
+	TTL(key string) (time.Duration, error)
+
+	// Touch renews the lifetime of the value stored by specified key without
+	// reading it, even when current store is transient.
+	//
+	// Errors:
+	// InvalidKeyError when requested key could not be found.
+	// NotSupportedError when current method cannot be implemented.
+	Touch(key string) error
+}
+
+// An AtomicStore represents a Store which supports atomic operations on
+// integer values, allowing callers to require them at compile time.
+type AtomicStore interface {
+	Store
+
+	// Decrement atomically gets the value stored by specified key and
+	// decrements it by one. If the key does not exist, it is created.
+	Decrement(key string) (int, error)
+
+	// DecrementBy atomically gets the value stored by specified key and
+	// decrements it by value. If the key does not exist, it is created.
+	DecrementBy(key string, value int) (int, error)
+
+	// Increment atomically gets the value stored by specified key and
+	// increments it by one. If the key does not exist, it is created.
+	Increment(key string) (int, error)
+
+	// IncrementBy atomically gets the value stored by specified key and
+	// increments it by value. If the key does not exist or its value is
+	// expired, it is created.
+	//
+	// Errors:
+	// InvalidTypeError when the value stored at key is not integer, which is
+	// kept unchanged.
+	IncrementBy(key string, value int) (int, error)
+}
+
+// A ResettableStore represents an AtomicStore which can read and reset an
+// integer value at once, like a counter collected periodically. It is
+// optional, hence callers type-assert for it.
+type ResettableStore interface {
+	AtomicStore
+
+	// GetAndReset atomically gets the integer value stored by specified key
+	// and resets it to zero.
+	//
+	// Errors:
+	// InvalidKeyError when requested key could not be found.
+	GetAndReset(key string) (int, error)
+}
+
+// atomicOf returns s as an AtomicStore, whether it supports atomic operations.
+// Otherwise, it returns NotSupportedError for specified method.
+func atomicOf(s Store, method string) (AtomicStore, error) {
+	as, ok := s.(AtomicStore)
+	if !ok {
+		return nil, dot.NotSupportedError(method)
+	}
+	return as, nil
+}
+
+// resettableOf returns s as a ResettableStore, whether it supports
+// GetAndReset. Otherwise, it returns NotSupportedError.
+func resettableOf(s Store) (ResettableStore, error) {
+	rs, ok := s.(ResettableStore)
+	if !ok {
+		return nil, dot.NotSupportedError("GetAndReset")
+	}
+	return rs, nil
 }
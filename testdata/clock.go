@@ -0,0 +1,50 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testdata
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock provides a controllable time to stores that support it, which
+// allows to test expiration without waiting for real elapsed time.
+type Clock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+// NewClock creates a new instance of Clock set to current local time.
+func NewClock() *Clock {
+	return &Clock{now: time.Now()}
+}
+
+// Advance moves forward the time of current clock by specified duration.
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Now returns the time of current clock.
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
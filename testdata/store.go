@@ -19,7 +19,11 @@
 package testdata
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,7 +31,7 @@ import (
 	"gopkg.in/raiqub/dot.v1"
 )
 
-func TestAtomic(store data.Store, t *testing.T) {
+func TestAtomic(store data.AtomicStore, t *testing.T) {
 	if err := store.SetLifetime(time.Hour*1, data.ScopeAll); err != nil {
 		t.Skip("Set lifetime to all items is not supported")
 	}
@@ -70,7 +74,179 @@ func TestAtomic(store data.Store, t *testing.T) {
 	}
 }
 
+func TestIncrementBy(store data.AtomicStore, t *testing.T) {
+	testIncrementBy(store, t, time.Sleep)
+}
+
+func TestIncrementByWithClock(
+	store data.AtomicStore, clock *Clock, t *testing.T,
+) {
+	testIncrementBy(store, t, clock.Advance)
+}
+
+func testIncrementBy(
+	store data.AtomicStore, t *testing.T, sleep func(time.Duration),
+) {
+	if err := store.SetLifetime(time.Millisecond*100, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	if value, err := store.IncrementBy("c1", 5); err != nil || value != 5 {
+		t.Errorf("Expected 5 but got %d: %v", value, err)
+	}
+	if value, err := store.IncrementBy("c1", -7); err != nil || value != -2 {
+		t.Errorf("Expected -2 but got %d: %v", value, err)
+	}
+
+	if err := store.Add("s1", "lorem"); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if _, err := store.IncrementBy("s1", 3); err == nil {
+		t.Error("A non-integer value should not be incremented")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected type error but got %v", err)
+	}
+	var str string
+	if err := store.Get("s1", &str); err != nil || str != "lorem" {
+		t.Errorf("A non-integer value should be kept, got %q: %v", str, err)
+	}
+
+	sleep(time.Millisecond * 300)
+	if value, err := store.IncrementBy("c1", 3); err != nil || value != 3 {
+		t.Errorf("An expired value should be restarted, got %d: %v",
+			value, err)
+	}
+}
+
+func TestGetAndReset(store data.ResettableStore, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	if _, err := store.GetAndReset("c1"); err == nil {
+		t.Error("A missing key should not be reset")
+	}
+
+	if _, err := store.IncrementBy("c1", 7); err != nil {
+		t.Errorf("Could not increment value: %v", err)
+	}
+	value, err := store.GetAndReset("c1")
+	if err != nil {
+		t.Errorf("Could not reset value: %v", err)
+	}
+	if value != 7 {
+		t.Errorf("The value of c1 should be 7 but got %d", value)
+	}
+
+	if err := store.Get("c1", &value); err != nil {
+		t.Errorf("The value c1 was not stored: %v", err)
+	}
+	if value != 0 {
+		t.Errorf("The value of c1 should be reset but got %d", value)
+	}
+
+	if err := store.Add("s1", "lorem"); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if _, err := store.GetAndReset("s1"); err == nil {
+		t.Error("A non-integer value should not be reset")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected type error but got %v", err)
+	}
+}
+
+func TestAddExpired(store data.Store, t *testing.T) {
+	testAddExpired(store, t, time.Sleep)
+}
+
+// TestAddExpiredWithClock runs TestAddExpired advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestAddExpiredWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testAddExpired(store, t, clock.Advance)
+}
+
+func testAddExpired(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Millisecond*100, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+
+	sleep(time.Millisecond * 300)
+
+	if err := store.Add("v1", 2); err != nil {
+		t.Errorf("The expired value v1 could not be replaced: %v", err)
+	}
+
+	var result int
+	if err := store.Get("v1", &result); err != nil {
+		t.Errorf("The value v1 was not stored: %v", err)
+	}
+	if result != 2 {
+		t.Errorf("The value of v1 should be 2 but got %d", result)
+	}
+}
+
+func TestDecrementAndDeleteAtZero(store data.AtomicStore, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	rc, ok := store.(interface {
+		DecrementAndDeleteAtZero(key string) (int, bool, error)
+	})
+	if !ok {
+		t.Skip("Decrement and delete at zero is not supported")
+	}
+
+	if _, err := store.IncrementBy("ref", 2); err != nil {
+		t.Errorf("Could not increment value: %v", err)
+	}
+
+	for _, expected := range []int{1, 0} {
+		value, deleted, err := rc.DecrementAndDeleteAtZero("ref")
+		if err != nil {
+			t.Errorf("Could not decrement value: %v", err)
+		}
+		if value != expected {
+			t.Errorf("Expected %d got %d", expected, value)
+		}
+		if deleted != (expected == 0) {
+			t.Errorf("Unexpected deleted state at %d: %v", expected, deleted)
+		}
+	}
+
+	var result int
+	if err := store.Get("ref", &result); err == nil {
+		t.Error("The value ref should be deleted at zero")
+	}
+	if _, _, err := rc.DecrementAndDeleteAtZero("ref"); err == nil {
+		t.Error("A missing key should return an error")
+	}
+
+	if err := store.Add("s1", "lorem"); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if _, _, err := rc.DecrementAndDeleteAtZero("s1"); err == nil {
+		t.Error("A non-integer value should not be decremented")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected type error but got %v", err)
+	}
+}
+
 func TestExpiration(store data.Store, t *testing.T) {
+	testExpiration(store, t, time.Sleep)
+}
+
+// TestExpirationWithClock runs TestExpiration advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestExpirationWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testExpiration(store, t, clock.Advance)
+}
+
+func testExpiration(store data.Store, t *testing.T, sleep func(time.Duration)) {
 	testValues := map[string]int{
 		"v1": 3,
 		"v2": 6,
@@ -95,7 +271,7 @@ func TestExpiration(store data.Store, t *testing.T) {
 		t.Errorf("The value v2 was not stored: %v", err)
 	}
 
-	time.Sleep(time.Second * 3)
+	sleep(time.Second * 3)
 
 	err := store.Get("v1", &result)
 	if _, ok := err.(dot.InvalidKeyError); !ok {
@@ -117,6 +293,16 @@ func TestExpiration(store data.Store, t *testing.T) {
 }
 
 func TestPostpone(store data.Store, t *testing.T) {
+	testPostpone(store, t, time.Sleep)
+}
+
+// TestPostponeWithClock runs TestPostpone advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestPostponeWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testPostpone(store, t, clock.Advance)
+}
+
+func testPostpone(store data.Store, t *testing.T, sleep func(time.Duration)) {
 	store.SetTransient(false)
 	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
 		t.Skip("Set lifetime to all items is not supported")
@@ -132,7 +318,7 @@ func TestPostpone(store data.Store, t *testing.T) {
 		t.Errorf("Could not add value: %v", err)
 	}
 
-	time.Sleep(time.Millisecond * 500)
+	sleep(time.Millisecond * 500)
 
 	var result int
 	if err := store.Get("v1", &result); err != nil {
@@ -148,7 +334,7 @@ func TestPostpone(store data.Store, t *testing.T) {
 		t.Errorf("Could not get value: %v", err)
 	}
 
-	time.Sleep(time.Millisecond * 600)
+	sleep(time.Millisecond * 600)
 	if err := store.Get("v1", &result); err != nil {
 		t.Errorf("Value expiration was not postponed: %v", err)
 	}
@@ -160,7 +346,179 @@ func TestPostpone(store data.Store, t *testing.T) {
 	}
 }
 
+func TestTouchMany(store data.Store, t *testing.T) {
+	testTouchMany(store, t, time.Sleep)
+}
+
+func TestTouchManyWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testTouchMany(store, t, clock.Advance)
+}
+
+func testTouchMany(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	tm, ok := store.(interface {
+		TouchMany(keys []string) (int, error)
+	})
+	if !ok {
+		t.Skip("Bulk touch is not supported")
+	}
+
+	store.SetTransient(true)
+	for _, key := range []string{"v1", "v2", "v3"} {
+		if err := store.Add(key, key); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+
+	sleep(time.Millisecond * 500)
+	count, err := tm.TouchMany([]string{"v1", "v2", "v4"})
+	if err != nil {
+		t.Errorf("Could not touch values: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 touched values but got %d", count)
+	}
+
+	sleep(time.Millisecond * 600)
+	var result string
+	for _, key := range []string{"v1", "v2"} {
+		if err := store.Get(key, &result); err != nil {
+			t.Errorf("Value expiration was not postponed: %v", err)
+		}
+	}
+	if err := store.Get("v3", &result); err == nil {
+		t.Error("The value v3 should be expired")
+	}
+}
+
+func TestMeta(store data.Store, t *testing.T) {
+	testMeta(store, t, time.Sleep)
+}
+
+func TestMetaWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testMeta(store, t, clock.Advance)
+}
+
+func testMeta(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	ms, ok := store.(interface {
+		Meta(key string) (data.EntryMeta, error)
+	})
+	if !ok {
+		t.Skip("Metadata is not supported")
+	}
+
+	if _, err := ms.Meta("v1"); err == nil {
+		t.Error("Metadata of a missing key should not be returned")
+	}
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	added, err := ms.Meta("v1")
+	if err != nil {
+		t.Fatalf("Could not get metadata: %v", err)
+	}
+	if added.CreatedAt.IsZero() || !added.UpdatedAt.Equal(added.CreatedAt) {
+		t.Errorf("Unexpected creation time %v and update time %v",
+			added.CreatedAt, added.UpdatedAt)
+	}
+	if !added.ExpiresAt.After(added.CreatedAt) {
+		t.Errorf("Expected expiration after %v but got %v",
+			added.CreatedAt, added.ExpiresAt)
+	}
+
+	sleep(time.Millisecond * 100)
+	if err := store.Set("v1", 2); err != nil {
+		t.Errorf("Could not set value: %v", err)
+	}
+	updated, err := ms.Meta("v1")
+	if err != nil {
+		t.Fatalf("Could not get metadata: %v", err)
+	}
+	if !updated.CreatedAt.Equal(added.CreatedAt) {
+		t.Errorf("Expected creation time %v but got %v",
+			added.CreatedAt, updated.CreatedAt)
+	}
+	if !updated.UpdatedAt.After(added.UpdatedAt) {
+		t.Errorf("Expected update time after %v but got %v",
+			added.UpdatedAt, updated.UpdatedAt)
+	}
+}
+
+func TestXFetch(store data.Store, t *testing.T) {
+	testXFetch(store, t, time.Sleep)
+}
+
+func TestXFetchWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testXFetch(store, t, clock.Advance)
+}
+
+func testXFetch(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	store.SetTransient(true)
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	xf, ok := store.(interface {
+		GetWithXFetch(key string, ref interface{}, beta float64) (bool, error)
+	})
+	if !ok {
+		t.Skip("Probabilistic early expiration is not supported")
+	}
+
+	if err := store.Add("v1", 45); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+
+	var result int
+	recompute, err := xf.GetWithXFetch("v1", &result, 0)
+	if err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if recompute {
+		t.Error("A zero beta should not recompute early")
+	}
+	if result != 45 {
+		t.Errorf("Expected 45 got %d", result)
+	}
+
+	recompute, _ = xf.GetWithXFetch("v1", &result, 1e-9)
+	if recompute {
+		t.Error("A fresh value should not be recomputed")
+	}
+
+	sleep(time.Millisecond * 800)
+	recompute, err = xf.GetWithXFetch("v1", &result, 1e6)
+	if err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if !recompute {
+		t.Error("A value close to expiration should be recomputed")
+	}
+
+	if _, err := xf.GetWithXFetch("v2", &result, 1); err == nil {
+		t.Error("A missing key should return an error")
+	}
+}
+
 func TestTransient(store data.Store, t *testing.T) {
+	testTransient(store, t, time.Sleep)
+}
+
+// TestTransientWithClock runs TestTransient advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestTransientWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testTransient(store, t, clock.Advance)
+}
+
+func testTransient(store data.Store, t *testing.T, sleep func(time.Duration)) {
 	store.SetTransient(true)
 	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
 		t.Skip("Set lifetime to all items is not supported")
@@ -176,7 +534,7 @@ func TestTransient(store data.Store, t *testing.T) {
 		t.Errorf("Could not add value: %v", err)
 	}
 
-	time.Sleep(time.Millisecond * 500)
+	sleep(time.Millisecond * 500)
 
 	var result int
 	if err := store.Get("v1", &result); err != nil {
@@ -192,7 +550,7 @@ func TestTransient(store data.Store, t *testing.T) {
 		t.Errorf("Could not get value: %v", err)
 	}
 
-	time.Sleep(time.Millisecond * 600)
+	sleep(time.Millisecond * 600)
 	if err := store.Get("v1", &result); err == nil {
 		t.Errorf("Value expiration should not be postponed: %s", "v1")
 	}
@@ -204,7 +562,75 @@ func TestTransient(store data.Store, t *testing.T) {
 	}
 }
 
+// TestGenericDecode tests whether values decoded into an empty interface have
+// the generic types defined by data.GenericValue.
+func TestGenericDecode(store data.Store, t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	values := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{5, int64(5)},
+		{int8(-3), int64(-3)},
+		{uint16(300), int64(300)},
+		{float32(2.5), float64(2.5)},
+		{1.5, float64(1.5)},
+		{"lorem", "lorem"},
+		{true, true},
+		{[]int{1, 2}, []interface{}{int64(1), int64(2)}},
+		{map[string]int{"a": 1}, map[string]interface{}{"a": int64(1)}},
+		{user{"John", 30}, map[string]interface{}{
+			"Name": "John",
+			"Age":  int64(30),
+		}},
+	}
+
+	for i, v := range values {
+		key := strconv.Itoa(i)
+		if err := store.Add(key, v.value); err != nil {
+			t.Errorf("Could not add value %v: %v", v.value, err)
+			continue
+		}
+
+		var result interface{}
+		if err := store.Get(key, &result); err != nil {
+			t.Errorf("Could not get value %v: %v", v.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, v.expected) {
+			t.Errorf("Expected '%#v' got '%#v'", v.expected, result)
+		}
+	}
+
+	as, ok := store.(data.AtomicStore)
+	if !ok {
+		return
+	}
+	if _, err := as.Increment("counter"); err != nil {
+		t.Errorf("Could not increment value: %v", err)
+	}
+	var result interface{}
+	if err := store.Get("counter", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if result != int64(1) {
+		t.Errorf("Expected '%#v' got '%#v'", int64(1), result)
+	}
+}
+
 func TestTypeError(store data.Store, t *testing.T) {
+	type user struct {
+		Name string
+	}
+
 	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
 		t.Skip("Set lifetime to all items is not supported")
 	}
@@ -215,6 +641,8 @@ func TestTypeError(store data.Store, t *testing.T) {
 	var str string
 	if err := store.Get("v1", &str); err == nil {
 		t.Errorf("The value %s should not be read", "v1")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected InvalidTypeError but got %v", err)
 	}
 
 	if err := store.Add("v2", "15"); err != nil {
@@ -223,6 +651,23 @@ func TestTypeError(store data.Store, t *testing.T) {
 	var integer int
 	if err := store.Get("v2", &integer); err == nil {
 		t.Errorf("The value %s should not be read", "v2")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected InvalidTypeError but got %v", err)
+	}
+
+	if err := store.Add("v3", user{"John"}); err != nil {
+		t.Errorf("The value %s could not be added", "v3")
+	}
+	if err := store.Get("v3", &integer); err == nil {
+		t.Errorf("The value %s should not be read", "v3")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected InvalidTypeError but got %v", err)
+	}
+	var u user
+	if err := store.Get("v1", &u); err == nil {
+		t.Errorf("The value %s should not be read", "v1")
+	} else if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected InvalidTypeError but got %v", err)
 	}
 }
 
@@ -326,6 +771,977 @@ func TestValueHandling(store data.Store, t *testing.T) {
 	}
 }
 
+func TestValueMiddleware(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	mw, ok := store.(interface {
+		SetValueMiddleware(onStore, onLoad data.ValueFunc)
+	})
+	if !ok {
+		t.Skip("Value middleware is not supported")
+	}
+
+	const prefix = "wrapped:"
+	mw.SetValueMiddleware(
+		func(key string, v interface{}) (interface{}, error) {
+			return prefix + v.(string), nil
+		},
+		func(key string, v interface{}) (interface{}, error) {
+			str := v.(string)
+			if !strings.HasPrefix(str, prefix) {
+				return nil, fmt.Errorf("The value %s was not wrapped", key)
+			}
+			return strings.TrimPrefix(str, prefix), nil
+		})
+	defer mw.SetValueMiddleware(nil, nil)
+
+	if err := store.Add("v1", "lorem"); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if err := store.Set("v1", "ipsum"); err != nil {
+		t.Errorf("Could not set value: %v", err)
+	}
+
+	var result string
+	if err := store.Get("v1", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if result != "ipsum" {
+		t.Errorf("Expected 'ipsum' got '%s'", result)
+	}
+
+	mw.SetValueMiddleware(nil, nil)
+	if err := store.Get("v1", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if result != prefix+"ipsum" {
+		t.Errorf("The value was not transformed before stored: '%s'", result)
+	}
+}
+
+func TestValidator(store data.Store, t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	vs, ok := store.(interface {
+		SetValidator(fn data.ValidatorFunc)
+	})
+	if !ok {
+		t.Skip("Value validation is not supported")
+	}
+
+	errRequired := fmt.Errorf("The field Name is required")
+	vs.SetValidator(func(key string, v interface{}) error {
+		if u, ok := v.(user); ok && u.Name == "" {
+			return errRequired
+		}
+		return nil
+	})
+	defer vs.SetValidator(nil)
+
+	if err := store.Add("u1", user{"John", 30}); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if err := store.Add("u2", user{Age: 25}); err != errRequired {
+		t.Errorf("Expected validation error but got %v", err)
+	}
+	if err := store.Set("u1", user{Age: 31}); err != errRequired {
+		t.Errorf("Expected validation error but got %v", err)
+	}
+
+	var result user
+	if err := store.Get("u1", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if expected := (user{"John", 30}); result != expected {
+		t.Errorf("Expected '%v' got '%v'", expected, result)
+	}
+	if err := store.Get("u2", &result); err == nil {
+		t.Error("An invalid value should not be stored")
+	}
+}
+
+func TestIncrementField(store data.Store, t *testing.T) {
+	type counters struct {
+		Name  string
+		Hits  int
+		Views int
+	}
+
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	fi, ok := store.(interface {
+		IncrementField(key, field string, delta int64) (int64, error)
+	})
+	if !ok {
+		t.Skip("Field increment is not supported")
+	}
+
+	if err := store.Add("c1", counters{"raiqub", 3, 8}); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+
+	value, err := fi.IncrementField("c1", "Hits", 4)
+	if err != nil {
+		t.Errorf("Could not increment field: %v", err)
+	}
+	if value != 7 {
+		t.Errorf("The field Hits should be 7 but got %d", value)
+	}
+
+	var result counters
+	if err := store.Get("c1", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if expected := (counters{"raiqub", 7, 8}); result != expected {
+		t.Errorf("Expected '%v' got '%v'", expected, result)
+	}
+
+	if _, err := fi.IncrementField("c1", "Clicks", 1); err == nil {
+		t.Error("A missing field should not be incremented")
+	}
+	if _, err := fi.IncrementField("c1", "Name", 1); err == nil {
+		t.Error("A non-numeric field should not be incremented")
+	}
+	if _, err := fi.IncrementField("c2", "Hits", 1); err == nil {
+		t.Error("A field of missing key should not be incremented")
+	}
+}
+
+func TestUpdate(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	us, ok := store.(interface {
+		Update(key string, fn data.UpdateFunc) error
+	})
+	if !ok {
+		t.Skip("Atomic update is not supported")
+	}
+
+	appendItem := func(current interface{}) (interface{}, error) {
+		list, _ := current.([]interface{})
+		return append(list, int64(len(list))), nil
+	}
+	for i := 0; i < 3; i++ {
+		if err := us.Update("list", appendItem); err != nil {
+			t.Errorf("Could not update value: %v", err)
+		}
+	}
+
+	var result []int
+	if err := store.Get("list", &result); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{0, 1, 2}) {
+		t.Errorf("Expected '%v' got '%v'", []int{0, 1, 2}, result)
+	}
+
+	errRefused := fmt.Errorf("The value was refused")
+	err := us.Update("list", func(current interface{}) (interface{}, error) {
+		return nil, errRefused
+	})
+	if err != errRefused {
+		t.Errorf("Expected refused error but got %v", err)
+	}
+
+	if err := store.Add("name", "lorem"); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	err = us.Update("name", func(current interface{}) (interface{}, error) {
+		return current.(string) + " ipsum", nil
+	})
+	if err != nil {
+		t.Errorf("Could not update value: %v", err)
+	}
+	var name string
+	if err := store.Get("name", &name); err != nil || name != "lorem ipsum" {
+		t.Errorf("Expected 'lorem ipsum' got '%s': %v", name, err)
+	}
+}
+
+func TestCompareAndSwap(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	cs, ok := store.(interface {
+		CompareAndSwap(key string, old, new interface{}) (bool, error)
+	})
+	if !ok {
+		t.Skip("Compare-and-swap is not supported")
+	}
+
+	values := []struct {
+		key           string
+		old, new, bad interface{}
+	}{
+		{"int", 1, 2, 3},
+		{"string", "lorem", "ipsum", "dolor"},
+		{"slice", []int{1, 2}, []int{3}, []int{2, 1}},
+	}
+	for _, v := range values {
+		if err := store.Add(v.key, v.old); err != nil {
+			t.Fatalf("Could not add value: %v", err)
+		}
+
+		if ok, err := cs.CompareAndSwap(v.key, v.bad, v.new); err != nil || ok {
+			t.Errorf("Value '%v' should not be swapped by %v: %v",
+				v.old, v.bad, err)
+		}
+		if ok, err := cs.CompareAndSwap(v.key, v.old, v.new); err != nil || !ok {
+			t.Errorf("Value '%v' should be swapped: %v", v.old, err)
+		}
+
+		result := reflect.New(reflect.TypeOf(v.new))
+		if err := store.Get(v.key, result.Interface()); err != nil {
+			t.Errorf("Could not get value: %v", err)
+		}
+		if !reflect.DeepEqual(result.Elem().Interface(), v.new) {
+			t.Errorf("Expected '%v' got '%v'", v.new, result.Elem().Interface())
+		}
+	}
+
+	_, err := cs.CompareAndSwap("missing", 1, 2)
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Expected InvalidKeyError but got %v", err)
+	}
+}
+
+func TestVersion(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	vs, ok := store.(interface {
+		GetWithVersion(key string, ref interface{}) (uint64, error)
+		SetWithVersion(
+			key string, value interface{}, expectedVersion uint64,
+		) (uint64, error)
+	})
+	if !ok {
+		t.Skip("Versioned values are not supported")
+	}
+
+	if err := store.Add("name", "lorem"); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	var name string
+	version, err := vs.GetWithVersion("name", &name)
+	if err != nil || name != "lorem" {
+		t.Fatalf("Expected 'lorem' got '%s': %v", name, err)
+	}
+
+	newVersion, err := vs.SetWithVersion("name", "ipsum", version)
+	if err != nil {
+		t.Fatalf("Could not set value: %v", err)
+	}
+	if newVersion <= version {
+		t.Errorf("Expected a version greater than %d got %d",
+			version, newVersion)
+	}
+
+	_, err = vs.SetWithVersion("name", "dolor", version)
+	if _, ok := err.(data.VersionConflictError); !ok {
+		t.Errorf("Expected VersionConflictError but got %v", err)
+	}
+
+	if err := store.Set("name", "dolor"); err != nil {
+		t.Errorf("Could not set value: %v", err)
+	}
+	if current, _ := vs.GetWithVersion("name", &name); current <= newVersion {
+		t.Errorf("Expected a version greater than %d got %d",
+			newVersion, current)
+	}
+
+	_, err = vs.SetWithVersion("missing", 1, version)
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Expected InvalidKeyError but got %v", err)
+	}
+}
+
+func TestDeletePrefix(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	ds, ok := store.(interface {
+		DeletePrefix(prefix string, dryRun bool) ([]string, error)
+	})
+	if !ok {
+		t.Skip("Delete by prefix is not supported")
+	}
+
+	for _, key := range []string{"user:2", "user:1", "user.3", "session:1"} {
+		if err := store.Add(key, 1); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+
+	expected := []string{"user:1", "user:2"}
+	keys, err := ds.DeletePrefix("user:", true)
+	if err != nil {
+		t.Errorf("Could not match values: %v", err)
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected matched keys %v got %v", expected, keys)
+	}
+	if count, _ := store.Count(); count != 4 {
+		t.Errorf("A dry run should not delete values, got %d values", count)
+	}
+
+	keys, err = ds.DeletePrefix("user:", false)
+	if err != nil {
+		t.Errorf("Could not delete values: %v", err)
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected deleted keys %v got %v", expected, keys)
+	}
+
+	var value int
+	for _, key := range expected {
+		if err := store.Get(key, &value); err == nil {
+			t.Errorf("The value %s should be deleted", key)
+		}
+	}
+	for _, key := range []string{"user.3", "session:1"} {
+		if err := store.Get(key, &value); err != nil {
+			t.Errorf("The value %s should not be deleted: %v", key, err)
+		}
+	}
+}
+
+func TestFlushPrefix(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	fs, ok := store.(interface {
+		FlushPrefix(prefix string) error
+	})
+	if !ok {
+		t.Skip("Flush by prefix is not supported")
+	}
+
+	for _, key := range []string{"user:2", "user:1", "user.3", "session:1"} {
+		if err := store.Add(key, 1); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+
+	if err := fs.FlushPrefix("user:"); err != nil {
+		t.Errorf("Could not flush values: %v", err)
+	}
+
+	var value int
+	for _, key := range []string{"user:1", "user:2"} {
+		if err := store.Get(key, &value); err == nil {
+			t.Errorf("The value %s should be deleted", key)
+		}
+	}
+	for _, key := range []string{"user.3", "session:1"} {
+		if err := store.Get(key, &value); err != nil {
+			t.Errorf("The value %s should be kept: %v", key, err)
+		}
+	}
+}
+
+func TestExists(store data.Store, t *testing.T) {
+	testExists(store, t, time.Sleep)
+}
+
+// TestExistsWithClock runs TestExists advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestExistsWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testExists(store, t, clock.Advance)
+}
+
+func testExists(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+	store.SetTransient(false)
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+
+	found, err := store.Exists("v1")
+	if _, ok := err.(dot.NotSupportedError); ok {
+		t.Skip("Checking existence is not supported")
+	}
+	if err != nil || !found {
+		t.Errorf("The value v1 should exist: %v", err)
+	}
+	if found, _ := store.Exists("v2"); found {
+		t.Error("The value v2 should not exist")
+	}
+
+	// Checking existence must not postpone expiration
+	sleep(time.Millisecond * 600)
+	if found, _ := store.Exists("v1"); !found {
+		t.Error("The value v1 should not be expired")
+	}
+	sleep(time.Millisecond * 600)
+	if found, _ := store.Exists("v1"); found {
+		t.Error("The value v1 should be expired")
+	}
+}
+
+func TestGetOrAdd(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	ga, ok := store.(interface {
+		GetOrAdd(key string, ref interface{}, factory data.FactoryFunc) error
+	})
+	if !ok {
+		t.Skip("Get or add is not supported")
+	}
+
+	calls := 0
+	factory := func() (interface{}, error) {
+		calls++
+		return "lorem", nil
+	}
+
+	var result string
+	if err := ga.GetOrAdd("v1", &result, factory); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if result != "lorem" || calls != 1 {
+		t.Errorf("Expected 'lorem' computed once but got %q by %d calls",
+			result, calls)
+	}
+
+	result = ""
+	if err := ga.GetOrAdd("v1", &result, factory); err != nil {
+		t.Errorf("Could not get value: %v", err)
+	}
+	if result != "lorem" || calls != 1 {
+		t.Errorf("Expected stored 'lorem' but got %q by %d calls",
+			result, calls)
+	}
+
+	failure := fmt.Errorf("factory failed")
+	err := ga.GetOrAdd("v2", &result, func() (interface{}, error) {
+		return nil, failure
+	})
+	if err != failure {
+		t.Errorf("Expected factory error but got %v", err)
+	}
+	if err := store.Get("v2", &result); err == nil {
+		t.Error("A value should not be stored when factory fails")
+	}
+}
+
+func TestGetSetMany(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	bs, ok := store.(interface {
+		GetMany(keys []string) (map[string]interface{}, error)
+		SetMany(values map[string]interface{}) error
+	})
+	if !ok {
+		t.Skip("Batch operations are not supported")
+	}
+
+	if err := store.Add("v1", "lorem"); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	err := bs.SetMany(map[string]interface{}{
+		"v1": "ipsum",
+		"v2": "dolor",
+	})
+	if err != nil {
+		t.Errorf("Could not set values: %v", err)
+	}
+
+	values, err := bs.GetMany([]string{"v1", "v2", "v3"})
+	if err != nil {
+		t.Errorf("Could not get values: %v", err)
+	}
+	if len(values) != 2 || values["v1"] != "ipsum" || values["v2"] != "dolor" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+	if _, ok := values["v3"]; ok {
+		t.Error("A missing key should be absent from values")
+	}
+}
+
+func TestRange(store data.Store, t *testing.T) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	expected := map[string]interface{}{
+		"v1": "lorem",
+		"v2": "ipsum",
+		"v3": "dolor",
+	}
+	for k, v := range expected {
+		if err := store.Add(k, v); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+
+	values := make(map[string]interface{})
+	err := store.Range(func(key string, value interface{}) bool {
+		values[key] = value
+		return true
+	})
+	if _, ok := err.(dot.NotSupportedError); ok {
+		t.Skip("Iterating values is not supported")
+	}
+	if err != nil {
+		t.Errorf("Could not range values: %v", err)
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected values %v but got %v", expected, values)
+	}
+
+	calls := 0
+	err = store.Range(func(key string, value interface{}) bool {
+		calls++
+		return false
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("Expected iteration to stop after 1 call but got %d: %v",
+			calls, err)
+	}
+}
+
+func TestLifetimeFor(store data.Store, t *testing.T) {
+	testLifetimeFor(store, t, time.Sleep)
+}
+
+// TestLifetimeForWithClock runs TestLifetimeFor advancing specified clock
+// instead of waiting for real elapsed time. The clock must be used by store.
+func TestLifetimeForWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testLifetimeFor(store, t, clock.Advance)
+}
+
+func testLifetimeFor(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	ls, ok := store.(interface {
+		AddWithLifetime(key string, value interface{}, d time.Duration) error
+		SetLifetimeFor(key string, d time.Duration) error
+	})
+	if !ok {
+		t.Skip("Lifetime by key is not supported")
+	}
+
+	if err := ls.AddWithLifetime("v1", 1, time.Second*3); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if err := store.Add("v2", 2); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if err := store.Add("v3", 3); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if err := ls.SetLifetimeFor("v3", time.Second*3); err != nil {
+		t.Errorf("Could not set lifetime of v3: %v", err)
+	}
+
+	sleep(time.Millisecond * 1500)
+
+	var result int
+	if err := store.Get("v1", &result); err != nil {
+		t.Errorf("The value v1 should not be expired by its lifetime: %v", err)
+	}
+	if err := store.Get("v2", &result); err == nil {
+		t.Error("The value v2 should expire by the lifetime of store")
+	}
+	if err := store.Get("v3", &result); err != nil {
+		t.Errorf("The value v3 should not be expired by its lifetime: %v", err)
+	}
+
+	err := ls.SetLifetimeFor("v2", time.Second*3)
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Expected InvalidKeyError for expired v2 but got %v", err)
+	}
+
+	// The lifetime of store is restored from now on
+	if err := ls.SetLifetimeFor("v1", 0); err != nil {
+		t.Errorf("Could not restore lifetime of v1: %v", err)
+	}
+	sleep(time.Millisecond * 1200)
+
+	if err := store.Get("v1", &result); err == nil {
+		t.Error("The value v1 should expire by the lifetime of store")
+	}
+	if err := store.Get("v3", &result); err != nil {
+		t.Errorf("The value v3 should not be expired by its lifetime: %v", err)
+	}
+}
+
+func TestScopeNew(store data.Store, t *testing.T) {
+	testScopeNew(store, t, time.Sleep)
+}
+
+// TestScopeNewWithClock runs TestScopeNew advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestScopeNewWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testScopeNew(store, t, clock.Advance)
+}
+
+func testScopeNew(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	if err := store.SetLifetime(time.Second*5, data.ScopeNew); err != nil {
+		t.Skip("Set lifetime to new items is not supported")
+	}
+	if err := store.Add("v2", 2); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+
+	sleep(time.Millisecond * 1500)
+
+	var result int
+	if err := store.Get("v1", &result); err == nil {
+		t.Error("The value v1 should expire by its former lifetime")
+	}
+	if err := store.Get("v2", &result); err != nil {
+		t.Errorf("The value v2 should not be expired: %v", err)
+	}
+}
+
+func TestTTL(store data.Store, t *testing.T) {
+	testTTL(store, t, time.Sleep)
+}
+
+// TestTTLWithClock runs TestTTL advancing specified clock instead of waiting
+// for real elapsed time. The clock must be used by store.
+func TestTTLWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testTTL(store, t, clock.Advance)
+}
+
+func testTTL(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	sleep(time.Millisecond * 400)
+
+	ttl, err := store.TTL("v1")
+	if _, ok := err.(dot.NotSupportedError); ok {
+		t.Skip("Getting remaining lifetime is not supported")
+	}
+	if err != nil {
+		t.Errorf("Could not get remaining lifetime: %v", err)
+	}
+	if ttl <= 0 || ttl > time.Millisecond*600 {
+		t.Errorf("Unexpected remaining lifetime of v1: %v", ttl)
+	}
+
+	_, err = store.TTL("v2")
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Expected InvalidKeyError for missing v2 but got %v", err)
+	}
+}
+
+func TestTouch(store data.Store, t *testing.T) {
+	testTouch(store, t, time.Sleep)
+}
+
+// TestTouchWithClock runs TestTouch advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestTouchWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testTouch(store, t, clock.Advance)
+}
+
+func testTouch(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+	store.SetTransient(true)
+	defer store.SetTransient(false)
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	sleep(time.Millisecond * 600)
+
+	err := store.Touch("v1")
+	if _, ok := err.(dot.NotSupportedError); ok {
+		t.Skip("Touching values is not supported")
+	}
+	if err != nil {
+		t.Errorf("Could not touch value: %v", err)
+	}
+	sleep(time.Millisecond * 600)
+
+	if ok, err := store.Exists("v1"); err != nil || !ok {
+		t.Errorf("The value v1 should be renewed by Touch: %v", err)
+	}
+
+	err = store.Touch("v2")
+	if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Expected InvalidKeyError for missing v2 but got %v", err)
+	}
+}
+
+func TestKeys(store data.Store, t *testing.T) {
+	testKeys(store, t, time.Sleep)
+}
+
+// TestKeysWithClock runs TestKeys advancing specified clock instead of waiting
+// for real elapsed time. The clock must be used by store.
+func TestKeysWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testKeys(store, t, clock.Advance)
+}
+
+func testKeys(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+	store.SetTransient(true)
+	defer store.SetTransient(false)
+
+	if err := store.Add("v1", 1); err != nil {
+		t.Errorf("Could not add value: %v", err)
+	}
+	sleep(time.Millisecond * 600)
+	for _, key := range []string{"v3", "v2"} {
+		if err := store.Add(key, 1); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+	}
+	sleep(time.Millisecond * 600)
+
+	keys, err := store.Keys()
+	if _, ok := err.(dot.NotSupportedError); ok {
+		t.Skip("Listing keys is not supported")
+	}
+	if err != nil {
+		t.Errorf("Could not list keys: %v", err)
+	}
+	sort.Strings(keys)
+	expected := []string{"v2", "v3"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v got %v", expected, keys)
+	}
+}
+
+// TestRegisterType tests whether values are decoded into the type registered
+// by the longest matching key prefix.
+func TestRegisterType(store data.Store, t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	type admin struct {
+		Name  string
+		Level int
+	}
+
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	tr, ok := store.(interface {
+		RegisterType(prefix string, proto interface{})
+		GetValue(key string) (interface{}, error)
+	})
+	if !ok {
+		t.Skip("Type registry is not supported")
+	}
+
+	tr.RegisterType("user:", user{})
+	tr.RegisterType("user:admin:", &admin{})
+
+	values := []struct {
+		key      string
+		value    interface{}
+		expected interface{}
+	}{
+		{"user:1", user{"John", 30}, user{"John", 30}},
+		{"user:admin:1", admin{"Mary", 2}, &admin{"Mary", 2}},
+		{"other:1", "value", "value"},
+	}
+	for _, v := range values {
+		if err := store.Add(v.key, v.value); err != nil {
+			t.Errorf("Could not add value %s: %v", v.key, err)
+		}
+	}
+
+	for _, v := range values {
+		result, err := tr.GetValue(v.key)
+		if err != nil {
+			t.Errorf("Could not get value %s: %v", v.key, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, v.expected) {
+			t.Errorf("Expected '%#v' for %s got '%#v'", v.expected, v.key, result)
+		}
+	}
+
+	if _, err := tr.GetValue("user:2"); err == nil {
+		t.Error("A missing key should return an error")
+	}
+
+	tr.RegisterType("user:", nil)
+	tr.RegisterType("user:admin:", nil)
+}
+
+// TestPointerValue checks that values and pointers are interchangeable, as
+// follows:
+//
+//	stored   | read into | result
+//	---------+-----------+--------------------------
+//	T        | *T        | value copied
+//	*T       | *T        | pointed value copied
+//	T        | **T       | new *T holding the value
+//	*T       | **T       | new *T holding the value
+func TestPointerValue(store data.Store, t *testing.T) {
+	type valueType struct {
+		Number int
+	}
+
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	number, str, strct := 15, "raiqub", valueType{83679}
+	testValues := map[string]interface{}{
+		"int":     number,
+		"*int":    &number,
+		"string":  str,
+		"*string": &str,
+		"struct":  strct,
+		"*struct": &strct,
+	}
+	for k, v := range testValues {
+		if err := store.Add(k, v); err != nil {
+			t.Errorf("The value %s could not be added: %v", k, err)
+		}
+	}
+
+	for k := range testValues {
+		var err error
+		var byValue, byPointer interface{}
+		switch k {
+		case "int", "*int":
+			var ref int
+			var pref *int
+			err = store.Get(k, &ref)
+			if err == nil {
+				err = store.Get(k, &pref)
+			}
+			byValue = ref
+			if pref != nil {
+				byPointer = *pref
+			}
+		case "string", "*string":
+			var ref string
+			var pref *string
+			err = store.Get(k, &ref)
+			if err == nil {
+				err = store.Get(k, &pref)
+			}
+			byValue = ref
+			if pref != nil {
+				byPointer = *pref
+			}
+		case "struct", "*struct":
+			var ref valueType
+			var pref *valueType
+			err = store.Get(k, &ref)
+			if err == nil {
+				err = store.Get(k, &pref)
+			}
+			byValue = ref
+			if pref != nil {
+				byPointer = *pref
+			}
+		}
+
+		expected := reflect.Indirect(reflect.ValueOf(testValues[k])).Interface()
+		if err != nil {
+			t.Errorf("The value %s could not be read: %v", k, err)
+		}
+		if byValue != expected {
+			t.Errorf("The value %s read into value is '%v' instead of '%v'",
+				k, byValue, expected)
+		}
+		if byPointer != expected {
+			t.Errorf("The value %s read into pointer is '%v' instead of '%v'",
+				k, byPointer, expected)
+		}
+	}
+}
+
+func TestEntriesByExpiry(store data.Store, t *testing.T) {
+	testEntriesByExpiry(store, t, time.Sleep)
+}
+
+// TestEntriesByExpiryWithClock runs TestEntriesByExpiry advancing specified clock instead of
+// waiting for real elapsed time. The clock must be used by store.
+func TestEntriesByExpiryWithClock(store data.Store, clock *Clock, t *testing.T) {
+	testEntriesByExpiry(store, t, clock.Advance)
+}
+
+func testEntriesByExpiry(store data.Store, t *testing.T, sleep func(time.Duration)) {
+	if err := store.SetLifetime(time.Second*1, data.ScopeAll); err != nil {
+		t.Skip("Set lifetime to all items is not supported")
+	}
+
+	ebe, ok := store.(interface {
+		EntriesByExpiry(limit int) ([]data.KeyTTL, error)
+	})
+	if !ok {
+		t.Skip("Listing entries by expiry is not supported")
+	}
+
+	for _, k := range []string{"v1", "v2", "v3"} {
+		if err := store.Add(k, k); err != nil {
+			t.Errorf("Could not add value: %v", err)
+		}
+		sleep(time.Millisecond * 20)
+	}
+
+	entries, err := ebe.EntriesByExpiry(2)
+	if err != nil {
+		t.Fatalf("Could not list entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries but got %d", len(entries))
+	}
+	if entries[0].Key != "v1" || entries[1].Key != "v2" {
+		t.Errorf("The entries are not sorted by expiry: %v", entries)
+	}
+	if entries[0].TTL <= 0 || entries[0].TTL > entries[1].TTL {
+		t.Errorf("Unexpected remaining lifetimes: %v", entries)
+	}
+}
+
 func TestKeyCollision(store data.Store, t *testing.T) {
 	if err := store.SetLifetime(time.Millisecond, data.ScopeAll); err != nil {
 		t.Skip("Set lifetime to all items is not supported")
@@ -382,7 +1798,7 @@ func BenchmarkAddGet(store data.Store, b *testing.B) {
 	b.StopTimer()
 }
 
-func BenchmarkAtomicIncrement(store data.Store, b *testing.B) {
+func BenchmarkAtomicIncrement(store data.AtomicStore, b *testing.B) {
 	if err := store.SetLifetime(time.Second*30, data.ScopeAll); err != nil {
 		b.Skip("Set lifetime to all items is not supported")
 	}
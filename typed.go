@@ -0,0 +1,65 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+// A TypedStore wraps a Store whose values have the same type T, so values are
+// read and written as T instead of empty interfaces. A mismatching value is
+// rejected at compile time, rather than by an InvalidTypeError at run time.
+//
+// Methods other than Add, Get and Set are promoted from wrapped store.
+type TypedStore[T any] struct {
+	Store
+}
+
+// NewTypedStore returns a TypedStore which reads and writes values of type T
+// from s.
+func NewTypedStore[T any](s Store) *TypedStore[T] {
+	return &TypedStore[T]{s}
+}
+
+// Add adds a new key:value to wrapped store.
+//
+// Errors:
+// DuplicatedKeyError when requested key already exists.
+func (s *TypedStore[T]) Add(key string, value T) error {
+	return s.Store.Add(key, value)
+}
+
+// Get gets the value stored by specified key. The zero value of T is returned
+// along with any error.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+// InvalidTypeError when the stored value cannot be decoded into T, like a
+// value written by the untyped store.
+func (s *TypedStore[T]) Get(key string) (T, error) {
+	var value T
+	if err := s.Store.Get(key, &value); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return value, nil
+}
+
+// Set sets the value of specified key.
+//
+// Errors:
+// InvalidKeyError when requested key could not be found.
+func (s *TypedStore[T]) Set(key string, value T) error {
+	return s.Store.Set(key, value)
+}
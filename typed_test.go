@@ -0,0 +1,83 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/raiqub/data.v0"
+	"gopkg.in/raiqub/data.v0/memstore"
+	"gopkg.in/raiqub/dot.v1"
+)
+
+func TestTypedStore(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	backend := memstore.New(time.Minute, false)
+	store := data.NewTypedStore[user](backend)
+
+	if err := store.Add("u1", user{"lorem", 30}); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+	if err := store.Set("u1", user{"ipsum", 31}); err != nil {
+		t.Fatalf("Could not set value: %v", err)
+	}
+
+	result, err := store.Get("u1")
+	if err != nil {
+		t.Fatalf("Could not get value: %v", err)
+	}
+	if expected := (user{"ipsum", 31}); result != expected {
+		t.Errorf("Expected '%v' got '%v'", expected, result)
+	}
+
+	if _, err := store.Get("u2"); err == nil {
+		t.Error("A missing key should return an error")
+	} else if _, ok := err.(dot.InvalidKeyError); !ok {
+		t.Errorf("Expected InvalidKeyError but got %v", err)
+	}
+
+	backend.Add("u3", "not an user")
+	result, err = store.Get("u3")
+	if _, ok := err.(data.InvalidTypeError); !ok {
+		t.Errorf("Expected InvalidTypeError but got %v", err)
+	}
+	if result != (user{}) {
+		t.Errorf("Expected zero value along with error but got %v", result)
+	}
+
+	if n, _ := store.Count(); n != 2 {
+		t.Errorf("Expected promoted Count of 2 but got %d", n)
+	}
+}
+
+func TestTypedStorePointer(t *testing.T) {
+	store := data.NewTypedStore[*int](memstore.New(time.Minute, false))
+	value := 5
+	if err := store.Add("v1", &value); err != nil {
+		t.Fatalf("Could not add value: %v", err)
+	}
+
+	result, err := store.Get("v1")
+	if err != nil || result == nil || *result != 5 {
+		t.Errorf("Expected pointer to 5 but got %v: %v", result, err)
+	}
+}
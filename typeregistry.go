@@ -0,0 +1,89 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A TypeRegistry maps key prefixes to the concrete types which values stored
+// by matching keys are decoded into. The zero value is an empty registry ready
+// to use.
+//
+// When multiple prefixes match a key the longest one takes precedence, so a
+// registry having "user:" and "user:admin:" decodes "user:admin:1" using the
+// type registered for "user:admin:".
+type TypeRegistry struct {
+	mutex sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// Register sets the type of proto as the type which values stored by keys
+// starting with prefix are decoded into. Registering an already registered
+// prefix replaces its type and a nil proto removes it.
+func (r *TypeRegistry) Register(prefix string, proto interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if proto == nil {
+		delete(r.types, prefix)
+		return
+	}
+	if r.types == nil {
+		r.types = make(map[string]reflect.Type)
+	}
+	r.types[prefix] = reflect.TypeOf(proto)
+}
+
+// TypeOf returns the type registered by the longest prefix of specified key.
+func (r *TypeRegistry) TypeOf(key string) (reflect.Type, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var match string
+	var typ reflect.Type
+	for prefix, t := range r.types {
+		if strings.HasPrefix(key, prefix) &&
+			(typ == nil || len(prefix) > len(match)) {
+			match, typ = prefix, t
+		}
+	}
+
+	return typ, typ != nil
+}
+
+// GetValue gets the value stored by specified key calling get with a newly
+// allocated value of the type registered for key. When no prefix matches the
+// key the value is decoded into an empty interface.
+func (r *TypeRegistry) GetValue(
+	key string,
+	get func(key string, ref interface{}) error,
+) (interface{}, error) {
+	typ, ok := r.TypeOf(key)
+	if !ok {
+		typ = reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+
+	ref := reflect.New(typ)
+	if err := get(key, ref.Interface()); err != nil {
+		return nil, err
+	}
+
+	return ref.Elem().Interface(), nil
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2015 Fabrício Godoy
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ShouldRecompute reports whether a value should be recomputed before it
+// expires, according to probabilistic early expiration (XFetch). The
+// probability rises as the remaining ttl approaches zero, so concurrent
+// callers of a hot key are unlikely to recompute it at once.
+//
+// The beta argument is the expected recompute time given as a fraction of
+// lifetime, where greater values recompute earlier and zero disables early
+// recomputation. A value already expired should always be recomputed.
+func ShouldRecompute(ttl, lifetime time.Duration, beta float64) bool {
+	if ttl <= 0 {
+		return true
+	}
+	if beta <= 0 || lifetime <= 0 {
+		return false
+	}
+
+	delta := float64(lifetime) * beta
+	return -delta*math.Log(rand.Float64()) >= float64(ttl)
+}